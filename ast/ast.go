@@ -9,6 +9,7 @@ import (
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.Position // ノードの Token の位置
 }
 
 type Stmt interface {
@@ -21,6 +22,12 @@ type Expr interface {
 	exprNode()
 }
 
+// TypeExpr 型注釈。
+type TypeExpr interface {
+	Node
+	typeNode()
+}
+
 type Program struct {
 	Stmts []Stmt
 }
@@ -35,6 +42,13 @@ func (p *Program) String() string {
 	return out.String()
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Stmts) > 0 {
+		return p.Stmts[0].Pos()
+	}
+	return token.Position{}
+}
+
 func (p *Program) TokenLiteral() string {
 	if len(p.Stmts) > 0 {
 		return p.Stmts[0].TokenLiteral()
@@ -53,6 +67,10 @@ func (b *BlockStmt) TokenLiteral() string {
 	return b.Token.Literal
 }
 
+func (b *BlockStmt) Pos() token.Position {
+	return b.Token.Pos
+}
+
 func (b *BlockStmt) String() string {
 	var out strings.Builder
 
@@ -66,6 +84,7 @@ func (b *BlockStmt) String() string {
 type LetStmt struct {
 	Token token.Token
 	Name  *Ident
+	Type  TypeExpr // 型注釈がなければ nil
 	Value Expr
 }
 
@@ -74,6 +93,9 @@ func (l *LetStmt) String() string {
 
 	out.WriteString(l.TokenLiteral() + " ")
 	out.WriteString(l.Name.String())
+	if l.Type != nil {
+		out.WriteString(": " + l.Type.String())
+	}
 	out.WriteString(" = ")
 	if l.Value != nil {
 		out.WriteString(l.Value.String())
@@ -89,6 +111,10 @@ func (l *LetStmt) TokenLiteral() string {
 	return l.Token.Literal
 }
 
+func (l *LetStmt) Pos() token.Position {
+	return l.Token.Pos
+}
+
 type ReturnStmt struct {
 	Token       token.Token
 	ReturnValue Expr
@@ -112,6 +138,87 @@ func (r *ReturnStmt) TokenLiteral() string {
 	return r.Token.Literal
 }
 
+func (r *ReturnStmt) Pos() token.Position {
+	return r.Token.Pos
+}
+
+// ThrowStmt throw 式; の形で、式の値をエラーとして投げる。
+type ThrowStmt struct {
+	Token token.Token // "throw" token
+	Value Expr
+}
+
+func (t *ThrowStmt) String() string {
+	var out strings.Builder
+
+	out.WriteString(t.TokenLiteral() + " ")
+	if t.Value != nil {
+		out.WriteString(t.Value.String())
+	}
+	out.WriteString(";")
+
+	return out.String()
+}
+
+func (t *ThrowStmt) stmtNode() {}
+
+func (t *ThrowStmt) TokenLiteral() string {
+	return t.Token.Literal
+}
+
+func (t *ThrowStmt) Pos() token.Position {
+	return t.Token.Pos
+}
+
+// ImportStmt import "path/to/mod"; の形で、モジュールをパスの最後の要素の名前で束縛する。
+type ImportStmt struct {
+	Token token.Token // "import" token
+	Path  *StringLiteral
+	Name  *Ident // ソースには書かれず、Path から作る。位置は Path と同じ
+}
+
+func (i *ImportStmt) String() string {
+	var out strings.Builder
+
+	out.WriteString(i.TokenLiteral() + " ")
+	if i.Path != nil {
+		out.WriteString(i.Path.String())
+	}
+	out.WriteString(";")
+
+	return out.String()
+}
+
+func (i *ImportStmt) stmtNode() {}
+
+func (i *ImportStmt) TokenLiteral() string {
+	return i.Token.Literal
+}
+
+func (i *ImportStmt) Pos() token.Position {
+	return i.Token.Pos
+}
+
+// ExportStmt export let x = 1; の形で、モジュールの外から見える変数を宣言する。
+type ExportStmt struct {
+	Token token.Token // "export" token
+	Let   *LetStmt
+}
+
+func (e *ExportStmt) String() string {
+	return e.TokenLiteral() + " " + e.Let.String()
+}
+
+func (e *ExportStmt) stmtNode() {}
+
+func (e *ExportStmt) TokenLiteral() string {
+	return e.Token.Literal
+}
+
+func (e *ExportStmt) Pos() token.Position {
+	return e.Token.Pos
+}
+
 type ExprStmt struct {
 	Token token.Token // 式の最初のトークン
 	Expr  Expr
@@ -130,6 +237,10 @@ func (e *ExprStmt) TokenLiteral() string {
 	return e.Token.Literal
 }
 
+func (e *ExprStmt) Pos() token.Position {
+	return e.Token.Pos
+}
+
 // =========================================
 // Expressions
 // =========================================
@@ -146,6 +257,10 @@ func (p *PrefixExpr) TokenLiteral() string {
 	return p.Token.Literal
 }
 
+func (p *PrefixExpr) Pos() token.Position {
+	return p.Token.Pos
+}
+
 func (p *PrefixExpr) String() string {
 	var out strings.Builder
 
@@ -157,6 +272,34 @@ func (p *PrefixExpr) String() string {
 	return out.String()
 }
 
+// PostfixExpr x? のように被演算子の後に置く演算子。
+type PostfixExpr struct {
+	Token    token.Token
+	Left     Expr
+	Operator string
+}
+
+func (p *PostfixExpr) exprNode() {}
+
+func (p *PostfixExpr) TokenLiteral() string {
+	return p.Token.Literal
+}
+
+func (p *PostfixExpr) Pos() token.Position {
+	return p.Token.Pos
+}
+
+func (p *PostfixExpr) String() string {
+	var out strings.Builder
+
+	out.WriteString("(")
+	out.WriteString(p.Left.String())
+	out.WriteString(p.Operator)
+	out.WriteString(")")
+
+	return out.String()
+}
+
 type InfixExpr struct {
 	Token    token.Token
 	Left     Expr
@@ -170,6 +313,10 @@ func (i *InfixExpr) TokenLiteral() string {
 	return i.Token.Literal
 }
 
+func (i *InfixExpr) Pos() token.Position {
+	return i.Token.Pos
+}
+
 func (i *InfixExpr) String() string {
 	var out strings.Builder
 
@@ -197,6 +344,10 @@ func (i *Ident) TokenLiteral() string {
 	return i.Token.Literal
 }
 
+func (i *Ident) Pos() token.Position {
+	return i.Token.Pos
+}
+
 type IntLiteral struct {
 	Token token.Token
 	Value int64
@@ -212,6 +363,79 @@ func (i *IntLiteral) TokenLiteral() string {
 	return i.Token.Literal
 }
 
+func (i *IntLiteral) Pos() token.Position {
+	return i.Token.Pos
+}
+
+// StringLiteral "..." の形の文字列。Token.Literal は引用符とエスケープを含むソースのままの表記。
+type StringLiteral struct {
+	Token token.Token
+	Value string
+}
+
+func (s *StringLiteral) String() string {
+	return s.Token.Literal
+}
+
+func (s *StringLiteral) exprNode() {}
+
+func (s *StringLiteral) TokenLiteral() string {
+	return s.Token.Literal
+}
+
+func (s *StringLiteral) Pos() token.Position {
+	return s.Token.Pos
+}
+
+// ArrayLiteral [a, b] の形の配列。
+type ArrayLiteral struct {
+	Token token.Token // "[" token
+	Elems []Expr
+}
+
+func (a *ArrayLiteral) exprNode() {}
+
+func (a *ArrayLiteral) TokenLiteral() string {
+	return a.Token.Literal
+}
+
+func (a *ArrayLiteral) Pos() token.Position {
+	return a.Token.Pos
+}
+
+func (a *ArrayLiteral) String() string {
+	elems := []string{}
+	for _, e := range a.Elems {
+		elems = append(elems, e.String())
+	}
+	return "[" + strings.Join(elems, ", ") + "]"
+}
+
+// MapLiteral {k: v} の形の連想配列。Keys と Values は同じ長さで、同じ位置どうしが組になる。
+type MapLiteral struct {
+	Token  token.Token // "{" token
+	Keys   []Expr
+	Values []Expr
+}
+
+func (m *MapLiteral) exprNode() {}
+
+func (m *MapLiteral) TokenLiteral() string {
+	return m.Token.Literal
+}
+
+func (m *MapLiteral) Pos() token.Position {
+	return m.Token.Pos
+}
+
+func (m *MapLiteral) String() string {
+	pairs := []string{}
+	for i, k := range m.Keys {
+		pairs = append(pairs, k.String()+": "+m.Values[i].String())
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
 type Boolean struct {
 	Token token.Token
 	Value bool
@@ -223,6 +447,10 @@ func (b *Boolean) TokenLiteral() string {
 	return b.Token.Literal
 }
 
+func (b *Boolean) Pos() token.Position {
+	return b.Token.Pos
+}
+
 func (b *Boolean) String() string {
 	return b.Token.Literal
 }
@@ -240,6 +468,10 @@ func (i *IfExpr) TokenLiteral() string {
 	return i.Token.Literal
 }
 
+func (i *IfExpr) Pos() token.Position {
+	return i.Token.Pos
+}
+
 func (i *IfExpr) String() string {
 	var out strings.Builder
 
@@ -256,10 +488,53 @@ func (i *IfExpr) String() string {
 	return out.String()
 }
 
+// TryExpr try { } catch (e) { } finally { }。catch と finally はどちらか一方を省略できる。
+type TryExpr struct {
+	Token   token.Token // "try" token
+	Body    *BlockStmt
+	Param   *Ident     // catch がなければ nil
+	Catch   *BlockStmt // catch がなければ nil
+	Finally *BlockStmt // finally がなければ nil
+}
+
+func (t *TryExpr) exprNode() {}
+
+func (t *TryExpr) TokenLiteral() string {
+	return t.Token.Literal
+}
+
+func (t *TryExpr) Pos() token.Position {
+	return t.Token.Pos
+}
+
+func (t *TryExpr) String() string {
+	var out strings.Builder
+
+	out.WriteString("try ")
+	out.WriteString(t.Body.String())
+
+	if t.Catch != nil {
+		out.WriteString(" catch (")
+		out.WriteString(t.Param.String())
+		out.WriteString(") ")
+		out.WriteString(t.Catch.String())
+	}
+	if t.Finally != nil {
+		out.WriteString(" finally ")
+		out.WriteString(t.Finally.String())
+	}
+
+	return out.String()
+}
+
 type FuncLiteral struct {
 	Token  token.Token // "fn" token
 	Params []*Ident
 	Body   *BlockStmt
+
+	// 型注釈。ParamTypes は Params と同じ長さで、注釈のない引数は nil。
+	ParamTypes []TypeExpr
+	ResultType TypeExpr
 }
 
 func (f *FuncLiteral) exprNode() {}
@@ -268,18 +543,30 @@ func (f *FuncLiteral) TokenLiteral() string {
 	return f.Token.Literal
 }
 
+func (f *FuncLiteral) Pos() token.Position {
+	return f.Token.Pos
+}
+
 func (f *FuncLiteral) String() string {
 	var out strings.Builder
 
 	params := []string{}
-	for _, p := range f.Params {
-		params = append(params, p.String())
+	for i, p := range f.Params {
+		if i < len(f.ParamTypes) && f.ParamTypes[i] != nil {
+			params = append(params, p.String()+": "+f.ParamTypes[i].String())
+		} else {
+			params = append(params, p.String())
+		}
 	}
 
 	out.WriteString(f.TokenLiteral())
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
-	out.WriteString(") ")
+	out.WriteString(")")
+	if f.ResultType != nil {
+		out.WriteString(": " + f.ResultType.String())
+	}
+	out.WriteString(" ")
 	out.WriteString(f.Body.String())
 
 	return out.String()
@@ -297,6 +584,10 @@ func (c *CallExpr) TokenLiteral() string {
 	return c.Token.Literal
 }
 
+func (c *CallExpr) Pos() token.Position {
+	return c.Token.Pos
+}
+
 func (c *CallExpr) String() string {
 	var out strings.Builder
 
@@ -312,3 +603,101 @@ func (c *CallExpr) String() string {
 
 	return out.String()
 }
+
+// SelectorExpr mod.name の形で、モジュールが export した変数を取り出す。
+type SelectorExpr struct {
+	Token token.Token // "." token
+	X     Expr
+	Sel   *Ident
+}
+
+func (s *SelectorExpr) exprNode() {}
+
+func (s *SelectorExpr) TokenLiteral() string {
+	return s.Token.Literal
+}
+
+func (s *SelectorExpr) Pos() token.Position {
+	return s.Token.Pos
+}
+
+func (s *SelectorExpr) String() string {
+	return s.X.String() + "." + s.Sel.String()
+}
+
+// IndexExpr a[i] の形で、配列の要素や連想配列の値を取り出す。
+type IndexExpr struct {
+	Token token.Token // "[" token
+	Left  Expr
+	Index Expr
+}
+
+func (i *IndexExpr) exprNode() {}
+
+func (i *IndexExpr) TokenLiteral() string {
+	return i.Token.Literal
+}
+
+func (i *IndexExpr) Pos() token.Position {
+	return i.Token.Pos
+}
+
+func (i *IndexExpr) String() string {
+	return "(" + i.Left.String() + "[" + i.Index.String() + "])"
+}
+
+// NamedType int や bool のような名前だけの型。
+type NamedType struct {
+	Token token.Token
+	Name  string
+}
+
+func (n *NamedType) typeNode() {}
+
+func (n *NamedType) TokenLiteral() string {
+	return n.Token.Literal
+}
+
+func (n *NamedType) Pos() token.Position {
+	return n.Token.Pos
+}
+
+func (n *NamedType) String() string {
+	return n.Name
+}
+
+// FuncType fn(int, bool): int の形の関数の型。
+type FuncType struct {
+	Token  token.Token // "fn" token
+	Params []TypeExpr
+	Result TypeExpr // 省略されていれば nil
+}
+
+func (f *FuncType) typeNode() {}
+
+func (f *FuncType) TokenLiteral() string {
+	return f.Token.Literal
+}
+
+func (f *FuncType) Pos() token.Position {
+	return f.Token.Pos
+}
+
+func (f *FuncType) String() string {
+	var out strings.Builder
+
+	params := []string{}
+	for _, p := range f.Params {
+		params = append(params, p.String())
+	}
+
+	out.WriteString(f.TokenLiteral())
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(")")
+	if f.Result != nil {
+		out.WriteString(": " + f.Result.String())
+	}
+
+	return out.String()
+}
@@ -0,0 +1,259 @@
+package ast
+
+import "fmt"
+
+// ApplyFunc Apply で各ノードに対して呼ばれる。
+type ApplyFunc func(*Cursor) bool
+
+// Apply root を深さ優先で辿り、各ノードで pre と post を呼ぶ。どちらも nil でよい。
+//
+// pre が false を返すとそのノードの子と post を飛ばす。post が false を返すと走査を打ち切る。
+// Cursor の Replace などで書き換えたノードを含む、書き換え後の root を返す。
+// pre でノードを置き換えたときは、置き換えたノードの子を辿る。
+func Apply(root Node, pre, post ApplyFunc) (result Node) {
+	defer func() {
+		if r := recover(); r != nil && r != abort {
+			panic(r)
+		}
+	}()
+
+	result = root
+	a := &application{pre: pre, post: post}
+	a.apply(nil, "", root, func(n Node) { result = n })
+	return result
+}
+
+var abort = new(int) // post が false を返したときの panic の値
+
+// Cursor Apply の途中で、今のノードとその親の情報を持つ。
+type Cursor struct {
+	parent Node
+	name   string
+	node   Node
+	iter   *iterator // リストの中のノードでなければ nil
+
+	set          func(Node)
+	del          func()
+	insertBefore func(Node)
+	insertAfter  func(Node)
+}
+
+// Node 今のノード。
+func (c *Cursor) Node() Node { return c.node }
+
+// Parent 今のノードの親。root なら nil。
+func (c *Cursor) Parent() Node { return c.parent }
+
+// Name 親の中で今のノードを持つフィールドの名前。"Stmts" や "Left" など。
+func (c *Cursor) Name() string { return c.name }
+
+// Index 今のノードがリストの要素ならその位置、そうでなければ -1。
+func (c *Cursor) Index() int {
+	if c.iter == nil {
+		return -1
+	}
+	return c.iter.index
+}
+
+// Replace 今のノードを n で置き換える。n は親のフィールドに入る型でなければならない。
+func (c *Cursor) Replace(n Node) {
+	c.set(n)
+	c.node = n
+}
+
+// Delete リストから今のノードを取り除く。
+func (c *Cursor) Delete() {
+	if c.iter == nil {
+		panic("Delete node not contained in list")
+	}
+	c.del()
+	c.iter.step--
+}
+
+// InsertBefore 今のノードの前に n を挿入する。n は辿らない。
+func (c *Cursor) InsertBefore(n Node) {
+	if c.iter == nil {
+		panic("InsertBefore node not contained in list")
+	}
+	c.insertBefore(n)
+	c.iter.index++
+}
+
+// InsertAfter 今のノードの後に n を挿入する。n は辿らない。
+func (c *Cursor) InsertAfter(n Node) {
+	if c.iter == nil {
+		panic("InsertAfter node not contained in list")
+	}
+	c.insertAfter(n)
+	c.iter.step++
+}
+
+// iterator リストを辿るときの位置。Delete や Insert で次に進む幅が変わる。
+type iterator struct {
+	index, step int
+}
+
+type application struct {
+	pre, post ApplyFunc
+	cursor    Cursor
+	iter      iterator
+}
+
+// apply リストの要素でない子ノード n を辿る。set は親のフィールドを書き換える。
+func (a *application) apply(parent Node, name string, n Node, set func(Node)) {
+	if isNil(n) {
+		return
+	}
+
+	saved := a.cursor
+	a.cursor = Cursor{parent: parent, name: name, node: n, set: set}
+	a.visit(saved)
+}
+
+// visit a.cursor のノードで pre と post を呼び、子を辿る。終わったら a.cursor を saved に戻す。
+func (a *application) visit(saved Cursor) {
+	if a.pre != nil && !a.pre(&a.cursor) {
+		a.cursor = saved
+		return
+	}
+
+	switch n := a.cursor.node.(type) {
+	case *Program:
+		applyList(a, n, "Stmts", &n.Stmts)
+
+	case *BlockStmt:
+		applyList(a, n, "Stmts", &n.Stmts)
+
+	case *LetStmt:
+		a.apply(n, "Name", n.Name, func(x Node) { n.Name = x.(*Ident) })
+		a.apply(n, "Type", n.Type, func(x Node) { n.Type = x.(TypeExpr) })
+		a.apply(n, "Value", n.Value, func(x Node) { n.Value = x.(Expr) })
+
+	case *ReturnStmt:
+		a.apply(n, "ReturnValue", n.ReturnValue, func(x Node) { n.ReturnValue = x.(Expr) })
+
+	case *ExprStmt:
+		a.apply(n, "Expr", n.Expr, func(x Node) { n.Expr = x.(Expr) })
+
+	case *ThrowStmt:
+		a.apply(n, "Value", n.Value, func(x Node) { n.Value = x.(Expr) })
+
+	case *ImportStmt:
+		a.apply(n, "Path", n.Path, func(x Node) { n.Path = x.(*StringLiteral) })
+
+	case *ExportStmt:
+		a.apply(n, "Let", n.Let, func(x Node) { n.Let = x.(*LetStmt) })
+
+	case *PrefixExpr:
+		a.apply(n, "Right", n.Right, func(x Node) { n.Right = x.(Expr) })
+
+	case *PostfixExpr:
+		a.apply(n, "Left", n.Left, func(x Node) { n.Left = x.(Expr) })
+
+	case *InfixExpr:
+		a.apply(n, "Left", n.Left, func(x Node) { n.Left = x.(Expr) })
+		a.apply(n, "Right", n.Right, func(x Node) { n.Right = x.(Expr) })
+
+	case *Ident, *IntLiteral, *StringLiteral, *Boolean:
+		// 子ノードなし
+
+	case *IfExpr:
+		a.apply(n, "Cond", n.Cond, func(x Node) { n.Cond = x.(Expr) })
+		a.apply(n, "Cons", n.Cons, func(x Node) { n.Cons = x.(*BlockStmt) })
+		a.apply(n, "Alt", n.Alt, func(x Node) { n.Alt = x.(*BlockStmt) })
+
+	case *TryExpr:
+		a.apply(n, "Body", n.Body, func(x Node) { n.Body = x.(*BlockStmt) })
+		a.apply(n, "Param", n.Param, func(x Node) { n.Param = x.(*Ident) })
+		a.apply(n, "Catch", n.Catch, func(x Node) { n.Catch = x.(*BlockStmt) })
+		a.apply(n, "Finally", n.Finally, func(x Node) { n.Finally = x.(*BlockStmt) })
+
+	case *FuncLiteral:
+		applyList(a, n, "Params", &n.Params)
+		applyList(a, n, "ParamTypes", &n.ParamTypes)
+		a.apply(n, "ResultType", n.ResultType, func(x Node) { n.ResultType = x.(TypeExpr) })
+		a.apply(n, "Body", n.Body, func(x Node) { n.Body = x.(*BlockStmt) })
+
+	case *ArrayLiteral:
+		applyList(a, n, "Elems", &n.Elems)
+
+	case *MapLiteral:
+		// キーと値は組でしか消せないので、リストとしては扱わない
+		for i := range n.Keys {
+			a.apply(n, "Keys", n.Keys[i], func(x Node) { n.Keys[i] = x.(Expr) })
+			a.apply(n, "Values", n.Values[i], func(x Node) { n.Values[i] = x.(Expr) })
+		}
+
+	case *CallExpr:
+		a.apply(n, "Fn", n.Fn, func(x Node) { n.Fn = x.(Expr) })
+		applyList(a, n, "Args", &n.Args)
+
+	case *IndexExpr:
+		a.apply(n, "Left", n.Left, func(x Node) { n.Left = x.(Expr) })
+		a.apply(n, "Index", n.Index, func(x Node) { n.Index = x.(Expr) })
+
+	case *SelectorExpr:
+		a.apply(n, "X", n.X, func(x Node) { n.X = x.(Expr) })
+		a.apply(n, "Sel", n.Sel, func(x Node) { n.Sel = x.(*Ident) })
+
+	case *NamedType:
+		// 子ノードなし
+
+	case *FuncType:
+		applyList(a, n, "Params", &n.Params)
+		a.apply(n, "Result", n.Result, func(x Node) { n.Result = x.(TypeExpr) })
+
+	default:
+		panic(fmt.Sprintf("ast.Apply: unexpected node type %T", n))
+	}
+
+	if a.post != nil && !a.post(&a.cursor) {
+		panic(abort)
+	}
+
+	a.cursor = saved
+}
+
+func applyList[T Node](a *application, parent Node, name string, list *[]T) {
+	saved := a.iter
+	a.iter.index = 0
+
+	for a.iter.index < len(*list) {
+		a.iter.step = 1
+		iter := &a.iter
+
+		outer := a.cursor
+		a.cursor = Cursor{
+			parent: parent,
+			name:   name,
+			node:   (*list)[iter.index],
+			iter:   iter,
+			set:    func(x Node) { (*list)[iter.index] = x.(T) },
+			del: func() {
+				*list = append((*list)[:iter.index], (*list)[iter.index+1:]...)
+			},
+			insertBefore: func(x Node) {
+				*list = insertAt(*list, iter.index, x.(T))
+			},
+			insertAfter: func(x Node) {
+				*list = insertAt(*list, iter.index+1, x.(T))
+			},
+		}
+		if isNil(a.cursor.node) {
+			a.cursor = outer
+		} else {
+			a.visit(outer)
+		}
+
+		a.iter.index += a.iter.step
+	}
+
+	a.iter = saved
+}
+
+func insertAt[T any](list []T, i int, x T) []T {
+	list = append(list, x)
+	copy(list[i+1:], list[i:])
+	list[i] = x
+	return list
+}
@@ -0,0 +1,199 @@
+package ast
+
+import "fmt"
+
+// Visitor Walk で出会ったノードごとに Visit が呼ばれる。
+// 返した Visitor が nil でなければ、それを使って子ノードを辿り、最後に Visit(nil) を呼ぶ。
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk node を深さ優先で辿る。子ノードはソース上の順番に辿る。
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		walkList(v, n.Stmts)
+
+	case *BlockStmt:
+		walkList(v, n.Stmts)
+
+	case *LetStmt:
+		walkIf(v, n.Name)
+		walkIf(v, n.Type)
+		walkIf(v, n.Value)
+
+	case *ReturnStmt:
+		walkIf(v, n.ReturnValue)
+
+	case *ExprStmt:
+		walkIf(v, n.Expr)
+
+	case *ThrowStmt:
+		walkIf(v, n.Value)
+
+	case *ImportStmt:
+		// Name はソースにないので辿らない
+		walkIf(v, n.Path)
+
+	case *ExportStmt:
+		walkIf(v, n.Let)
+
+	case *PrefixExpr:
+		walkIf(v, n.Right)
+
+	case *PostfixExpr:
+		walkIf(v, n.Left)
+
+	case *InfixExpr:
+		walkIf(v, n.Left)
+		walkIf(v, n.Right)
+
+	case *Ident, *IntLiteral, *StringLiteral, *Boolean:
+		// 子ノードなし
+
+	case *IfExpr:
+		walkIf(v, n.Cond)
+		walkIf(v, n.Cons)
+		walkIf(v, n.Alt)
+
+	case *TryExpr:
+		walkIf(v, n.Body)
+		walkIf(v, n.Param)
+		walkIf(v, n.Catch)
+		walkIf(v, n.Finally)
+
+	case *FuncLiteral:
+		for i, param := range n.Params {
+			walkIf(v, param)
+			if i < len(n.ParamTypes) {
+				walkIf(v, n.ParamTypes[i])
+			}
+		}
+		walkIf(v, n.ResultType)
+		walkIf(v, n.Body)
+
+	case *ArrayLiteral:
+		walkList(v, n.Elems)
+
+	case *MapLiteral:
+		for i := range n.Keys {
+			walkIf(v, n.Keys[i])
+			walkIf(v, n.Values[i])
+		}
+
+	case *CallExpr:
+		walkIf(v, n.Fn)
+		walkList(v, n.Args)
+
+	case *IndexExpr:
+		walkIf(v, n.Left)
+		walkIf(v, n.Index)
+
+	case *SelectorExpr:
+		walkIf(v, n.X)
+		walkIf(v, n.Sel)
+
+	case *NamedType:
+		// 子ノードなし
+
+	case *FuncType:
+		walkList(v, n.Params)
+		walkIf(v, n.Result)
+
+	default:
+		panic(fmt.Sprintf("ast.Walk: unexpected node type %T", n))
+	}
+
+	v.Visit(nil)
+}
+
+// walkIf 解析エラーで欠けたノード（nil）は辿らない。
+func walkIf(v Visitor, node Node) {
+	if !isNil(node) {
+		Walk(v, node)
+	}
+}
+
+func walkList[T Node](v Visitor, list []T) {
+	for _, node := range list {
+		walkIf(v, node)
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect node を深さ優先で辿り、各ノードで f(node) を呼ぶ。
+// f が false を返すとそのノードの子は辿らない。子を辿り終えると f(nil) が呼ばれる。
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+// isNil nil のインターフェースと、nil のポインタを入れたインターフェースの両方を nil とみなす。
+func isNil(node Node) bool {
+	switch n := node.(type) {
+	case nil:
+		return true
+	case *Program:
+		return n == nil
+	case *BlockStmt:
+		return n == nil
+	case *LetStmt:
+		return n == nil
+	case *ReturnStmt:
+		return n == nil
+	case *ExprStmt:
+		return n == nil
+	case *ThrowStmt:
+		return n == nil
+	case *ImportStmt:
+		return n == nil
+	case *ExportStmt:
+		return n == nil
+	case *PrefixExpr:
+		return n == nil
+	case *PostfixExpr:
+		return n == nil
+	case *InfixExpr:
+		return n == nil
+	case *Ident:
+		return n == nil
+	case *IntLiteral:
+		return n == nil
+	case *StringLiteral:
+		return n == nil
+	case *Boolean:
+		return n == nil
+	case *IfExpr:
+		return n == nil
+	case *TryExpr:
+		return n == nil
+	case *FuncLiteral:
+		return n == nil
+	case *ArrayLiteral:
+		return n == nil
+	case *MapLiteral:
+		return n == nil
+	case *CallExpr:
+		return n == nil
+	case *IndexExpr:
+		return n == nil
+	case *SelectorExpr:
+		return n == nil
+	case *NamedType:
+		return n == nil
+	case *FuncType:
+		return n == nil
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+package ast
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ei1chi/sample-lang/token"
+)
+
+func ident(name string) *Ident {
+	return &Ident{Token: token.Token{Type: token.IDENT, Literal: name}, Value: name}
+}
+
+func intLit(v int64, lit string) *IntLiteral {
+	return &IntLiteral{Token: token.Token{Type: token.INT, Literal: lit}, Value: v}
+}
+
+// let f = fn(a, b) { if (a < 1) { return b } else { g(a, 2) } };
+func testProgram() *Program {
+	return &Program{Stmts: []Stmt{
+		&LetStmt{
+			Token: token.Token{Type: token.LET, Literal: "let"},
+			Name:  ident("f"),
+			Value: &FuncLiteral{
+				Token:  token.Token{Type: token.FUNCTION, Literal: "fn"},
+				Params: []*Ident{ident("a"), ident("b")},
+				Body: &BlockStmt{Stmts: []Stmt{
+					&ExprStmt{Expr: &IfExpr{
+						Token: token.Token{Type: token.IF, Literal: "if"},
+						Cond:  &InfixExpr{Left: ident("a"), Operator: "<", Right: intLit(1, "1")},
+						Cons: &BlockStmt{Stmts: []Stmt{
+							&ReturnStmt{Token: token.Token{Type: token.RETURN, Literal: "return"}, ReturnValue: ident("b")},
+						}},
+						Alt: &BlockStmt{Stmts: []Stmt{
+							&ExprStmt{Expr: &CallExpr{Fn: ident("g"), Args: []Expr{ident("a"), intLit(2, "2")}}},
+						}},
+					}},
+				}},
+			},
+		},
+	}}
+}
+
+func nodeName(n Node) string {
+	switch n := n.(type) {
+	case *Ident:
+		return n.Value
+	case *IntLiteral:
+		return n.Token.Literal
+	case *Program:
+		return "Program"
+	case *LetStmt:
+		return "LetStmt"
+	case *FuncLiteral:
+		return "FuncLiteral"
+	case *BlockStmt:
+		return "BlockStmt"
+	case *ExprStmt:
+		return "ExprStmt"
+	case *IfExpr:
+		return "IfExpr"
+	case *InfixExpr:
+		return "InfixExpr"
+	case *ReturnStmt:
+		return "ReturnStmt"
+	case *CallExpr:
+		return "CallExpr"
+	}
+	return "?"
+}
+
+func TestInspect(t *testing.T) {
+	var visited []string
+	Inspect(testProgram(), func(n Node) bool {
+		if n != nil {
+			visited = append(visited, nodeName(n))
+		}
+		return true
+	})
+
+	expected := "Program LetStmt f FuncLiteral a b BlockStmt ExprStmt IfExpr InfixExpr a 1 " +
+		"BlockStmt ReturnStmt b BlockStmt ExprStmt CallExpr g a 2"
+	if strings.Join(visited, " ") != expected {
+		t.Errorf("wrong order.\nwant=%s\ngot=%s", expected, strings.Join(visited, " "))
+	}
+}
+
+func TestInspectSkipChildren(t *testing.T) {
+	count := 0
+	Inspect(testProgram(), func(n Node) bool {
+		if n == nil {
+			return false
+		}
+		count++
+		_, isFunc := n.(*FuncLiteral)
+		return !isFunc
+	})
+
+	// Program, LetStmt, f, FuncLiteral
+	if count != 4 {
+		t.Errorf("wrong number of nodes. want=4, got=%d", count)
+	}
+}
+
+func TestInspectMissingNodes(t *testing.T) {
+	// 解析エラーで欠けたノードがあっても落ちない
+	program := &Program{Stmts: []Stmt{
+		&LetStmt{Name: ident("x")},
+		&ExprStmt{Expr: &IfExpr{Cond: ident("c"), Cons: &BlockStmt{}}},
+	}}
+
+	count := 0
+	Inspect(program, func(n Node) bool {
+		if n != nil {
+			count++
+		}
+		return true
+	})
+	if count != 7 {
+		t.Errorf("wrong number of nodes. want=7, got=%d", count)
+	}
+}
+
+func TestApplyReplace(t *testing.T) {
+	// 識別子 a をすべて x に置き換える
+	result := Apply(testProgram(), func(c *Cursor) bool {
+		if id, ok := c.Node().(*Ident); ok && id.Value == "a" {
+			c.Replace(ident("x"))
+		}
+		return true
+	}, nil)
+
+	expected := "let f = fn(x, b) if(x < 1) return b;else g(x, 2);"
+	if result.String() != expected {
+		t.Errorf("wrong result.\nwant=%q\ngot=%q", expected, result.String())
+	}
+}
+
+func TestApplyRoot(t *testing.T) {
+	result := Apply(ident("a"), func(c *Cursor) bool {
+		if c.Parent() != nil || c.Index() != -1 {
+			t.Errorf("root has parent or index. parent=%v, index=%d", c.Parent(), c.Index())
+		}
+		c.Replace(intLit(1, "1"))
+		return true
+	}, nil)
+
+	if result.String() != "1" {
+		t.Errorf("root not replaced. got=%q", result.String())
+	}
+}
+
+func TestApplyListEdits(t *testing.T) {
+	stmt := func(name string) Stmt { return &ExprStmt{Expr: ident(name)} }
+	program := &Program{Stmts: []Stmt{stmt("a"), stmt("b"), stmt("c"), stmt("d")}}
+
+	var visited []string
+	Apply(program, func(c *Cursor) bool {
+		es, ok := c.Node().(*ExprStmt)
+		if !ok {
+			return true
+		}
+
+		name := es.Expr.(*Ident).Value
+		visited = append(visited, name)
+		if c.Name() != "Stmts" {
+			t.Errorf("wrong name. got=%q", c.Name())
+		}
+
+		switch name {
+		case "a":
+			c.InsertBefore(stmt("before"))
+		case "b":
+			c.Delete()
+		case "c":
+			c.InsertAfter(stmt("after"))
+		}
+		return false
+	}, nil)
+
+	if program.String() != "beforeacafterd" {
+		t.Errorf("wrong result. got=%q", program.String())
+	}
+	// 挿入したノードは辿らない
+	if strings.Join(visited, "") != "abcd" {
+		t.Errorf("wrong visit order. got=%q", strings.Join(visited, ""))
+	}
+}
+
+func TestApplyPostAbort(t *testing.T) {
+	count := 0
+	Apply(testProgram(), nil, func(c *Cursor) bool {
+		count++
+		_, isIdent := c.Node().(*Ident)
+		return !isIdent
+	})
+
+	// 最初に post が呼ばれるのは LetStmt の Name
+	if count != 1 {
+		t.Errorf("traversal not aborted. post called %d times", count)
+	}
+}
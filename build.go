@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ei1chi/sample-lang/compiler"
+	"github.com/ei1chi/sample-lang/eval"
+	"github.com/ei1chi/sample-lang/lexer"
+	"github.com/ei1chi/sample-lang/parser"
+)
+
+// compiledExt build が書くファイルの拡張子。
+const compiledExt = ".slc"
+
+// runBuild sample-lang build [-o file] file
+// ファイルをコンパイルして、run で実行できるコンパイル済みのファイルに書く。
+// -o を省略すると、ファイルの拡張子を .slc に替えた名前で書く。
+func runBuild(args []string) int {
+	flags := flag.NewFlagSet("build", flag.ContinueOnError)
+	output := flags.String("o", "", "write the compiled program to `file`")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: sample-lang build [-o file] file\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	path := flags.Arg(0)
+	src, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "build: %s\n", err)
+		return 1
+	}
+
+	p := parser.NewParser(lexer.NewLexer(string(src)))
+	program := p.ParseProgram()
+	if errs := p.ErrorList(); len(errs) != 0 {
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "%s:%s\n", path, err)
+		}
+		return 1
+	}
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+		return 1
+	}
+
+	var buf bytes.Buffer
+	if _, err := comp.Bytecode().WriteTo(&buf); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+		return 1
+	}
+
+	out := *output
+	if out == "" {
+		out = strings.TrimSuffix(path, eval.Ext) + compiledExt
+	}
+	if err := os.WriteFile(out, buf.Bytes(), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "build: %s\n", err)
+		return 1
+	}
+	return 0
+}
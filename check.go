@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ei1chi/sample-lang/ast"
+	"github.com/ei1chi/sample-lang/lexer"
+	"github.com/ei1chi/sample-lang/parser"
+	"github.com/ei1chi/sample-lang/types"
+)
+
+// runCheck sample-lang check [files...]
+// 型を推論し、トップレベルの let と export let の型を標準出力に書く。型の誤りは標準エラー出力に書き、終了コードは 1。
+func runCheck(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: sample-lang check [files...]\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if flags.NArg() == 0 {
+		src, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "check: %s\n", err)
+			return 1
+		}
+		return checkSource("<stdin>", src)
+	}
+
+	status := 0
+	for _, path := range flags.Args() {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "check: %s\n", err)
+			status = 1
+			continue
+		}
+		if s := checkSource(path, src); s != 0 {
+			status = s
+		}
+	}
+	return status
+}
+
+func checkSource(path string, src []byte) int {
+	p := parser.NewParser(lexer.NewLexer(string(src)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		for _, msg := range p.Errors() {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, msg)
+		}
+		return 1
+	}
+
+	info, errs := types.Infer(program)
+	for _, stmt := range program.Stmts {
+		if export, ok := stmt.(*ast.ExportStmt); ok {
+			stmt = export.Let
+		}
+		if let, ok := stmt.(*ast.LetStmt); ok {
+			fmt.Printf("%s:%s: %s: %s\n", path, let.Name.Pos(), let.Name.Value, info.Defs[let.Name])
+		}
+	}
+
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "%s:%s\n", path, err)
+	}
+	if len(errs) != 0 {
+		return 1
+	}
+	return 0
+}
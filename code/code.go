@@ -0,0 +1,242 @@
+package code
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ei1chi/sample-lang/token"
+)
+
+type Instructions []byte
+
+func (ins Instructions) String() string {
+	var out strings.Builder
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+
+		i += 1 + read
+	}
+
+	return out.String()
+}
+
+func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
+	if len(operands) != len(def.OperandWidths) {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n", len(operands), len(def.OperandWidths))
+	}
+
+	switch len(operands) {
+	case 0:
+		return def.Name
+	case 1:
+		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
+	}
+
+	return fmt.Sprintf("ERROR: unhandled operand count for %s\n", def.Name)
+}
+
+type Opcode byte
+
+const (
+	OpConstant Opcode = iota
+	OpPop
+
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+
+	OpTrue
+	OpFalse
+	OpNull
+
+	OpEqual
+	OpNotEqual
+	OpGreaterThan
+	OpLessThan
+
+	OpMinus
+	OpBang
+
+	OpJumpNotTruthy
+	OpJump
+
+	OpGetGlobal
+	OpSetGlobal
+	OpGetLocal
+	OpSetLocal
+	OpGetFree
+	OpGetName
+	OpCurrentClosure
+
+	OpClosure
+	OpCall
+	OpReturnValue
+	OpReturn
+
+	OpArray
+	OpMap
+	OpIndex
+	OpSelect
+	OpImport
+
+	OpThrow
+	OpPushHandler
+	OpPopHandler
+	OpJumpNotErrorValue
+)
+
+// Definition 命令の名前と、各オペランドのバイト幅。
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{2}},
+	OpPop:      {"OpPop", []int{}},
+
+	OpAdd: {"OpAdd", []int{}},
+	OpSub: {"OpSub", []int{}},
+	OpMul: {"OpMul", []int{}},
+	OpDiv: {"OpDiv", []int{}},
+
+	OpTrue:  {"OpTrue", []int{}},
+	OpFalse: {"OpFalse", []int{}},
+	OpNull:  {"OpNull", []int{}},
+
+	OpEqual:       {"OpEqual", []int{}},
+	OpNotEqual:    {"OpNotEqual", []int{}},
+	OpGreaterThan: {"OpGreaterThan", []int{}},
+	OpLessThan:    {"OpLessThan", []int{}},
+
+	OpMinus: {"OpMinus", []int{}},
+	OpBang:  {"OpBang", []int{}},
+
+	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
+	OpJump:          {"OpJump", []int{2}},
+
+	OpGetGlobal:      {"OpGetGlobal", []int{2}},
+	OpSetGlobal:      {"OpSetGlobal", []int{2}},
+	OpGetLocal:       {"OpGetLocal", []int{1}},
+	OpSetLocal:       {"OpSetLocal", []int{1}},
+	OpGetFree:        {"OpGetFree", []int{1}},
+	OpGetName:        {"OpGetName", []int{2}},
+	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+
+	OpClosure:     {"OpClosure", []int{2, 1}},
+	OpCall:        {"OpCall", []int{1}},
+	OpReturnValue: {"OpReturnValue", []int{}},
+	OpReturn:      {"OpReturn", []int{}},
+
+	// OpArray n は要素 n 個、OpMap n はキーと値の組 n 個をスタックから下ろす
+	OpArray:  {"OpArray", []int{2}},
+	OpMap:    {"OpMap", []int{2}},
+	OpIndex:  {"OpIndex", []int{}},
+	OpSelect: {"OpSelect", []int{2}}, // 選ぶ名前の名前表での番号
+	OpImport: {"OpImport", []int{2}}, // パスの文字列の定数の番号
+
+	// OpPushHandler はエラーが起きたら飛ぶ先を積む。飛ぶときはエラーの値を一つ積む
+	OpThrow:             {"OpThrow", []int{}},
+	OpPushHandler:       {"OpPushHandler", []int{2}},
+	OpPopHandler:        {"OpPopHandler", []int{}},
+	OpJumpNotErrorValue: {"OpJumpNotErrorValue", []int{2}},
+}
+
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+
+	return def, nil
+}
+
+// Make 命令とオペランドをバイト列にする。オペランドはビッグエンディアン。
+// 幅に収まらないオペランドは切り詰めるので、収まるかは呼び出し側で確かめる。
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	instructionLen := 1
+	for _, w := range def.OperandWidths {
+		instructionLen += w
+	}
+
+	instruction := make([]byte, instructionLen)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		switch width {
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
+		}
+		offset += width
+	}
+
+	return instruction
+}
+
+// ReadOperands Make の逆。読み取ったオペランドと、読んだバイト数を返す。
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+		offset += width
+	}
+
+	return operands, offset
+}
+
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
+
+func ReadUint8(ins Instructions) uint8 {
+	return uint8(ins[0])
+}
+
+// SourcePos Offset 以降の命令を生成したソースの位置。
+type SourcePos struct {
+	Offset int
+	Pos    token.Position
+}
+
+// PosTable 命令の位置からソースの位置を引く表。Offset の昇順に並ぶ。
+type PosTable []SourcePos
+
+// Lookup offset の命令を生成したソースの位置を返す。
+func (t PosTable) Lookup(offset int) token.Position {
+	i := sort.Search(len(t), func(i int) bool { return t[i].Offset > offset })
+	if i == 0 {
+		return token.Position{}
+	}
+	return t[i-1].Pos
+}
@@ -0,0 +1,670 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/ei1chi/sample-lang/ast"
+	"github.com/ei1chi/sample-lang/code"
+	"github.com/ei1chi/sample-lang/object"
+	"github.com/ei1chi/sample-lang/token"
+)
+
+// Bytecode コンパイル結果。仮想マシンはこれだけを見て実行する。
+type Bytecode struct {
+	Instructions code.Instructions
+	Positions    code.PosTable
+	Constants    []object.Object
+
+	// Globals グローバル変数のスロットごとの名前。
+	// トップレベルの catch の中で定義した変数は外から名前で引けないので空文字列にする。
+	Globals []string
+	// Names OpGetName で実行時に名前から引く識別子。
+	Names []string
+}
+
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int
+}
+
+// CompilationScope 関数ごとの命令列。
+type CompilationScope struct {
+	instructions        code.Instructions
+	positions           code.PosTable
+	lastInstruction     EmittedInstruction
+	previousInstruction EmittedInstruction
+
+	// tries 今コンパイルしている位置で有効なエラーハンドラ。内側が後ろに並ぶ
+	tries []activeTry
+}
+
+// activeTry try の本体か、finally のある catch の中。return で抜けるときは
+// ハンドラを外してから finally を実行する。
+type activeTry struct {
+	finally *ast.BlockStmt // nil なら finally はない
+}
+
+type Compiler struct {
+	constants []object.Object
+	ints      map[int64]int
+
+	symbolTable *SymbolTable
+	globals     []string
+	names       []string
+	nameIndex   map[string]int
+
+	scopes     []CompilationScope
+	scopeIndex int
+
+	pos token.Position // 今コンパイルしているノードの位置
+
+	// err オペランドに収まらない番号や飛び先。emit は値を返さないので、ここに残して Compile で返す
+	err error
+}
+
+func New() *Compiler {
+	return &Compiler{
+		constants:   []object.Object{},
+		ints:        make(map[int64]int),
+		symbolTable: NewSymbolTable(),
+		nameIndex:   make(map[string]int),
+		scopes:      []CompilationScope{{instructions: code.Instructions{}}},
+	}
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{
+		Instructions: c.currentInstructions(),
+		Positions:    c.scopes[c.scopeIndex].positions,
+		Constants:    c.constants,
+		Globals:      c.globals,
+		Names:        c.names,
+	}
+}
+
+func (c *Compiler) Compile(node ast.Node) (err error) {
+	if pos := node.Pos(); pos.IsValid() {
+		outer := c.pos
+		c.pos = pos
+		defer func() { c.pos = outer }()
+	}
+	defer func() {
+		if err == nil {
+			err = c.err
+		}
+	}()
+
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, s := range node.Stmts {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+
+	case *ast.ExprStmt:
+		if err := c.Compile(node.Expr); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+
+	case *ast.BlockStmt:
+		for _, s := range node.Stmts {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+
+	case *ast.LetStmt:
+		var err error
+		if fl, ok := node.Value.(*ast.FuncLiteral); ok {
+			err = c.compileFunc(fl, node.Name.Value)
+		} else {
+			err = c.Compile(node.Value)
+		}
+		if err != nil {
+			return err
+		}
+
+		c.setSymbol(c.symbolTable.Define(node.Name.Value))
+
+	case *ast.ReturnStmt:
+		if err := c.Compile(node.ReturnValue); err != nil {
+			return err
+		}
+		return c.compileReturn()
+
+	case *ast.ImportStmt:
+		c.emit(code.OpImport, c.addConstant(&object.String{Value: node.Path.Value}))
+		c.setSymbol(c.symbolTable.Define(node.Name.Value))
+
+	case *ast.ExportStmt:
+		// 外から見えるかどうかは import した側で決まるので、ここでは let と同じ
+		return c.Compile(node.Let)
+
+	case *ast.ThrowStmt:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+
+	case *ast.TryExpr:
+		return c.compileTryExpr(node)
+
+	case *ast.IfExpr:
+		return c.compileIfExpr(node)
+
+	case *ast.PrefixExpr:
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+
+		switch node.Operator {
+		case "!":
+			c.emit(code.OpBang)
+		case "-":
+			c.emit(code.OpMinus)
+		default:
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+
+	case *ast.InfixExpr:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+
+		op, ok := infixOpcodes[node.Operator]
+		if !ok {
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+		c.emit(op)
+
+	case *ast.PostfixExpr:
+		if node.Operator != "?" {
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+
+		// エラーの値でなければそのまま式の値にし、エラーの値なら return する
+		jumpPos := c.emit(code.OpJumpNotErrorValue, 9999)
+		if err := c.compileReturn(); err != nil {
+			return err
+		}
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	case *ast.IndexExpr:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		c.emit(code.OpIndex)
+
+	case *ast.SelectorExpr:
+		if err := c.Compile(node.X); err != nil {
+			return err
+		}
+		c.emit(code.OpSelect, c.addName(node.Sel.Value))
+
+	case *ast.IntLiteral:
+		c.emit(code.OpConstant, c.addInteger(node.Value))
+
+	case *ast.StringLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: node.Value}))
+
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elems {
+			if err := c.Compile(el); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpArray, len(node.Elems))
+
+	case *ast.MapLiteral:
+		for i := range node.Keys {
+			if err := c.Compile(node.Keys[i]); err != nil {
+				return err
+			}
+			if err := c.Compile(node.Values[i]); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpMap, len(node.Keys))
+
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+
+	case *ast.Ident:
+		symbol, ok := c.symbolTable.Resolve(node.Value)
+		if !ok {
+			// 後から定義されるグローバル変数か組み込み関数。実行時に名前で引く
+			c.emit(code.OpGetName, c.addName(node.Value))
+			return nil
+		}
+		c.loadSymbol(symbol)
+
+	case *ast.FuncLiteral:
+		return c.compileFunc(node, "")
+
+	case *ast.CallExpr:
+		if err := c.Compile(node.Fn); err != nil {
+			return err
+		}
+
+		for _, a := range node.Args {
+			if err := c.Compile(a); err != nil {
+				return err
+			}
+		}
+
+		if len(node.Args) > 255 {
+			return fmt.Errorf("too many arguments: %d", len(node.Args))
+		}
+		c.emit(code.OpCall, len(node.Args))
+
+	default:
+		return fmt.Errorf("cannot compile %T", node)
+	}
+
+	return nil
+}
+
+var infixOpcodes = map[string]code.Opcode{
+	"+":  code.OpAdd,
+	"-":  code.OpSub,
+	"*":  code.OpMul,
+	"/":  code.OpDiv,
+	">":  code.OpGreaterThan,
+	"<":  code.OpLessThan,
+	"==": code.OpEqual,
+	"!=": code.OpNotEqual,
+}
+
+// IF の値はスタックに一つ残す。値のないブロックは null になる。
+func (c *Compiler) compileIfExpr(node *ast.IfExpr) error {
+	if err := c.Compile(node.Cond); err != nil {
+		return err
+	}
+
+	// 飛び先は後で書き換える
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	if err := c.compileBranch(node.Cons); err != nil {
+		return err
+	}
+
+	jumpPos := c.emit(code.OpJump, 9999)
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+	if node.Alt == nil {
+		c.emit(code.OpNull)
+	} else if err := c.compileBranch(node.Alt); err != nil {
+		return err
+	}
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	return nil
+}
+
+func (c *Compiler) compileBranch(block *ast.BlockStmt) error {
+	if err := c.Compile(block); err != nil {
+		return err
+	}
+
+	if c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
+
+	return nil
+}
+
+// compileReturn スタックの一番上の値を返す。try の中なら、内側から順に
+// ハンドラを外して finally を実行してから返す。
+func (c *Compiler) compileReturn() error {
+	tries := c.scopes[c.scopeIndex].tries
+	defer func() { c.scopes[c.scopeIndex].tries = tries }()
+
+	for i := len(tries) - 1; i >= 0; i-- {
+		c.emit(code.OpPopHandler)
+		if tries[i].finally == nil {
+			continue
+		}
+		// finally の中の return は、外側の try だけを抜ける
+		c.scopes[c.scopeIndex].tries = tries[:i]
+		if err := c.Compile(tries[i].finally); err != nil {
+			return err
+		}
+	}
+
+	c.emit(code.OpReturnValue)
+	return nil
+}
+
+// compileTryExpr 本体か catch の値をスタックに一つ残す。
+// 本体で起きたエラーはハンドラで catch へ飛び、catch か finally がなければ finally を実行してから投げ直す。
+//
+//	OpPushHandler catch       本体のハンドラ
+//	本体
+//	OpPopHandler
+//	OpJump finally
+//	catch: 引数を束縛          エラーの値が一つ積まれている
+//	OpPushHandler rethrow     finally があるときだけ
+//	catch の本体
+//	OpPopHandler
+//	finally: finally; OpJump end
+//	rethrow: finally; OpThrow エラーの値を投げ直す
+//	end:
+func (c *Compiler) compileTryExpr(node *ast.TryExpr) error {
+	try := activeTry{finally: node.Finally}
+
+	// catch がなければ、本体のハンドラは finally を実行して投げ直す
+	handlerPos := c.emit(code.OpPushHandler, 9999)
+	if err := c.compileInTry(node.Body, try); err != nil {
+		return err
+	}
+	c.emit(code.OpPopHandler)
+	jumpPos := c.emit(code.OpJump, 9999)
+
+	rethrowPos := -1
+	if node.Catch != nil {
+		c.changeOperand(handlerPos, len(c.currentInstructions()))
+
+		// catch の引数と catch の中の let は外に漏らさない
+		c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+		c.setSymbol(c.symbolTable.Define(node.Param.Value))
+
+		var err error
+		if node.Finally != nil {
+			rethrowPos = c.emit(code.OpPushHandler, 9999)
+			err = c.compileInTry(node.Catch, try)
+			c.emit(code.OpPopHandler)
+		} else {
+			err = c.compileBranch(node.Catch)
+		}
+		c.symbolTable = c.symbolTable.Outer
+		if err != nil {
+			return err
+		}
+	} else {
+		rethrowPos = handlerPos
+	}
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	if node.Finally == nil {
+		return nil
+	}
+
+	if err := c.Compile(node.Finally); err != nil {
+		return err
+	}
+	endPos := c.emit(code.OpJump, 9999)
+
+	c.changeOperand(rethrowPos, len(c.currentInstructions()))
+	if err := c.Compile(node.Finally); err != nil {
+		return err
+	}
+	c.emit(code.OpThrow)
+
+	c.changeOperand(endPos, len(c.currentInstructions()))
+	return nil
+}
+
+// compileInTry ハンドラを付けたまま block を値として実行する。
+func (c *Compiler) compileInTry(block *ast.BlockStmt, try activeTry) error {
+	tries := c.scopes[c.scopeIndex].tries
+	// compileReturn が途中まで縮めた tries の後ろを書き換えないよう、いつも新しく確保する
+	c.scopes[c.scopeIndex].tries = append(tries[:len(tries):len(tries)], try)
+	err := c.compileBranch(block)
+	c.scopes[c.scopeIndex].tries = tries
+	return err
+}
+
+func (c *Compiler) compileFunc(fl *ast.FuncLiteral, name string) error {
+	c.enterScope()
+
+	if name != "" {
+		c.symbolTable.DefineFunctionName(name)
+	}
+
+	for _, p := range fl.Params {
+		c.symbolTable.Define(p.Value)
+	}
+
+	if err := c.Compile(fl.Body); err != nil {
+		return err
+	}
+
+	// 最後の式の値を返り値にする
+	if c.lastInstructionIs(code.OpPop) {
+		c.replaceLastPopWithReturn()
+	}
+	if !c.lastInstructionIs(code.OpReturnValue) {
+		c.emit(code.OpReturn)
+	}
+
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.numDefinitions
+	positions := c.scopes[c.scopeIndex].positions
+	instructions := c.leaveScope()
+
+	if numLocals > 256 {
+		return fmt.Errorf("too many local variables: %d", numLocals)
+	}
+
+	for _, s := range freeSymbols {
+		c.loadSymbol(s)
+	}
+
+	compiledFn := &object.CompiledFunction{
+		Instructions: instructions,
+		Positions:    positions,
+		NumLocals:    numLocals,
+		NumParams:    len(fl.Params),
+		Name:         name,
+	}
+
+	fnIndex := c.addConstant(compiledFn)
+	c.emit(code.OpClosure, fnIndex, len(freeSymbols))
+
+	return nil
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
+
+// setSymbol スタックの一番上の値を s に入れる。
+func (c *Compiler) setSymbol(s Symbol) {
+	if s.Scope == GlobalScope {
+		c.defineGlobal(s)
+		c.emit(code.OpSetGlobal, s.Index)
+	} else {
+		c.emit(code.OpSetLocal, s.Index)
+	}
+}
+
+func (c *Compiler) defineGlobal(s Symbol) {
+	if s.Index == len(c.globals) {
+		name := s.Name
+		if c.symbolTable.Block {
+			name = ""
+		}
+		c.globals = append(c.globals, name)
+	}
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+// addInteger 同じ値の整数は定数プールで共有する。
+func (c *Compiler) addInteger(value int64) int {
+	if i, ok := c.ints[value]; ok {
+		return i
+	}
+	i := c.addConstant(&object.Integer{Value: value})
+	c.ints[value] = i
+	return i
+}
+
+func (c *Compiler) addName(name string) int {
+	if i, ok := c.nameIndex[name]; ok {
+		return i
+	}
+	c.names = append(c.names, name)
+	c.nameIndex[name] = len(c.names) - 1
+	return len(c.names) - 1
+}
+
+// =========================================
+// Instructions
+// =========================================
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	c.checkOperands(op, operands)
+	ins := code.Make(op, operands...)
+	pos := c.addInstruction(ins)
+
+	c.setLastInstruction(op, pos)
+	c.addPosition(pos)
+
+	return pos
+}
+
+// addPosition ソースの位置が変わったときだけ位置表に追加する。
+func (c *Compiler) addPosition(offset int) {
+	scope := &c.scopes[c.scopeIndex]
+	if n := len(scope.positions); n > 0 && scope.positions[n-1].Pos == c.pos {
+		return
+	}
+	scope.positions = append(scope.positions, code.SourcePos{Offset: offset, Pos: c.pos})
+}
+
+func (c *Compiler) addInstruction(ins []byte) int {
+	posNewInstruction := len(c.currentInstructions())
+	c.scopes[c.scopeIndex].instructions = append(c.currentInstructions(), ins...)
+	return posNewInstruction
+}
+
+func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
+	previous := c.scopes[c.scopeIndex].lastInstruction
+	last := EmittedInstruction{Opcode: op, Position: pos}
+
+	c.scopes[c.scopeIndex].previousInstruction = previous
+	c.scopes[c.scopeIndex].lastInstruction = last
+}
+
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+}
+
+func (c *Compiler) removeLastPop() {
+	last := c.scopes[c.scopeIndex].lastInstruction
+	previous := c.scopes[c.scopeIndex].previousInstruction
+
+	c.scopes[c.scopeIndex].instructions = c.currentInstructions()[:last.Position]
+	c.scopes[c.scopeIndex].lastInstruction = previous
+
+	positions := c.scopes[c.scopeIndex].positions
+	for len(positions) > 0 && positions[len(positions)-1].Offset >= last.Position {
+		positions = positions[:len(positions)-1]
+	}
+	c.scopes[c.scopeIndex].positions = positions
+}
+
+func (c *Compiler) replaceLastPopWithReturn() {
+	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
+	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
+	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
+}
+
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+	copy(ins[pos:], newInstruction)
+}
+
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	op := code.Opcode(c.currentInstructions()[opPos])
+	c.checkOperands(op, []int{operand})
+	newInstruction := code.Make(op, operand)
+
+	c.replaceInstruction(opPos, newInstruction)
+}
+
+// checkOperands code.Make は幅に収まらないオペランドを黙って切り詰めるので、その前に調べる。
+// 2 バイトのオペランドなら 65535 まで。
+func (c *Compiler) checkOperands(op code.Opcode, operands []int) {
+	if c.err != nil {
+		return
+	}
+	def, err := code.Lookup(byte(op))
+	if err != nil {
+		c.err = err
+		return
+	}
+
+	for i, o := range operands {
+		if o < 1<<(8*def.OperandWidths[i]) {
+			continue
+		}
+		switch {
+		case op == code.OpConstant || op == code.OpImport || op == code.OpClosure && i == 0:
+			c.err = fmt.Errorf("too many constants: %d", o+1)
+		case op == code.OpGetGlobal || op == code.OpSetGlobal:
+			c.err = fmt.Errorf("too many global variables: %d", o+1)
+		case op == code.OpJump || op == code.OpJumpNotTruthy || op == code.OpJumpNotErrorValue || op == code.OpPushHandler:
+			c.err = fmt.Errorf("program too large: jump target %d out of range", o)
+		default:
+			c.err = fmt.Errorf("%s operand %d does not fit in %d bytes", def.Name, o, def.OperandWidths[i])
+		}
+		return
+	}
+}
+
+func (c *Compiler) enterScope() {
+	c.scopes = append(c.scopes, CompilationScope{instructions: code.Instructions{}})
+	c.scopeIndex++
+
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() code.Instructions {
+	instructions := c.currentInstructions()
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+
+	c.symbolTable = c.symbolTable.Outer
+
+	return instructions
+}
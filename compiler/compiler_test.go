@@ -0,0 +1,293 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ei1chi/sample-lang/code"
+	"github.com/ei1chi/sample-lang/lexer"
+	"github.com/ei1chi/sample-lang/object"
+	"github.com/ei1chi/sample-lang/parser"
+)
+
+func testCompile(t *testing.T, input string) *Bytecode {
+	l := lexer.NewLexer(input)
+	p := parser.NewParser(l)
+	program := p.ParseProgram()
+
+	c := New()
+	if err := c.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return c.Bytecode()
+}
+
+func concatInstructions(s ...[]byte) code.Instructions {
+	out := code.Instructions{}
+	for _, ins := range s {
+		out = append(out, ins...)
+	}
+	return out
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected code.Instructions
+	}{
+		{
+			"1 + 2",
+			concatInstructions(
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			),
+		},
+		{
+			"1 < 2",
+			concatInstructions(
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpLessThan),
+				code.Make(code.OpPop),
+			),
+		},
+		{
+			"-1; !true",
+			concatInstructions(
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMinus),
+				code.Make(code.OpPop),
+				code.Make(code.OpTrue),
+				code.Make(code.OpBang),
+				code.Make(code.OpPop),
+			),
+		},
+		{
+			"let x = 1 + 2; if (x > 1) { x } else { 3 }",
+			concatInstructions(
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpGreaterThan),
+				code.Make(code.OpJumpNotTruthy, 26),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpJump, 29),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpPop),
+			),
+		},
+		{
+			"if (true) { 1 }",
+			concatInstructions(
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 10),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpJump, 11),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			),
+		},
+		{
+			"foo(1)",
+			concatInstructions(
+				code.Make(code.OpGetName, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			),
+		},
+		{
+			`{"a": [1]}["a"]`,
+			concatInstructions(
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpMap, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpIndex),
+				code.Make(code.OpPop),
+			),
+		},
+		{
+			"try { 1 } catch (e) { e }",
+			concatInstructions(
+				code.Make(code.OpPushHandler, 10),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPopHandler),
+				code.Make(code.OpJump, 16),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			),
+		},
+	}
+
+	for _, test := range tests {
+		bytecode := testCompile(t, test.input)
+		if bytecode.Instructions.String() != test.expected.String() {
+			t.Errorf("%q: wrong instructions.\nwant=%s\ngot=%s", test.input, test.expected, bytecode.Instructions)
+		}
+	}
+}
+
+func TestCompileClosures(t *testing.T) {
+	bytecode := testCompile(t, "fn(a) { fn(b) { a + b } }")
+
+	inner, ok := bytecode.Constants[0].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("constant 0 is not CompiledFunction. got=%T", bytecode.Constants[0])
+	}
+	expected := concatInstructions(
+		code.Make(code.OpGetFree, 0),
+		code.Make(code.OpGetLocal, 0),
+		code.Make(code.OpAdd),
+		code.Make(code.OpReturnValue),
+	)
+	if inner.Instructions.String() != expected.String() {
+		t.Errorf("wrong inner instructions.\nwant=%s\ngot=%s", expected, inner.Instructions)
+	}
+
+	outer := bytecode.Constants[1].(*object.CompiledFunction)
+	expected = concatInstructions(
+		code.Make(code.OpGetLocal, 0),
+		code.Make(code.OpClosure, 0, 1),
+		code.Make(code.OpReturnValue),
+	)
+	if outer.Instructions.String() != expected.String() {
+		t.Errorf("wrong outer instructions.\nwant=%s\ngot=%s", expected, outer.Instructions)
+	}
+}
+
+func TestSymbolTable(t *testing.T) {
+	global := NewSymbolTable()
+	a := global.Define("a")
+	if a != (Symbol{Name: "a", Scope: GlobalScope, Index: 0}) {
+		t.Errorf("wrong symbol for a. got=%+v", a)
+	}
+	if again := global.Define("a"); again != a {
+		t.Errorf("redefinition should reuse slot. got=%+v", again)
+	}
+
+	local := NewEnclosedSymbolTable(global)
+	local.Define("b")
+	inner := NewEnclosedSymbolTable(local)
+	inner.Define("c")
+
+	tests := []struct {
+		name     string
+		expected Symbol
+	}{
+		{"a", Symbol{Name: "a", Scope: GlobalScope, Index: 0}},
+		{"b", Symbol{Name: "b", Scope: FreeScope, Index: 0}},
+		{"c", Symbol{Name: "c", Scope: LocalScope, Index: 0}},
+	}
+
+	for _, test := range tests {
+		sym, ok := inner.Resolve(test.name)
+		if !ok {
+			t.Errorf("name %s not resolvable", test.name)
+			continue
+		}
+		if sym != test.expected {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", test.name, test.expected, sym)
+		}
+	}
+
+	if _, ok := inner.Resolve("d"); ok {
+		t.Errorf("name d resolved, but was not defined")
+	}
+}
+
+func TestCompileTryInFunction(t *testing.T) {
+	bytecode := testCompile(t, "fn() { try { return 1 } finally { 2 } }")
+
+	// return の前にハンドラを外して finally を実行する
+	fn := bytecode.Constants[2].(*object.CompiledFunction)
+	expected := concatInstructions(
+		code.Make(code.OpPushHandler, 24),
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpPopHandler),
+		code.Make(code.OpConstant, 1),
+		code.Make(code.OpPop),
+		code.Make(code.OpReturnValue),
+		code.Make(code.OpNull),
+		code.Make(code.OpPopHandler),
+		code.Make(code.OpJump, 17),
+		code.Make(code.OpConstant, 1),
+		code.Make(code.OpPop),
+		code.Make(code.OpJump, 29),
+		code.Make(code.OpConstant, 1),
+		code.Make(code.OpPop),
+		code.Make(code.OpThrow),
+		code.Make(code.OpReturnValue),
+	)
+	if fn.Instructions.String() != expected.String() {
+		t.Errorf("wrong instructions.\nwant=%s\ngot=%s", expected, fn.Instructions)
+	}
+}
+
+func TestBlockSymbolTable(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	local := NewEnclosedSymbolTable(global)
+	local.Define("b")
+
+	// catch の変数は関数のスロットを使い、外側の変数は自由変数にしない
+	block := NewBlockSymbolTable(local)
+	if e := block.Define("e"); e != (Symbol{Name: "e", Scope: LocalScope, Index: 1}) {
+		t.Errorf("wrong symbol for e. got=%+v", e)
+	}
+	if b, _ := block.Resolve("b"); b != (Symbol{Name: "b", Scope: LocalScope, Index: 0}) {
+		t.Errorf("wrong symbol for b. got=%+v", b)
+	}
+
+	if _, ok := local.Resolve("e"); ok {
+		t.Errorf("name e resolved outside the block")
+	}
+	if c := local.Define("c"); c.Index != 2 {
+		t.Errorf("slot of e reused. got=%+v", c)
+	}
+}
+
+// 2 バイトのオペランドに収まらない番号や飛び先は、切り詰めずにエラーにする。
+func TestCompileOperandLimits(t *testing.T) {
+	var lets, ints strings.Builder
+	for i := 0; i < 70000; i++ {
+		// 識別子に数字は使えないので、番号を英字で書く
+		name := []byte{'a' + byte(i/26/26/26), 'a' + byte(i/26/26%26), 'a' + byte(i/26%26), 'a' + byte(i%26)}
+		fmt.Fprintf(&lets, "let %s = 0;", name)
+		fmt.Fprintf(&ints, "%d;", i)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{lets.String(), "too many global variables: 65537"},
+		{ints.String(), "too many constants: 65537"},
+		{"if (true) {" + strings.Repeat("1;", 20000) + "}", "program too large: jump target 80006 out of range"},
+	}
+
+	for _, test := range tests {
+		p := parser.NewParser(lexer.NewLexer(test.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("parser errors: %v", p.Errors()[0])
+		}
+
+		err := New().Compile(program)
+		if err == nil {
+			t.Errorf("expected error %q", test.expected)
+			continue
+		}
+		if err.Error() != test.expected {
+			t.Errorf("wrong error. expected=%q, got=%q", test.expected, err.Error())
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/ei1chi/sample-lang/code"
+	"github.com/ei1chi/sample-lang/object"
+	"github.com/ei1chi/sample-lang/token"
+)
+
+// コンパイル済みファイルの形式
+//
+//	magic    "SLBC"
+//	version  uint16（ビッグエンディアン）
+//	payload  命令列、位置表、グローバル変数名、名前表、定数プール
+//	checksum magic から payload までの CRC-32（IEEE）、uint32
+//
+// payload の整数は可変長（encoding/binary の varint）で書く。
+const (
+	Magic         = "SLBC"
+	FormatVersion = 3
+)
+
+// 定数プールの要素の種類
+const (
+	constInteger byte = iota + 1
+	constCompiledFunction
+	constString
+)
+
+var (
+	ErrBadMagic = errors.New("not a compiled program: bad magic header")
+	ErrChecksum = errors.New("compiled program is corrupt: checksum mismatch")
+)
+
+// VersionError 対応していない形式のバージョンを読み込もうとした。
+type VersionError struct {
+	Version int
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("incompatible compiled program: format version %d, want %d (recompile the source)", e.Version, FormatVersion)
+}
+
+// WriteTo b をコンパイル済みファイルの形式で w に書く。
+func (b *Bytecode) WriteTo(w io.Writer) (int64, error) {
+	enc := &encoder{}
+	enc.buf.WriteString(Magic)
+	enc.buf.Write(binary.BigEndian.AppendUint16(nil, FormatVersion))
+
+	enc.instructions(b.Instructions)
+	enc.positions(b.Positions)
+	enc.strings(b.Globals)
+	enc.strings(b.Names)
+
+	enc.uvarint(uint64(len(b.Constants)))
+	for _, c := range b.Constants {
+		if err := enc.constant(c); err != nil {
+			return 0, err
+		}
+	}
+
+	sum := crc32.ChecksumIEEE(enc.buf.Bytes())
+	enc.buf.Write(binary.BigEndian.AppendUint32(nil, sum))
+
+	return enc.buf.WriteTo(w)
+}
+
+// ReadBytecode WriteTo で書いたコンパイル済みファイルを読み込む。
+// 仮想マシンが範囲外を読まないよう、命令列が指す番号や飛び先も確かめる。
+func ReadBytecode(r io.Reader) (*Bytecode, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	headerLen := len(Magic) + 2
+	if len(data) < headerLen || string(data[:len(Magic)]) != Magic {
+		return nil, ErrBadMagic
+	}
+
+	// 形式が変わるとチェックサムの位置も変わりうるので、バージョンを先に確かめる
+	version := int(binary.BigEndian.Uint16(data[len(Magic):]))
+	if version != FormatVersion {
+		return nil, &VersionError{Version: version}
+	}
+
+	if len(data) < headerLen+4 {
+		return nil, ErrChecksum
+	}
+	body, sum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, ErrChecksum
+	}
+
+	dec := &decoder{data: body[headerLen:]}
+	b := &Bytecode{}
+	b.Instructions = dec.instructions()
+	b.Positions = dec.positions()
+	b.Globals = dec.strings()
+	b.Names = dec.strings()
+
+	n := dec.length()
+	b.Constants = make([]object.Object, 0, n)
+	for i := 0; i < n && dec.err == nil; i++ {
+		b.Constants = append(b.Constants, dec.constant())
+	}
+
+	if dec.err == nil && len(dec.data) != 0 {
+		dec.err = fmt.Errorf("%d bytes of trailing data", len(dec.data))
+	}
+	if dec.err != nil {
+		return nil, fmt.Errorf("malformed compiled program: %w", dec.err)
+	}
+
+	if err := b.verify(); err != nil {
+		return nil, fmt.Errorf("invalid compiled program: %w", err)
+	}
+	return b, nil
+}
+
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) uvarint(v uint64) {
+	e.buf.Write(binary.AppendUvarint(nil, v))
+}
+
+func (e *encoder) varint(v int64) {
+	e.buf.Write(binary.AppendVarint(nil, v))
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.buf.Write(b)
+}
+
+func (e *encoder) instructions(ins code.Instructions) {
+	e.bytes(ins)
+}
+
+func (e *encoder) positions(t code.PosTable) {
+	e.uvarint(uint64(len(t)))
+	for _, p := range t {
+		e.uvarint(uint64(p.Offset))
+		e.uvarint(uint64(p.Pos.Line))
+		e.uvarint(uint64(p.Pos.Column))
+	}
+}
+
+func (e *encoder) strings(ss []string) {
+	e.uvarint(uint64(len(ss)))
+	for _, s := range ss {
+		e.bytes([]byte(s))
+	}
+}
+
+func (e *encoder) constant(obj object.Object) error {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		e.buf.WriteByte(constInteger)
+		e.varint(obj.Value)
+	case *object.CompiledFunction:
+		e.buf.WriteByte(constCompiledFunction)
+		e.uvarint(uint64(obj.NumLocals))
+		e.uvarint(uint64(obj.NumParams))
+		e.instructions(obj.Instructions)
+		e.positions(obj.Positions)
+		e.bytes([]byte(obj.Name))
+	case *object.String:
+		e.buf.WriteByte(constString)
+		e.bytes([]byte(obj.Value))
+	default:
+		return fmt.Errorf("cannot serialize constant of type %s", obj.Type())
+	}
+	return nil
+}
+
+// decoder 最初のエラーを覚えておき、以降の読み込みはゼロ値を返す。
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) fail(format string, a ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf(format, a...)
+	}
+	d.data = nil
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.fail("invalid varint")
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.fail("invalid varint")
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+// length 要素数を読む。残りのバイト数より多ければ壊れている。
+func (d *decoder) length() int {
+	n := d.uvarint()
+	if n > uint64(len(d.data)) {
+		d.fail("length %d exceeds remaining %d bytes", n, len(d.data))
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.length()
+	if d.err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	copy(b, d.data[:n])
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.data) == 0 {
+		d.fail("unexpected end of data")
+		return 0
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
+
+func (d *decoder) instructions() code.Instructions {
+	return code.Instructions(d.bytes())
+}
+
+func (d *decoder) positions() code.PosTable {
+	n := d.length()
+	t := make(code.PosTable, 0, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		offset := int(d.uvarint())
+		line := int(d.uvarint())
+		col := int(d.uvarint())
+		t = append(t, code.SourcePos{Offset: offset, Pos: token.Position{Line: line, Column: col}})
+	}
+	return t
+}
+
+func (d *decoder) strings() []string {
+	n := d.length()
+	ss := make([]string, 0, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		ss = append(ss, string(d.bytes()))
+	}
+	return ss
+}
+
+func (d *decoder) constant() object.Object {
+	switch tag := d.byte(); tag {
+	case constInteger:
+		return &object.Integer{Value: d.varint()}
+	case constCompiledFunction:
+		fn := &object.CompiledFunction{}
+		fn.NumLocals = int(d.uvarint())
+		fn.NumParams = int(d.uvarint())
+		fn.Instructions = d.instructions()
+		fn.Positions = d.positions()
+		fn.Name = string(d.bytes())
+		return fn
+	case constString:
+		return &object.String{Value: string(d.bytes())}
+	default:
+		d.fail("unknown constant tag %d", tag)
+		return nil
+	}
+}
@@ -0,0 +1,209 @@
+package compiler
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ei1chi/sample-lang/code"
+	"github.com/ei1chi/sample-lang/object"
+	"github.com/ei1chi/sample-lang/token"
+)
+
+func TestSerializeRoundTrip(t *testing.T) {
+	input := `let add = fn(x, y) {
+	x + y
+};
+let n = add(1, -2);
+if (n < 0) { foo(n, "negative") } else { 3 }`
+
+	original := testCompile(t, input)
+
+	var buf bytes.Buffer
+	if _, err := original.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo returned error: %s", err)
+	}
+
+	loaded, err := ReadBytecode(&buf)
+	if err != nil {
+		t.Fatalf("ReadBytecode returned error: %s", err)
+	}
+
+	if !bytes.Equal(loaded.Instructions, original.Instructions) {
+		t.Errorf("instructions differ.\nwant=%s\ngot=%s", original.Instructions, loaded.Instructions)
+	}
+	if !reflect.DeepEqual(loaded.Positions, original.Positions) {
+		t.Errorf("positions differ. want=%v, got=%v", original.Positions, loaded.Positions)
+	}
+	if !reflect.DeepEqual(loaded.Globals, original.Globals) || !reflect.DeepEqual(loaded.Names, original.Names) {
+		t.Errorf("names differ. want=%v %v, got=%v %v", original.Globals, original.Names, loaded.Globals, loaded.Names)
+	}
+	if !reflect.DeepEqual(loaded.Constants, original.Constants) {
+		t.Errorf("constants differ. want=%v, got=%v", original.Constants, loaded.Constants)
+	}
+
+	fn := loaded.Constants[0].(*object.CompiledFunction)
+	if pos := fn.Positions.Lookup(0); pos != (token.Position{Line: 2, Column: 2}) {
+		t.Errorf("wrong position of first instruction in add. got=%s", pos)
+	}
+	if fn.Name != "add" {
+		t.Errorf("wrong function name. want=add, got=%q", fn.Name)
+	}
+}
+
+func TestReadBytecodeErrors(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := testCompile(t, "1 + 2").WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo returned error: %s", err)
+	}
+	data := buf.Bytes()
+
+	corrupt := append([]byte{}, data...)
+	corrupt[len(corrupt)-6] ^= 0xff
+
+	newer := append([]byte{}, data...)
+	newer[5] = FormatVersion + 1
+
+	if _, err := ReadBytecode(bytes.NewReader(corrupt)); !errors.Is(err, ErrChecksum) {
+		t.Errorf("expected checksum error. got=%v", err)
+	}
+
+	if _, err := ReadBytecode(bytes.NewReader([]byte("let x = 1;"))); !errors.Is(err, ErrBadMagic) {
+		t.Errorf("expected bad magic error. got=%v", err)
+	}
+
+	_, err := ReadBytecode(bytes.NewReader(newer))
+	var verr *VersionError
+	if !errors.As(err, &verr) || verr.Version != FormatVersion+1 {
+		t.Errorf("expected version error. got=%v", err)
+	}
+}
+
+func TestReadBytecodeVerifies(t *testing.T) {
+	one := &object.Integer{Value: 1}
+	fn := func(numLocals int, ins ...[]byte) *object.CompiledFunction {
+		return &object.CompiledFunction{Instructions: concatInstructions(ins...), NumLocals: numLocals}
+	}
+
+	tests := []struct {
+		bytecode *Bytecode
+		expected string
+	}{
+		{
+			&Bytecode{Instructions: concatInstructions(code.Make(code.OpConstant, 5), code.Make(code.OpPop)), Constants: []object.Object{one}},
+			"main: 0000 OpConstant: constant index 5 out of range (1)",
+		},
+		{
+			&Bytecode{Instructions: concatInstructions(code.Make(code.OpGetGlobal, 0), code.Make(code.OpPop))},
+			"main: 0000 OpGetGlobal: global index 0 out of range (0)",
+		},
+		{
+			&Bytecode{Instructions: concatInstructions(code.Make(code.OpGetName, 3), code.Make(code.OpPop)), Names: []string{"x"}},
+			"main: 0000 OpGetName: name index 3 out of range (1)",
+		},
+		{
+			&Bytecode{Instructions: concatInstructions(code.Make(code.OpJump, 1))},
+			"main: 0000 OpJump: jump target 0001 is not the start of an instruction",
+		},
+		{
+			&Bytecode{Instructions: code.Instructions{byte(code.OpConstant), 0}, Constants: []object.Object{one}},
+			"main: 0000 OpConstant: operands truncated, want 2 bytes, have 1",
+		},
+		{
+			&Bytecode{Instructions: code.Instructions{200}},
+			"main: 0000: opcode 200 undefined",
+		},
+		{
+			&Bytecode{Instructions: concatInstructions(code.Make(code.OpPop))},
+			"main: 0000 OpPop: stack underflow, want 1 values, have 0",
+		},
+		{
+			&Bytecode{Instructions: concatInstructions(code.Make(code.OpGetLocal, 0), code.Make(code.OpPop))},
+			"main: 0000 OpGetLocal: local index 0 out of range (0)",
+		},
+		{
+			&Bytecode{Instructions: concatInstructions(
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 5),
+				code.Make(code.OpNull),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			)},
+			"main: 0005: inconsistent stack depth 0 and 1",
+		},
+		{
+			&Bytecode{
+				Instructions: concatInstructions(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+				Constants:    []object.Object{one},
+			},
+			"main: 0000 OpClosure: constant 0 is INTEGER, not a function",
+		},
+		{
+			&Bytecode{
+				Instructions: concatInstructions(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+				Constants:    []object.Object{fn(1, code.Make(code.OpGetLocal, 2), code.Make(code.OpReturnValue))},
+			},
+			"constant 0: 0000 OpGetLocal: local index 2 out of range (1)",
+		},
+		{
+			&Bytecode{
+				Instructions: concatInstructions(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+				Constants:    []object.Object{fn(0, code.Make(code.OpGetFree, 0), code.Make(code.OpReturnValue))},
+			},
+			"constant 0: 0000 OpGetFree: free variable index 0 out of range (0)",
+		},
+		{
+			&Bytecode{
+				Instructions: concatInstructions(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+				Constants:    []object.Object{fn(0, code.Make(code.OpNull))},
+			},
+			"constant 0: 0000 OpNull: function ends without return",
+		},
+		{
+			&Bytecode{Instructions: concatInstructions(code.Make(code.OpImport, 0), code.Make(code.OpPop)), Constants: []object.Object{one}},
+			"main: 0000 OpImport: constant 0 is INTEGER, not a string",
+		},
+		{
+			&Bytecode{Instructions: concatInstructions(code.Make(code.OpPopHandler))},
+			"main: 0000 OpPopHandler: no error handler to pop",
+		},
+		{
+			&Bytecode{Instructions: concatInstructions(code.Make(code.OpPushHandler, 6), code.Make(code.OpJump, 7), code.Make(code.OpPop))},
+			"main: 0003 OpJump: program ends with 1 error handlers",
+		},
+		{
+			&Bytecode{
+				Instructions: concatInstructions(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+				Constants: []object.Object{fn(0,
+					code.Make(code.OpPushHandler, 5),
+					code.Make(code.OpNull),
+					code.Make(code.OpReturnValue),
+					code.Make(code.OpReturnValue),
+				)},
+			},
+			"constant 0: 0004 OpReturnValue: return with 1 error handlers",
+		},
+		{
+			&Bytecode{Constants: []object.Object{&object.CompiledFunction{NumLocals: 300}}},
+			"constant 0: invalid function with 0 parameters and 300 locals",
+		},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if _, err := test.bytecode.WriteTo(&buf); err != nil {
+			t.Fatalf("WriteTo returned error: %s", err)
+		}
+
+		_, err := ReadBytecode(&buf)
+		if err == nil {
+			t.Errorf("expected error %q", test.expected)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), "invalid compiled program: ") || !strings.HasSuffix(err.Error(), test.expected) {
+			t.Errorf("wrong error. expected=%q, got=%q", test.expected, err.Error())
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package compiler
+
+type SymbolScope string
+
+const (
+	GlobalScope   SymbolScope = "GLOBAL"
+	LocalScope    SymbolScope = "LOCAL"
+	FreeScope     SymbolScope = "FREE"
+	FunctionScope SymbolScope = "FUNCTION"
+)
+
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+}
+
+// SymbolTable 関数ごとに一つ作り、外側の関数の表を Outer で辿る。
+// catch のブロックにも一つ作る。その変数は含む関数のスロットを使う。
+type SymbolTable struct {
+	Outer *SymbolTable
+	Block bool // catch のブロックの表
+
+	store          map[string]Symbol
+	numDefinitions int
+
+	// FreeSymbols 外側の関数から捕捉した変数。クロージャを作るときに積む順番でもある。
+	FreeSymbols []Symbol
+}
+
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{
+		store:       make(map[string]Symbol),
+		FreeSymbols: []Symbol{},
+	}
+}
+
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+// NewBlockSymbolTable catch のブロックの表を作る。
+func NewBlockSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewEnclosedSymbolTable(outer)
+	s.Block = true
+	return s
+}
+
+// fn s を含む関数（またはグローバル）の表。
+func (s *SymbolTable) fn() *SymbolTable {
+	for s.Block {
+		s = s.Outer
+	}
+	return s
+}
+
+// Define name を定義する。同じ表で定義済みなら同じスロットを使い回す。
+func (s *SymbolTable) Define(name string) Symbol {
+	if sym, ok := s.store[name]; ok && (sym.Scope == GlobalScope || sym.Scope == LocalScope) {
+		return sym
+	}
+
+	fn := s.fn()
+	symbol := Symbol{Name: name, Index: fn.numDefinitions}
+	if fn.Outer == nil {
+		symbol.Scope = GlobalScope
+	} else {
+		symbol.Scope = LocalScope
+	}
+
+	s.store[name] = symbol
+	fn.numDefinitions++
+	return symbol
+}
+
+// DefineFunctionName let で名前を付けた関数の中から、自分自身を参照できるようにする。
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1, Scope: FreeScope}
+	s.store[original.Name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	obj, ok := s.store[name]
+	if ok || s.Outer == nil {
+		return obj, ok
+	}
+	if s.Block {
+		// 同じ関数の中なので、自由変数にはならない
+		return s.Outer.Resolve(name)
+	}
+
+	obj, ok = s.Outer.Resolve(name)
+	if !ok || obj.Scope == GlobalScope {
+		return obj, ok
+	}
+
+	return s.defineFree(obj), true
+}
@@ -0,0 +1,274 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/ei1chi/sample-lang/code"
+	"github.com/ei1chi/sample-lang/object"
+)
+
+// maxLocals 関数一つのローカル変数の上限。OpGetLocal のオペランドが 1 バイトなので 256。
+const maxLocals = 256
+
+// instruction 命令列を読んだ命令一つ。
+type instruction struct {
+	offset   int
+	op       code.Opcode
+	def      *code.Definition
+	operands []int
+	next     int // 次の命令の位置
+}
+
+// verify 仮想マシンが範囲外を読まずに実行できるかを確かめる。
+// オペランドの幅、定数・グローバル変数・名前・ローカル変数・自由変数の番号、飛び先、
+// スタックに積まれている値の数と、エラーハンドラの数を調べる。ファイルから読み込んだものは信用できないので ReadBytecode で呼ぶ。
+func (b *Bytecode) verify() error {
+	streams := []*stream{{name: "main", ins: b.Instructions, main: true}}
+	for i, c := range b.Constants {
+		fn, ok := c.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		if fn.NumParams < 0 || fn.NumLocals < fn.NumParams || fn.NumLocals > maxLocals {
+			return fmt.Errorf("constant %d: invalid function with %d parameters and %d locals", i, fn.NumParams, fn.NumLocals)
+		}
+		streams = append(streams, &stream{name: fmt.Sprintf("constant %d", i), ins: fn.Instructions, fn: fn, constIndex: i})
+	}
+
+	// 自由変数の数は関数を作る OpClosure の側にしか書かれていないので、先に集める
+	numFree := map[int]int{}
+	for _, s := range streams {
+		if err := s.decode(); err != nil {
+			return err
+		}
+		for _, in := range s.instructions {
+			if in.op != code.OpClosure {
+				continue
+			}
+			if n, ok := numFree[in.operands[0]]; !ok || in.operands[1] < n {
+				numFree[in.operands[0]] = in.operands[1]
+			}
+		}
+	}
+
+	for _, s := range streams {
+		if !s.main {
+			s.numFree = numFree[s.constIndex]
+		}
+		if err := s.verifyOperands(b); err != nil {
+			return err
+		}
+		if err := s.verifyStack(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stream 一つの命令列。main はプログラム全体、それ以外は関数の定数。
+type stream struct {
+	name       string
+	ins        code.Instructions
+	main       bool
+	fn         *object.CompiledFunction
+	constIndex int
+	numFree    int
+
+	instructions []instruction
+	at           map[int]int // 命令の位置から instructions の添字
+}
+
+func (s *stream) errorf(in instruction, format string, a ...interface{}) error {
+	return fmt.Errorf("%s: %04d %s: %s", s.name, in.offset, in.def.Name, fmt.Sprintf(format, a...))
+}
+
+// decode 命令列を命令に分ける。未定義の命令と、オペランドの途中で終わる命令はエラー。
+func (s *stream) decode() error {
+	s.at = map[int]int{}
+	for offset := 0; offset < len(s.ins); {
+		def, err := code.Lookup(s.ins[offset])
+		if err != nil {
+			return fmt.Errorf("%s: %04d: %s", s.name, offset, err)
+		}
+
+		width := 0
+		for _, w := range def.OperandWidths {
+			width += w
+		}
+		if offset+1+width > len(s.ins) {
+			return fmt.Errorf("%s: %04d %s: operands truncated, want %d bytes, have %d", s.name, offset, def.Name, width, len(s.ins)-offset-1)
+		}
+
+		operands, read := code.ReadOperands(def, s.ins[offset+1:])
+		s.at[offset] = len(s.instructions)
+		s.instructions = append(s.instructions, instruction{
+			offset:   offset,
+			op:       code.Opcode(s.ins[offset]),
+			def:      def,
+			operands: operands,
+			next:     offset + 1 + read,
+		})
+		offset += 1 + read
+	}
+	return nil
+}
+
+// verifyOperands オペランドが指す番号と飛び先が範囲に収まるか。
+func (s *stream) verifyOperands(b *Bytecode) error {
+	numLocals := 0
+	if s.fn != nil {
+		numLocals = s.fn.NumLocals
+	}
+
+	for _, in := range s.instructions {
+		var index, limit int
+		var what string
+
+		switch in.op {
+		case code.OpConstant, code.OpClosure:
+			index, limit, what = in.operands[0], len(b.Constants), "constant"
+			if in.op == code.OpClosure && index < limit {
+				if _, ok := b.Constants[index].(*object.CompiledFunction); !ok {
+					return s.errorf(in, "constant %d is %s, not a function", index, b.Constants[index].Type())
+				}
+			}
+		case code.OpGetGlobal, code.OpSetGlobal:
+			index, limit, what = in.operands[0], len(b.Globals), "global"
+		case code.OpGetName, code.OpSelect:
+			index, limit, what = in.operands[0], len(b.Names), "name"
+		case code.OpImport:
+			index, limit, what = in.operands[0], len(b.Constants), "constant"
+			if index < limit && b.Constants[index].Type() != object.STRING {
+				return s.errorf(in, "constant %d is %s, not a string", index, b.Constants[index].Type())
+			}
+		case code.OpGetLocal, code.OpSetLocal:
+			index, limit, what = in.operands[0], numLocals, "local"
+		case code.OpGetFree:
+			index, limit, what = in.operands[0], s.numFree, "free variable"
+		case code.OpJump, code.OpJumpNotTruthy, code.OpJumpNotErrorValue, code.OpPushHandler:
+			target := in.operands[0]
+			if _, ok := s.at[target]; !ok && target != len(s.ins) {
+				return s.errorf(in, "jump target %04d is not the start of an instruction", target)
+			}
+			continue
+		default:
+			continue
+		}
+
+		if index >= limit {
+			return s.errorf(in, "%s index %d out of range (%d)", what, index, limit)
+		}
+	}
+	return nil
+}
+
+// depth 命令を実行する直前の、スタックに積まれている値とエラーハンドラの数。
+type depth struct {
+	stack    int
+	handlers int
+}
+
+// verifyStack どの経路で着いても、命令が下ろす値がスタックに積まれているか。
+// 同じ命令に別の経路で着いたときは、積まれている数が同じでなければならない。
+// エラーハンドラも同じように数え、関数から戻るときには全部外していなければならない。
+func (s *stream) verifyStack() error {
+	if len(s.instructions) == 0 {
+		return nil
+	}
+
+	depths := map[int]depth{0: {}}
+	work := []int{0}
+	for len(work) > 0 {
+		in := s.instructions[s.at[work[len(work)-1]]]
+		work = work[:len(work)-1]
+
+		pop, push := stackEffect(in)
+		d := depths[in.offset]
+		if d.stack < pop {
+			return s.errorf(in, "stack underflow, want %d values, have %d", pop, d.stack)
+		}
+		d.stack += push - pop
+
+		type successor struct {
+			offset int
+			depth  depth
+		}
+		var next []successor
+		switch in.op {
+		case code.OpJump:
+			next = []successor{{in.operands[0], d}}
+		case code.OpJumpNotTruthy, code.OpJumpNotErrorValue:
+			next = []successor{{in.next, d}, {in.operands[0], d}}
+		case code.OpPushHandler:
+			// エラーが起きたら、今のスタックにエラーの値を一つ積んで飛ぶ
+			next = []successor{
+				{in.next, depth{d.stack, d.handlers + 1}},
+				{in.operands[0], depth{d.stack + 1, d.handlers}},
+			}
+		case code.OpPopHandler:
+			if d.handlers == 0 {
+				return s.errorf(in, "no error handler to pop")
+			}
+			d.handlers--
+			next = []successor{{in.next, d}}
+		case code.OpReturnValue, code.OpReturn:
+			if d.handlers != 0 {
+				return s.errorf(in, "return with %d error handlers", d.handlers)
+			}
+		case code.OpThrow:
+		default:
+			next = []successor{{in.next, d}}
+		}
+
+		for _, n := range next {
+			if n.offset == len(s.ins) {
+				if !s.main {
+					return s.errorf(in, "function ends without return")
+				}
+				if n.depth.handlers != 0 {
+					return s.errorf(in, "program ends with %d error handlers", n.depth.handlers)
+				}
+				continue
+			}
+			if prev, ok := depths[n.offset]; ok {
+				if prev.stack != n.depth.stack {
+					return fmt.Errorf("%s: %04d: inconsistent stack depth %d and %d", s.name, n.offset, prev.stack, n.depth.stack)
+				}
+				if prev.handlers != n.depth.handlers {
+					return fmt.Errorf("%s: %04d: inconsistent error handlers %d and %d", s.name, n.offset, prev.handlers, n.depth.handlers)
+				}
+				continue
+			}
+			depths[n.offset] = n.depth
+			work = append(work, n.offset)
+		}
+	}
+	return nil
+}
+
+// stackEffect 命令がスタックから下ろす値と積む値の数。
+func stackEffect(in instruction) (pop, push int) {
+	switch in.op {
+	case code.OpPop, code.OpJumpNotTruthy, code.OpSetGlobal, code.OpSetLocal, code.OpReturnValue, code.OpThrow:
+		return 1, 0
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv,
+		code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan:
+		return 2, 1
+	case code.OpMinus, code.OpBang, code.OpSelect, code.OpJumpNotErrorValue:
+		return 1, 1
+	case code.OpIndex:
+		return 2, 1
+	case code.OpArray:
+		return in.operands[0], 1
+	case code.OpMap:
+		return 2 * in.operands[0], 1
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull,
+		code.OpGetGlobal, code.OpGetLocal, code.OpGetFree, code.OpGetName, code.OpCurrentClosure, code.OpImport:
+		return 0, 1
+	case code.OpClosure:
+		return in.operands[1], 1
+	case code.OpCall:
+		return in.operands[0] + 1, 1
+	}
+	return 0, 0
+}
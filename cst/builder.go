@@ -0,0 +1,123 @@
+package cst
+
+import (
+	"github.com/ei1chi/sample-lang/ast"
+	"github.com/ei1chi/sample-lang/token"
+)
+
+type eventType int
+
+const (
+	startEvent eventType = iota
+	finishEvent
+	tokenEvent
+)
+
+type event struct {
+	typ  eventType
+	id   int
+	kind Kind
+	ast  ast.Node
+	tok  token.Token
+}
+
+// Builder 構文解析の途中で開始・終了・トークンのイベントを記録し、最後に木を組み立てる。
+// 左辺を読んでから中置演算子が来たと分かる場合は Mark と Precede で親を後から差し込む。
+type Builder struct {
+	events []event
+	nextID int
+}
+
+// Mark これから始まるノードの位置。Precede に渡す。
+func (b *Builder) Mark() int {
+	return len(b.events)
+}
+
+// Start ノードを開始して、Finish に渡す ID を返す。
+func (b *Builder) Start() int {
+	b.nextID++
+	b.events = append(b.events, event{typ: startEvent, id: b.nextID})
+	return b.nextID
+}
+
+// Precede mark から始まったノードを包む親ノードを開始する。
+func (b *Builder) Precede(mark int) int {
+	b.nextID++
+	b.events = append(b.events, event{})
+	copy(b.events[mark+1:], b.events[mark:])
+	b.events[mark] = event{typ: startEvent, id: b.nextID}
+	return b.nextID
+}
+
+// Finish id のノードを終える。間で終わっていないノードがあれば ERROR として閉じる。
+func (b *Builder) Finish(id int, kind Kind, n ast.Node) {
+	if kind == ERROR {
+		n = nil
+	}
+	b.events = append(b.events, event{typ: finishEvent, id: id, kind: kind, ast: n})
+}
+
+func (b *Builder) Token(tok token.Token) {
+	b.events = append(b.events, event{typ: tokenEvent, tok: tok})
+}
+
+// Tree 記録したイベントから木を作る。根が一つにならなければ ERROR で包む。
+func (b *Builder) Tree() *Node {
+	top := &Node{Kind: ERROR}
+	stack := []open{{node: top}}
+
+	closeTop := func() *Node {
+		n := stack[len(stack)-1].node
+		stack = stack[:len(stack)-1]
+		parent := stack[len(stack)-1].node
+		parent.Children = append(parent.Children, n)
+		return n
+	}
+
+	for _, ev := range b.events {
+		switch ev.typ {
+		case startEvent:
+			stack = append(stack, open{id: ev.id, node: &Node{Kind: ERROR}})
+
+		case finishEvent:
+			if !isOpen(stack, ev.id) {
+				continue
+			}
+			for stack[len(stack)-1].id != ev.id {
+				closeTop()
+			}
+			n := closeTop()
+			n.Kind = ev.kind
+			n.AST = ev.ast
+
+		case tokenEvent:
+			n := stack[len(stack)-1].node
+			n.Children = append(n.Children, &Token{Token: ev.tok})
+		}
+	}
+
+	for len(stack) > 1 {
+		closeTop()
+	}
+
+	if len(top.Children) == 1 {
+		if root, ok := top.Children[0].(*Node); ok {
+			return root
+		}
+	}
+	return top
+}
+
+type open struct {
+	id   int
+	node *Node
+}
+
+func isOpen(stack []open, id int) bool {
+	for i := len(stack) - 1; i > 0; i-- {
+		if stack[i].id == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+// Package cst は空白とコメントも含めてソースをそのまま保持する具象構文木。
+// parser.ParseCST で作り、Text でソースを 1 バイトも違わずに復元できる。
+package cst
+
+import (
+	"strings"
+
+	"github.com/ei1chi/sample-lang/ast"
+	"github.com/ei1chi/sample-lang/token"
+)
+
+type Kind string
+
+const (
+	PROGRAM        Kind = "PROGRAM"
+	BLOCK_STMT     Kind = "BLOCK_STMT"
+	LET_STMT       Kind = "LET_STMT"
+	RETURN_STMT    Kind = "RETURN_STMT"
+	EXPR_STMT      Kind = "EXPR_STMT"
+	THROW_STMT     Kind = "THROW_STMT"
+	IMPORT_STMT    Kind = "IMPORT_STMT"
+	EXPORT_STMT    Kind = "EXPORT_STMT"
+	IDENT          Kind = "IDENT"
+	INT_LITERAL    Kind = "INT_LITERAL"
+	STRING_LITERAL Kind = "STRING_LITERAL"
+	BOOLEAN        Kind = "BOOLEAN"
+	ARRAY_LITERAL  Kind = "ARRAY_LITERAL"
+	MAP_LITERAL    Kind = "MAP_LITERAL"
+	PREFIX_EXPR    Kind = "PREFIX_EXPR"
+	POSTFIX_EXPR   Kind = "POSTFIX_EXPR"
+	INFIX_EXPR     Kind = "INFIX_EXPR"
+	PAREN_EXPR     Kind = "PAREN_EXPR"
+	IF_EXPR        Kind = "IF_EXPR"
+	TRY_EXPR       Kind = "TRY_EXPR"
+	FUNC_LITERAL   Kind = "FUNC_LITERAL"
+	CALL_EXPR      Kind = "CALL_EXPR"
+	INDEX_EXPR     Kind = "INDEX_EXPR"
+	SELECTOR_EXPR  Kind = "SELECTOR_EXPR"
+	NAMED_TYPE     Kind = "NAMED_TYPE"
+	FUNC_TYPE      Kind = "FUNC_TYPE"
+
+	// 構文エラーで解析できなかった部分
+	ERROR Kind = "ERROR"
+)
+
+// Element Node の子。*Node か *Token。
+type Element interface {
+	// Text トリビアを含めた元のソース
+	Text() string
+}
+
+// Node 構文要素。子を順に並べるとソースの該当範囲になる。
+type Node struct {
+	Kind     Kind
+	Children []Element
+
+	// AST 対応する AST のノード。PAREN_EXPR なら括弧の中の式、ERROR なら nil。
+	AST ast.Node
+}
+
+func (n *Node) Text() string {
+	var out strings.Builder
+	n.writeTo(&out)
+	return out.String()
+}
+
+func (n *Node) String() string {
+	return n.Text()
+}
+
+func (n *Node) writeTo(out *strings.Builder) {
+	for _, c := range n.Children {
+		switch c := c.(type) {
+		case *Node:
+			c.writeTo(out)
+		case *Token:
+			out.WriteString(c.Text())
+		}
+	}
+}
+
+// Tokens n に含まれるトークンを順に返す。
+func (n *Node) Tokens() []*Token {
+	var toks []*Token
+	for _, c := range n.Children {
+		switch c := c.(type) {
+		case *Node:
+			toks = append(toks, c.Tokens()...)
+		case *Token:
+			toks = append(toks, c)
+		}
+	}
+	return toks
+}
+
+// Token 葉になるトークン。
+type Token struct {
+	token.Token
+}
+
+func (t *Token) Text() string {
+	return t.Leading + t.Literal + t.Trailing
+}
+
+// KindOf AST のノードに対応する種類。nil なら ERROR。
+func KindOf(n ast.Node) Kind {
+	switch n := n.(type) {
+	case *ast.Program:
+		return kindOrError(n == nil, PROGRAM)
+	case *ast.BlockStmt:
+		return kindOrError(n == nil, BLOCK_STMT)
+	case *ast.LetStmt:
+		return kindOrError(n == nil, LET_STMT)
+	case *ast.ReturnStmt:
+		return kindOrError(n == nil, RETURN_STMT)
+	case *ast.ExprStmt:
+		return kindOrError(n == nil, EXPR_STMT)
+	case *ast.ThrowStmt:
+		return kindOrError(n == nil, THROW_STMT)
+	case *ast.ImportStmt:
+		return kindOrError(n == nil, IMPORT_STMT)
+	case *ast.ExportStmt:
+		return kindOrError(n == nil, EXPORT_STMT)
+	case *ast.Ident:
+		return kindOrError(n == nil, IDENT)
+	case *ast.IntLiteral:
+		return kindOrError(n == nil, INT_LITERAL)
+	case *ast.StringLiteral:
+		return kindOrError(n == nil, STRING_LITERAL)
+	case *ast.Boolean:
+		return kindOrError(n == nil, BOOLEAN)
+	case *ast.ArrayLiteral:
+		return kindOrError(n == nil, ARRAY_LITERAL)
+	case *ast.MapLiteral:
+		return kindOrError(n == nil, MAP_LITERAL)
+	case *ast.PrefixExpr:
+		return kindOrError(n == nil, PREFIX_EXPR)
+	case *ast.PostfixExpr:
+		return kindOrError(n == nil, POSTFIX_EXPR)
+	case *ast.InfixExpr:
+		return kindOrError(n == nil, INFIX_EXPR)
+	case *ast.IfExpr:
+		return kindOrError(n == nil, IF_EXPR)
+	case *ast.TryExpr:
+		return kindOrError(n == nil, TRY_EXPR)
+	case *ast.FuncLiteral:
+		return kindOrError(n == nil, FUNC_LITERAL)
+	case *ast.CallExpr:
+		return kindOrError(n == nil, CALL_EXPR)
+	case *ast.IndexExpr:
+		return kindOrError(n == nil, INDEX_EXPR)
+	case *ast.SelectorExpr:
+		return kindOrError(n == nil, SELECTOR_EXPR)
+	case *ast.NamedType:
+		return kindOrError(n == nil, NAMED_TYPE)
+	case *ast.FuncType:
+		return kindOrError(n == nil, FUNC_TYPE)
+	}
+	return ERROR
+}
+
+func kindOrError(isNil bool, kind Kind) Kind {
+	if isNil {
+		return ERROR
+	}
+	return kind
+}
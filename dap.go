@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ei1chi/sample-lang/dap"
+)
+
+// runDAP sample-lang dap
+// 標準入出力で Debug Adapter Protocol を話す。
+func runDAP(args []string) int {
+	flags := flag.NewFlagSet("dap", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: sample-lang dap\n")
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if err := dap.Serve(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "dap: %s\n", err)
+		return 1
+	}
+	return 0
+}
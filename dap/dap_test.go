@@ -0,0 +1,256 @@
+package dap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// incoming サーバーから届いた応答かイベント。
+type incoming struct {
+	Type       string          `json:"type"`
+	Command    string          `json:"command"`
+	Event      string          `json:"event"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Message    string          `json:"message"`
+	Body       json.RawMessage `json:"body"`
+}
+
+type client struct {
+	t    *testing.T
+	w    io.Writer
+	seq  int
+	msgs chan incoming
+}
+
+func newClient(t *testing.T) *client {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+
+	go func() {
+		if err := Serve(inR, outW); err != nil {
+			t.Errorf("Serve failed: %s", err)
+		}
+		outW.Close()
+	}()
+
+	c := &client{t: t, w: inW, msgs: make(chan incoming, 100)}
+	go func() {
+		r := newConn(outR, nil)
+		defer close(c.msgs)
+		for {
+			header, err := r.r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			var length int
+			fmt.Sscanf(header, "Content-Length: %d", &length)
+			r.r.ReadString('\n')
+			body := make([]byte, length)
+			io.ReadFull(r.r, body)
+
+			var msg incoming
+			json.Unmarshal(body, &msg)
+			c.msgs <- msg
+		}
+	}()
+	return c
+}
+
+// send 要求を送り、その応答の body を v に読む。
+func (c *client) send(command string, args interface{}, v interface{}) {
+	c.t.Helper()
+
+	c.seq++
+	b, _ := json.Marshal(map[string]interface{}{"seq": c.seq, "type": "request", "command": command, "arguments": args})
+	fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(b), b)
+
+	msg := c.next()
+	if msg.Type != "response" || msg.RequestSeq != c.seq {
+		c.t.Fatalf("%s: want response, got %+v", command, msg)
+	}
+	if !msg.Success {
+		c.t.Fatalf("%s failed: %s", command, msg.Message)
+	}
+	if v != nil {
+		if err := json.Unmarshal(msg.Body, v); err != nil {
+			c.t.Fatalf("%s: bad body %s", command, msg.Body)
+		}
+	}
+}
+
+// expect 次のイベントが name であることを確かめ、body を v に読む。
+func (c *client) expect(name string, v interface{}) {
+	c.t.Helper()
+
+	msg := c.next()
+	if msg.Type != "event" || msg.Event != name {
+		c.t.Fatalf("want event %s, got %+v", name, msg)
+	}
+	if v != nil {
+		json.Unmarshal(msg.Body, v)
+	}
+}
+
+func (c *client) next() incoming {
+	c.t.Helper()
+
+	select {
+	case msg, ok := <-c.msgs:
+		if !ok {
+			c.t.Fatalf("connection closed")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		c.t.Fatalf("timed out")
+	}
+	return incoming{}
+}
+
+type stoppedBody struct {
+	Reason string `json:"reason"`
+}
+
+const script = `let double = fn(x) {
+	let y = x * 2;
+	y
+};
+let a = double(3);
+a + 1`
+
+func TestSession(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script.sl")
+	if err := os.WriteFile(path, []byte(script), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newClient(t)
+	c.send("initialize", map[string]string{"adapterID": "sample-lang"}, nil)
+	c.expect("initialized", nil)
+	c.send("launch", LaunchArguments{Program: path, StopOnEntry: true}, nil)
+
+	var bps struct {
+		Breakpoints []Breakpoint `json:"breakpoints"`
+	}
+	c.send("setBreakpoints", SetBreakpointsArguments{
+		Source:      Source{Path: path},
+		Breakpoints: []SourceBreakpoint{{Line: 2}, {Line: 4}},
+	}, &bps)
+	if !bps.Breakpoints[0].Verified || bps.Breakpoints[1].Verified {
+		t.Errorf("want line 2 verified and line 4 not, got %+v", bps.Breakpoints)
+	}
+
+	c.send("configurationDone", nil, nil)
+	var stopped stoppedBody
+	c.expect("stopped", &stopped)
+	if stopped.Reason != "entry" {
+		t.Errorf("want entry, got %q", stopped.Reason)
+	}
+
+	c.send("continue", map[string]int{"threadId": 1}, nil)
+	c.expect("stopped", &stopped)
+	if stopped.Reason != "breakpoint" {
+		t.Errorf("want breakpoint, got %q", stopped.Reason)
+	}
+
+	var trace struct {
+		StackFrames []StackFrame `json:"stackFrames"`
+	}
+	c.send("stackTrace", StackTraceArguments{ThreadID: 1}, &trace)
+	got := []string{}
+	for _, f := range trace.StackFrames {
+		got = append(got, fmt.Sprintf("%s %d:%d", f.Name, f.Line, f.Column))
+	}
+	expected := []string{"double 2:2", "main 5:1"}
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("wrong stack trace. want %q, got %q", expected, got)
+	}
+
+	var scopes struct {
+		Scopes []Scope `json:"scopes"`
+	}
+	c.send("scopes", ScopesArguments{FrameID: trace.StackFrames[0].ID}, &scopes)
+	if len(scopes.Scopes) != 2 || scopes.Scopes[0].Name != "Locals" {
+		t.Fatalf("want Locals and Globals, got %+v", scopes.Scopes)
+	}
+
+	var vars struct {
+		Variables []Variable `json:"variables"`
+	}
+	c.send("variables", VariablesArguments{VariablesReference: scopes.Scopes[0].VariablesReference}, &vars)
+	if len(vars.Variables) != 1 || vars.Variables[0].Name != "x" || vars.Variables[0].Value != "3" {
+		t.Errorf("want x = 3, got %+v", vars.Variables)
+	}
+
+	c.send("stepOut", map[string]int{"threadId": 1}, nil)
+	c.expect("stopped", &stopped)
+	c.send("stackTrace", StackTraceArguments{ThreadID: 1}, &trace)
+	if len(trace.StackFrames) != 1 || trace.StackFrames[0].Line != 6 {
+		t.Errorf("want to stop at line 6 in main, got %+v", trace.StackFrames)
+	}
+
+	c.send("continue", map[string]int{"threadId": 1}, nil)
+	var output struct {
+		Output string `json:"output"`
+	}
+	c.expect("output", &output)
+	if output.Output != "7\n" {
+		t.Errorf("want output 7, got %q", output.Output)
+	}
+	var exited struct {
+		ExitCode int `json:"exitCode"`
+	}
+	c.expect("exited", &exited)
+	if exited.ExitCode != 0 {
+		t.Errorf("want exit code 0, got %d", exited.ExitCode)
+	}
+	c.expect("terminated", nil)
+
+	c.send("disconnect", nil, nil)
+	if _, ok := <-c.msgs; ok {
+		t.Errorf("connection not closed after disconnect")
+	}
+}
+
+func TestDisconnectWhileStopped(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "loop.sl")
+	if err := os.WriteFile(path, []byte("let loop = fn(n) { loop(n + 1) };\nloop(0)"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newClient(t)
+	c.send("initialize", nil, nil)
+	c.expect("initialized", nil)
+	c.send("launch", LaunchArguments{Program: path}, nil)
+	c.send("setBreakpoints", SetBreakpointsArguments{Breakpoints: []SourceBreakpoint{{Line: 1}}}, nil)
+	c.send("configurationDone", nil, nil)
+	c.expect("stopped", nil)
+
+	c.send("disconnect", nil, nil)
+	c.expect("exited", nil)
+	c.expect("terminated", nil)
+}
+
+func TestLaunchSyntaxError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.sl")
+	if err := os.WriteFile(path, []byte("let = 1;"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newClient(t)
+	c.seq++
+	b, _ := json.Marshal(map[string]interface{}{"seq": c.seq, "type": "request", "command": "launch", "arguments": LaunchArguments{Program: path}})
+	fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(b), b)
+
+	msg := c.next()
+	expected := path + ":1:5: expected next token to be IDENT, got = instead\n" +
+		path + ":1:5: no prefix parse function for = found"
+	if msg.Success || msg.Message != expected {
+		t.Errorf("want failure %q, got %+v", expected, msg)
+	}
+}
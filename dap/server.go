@@ -0,0 +1,376 @@
+// Package dap は標準入出力で Debug Adapter Protocol を話すデバッグアダプタ。
+//
+// launch で指定した一つのファイルを debug.Debugger の下で評価する。
+// スレッドは一つだけで、ID は常に 1。
+package dap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ei1chi/sample-lang/ast"
+	"github.com/ei1chi/sample-lang/debug"
+	"github.com/ei1chi/sample-lang/eval"
+	"github.com/ei1chi/sample-lang/lexer"
+	"github.com/ei1chi/sample-lang/object"
+	"github.com/ei1chi/sample-lang/parser"
+)
+
+const threadID = 1
+
+// Serve r から要求を読み、w に応答とイベントを書く。disconnect を受けるか r が終わると戻る。
+func Serve(r io.Reader, w io.Writer) error {
+	s := &server{conn: newConn(r, w), debugger: debug.New()}
+	s.debugger.OnStop = s.stopped
+	return s.run()
+}
+
+type server struct {
+	conn     *conn
+	debugger *debug.Debugger
+
+	path    string
+	program *ast.Program
+	lines   map[int]bool // 文が始まる行
+
+	launched    bool
+	configured  bool
+	stopOnEntry bool
+	cancel      context.CancelFunc
+	done        chan struct{} // 評価が終わると閉じる
+
+	after func() // 応答を書いたあとにすること
+}
+
+type handler func(s *server, args json.RawMessage) (interface{}, error)
+
+var handlers = map[string]handler{
+	"initialize":        (*server).initialize,
+	"launch":            (*server).launch,
+	"setBreakpoints":    (*server).setBreakpoints,
+	"configurationDone": (*server).configurationDone,
+	"threads":           (*server).threads,
+	"stackTrace":        (*server).stackTrace,
+	"scopes":            (*server).scopes,
+	"variables":         (*server).variables,
+	"continue":          resume((*debug.Debugger).Continue),
+	"next":              resume((*debug.Debugger).StepOver),
+	"stepIn":            resume((*debug.Debugger).StepIn),
+	"stepOut":           resume((*debug.Debugger).StepOut),
+	"pause":             (*server).pause,
+	"terminate":         (*server).terminate,
+	"disconnect":        (*server).terminate,
+}
+
+// errDisconnect disconnect への応答を書いたら Serve を終える。
+var errDisconnect = errors.New("disconnect")
+
+func (s *server) run() error {
+	defer s.stop()
+
+	for {
+		req, err := s.conn.read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := s.handle(req); err != nil {
+			if errors.Is(err, errDisconnect) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func (s *server) handle(req *request) error {
+	resp := &response{Type: "response", RequestSeq: req.Seq, Command: req.Command, Success: true}
+
+	h, ok := handlers[req.Command]
+	if !ok {
+		resp.Success = false
+		resp.Message = "unrecognized request: " + req.Command
+		return s.conn.write(resp)
+	}
+
+	s.after = nil
+	body, err := h(s, req.Arguments)
+	if err != nil {
+		resp.Success = false
+		resp.Message = err.Error()
+	} else {
+		resp.Body = body
+	}
+	if err := s.conn.write(resp); err != nil {
+		return err
+	}
+
+	if s.after != nil {
+		s.after()
+	}
+	if req.Command == "disconnect" {
+		return errDisconnect
+	}
+	return nil
+}
+
+func (s *server) initialize(json.RawMessage) (interface{}, error) {
+	s.after = func() { s.conn.event("initialized", nil) }
+
+	return map[string]interface{}{
+		"supportsConfigurationDoneRequest": true,
+		"supportsTerminateRequest":         true,
+	}, nil
+}
+
+func (s *server) launch(raw json.RawMessage) (interface{}, error) {
+	var args LaunchArguments
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	if s.launched {
+		return nil, errors.New("already launched")
+	}
+
+	src, err := os.ReadFile(args.Program)
+	if err != nil {
+		return nil, err
+	}
+
+	p := parser.NewParser(lexer.NewLexer(string(src)))
+	program := p.ParseProgram()
+	if errs := p.ErrorList(); len(errs) != 0 {
+		msgs := make([]string, len(errs))
+		for i, err := range errs {
+			msgs[i] = fmt.Sprintf("%s:%s", args.Program, err)
+		}
+		return nil, errors.New(strings.Join(msgs, "\n"))
+	}
+
+	s.path = args.Program
+	s.program = program
+	s.lines = stmtLines(program)
+	s.stopOnEntry = args.StopOnEntry
+	s.launched = true
+	s.after = s.start
+	return nil, nil
+}
+
+// stmtLines 文が始まる行。ブレークポイントを置けるのはこれらの行だけ。
+func stmtLines(program *ast.Program) map[int]bool {
+	lines := map[int]bool{}
+	ast.Inspect(program, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.Program, *ast.BlockStmt:
+		case ast.Stmt:
+			lines[node.Pos().Line] = true
+		}
+		return true
+	})
+	return lines
+}
+
+func (s *server) setBreakpoints(raw json.RawMessage) (interface{}, error) {
+	var args SetBreakpointsArguments
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	var lines []int
+	bps := []Breakpoint{}
+	for _, bp := range args.Breakpoints {
+		// launch の前は行を確かめられないので、そのまま受け付ける
+		verified := s.program == nil || s.lines[bp.Line]
+		if verified {
+			lines = append(lines, bp.Line)
+		}
+		bps = append(bps, Breakpoint{Verified: verified, Line: bp.Line})
+	}
+	s.debugger.SetBreakpoints(lines)
+
+	return map[string]interface{}{"breakpoints": bps}, nil
+}
+
+func (s *server) configurationDone(json.RawMessage) (interface{}, error) {
+	s.configured = true
+	s.after = s.start
+	return nil, nil
+}
+
+// start launch と configurationDone の両方が済んだら評価を始める。
+func (s *server) start() {
+	if !s.launched || !s.configured || s.done != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	if s.stopOnEntry {
+		s.debugger.StopOnEntry()
+	}
+
+	go func() {
+		defer close(s.done)
+
+		loader := &eval.Loader{Paths: []string{filepath.Dir(s.path)}}
+		cfg := eval.Config{Hook: s.debugger.Hook, Context: ctx, Loader: loader}
+		result := eval.New(cfg).Eval(s.program, object.NewEnvironment())
+
+		exitCode := 0
+		switch result := result.(type) {
+		case *object.Error:
+			if result.Kind != object.CONTEXT_CANCELED {
+				s.output("stderr", result.Inspect()+"\n"+strings.TrimSuffix(result.StackTrace(s.path), "\n"))
+			}
+			exitCode = 1
+		case nil, *object.Null:
+		default:
+			s.output("stdout", result.Inspect())
+		}
+
+		s.conn.event("exited", map[string]int{"exitCode": exitCode})
+		s.conn.event("terminated", nil)
+	}()
+}
+
+// stop 評価していれば中断して、終わるのを待つ。
+func (s *server) stop() {
+	if s.done == nil {
+		return
+	}
+	s.cancel()
+	s.debugger.Detach()
+	<-s.done
+}
+
+func (s *server) output(category, text string) {
+	s.conn.event("output", map[string]string{"category": category, "output": text + "\n"})
+}
+
+// stopped debug.Debugger.OnStop に渡す。
+func (s *server) stopped(stop debug.Stop) {
+	s.conn.event("stopped", map[string]interface{}{
+		"reason":            string(stop.Reason),
+		"threadId":          threadID,
+		"allThreadsStopped": true,
+	})
+}
+
+func (s *server) threads(json.RawMessage) (interface{}, error) {
+	return map[string]interface{}{"threads": []Thread{{ID: threadID, Name: "main"}}}, nil
+}
+
+// frame 止まっている間のフレーム。ID は外側から 1, 2, ... と振る。
+func (s *server) frame(id int) (eval.Frame, error) {
+	stop, ok := s.debugger.Stopped()
+	if !ok {
+		return eval.Frame{}, errors.New("not stopped")
+	}
+	if id < 1 || id > len(stop.Frames) {
+		return eval.Frame{}, fmt.Errorf("unknown frame %d", id)
+	}
+	return stop.Frames[id-1], nil
+}
+
+func (s *server) stackTrace(raw json.RawMessage) (interface{}, error) {
+	stop, ok := s.debugger.Stopped()
+	if !ok {
+		return nil, errors.New("not stopped")
+	}
+
+	// 内側のフレームから並べる
+	frames := []StackFrame{}
+	for i := len(stop.Frames) - 1; i >= 0; i-- {
+		f := stop.Frames[i]
+		name := f.Name
+		if name == "" {
+			name = "<anonymous>"
+		}
+		path := s.path
+		if f.File != "" {
+			path = f.File
+		}
+		pos := f.Stmt.Pos()
+		frames = append(frames, StackFrame{
+			ID:     i + 1,
+			Name:   name,
+			Source: Source{Path: path},
+			Line:   pos.Line,
+			Column: pos.Column,
+		})
+	}
+
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, nil
+}
+
+// scopes 変数の参照番号はフレームの ID と同じにする。一番外側のフレームの変数がグローバル。
+func (s *server) scopes(raw json.RawMessage) (interface{}, error) {
+	var args ScopesArguments
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	if _, err := s.frame(args.FrameID); err != nil {
+		return nil, err
+	}
+
+	scopes := []Scope{}
+	if args.FrameID > 1 {
+		scopes = append(scopes, Scope{Name: "Locals", VariablesReference: args.FrameID})
+	}
+	scopes = append(scopes, Scope{Name: "Globals", VariablesReference: 1})
+
+	return map[string]interface{}{"scopes": scopes}, nil
+}
+
+func (s *server) variables(raw json.RawMessage) (interface{}, error) {
+	var args VariablesArguments
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	f, err := s.frame(args.VariablesReference)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := []Variable{}
+	for _, name := range f.Env.Names() {
+		val, _ := f.Env.Get(name)
+		vars = append(vars, Variable{Name: name, Value: val.Inspect(), Type: string(val.Type())})
+	}
+
+	return map[string]interface{}{"variables": vars}, nil
+}
+
+// resume continue, next, stepIn, stepOut の処理。
+// 再開すると次の stopped イベントが書かれうるので、再開は応答を書いてからにする。
+func resume(proceed func(*debug.Debugger)) handler {
+	return func(s *server, _ json.RawMessage) (interface{}, error) {
+		if _, ok := s.debugger.Stopped(); !ok {
+			return nil, errors.New("not stopped")
+		}
+
+		s.after = func() { proceed(s.debugger) }
+		return map[string]bool{"allThreadsContinued": true}, nil
+	}
+}
+
+func (s *server) pause(json.RawMessage) (interface{}, error) {
+	s.debugger.Pause()
+	return nil, nil
+}
+
+func (s *server) terminate(json.RawMessage) (interface{}, error) {
+	s.after = s.stop
+	return nil, nil
+}
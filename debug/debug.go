@@ -0,0 +1,195 @@
+// Package debug は評価を文ごとに止めるデバッガ。
+//
+// Debugger.Hook を eval.Config.Hook に渡して評価すると、ブレークポイントや
+// ステップ実行で止まったところで OnStop が呼ばれ、再開の指示があるまで評価が止まる。
+// 評価と再開の指示は別のゴルーチンから行う。
+package debug
+
+import (
+	"sync"
+
+	"github.com/ei1chi/sample-lang/ast"
+	"github.com/ei1chi/sample-lang/eval"
+)
+
+// Reason 止まった理由。
+type Reason string
+
+const (
+	ENTRY      Reason = "entry"
+	BREAKPOINT Reason = "breakpoint"
+	STEP       Reason = "step"
+	PAUSE      Reason = "pause"
+)
+
+// Stop 止まったときの状態。Frames は外側の呼び出しから順に並ぶ。
+type Stop struct {
+	Reason Reason
+	Stmt   ast.Stmt
+	Frames []eval.Frame
+}
+
+// mode 再開したあとどこで止まるか。
+type mode int
+
+const (
+	run      mode = iota // ブレークポイントまで
+	stepIn               // 次の文
+	stepOver             // 同じ深さか外側の次の文
+	stepOut              // 外側の次の文
+)
+
+type Debugger struct {
+	// OnStop 止まるたびに評価しているゴルーチンから呼ばれる。
+	OnStop func(Stop)
+
+	mu          sync.Mutex
+	breakpoints map[int]bool
+	mode        mode
+	depth       int   // ステップ実行を始めたときの呼び出しの深さ
+	lines       []int // 深さごとに最後に止まった行。その行を離れるまではブレークポイントで止まり直さない
+	entry       bool
+	pause       bool
+	stop        *Stop // 止まっていなければ nil
+	resume      chan struct{}
+}
+
+func New() *Debugger {
+	return &Debugger{
+		breakpoints: map[int]bool{},
+		resume:      make(chan struct{}),
+	}
+}
+
+// StopOnEntry 最初の文で止まるようにする。評価を始める前に呼ぶ。
+func (d *Debugger) StopOnEntry() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.mode = stepIn
+	d.entry = true
+}
+
+// SetBreakpoints ブレークポイントを lines の行に置き換える。
+func (d *Debugger) SetBreakpoints(lines []int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.breakpoints = map[int]bool{}
+	for _, line := range lines {
+		d.breakpoints[line] = true
+	}
+}
+
+// Hook eval.Config.Hook に渡す。
+func (d *Debugger) Hook(stmt ast.Stmt, frames []eval.Frame) {
+	d.mu.Lock()
+	reason, ok := d.shouldStop(stmt, frames)
+	if !ok {
+		d.mu.Unlock()
+		return
+	}
+
+	// frames は評価が進むと書き換わるので写しておく
+	stop := Stop{Reason: reason, Stmt: stmt, Frames: append([]eval.Frame(nil), frames...)}
+	d.stop = &stop
+	d.lines[len(frames)] = stmt.Pos().Line
+	onStop := d.OnStop
+	d.mu.Unlock()
+
+	if onStop != nil {
+		onStop(stop)
+	}
+	<-d.resume
+}
+
+// shouldStop ブレークポイントの行は評価しているプログラムのもので、import したモジュールの中では止まらない。
+// 一つの行に文がいくつあっても、ブレークポイントで止まるのはその行に入ったときの一度だけ。
+func (d *Debugger) shouldStop(stmt ast.Stmt, frames []eval.Frame) (Reason, bool) {
+	depth := len(frames)
+	line := stmt.Pos().Line
+	inModule := depth > 0 && frames[depth-1].File != ""
+
+	// 戻った関数で止まった行は忘れ、同じ深さで別の行に移ったら止まった行を忘れる
+	for len(d.lines) <= depth {
+		d.lines = append(d.lines, 0)
+	}
+	d.lines = d.lines[:depth+1]
+	if d.lines[depth] != line {
+		d.lines[depth] = 0
+	}
+
+	switch {
+	case d.entry:
+		d.entry = false
+		return ENTRY, true
+	case d.pause:
+		d.pause = false
+		return PAUSE, true
+	case d.breakpoints[line] && !inModule && d.lines[depth] != line:
+		return BREAKPOINT, true
+	case d.mode == stepIn,
+		d.mode == stepOver && depth <= d.depth,
+		d.mode == stepOut && depth < d.depth:
+		return STEP, true
+	}
+	return "", false
+}
+
+// Stopped 止まっていればそのときの状態。
+func (d *Debugger) Stopped() (Stop, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.stop == nil {
+		return Stop{}, false
+	}
+	return *d.stop, true
+}
+
+// Pause 次の文で止める。
+func (d *Debugger) Pause() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.pause = true
+}
+
+// Continue 次のブレークポイントまで進める。
+func (d *Debugger) Continue() { d.proceed(run) }
+
+// StepIn 次の文まで進める。関数を呼んでいればその中で止まる。
+func (d *Debugger) StepIn() { d.proceed(stepIn) }
+
+// StepOver 呼び出した関数の中では止まらずに、次の文まで進める。
+func (d *Debugger) StepOver() { d.proceed(stepOver) }
+
+// StepOut 今の関数から戻ったところまで進める。
+func (d *Debugger) StepOut() { d.proceed(stepOut) }
+
+// Detach ブレークポイントを外して、最後まで止めずに実行させる。
+func (d *Debugger) Detach() {
+	d.mu.Lock()
+	d.breakpoints = map[int]bool{}
+	d.entry = false
+	d.pause = false
+	d.mode = run
+	d.mu.Unlock()
+
+	d.proceed(run)
+}
+
+// proceed 止まっていれば m のとおりに再開する。止まっていなければ何もしない。
+func (d *Debugger) proceed(m mode) {
+	d.mu.Lock()
+	if d.stop == nil {
+		d.mu.Unlock()
+		return
+	}
+	d.mode = m
+	d.depth = len(d.stop.Frames)
+	d.stop = nil
+	d.mu.Unlock()
+
+	d.resume <- struct{}{}
+}
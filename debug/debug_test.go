@@ -0,0 +1,145 @@
+package debug
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ei1chi/sample-lang/eval"
+	"github.com/ei1chi/sample-lang/lexer"
+	"github.com/ei1chi/sample-lang/object"
+	"github.com/ei1chi/sample-lang/parser"
+)
+
+const input = `let double = fn(x) {
+	let y = x * 2;
+	y
+};
+let a = double(1);
+let b = double(a);
+a + b`
+
+// start input の評価を始め、止まるたびに状態を stops に送る。
+func start(t *testing.T, d *Debugger, input string) (stops chan Stop, done chan object.Object) {
+	t.Helper()
+
+	p := parser.NewParser(lexer.NewLexer(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	stops = make(chan Stop)
+	done = make(chan object.Object, 1)
+	d.OnStop = func(s Stop) { stops <- s }
+
+	go func() {
+		done <- eval.New(eval.Config{Hook: d.Hook}).Eval(program, object.NewEnvironment())
+		close(stops)
+	}()
+	return stops, done
+}
+
+// describe "理由 行 深さ"
+func describe(s Stop) string {
+	return fmt.Sprintf("%s %d %d", s.Reason, s.Stmt.Pos().Line, len(s.Frames))
+}
+
+func TestStepping(t *testing.T) {
+	tests := []struct {
+		name     string
+		actions  []func(*Debugger) // 止まるたびに順に呼ぶ
+		expected []string
+	}{
+		{
+			"step in",
+			[]func(*Debugger){(*Debugger).StepIn, (*Debugger).StepIn, (*Debugger).StepIn, (*Debugger).StepIn, (*Debugger).Continue},
+			[]string{"entry 1 1", "step 5 1", "step 2 2", "step 3 2", "step 6 1"},
+		},
+		{
+			"step over",
+			[]func(*Debugger){(*Debugger).StepIn, (*Debugger).StepOver, (*Debugger).StepOver, (*Debugger).Continue},
+			[]string{"entry 1 1", "step 5 1", "step 6 1", "step 7 1"},
+		},
+		{
+			"step out",
+			[]func(*Debugger){(*Debugger).StepIn, (*Debugger).StepIn, (*Debugger).StepOut, (*Debugger).Continue},
+			[]string{"entry 1 1", "step 5 1", "step 2 2", "step 6 1"},
+		},
+	}
+
+	for _, test := range tests {
+		d := New()
+		d.StopOnEntry()
+		stops, done := start(t, d, input)
+
+		var got []string
+		for s := range stops {
+			got = append(got, describe(s))
+			if len(got) > len(test.actions) {
+				t.Fatalf("%s: too many stops: %q", test.name, got)
+			}
+			test.actions[len(got)-1](d)
+		}
+
+		if fmt.Sprint(got) != fmt.Sprint(test.expected) {
+			t.Errorf("%s: want %q, got %q", test.name, test.expected, got)
+		}
+		if result, ok := (<-done).(*object.Integer); !ok || result.Value != 6 {
+			t.Errorf("%s: wrong result %v", test.name, result)
+		}
+	}
+}
+
+func TestBreakpoints(t *testing.T) {
+	d := New()
+	d.SetBreakpoints([]int{2, 7})
+	stops, done := start(t, d, input)
+
+	var got []string
+	for s := range stops {
+		got = append(got, describe(s))
+
+		if stopped, ok := d.Stopped(); !ok || stopped.Stmt != s.Stmt {
+			t.Errorf("Stopped() does not report the current stop")
+		}
+		if s.Reason == BREAKPOINT && s.Stmt.Pos().Line == 2 {
+			x, _ := s.Frames[len(s.Frames)-1].Env.Get("x")
+			got = append(got, "x="+x.Inspect())
+		}
+		d.Continue()
+	}
+	<-done
+
+	expected := []string{"breakpoint 2 2", "x=1", "breakpoint 2 2", "x=2", "breakpoint 7 1"}
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("want %q, got %q", expected, got)
+	}
+	if _, ok := d.Stopped(); ok {
+		t.Errorf("still stopped after the program finished")
+	}
+}
+
+func TestBreakpointsOnOneLine(t *testing.T) {
+	input := `let f = fn(x) { let y = x; y };
+let a = 1; let b = f(a); let c = f(b);
+a + b + c`
+
+	d := New()
+	d.SetBreakpoints([]int{1, 2})
+	stops, done := start(t, d, input)
+
+	var got []string
+	for s := range stops {
+		got = append(got, describe(s))
+		d.Continue()
+	}
+
+	// 2 行目は f を呼んで戻ってきても同じ行なので止まり直さない。f の中では呼ぶたびに一度止まる
+	expected := []string{"breakpoint 1 1", "breakpoint 2 1", "breakpoint 1 2", "breakpoint 1 2"}
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("want %q, got %q", expected, got)
+	}
+	if result, ok := (<-done).(*object.Integer); !ok || result.Value != 3 {
+		t.Errorf("wrong result %v", result)
+	}
+}
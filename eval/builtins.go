@@ -0,0 +1,74 @@
+package eval
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/ei1chi/sample-lang/object"
+)
+
+// builtins どの環境からも使える組み込み関数。同じ名前を let で束縛すればそちらが優先する。
+var builtins = map[string]*object.Builtin{
+	// error(x) x をメッセージにしたエラーの値。throw と違って評価は止まらない
+	"error": {Fn: func(args ...object.Object) object.Object {
+		if len(args) != 1 {
+			return newError("wrong number of arguments. got=%d, want=1", len(args))
+		}
+		return &object.ErrorValue{Err: throw(args[0])}
+	}},
+
+	// is_error(x) x がエラーの値か
+	"is_error": {Fn: func(args ...object.Object) object.Object {
+		if len(args) != 1 {
+			return newError("wrong number of arguments. got=%d, want=1", len(args))
+		}
+		return nativeBooleanObject(args[0].Type() == object.ERROR_VALUE)
+	}},
+
+	// message(e) catch で受け取ったエラーのメッセージ
+	"message": {Fn: errorAccessor("message", func(err *object.Error) object.Object {
+		return &object.String{Value: err.Message}
+	})},
+
+	// trace(e) エラーが起きたときの呼び出しの列。内側から "at fib (4:10)" の形で 1 行ずつ並ぶ
+	"trace": {Fn: errorAccessor("trace", func(err *object.Error) object.Object {
+		lines := make([]string, len(err.Stack))
+		for i, f := range err.Stack {
+			lines[i] = f.Describe("")
+		}
+		return &object.String{Value: strings.Join(lines, "\n")}
+	})},
+}
+
+// BuiltinNames 組み込み関数の名前を辞書順に並べる。
+func BuiltinNames() []string {
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Builtins 組み込み関数を名前から引く表。仮想マシンのように評価器の外で同じ関数を使うときに渡す。
+func Builtins() map[string]object.Object {
+	m := make(map[string]object.Object, len(builtins))
+	for name, b := range builtins {
+		m[name] = b
+	}
+	return m
+}
+
+// errorAccessor エラーの値を一つ受け取る組み込み関数を作る。
+func errorAccessor(name string, get func(*object.Error) object.Object) object.BuiltinFunction {
+	return func(args ...object.Object) object.Object {
+		if len(args) != 1 {
+			return newError("wrong number of arguments. got=%d, want=1", len(args))
+		}
+		ev, ok := args[0].(*object.ErrorValue)
+		if !ok {
+			return newError("argument to `%s` must be %s, got %s", name, object.ERROR_VALUE, args[0].Type())
+		}
+		return get(ev.Err)
+	}
+}
@@ -1,72 +1,385 @@
 package eval
 
 import (
+	"context"
 	"fmt"
+	"time"
+	"unsafe"
 
 	"github.com/ei1chi/sample-lang/ast"
 	"github.com/ei1chi/sample-lang/object"
+	"github.com/ei1chi/sample-lang/token"
 )
 
 var (
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
-	NULL  = &object.Null{}
+	TRUE  = object.TrueValue
+	FALSE = object.FalseValue
+	NULL  = object.NullValue
 )
 
-func Eval(node ast.Node) object.Object {
+// DefaultMaxDepth MaxDepth がゼロのときの関数呼び出しの深さの上限。
+// 評価器は Go の再帰で関数を呼ぶので、深さに上限がないと Go のスタックを使い切ってプロセスごと落ちる。
+const DefaultMaxDepth = 10000
+
+// Config 評価の設定。実行制限はゼロ値なら制限しない。ただし MaxDepth は DefaultMaxDepth になる。
+type Config struct {
+	MaxSteps int              // 評価するノード数の上限
+	MaxDepth int              // 関数呼び出しの深さの上限
+	MaxAlloc int64            // 割り当てるオブジェクトの推定バイト数の上限
+	Context  context.Context  // キャンセルされたら評価を中断する
+	Hook     Hook             // 文を評価する直前に呼ぶ
+	Loader   *Loader          // import するモジュールを探す。nil なら import はエラーになる
+	Clock    func() time.Time // time.now が返す今の時刻。nil なら time.Now
+
+	// Importer import のたびに組み込みのモジュールより先に呼ぶ。nil を返したらいつもどおり探す。
+	// ホストがモジュールを差し替えたり、import に権限を求めたりするのに使う。
+	Importer func(path string) object.Object
+}
+
+// Hook 文を評価する直前に呼ばれる。frames は呼び出し中の関数で、最後の要素が stmt を評価しているもの。
+// frames はフックから戻るまでのあいだだけ有効。
+type Hook func(stmt ast.Stmt, frames []Frame)
+
+// Frame 評価中の関数呼び出し。一番外側はプログラム全体を表す。
+type Frame struct {
+	Name string         // 呼び出した関数の名前。名前で呼ばなかったら空
+	Call token.Position // 呼び出した位置。プログラム全体ならゼロ値
+	Env  *object.Environment
+	Stmt ast.Stmt // 評価中の文
+	File string   // Stmt のあるモジュールのファイル。評価したプログラムそのものなら空
+}
+
+// Evaluator 制限付きで評価を行う。カウンタを持つので評価ごとに New で作る。
+type Evaluator struct {
+	cfg    Config
+	steps  int
+	depth  int
+	alloc  int64
+	frames []Frame
+
+	timeModule    *object.Module // import "time" の結果。now が Clock を使うので評価ごとに作る
+	stringsModule *object.Module // import "strings" の結果。repeat が割り当ての上限を使うので評価ごとに作る
+}
+
+func New(cfg Config) *Evaluator {
+	return &Evaluator{cfg: cfg}
+}
+
+// Eval 制限なしで node を評価する。
+func Eval(node ast.Node, env *object.Environment) object.Object {
+	return New(Config{}).Eval(node, env)
+}
+
+// Eval node を評価する。実行時エラーには、それが起きた位置と呼び出し中の関数を記録する。
+func (e *Evaluator) Eval(node ast.Node, env *object.Environment) object.Object {
+	result := e.eval(node, env)
+
+	// 位置がまだなければ、このノードがエラーの起きたところ
+	if err, ok := result.(*object.Error); ok && err.Pos == (token.Position{}) {
+		e.trace(err, node.Pos())
+	}
+	return result
+}
+
+func (e *Evaluator) eval(node ast.Node, env *object.Environment) object.Object {
+	if err := e.step(); err != nil {
+		return err
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
-		return evalProgram(node.Stmts)
+		e.frames = append(e.frames, Frame{Name: "main", Env: env})
+		defer e.popFrame()
+		return e.evalProgram(node.Stmts, env)
 
 	case *ast.BlockStmt:
-		return evalBlockStmt(node.Stmts)
+		return e.evalBlockStmt(node.Stmts, env)
 
 	case *ast.ReturnStmt:
-		val := Eval(node.ReturnValue)
-		if isError(val) {
+		val := e.Eval(node.ReturnValue, env)
+		if isAbrupt(val) {
 			return val
 		}
+		if err := e.allocate(returnValueSize); err != nil {
+			return err
+		}
 		return &object.ReturnValue{Value: val}
 
+	case *ast.LetStmt:
+		val := e.Eval(node.Value, env)
+		if isAbrupt(val) {
+			return val
+		}
+		env.Set(node.Name.Value, val)
+
+	case *ast.ImportStmt:
+		mod := e.importModule(node.Path.Value, node.Pos())
+		if isError(mod) {
+			return mod
+		}
+		env.Set(node.Name.Value, mod)
+
+	case *ast.ExportStmt:
+		// 外から見えるかどうかは import した側で決まるので、ここでは let と同じ
+		return e.Eval(node.Let, env)
+
 	case *ast.IfExpr:
-		return evalIfExpr(node)
+		return e.evalIfExpr(node, env)
 
 	case *ast.ExprStmt:
-		return Eval(node.Expr)
+		return e.Eval(node.Expr, env)
+
+	case *ast.ThrowStmt:
+		val := e.Eval(node.Value, env)
+		if isAbrupt(val) {
+			return val
+		}
+		return throw(val)
+
+	case *ast.TryExpr:
+		return e.evalTryExpr(node, env)
 
 	case *ast.IntLiteral:
-		return &object.Integer{Value: node.Value}
+		return e.newInteger(node.Value)
+
+	case *ast.StringLiteral:
+		return e.newString(node.Value)
 
 	case *ast.Boolean:
 		return nativeBooleanObject(node.Value)
 
+	case *ast.ArrayLiteral:
+		elems := e.evalExprs(node.Elems, env)
+		if len(elems) == 1 && isAbrupt(elems[0]) {
+			return elems[0]
+		}
+		return e.newArray(elems)
+
+	case *ast.MapLiteral:
+		return e.evalMapLiteral(node, env)
+
+	case *ast.Ident:
+		return evalIdent(node, env)
+
+	case *ast.FuncLiteral:
+		if err := e.allocate(functionSize); err != nil {
+			return err
+		}
+		return &object.Function{Params: node.Params, Body: node.Body, Env: env, File: e.file()}
+
+	case *ast.CallExpr:
+		fn := e.Eval(node.Fn, env)
+		if isAbrupt(fn) {
+			return fn
+		}
+		args := e.evalExprs(node.Args, env)
+		if len(args) == 1 && isAbrupt(args[0]) {
+			return args[0]
+		}
+		return e.applyFunction(callName(node.Fn), node.Pos(), fn, args)
+
 	case *ast.PrefixExpr:
-		right := Eval(node.Right)
-		if isError(right) {
+		right := e.Eval(node.Right, env)
+		if isAbrupt(right) {
 			return right
 		}
-		return evalPrefixExpr(node.Operator, right)
+		return e.evalPrefixExpr(node.Operator, right)
+
+	case *ast.IndexExpr:
+		left := e.Eval(node.Left, env)
+		if isAbrupt(left) {
+			return left
+		}
+		index := e.Eval(node.Index, env)
+		if isAbrupt(index) {
+			return index
+		}
+		return evalIndexExpr(left, index)
+
+	case *ast.SelectorExpr:
+		x := e.Eval(node.X, env)
+		if isAbrupt(x) {
+			return x
+		}
+		return evalSelectorExpr(x, node.Sel.Value)
+
+	case *ast.PostfixExpr:
+		left := e.Eval(node.Left, env)
+		if isAbrupt(left) {
+			return left
+		}
+		return e.evalPostfixExpr(node.Operator, left)
 
 	case *ast.InfixExpr:
-		left := Eval(node.Left)
-		if isError(left) {
+		left := e.Eval(node.Left, env)
+		if isAbrupt(left) {
 			return left
 		}
-		right := Eval(node.Right)
-		if isError(right) {
+		right := e.Eval(node.Right, env)
+		if isAbrupt(right) {
 			return right
 		}
-		return evalInfixExpr(node.Operator, left, right)
+		return e.evalInfixExpr(node.Operator, left, right)
+	}
+
+	return nil
+}
+
+// 割り当ての見積もりに使うバイト数
+var (
+	integerSize     = int64(unsafe.Sizeof(object.Integer{}))
+	stringSize      = int64(unsafe.Sizeof(object.String{}))
+	arraySize       = int64(unsafe.Sizeof(object.Array{}))
+	elemSize        = int64(unsafe.Sizeof(object.Object(nil)))
+	mapSize         = int64(unsafe.Sizeof(object.Map{}))
+	floatSize       = int64(unsafe.Sizeof(object.Float{}))
+	timeSize        = int64(unsafe.Sizeof(object.Time{}))
+	durationSize    = int64(unsafe.Sizeof(object.Duration{}))
+	returnValueSize = int64(unsafe.Sizeof(object.ReturnValue{}))
+	functionSize    = int64(unsafe.Sizeof(object.Function{}))
+	environmentSize = int64(unsafe.Sizeof(object.Environment{}))
+	bindingSize     = int64(unsafe.Sizeof("")) + int64(unsafe.Sizeof(object.Object(nil)))
+)
+
+// step ノードを一つ評価するたびに呼び、制限を超えていたらエラーを返す。
+func (e *Evaluator) step() *object.Error {
+	e.steps++
+	if e.cfg.MaxSteps > 0 && e.steps > e.cfg.MaxSteps {
+		return newLimitError(object.STEP_LIMIT_EXCEEDED)
+	}
+
+	if e.cfg.Context != nil {
+		select {
+		case <-e.cfg.Context.Done():
+			return &object.Error{Kind: object.CONTEXT_CANCELED, Message: e.cfg.Context.Err().Error()}
+		default:
+		}
 	}
 
 	return nil
 }
 
-func evalProgram(stmts []ast.Stmt) object.Object {
+func (e *Evaluator) allocate(size int64) *object.Error {
+	e.alloc += size
+	if e.cfg.MaxAlloc > 0 && e.alloc > e.cfg.MaxAlloc {
+		return newLimitError(object.ALLOC_LIMIT_EXCEEDED)
+	}
+	return nil
+}
+
+// checkAllocate size を割り当てても上限を超えないか。割り当てたとは数えない。
+// 組み込み関数が大きな値を作る前に調べるのに使い、作った値は呼び出し側が allocate で数える。
+func (e *Evaluator) checkAllocate(size int64) *object.Error {
+	if e.cfg.MaxAlloc > 0 && e.alloc+size > e.cfg.MaxAlloc {
+		return newLimitError(object.ALLOC_LIMIT_EXCEEDED)
+	}
+	return nil
+}
+
+func (e *Evaluator) newInteger(value int64) object.Object {
+	if err := e.allocate(integerSize); err != nil {
+		return err
+	}
+	return &object.Integer{Value: value}
+}
+
+func (e *Evaluator) newString(value string) object.Object {
+	if err := e.allocate(stringSize + int64(len(value))); err != nil {
+		return err
+	}
+	return &object.String{Value: value}
+}
+
+// objectSize 組み込み関数が作った値の割り当ての見積もり。配列や連想配列なら中の値も数える。
+func objectSize(obj object.Object) int64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return integerSize
+	case *object.String:
+		return stringSize + int64(len(obj.Value))
+	case *object.Float:
+		return floatSize
+	case *object.Time:
+		return timeSize
+	case *object.Duration:
+		return durationSize
+	case *object.Array:
+		size := arraySize + elemSize*int64(len(obj.Elems))
+		for _, elem := range obj.Elems {
+			size += objectSize(elem)
+		}
+		return size
+	case *object.Map:
+		size := mapSize
+		for _, key := range obj.Keys {
+			size += bindingSize + int64(len(key)) + objectSize(obj.Values[key])
+		}
+		return size
+	}
+	return 0
+}
+
+func (e *Evaluator) newArray(elems []object.Object) object.Object {
+	if err := e.allocate(arraySize + elemSize*int64(len(elems))); err != nil {
+		return err
+	}
+	return &object.Array{Elems: elems}
+}
+
+func (e *Evaluator) newFloat(value float64) object.Object {
+	if err := e.allocate(floatSize); err != nil {
+		return err
+	}
+	return &object.Float{Value: value}
+}
+
+func (e *Evaluator) newTime(value time.Time) object.Object {
+	if err := e.allocate(timeSize); err != nil {
+		return err
+	}
+	return &object.Time{Value: value}
+}
+
+func (e *Evaluator) newDuration(value time.Duration) object.Object {
+	if err := e.allocate(durationSize); err != nil {
+		return err
+	}
+	return &object.Duration{Value: value}
+}
+
+// evalMapLiteral キーと値を組ごとに左から評価する。同じキーが二度現れたら後の値になる。
+func (e *Evaluator) evalMapLiteral(node *ast.MapLiteral, env *object.Environment) object.Object {
+	if err := e.allocate(mapSize); err != nil {
+		return err
+	}
+	m := object.NewMap()
+	for i := range node.Keys {
+		key := e.Eval(node.Keys[i], env)
+		if isAbrupt(key) {
+			return key
+		}
+		str, ok := key.(*object.String)
+		if !ok {
+			return newError("map key must be %s, got %s", object.STRING, key.Type())
+		}
+		val := e.Eval(node.Values[i], env)
+		if isAbrupt(val) {
+			return val
+		}
+		if err := e.allocate(bindingSize); err != nil {
+			return err
+		}
+		m.Set(str.Value, val)
+	}
+	return m
+}
+
+func (e *Evaluator) evalProgram(stmts []ast.Stmt, env *object.Environment) object.Object {
 	var result object.Object
 
 	for _, stmt := range stmts {
-		result = Eval(stmt)
+		e.enterStmt(stmt)
+		result = e.Eval(stmt, env)
 
 		// BlockStmts もしくは ProgramStmts の中で、
 		// retrun 文が来たら中断して上流に返す
@@ -81,11 +394,12 @@ func evalProgram(stmts []ast.Stmt) object.Object {
 	return result
 }
 
-func evalBlockStmt(stmts []ast.Stmt) object.Object {
+func (e *Evaluator) evalBlockStmt(stmts []ast.Stmt, env *object.Environment) object.Object {
 	var result object.Object
 
 	for _, stmt := range stmts {
-		result = Eval(stmt)
+		e.enterStmt(stmt)
+		result = e.Eval(stmt, env)
 
 		// BlockStmts の中で、
 		// retrun 文が来たら中断して上流に返す
@@ -97,24 +411,86 @@ func evalBlockStmt(stmts []ast.Stmt) object.Object {
 		}
 	}
 
+	// 空のブロックや let で終わるブロックも、仮想マシンと同じく値は NULL
+	if result == nil {
+		return NULL
+	}
 	return result
 }
 
-func evalPrefixExpr(ope string, right object.Object) object.Object {
+// enterStmt 評価中の文を記録してフックを呼ぶ。
+func (e *Evaluator) enterStmt(stmt ast.Stmt) {
+	if len(e.frames) == 0 {
+		return
+	}
+	e.frames[len(e.frames)-1].Stmt = stmt
+	if e.cfg.Hook != nil {
+		e.cfg.Hook(stmt, e.frames)
+	}
+}
+
+// trace エラーが pos で起きたことと、そのときの呼び出しの列を err に記録する。
+func (e *Evaluator) trace(err *object.Error, pos token.Position) {
+	err.Pos = pos
+	err.Stack = make([]object.StackFrame, 0, len(e.frames))
+	for i := len(e.frames) - 1; i >= 0; i-- {
+		err.Stack = append(err.Stack, object.StackFrame{Name: e.frames[i].Name, Pos: pos, File: e.frames[i].File})
+		pos = e.frames[i].Call
+	}
+}
+
+// file 評価中の文のあるモジュールのファイル。
+func (e *Evaluator) file() string {
+	if len(e.frames) == 0 {
+		return ""
+	}
+	return e.frames[len(e.frames)-1].File
+}
+
+func (e *Evaluator) popFrame() {
+	e.frames = e.frames[:len(e.frames)-1]
+}
+
+// evalPostfixExpr x? は x がエラーの値なら、それを返り値にして関数から抜ける。
+func (e *Evaluator) evalPostfixExpr(ope string, left object.Object) object.Object {
+	switch ope {
+	case "?":
+		if left.Type() != object.ERROR_VALUE {
+			return left
+		}
+		if err := e.allocate(returnValueSize); err != nil {
+			return err
+		}
+		return &object.ReturnValue{Value: left}
+	default:
+		return newError("unknown operator: %s%s", left.Type(), ope)
+	}
+}
+
+func (e *Evaluator) evalPrefixExpr(ope string, right object.Object) object.Object {
 	switch ope {
 	case "!":
 		return evalBangOperatorExpr(right)
 	case "-":
-		return evalMinusPrefixOperatorExpr(right)
+		return e.evalMinusPrefixOperatorExpr(right)
 	default:
 		return newError("unknown operator: %s%s", ope, right.Type())
 	}
 }
 
-func evalInfixExpr(ope string, left, right object.Object) object.Object {
+func (e *Evaluator) evalInfixExpr(ope string, left, right object.Object) object.Object {
+	if result := e.evalTimeInfixExpr(ope, left, right); result != nil {
+		return result
+	}
+
 	switch {
 	case left.Type() == object.INTEGER && right.Type() == object.INTEGER:
-		return evalIntegerInfixExpr(ope, left, right)
+		return e.evalIntegerInfixExpr(ope, left, right)
+	case isNumber(left) && isNumber(right):
+		// 片方が浮動小数点数なら、もう片方も浮動小数点数にして計算する
+		return e.evalFloatInfixExpr(ope, toFloat(left), toFloat(right))
+	case left.Type() == object.STRING && right.Type() == object.STRING:
+		return evalStringInfixExpr(ope, left, right)
 	case ope == "==":
 		return nativeBooleanObject(left == right)
 	case ope == "!=":
@@ -126,6 +502,99 @@ func evalInfixExpr(ope string, left, right object.Object) object.Object {
 	}
 }
 
+func (e *Evaluator) evalFloatInfixExpr(ope string, lval, rval float64) object.Object {
+	switch ope {
+	case "+":
+		return e.newFloat(lval + rval)
+	case "-":
+		return e.newFloat(lval - rval)
+	case "*":
+		return e.newFloat(lval * rval)
+	case "/":
+		if rval == 0 {
+			return newError("division by zero: %s / %s", (&object.Float{Value: lval}).Inspect(), (&object.Float{Value: rval}).Inspect())
+		}
+		return e.newFloat(lval / rval)
+
+	case "<":
+		return nativeBooleanObject(lval < rval)
+	case ">":
+		return nativeBooleanObject(lval > rval)
+	case "==":
+		return nativeBooleanObject(lval == rval)
+	case "!=":
+		return nativeBooleanObject(lval != rval)
+	}
+	return newError("unknown operator: %s %s %s", object.FLOAT, ope, object.FLOAT)
+}
+
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER || obj.Type() == object.FLOAT
+}
+
+func toFloat(obj object.Object) float64 {
+	if i, ok := obj.(*object.Integer); ok {
+		return float64(i.Value)
+	}
+	return obj.(*object.Float).Value
+}
+
+func evalStringInfixExpr(ope string, left, right object.Object) object.Object {
+	lval := left.(*object.String).Value
+	rval := right.(*object.String).Value
+
+	switch ope {
+	case "==":
+		return nativeBooleanObject(lval == rval)
+	case "!=":
+		return nativeBooleanObject(lval != rval)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), ope, right.Type())
+	}
+}
+
+// evalSelectorExpr モジュールが export した変数 name の値。
+func evalSelectorExpr(x object.Object, name string) object.Object {
+	mod, ok := x.(*object.Module)
+	if !ok {
+		return newError("cannot select %s from %s", name, x.Type())
+	}
+	val, ok := mod.Exports[name]
+	if !ok {
+		return newError("module %q does not export %s", mod.Path, name)
+	}
+	return val
+}
+
+// evalIndexExpr 配列の index 番目の要素か、連想配列のキー index の値。
+// 配列の範囲の外はエラーにし、連想配列にないキーは null にする。
+func evalIndexExpr(left, index object.Object) object.Object {
+	switch left := left.(type) {
+	case *object.Array:
+		i, ok := index.(*object.Integer)
+		if !ok {
+			return newError("array index must be %s, got %s", object.INTEGER, index.Type())
+		}
+		if i.Value < 0 || i.Value >= int64(len(left.Elems)) {
+			return newError("index out of range: %d with length %d", i.Value, len(left.Elems))
+		}
+		return left.Elems[i.Value]
+
+	case *object.Map:
+		key, ok := index.(*object.String)
+		if !ok {
+			return newError("map key must be %s, got %s", object.STRING, index.Type())
+		}
+		if val, ok := left.Values[key.Value]; ok {
+			return val
+		}
+		return NULL
+
+	default:
+		return newError("index operator not supported: %s", left.Type())
+	}
+}
+
 func nativeBooleanObject(input bool) *object.Boolean {
 	if input {
 		return TRUE
@@ -146,28 +615,35 @@ func evalBangOperatorExpr(right object.Object) object.Object {
 	}
 }
 
-func evalMinusPrefixOperatorExpr(right object.Object) object.Object {
-	if right.Type() != object.INTEGER {
+func (e *Evaluator) evalMinusPrefixOperatorExpr(right object.Object) object.Object {
+	switch right := right.(type) {
+	case *object.Integer:
+		return e.newInteger(-right.Value)
+	case *object.Float:
+		return e.newFloat(-right.Value)
+	case *object.Duration:
+		return e.newDuration(-right.Value)
+	default:
 		return newError("unknown operator: -%s", right.Type())
 	}
-
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
 }
 
-func evalIntegerInfixExpr(ope string, left, right object.Object) object.Object {
+func (e *Evaluator) evalIntegerInfixExpr(ope string, left, right object.Object) object.Object {
 	lval := left.(*object.Integer).Value
 	rval := right.(*object.Integer).Value
 
 	switch ope {
 	case "+":
-		return &object.Integer{Value: lval + rval}
+		return e.newInteger(lval + rval)
 	case "-":
-		return &object.Integer{Value: lval - rval}
+		return e.newInteger(lval - rval)
 	case "*":
-		return &object.Integer{Value: lval * rval}
+		return e.newInteger(lval * rval)
 	case "/":
-		return &object.Integer{Value: lval / rval}
+		if rval == 0 {
+			return newError("division by zero: %d / %d", lval, rval)
+		}
+		return e.newInteger(lval / rval)
 
 	case "<":
 		return nativeBooleanObject(lval < rval)
@@ -181,21 +657,168 @@ func evalIntegerInfixExpr(ope string, left, right object.Object) object.Object {
 	return newError("unknown operator: %s %s %s", left.Type(), ope, right.Type())
 }
 
-func evalIfExpr(ie *ast.IfExpr) object.Object {
-	cond := Eval(ie.Cond)
-	if isError(cond) {
+func (e *Evaluator) evalIfExpr(ie *ast.IfExpr, env *object.Environment) object.Object {
+	cond := e.Eval(ie.Cond, env)
+	if isAbrupt(cond) {
 		return cond
 	}
 
 	if isTruthy(cond) {
-		return Eval(ie.Cons)
+		return e.Eval(ie.Cons, env)
 	} else if ie.Alt != nil {
-		return Eval(ie.Alt)
+		return e.Eval(ie.Alt, env)
 	} else {
 		return NULL
 	}
 }
 
+// throw val を投げるエラーにする。catch で受け取ったエラーなら、起きた位置を保ったまま投げ直す。
+func throw(val object.Object) *object.Error {
+	switch val := val.(type) {
+	case *object.ErrorValue:
+		return val.Err
+	case *object.String:
+		return newError("%s", val.Value)
+	default:
+		return newError("%s", val.Inspect())
+	}
+}
+
+// evalTryExpr 値は本体か catch の最後の値。finally の値は使わないが、
+// finally の中のエラーと return は try の結果より優先する。
+func (e *Evaluator) evalTryExpr(te *ast.TryExpr, env *object.Environment) object.Object {
+	result := e.Eval(te.Body, env)
+
+	if err, ok := result.(*object.Error); ok {
+		// 実行制限によるエラーは catch も finally もせずに止める
+		if !err.Catchable() {
+			return err
+		}
+		if te.Catch != nil {
+			// catch の引数と catch の中の let は外に漏らさない
+			catchEnv := object.NewEnclosedEnvironment(env)
+			catchEnv.Set(te.Param.Value, &object.ErrorValue{Err: err})
+			result = e.Eval(te.Catch, catchEnv)
+		}
+	}
+
+	if te.Finally != nil {
+		fin := e.Eval(te.Finally, env)
+		if fin != nil && (fin.Type() == object.ERROR || fin.Type() == object.RETURN_VALUE) {
+			return fin
+		}
+	}
+
+	return result
+}
+
+func evalIdent(node *ast.Ident, env *object.Environment) object.Object {
+	if val, ok := env.Get(node.Value); ok {
+		return val
+	}
+	if builtin, ok := builtins[node.Value]; ok {
+		return builtin
+	}
+	return newError("identifier not found: %s", node.Value)
+}
+
+// evalExprs 引数を左から順に評価する。エラーか ? で抜ける値があればそれだけを返す。
+func (e *Evaluator) evalExprs(exprs []ast.Expr, env *object.Environment) []object.Object {
+	var result []object.Object
+
+	for _, expr := range exprs {
+		evaled := e.Eval(expr, env)
+		if isAbrupt(evaled) {
+			return []object.Object{evaled}
+		}
+		result = append(result, evaled)
+	}
+
+	return result
+}
+
+// callName 呼び出した式が識別子ならその名前。モジュールの変数なら mod.name の形。
+func callName(fn ast.Expr) string {
+	switch fn := fn.(type) {
+	case *ast.Ident:
+		return fn.Value
+	case *ast.SelectorExpr:
+		if x := callName(fn.X); x != "" && fn.Sel != nil {
+			return x + "." + fn.Sel.Value
+		}
+	}
+	return ""
+}
+
+// applyFunction name は呼び出した関数の名前、pos は呼び出した位置。
+func (e *Evaluator) applyFunction(name string, pos token.Position, fn object.Object, args []object.Object) object.Object {
+	switch fn := fn.(type) {
+	case *object.Function:
+		if len(args) != len(fn.Params) {
+			return newError("wrong number of arguments. got=%d, want=%d", len(args), len(fn.Params))
+		}
+
+		maxDepth := e.cfg.MaxDepth
+		if maxDepth <= 0 {
+			maxDepth = DefaultMaxDepth
+		}
+		if e.depth >= maxDepth {
+			return newLimitError(object.DEPTH_LIMIT_EXCEEDED)
+		}
+		e.depth++
+		defer func() { e.depth-- }()
+
+		if err := e.allocate(environmentSize + bindingSize*int64(len(args))); err != nil {
+			return err
+		}
+		extendedEnv := extendFunctionEnv(fn, args)
+		e.frames = append(e.frames, Frame{Name: name, Call: pos, Env: extendedEnv, File: fn.File})
+		defer e.popFrame()
+		evaled := e.Eval(fn.Body, extendedEnv)
+		return unwrapReturnValue(evaled)
+
+	case *object.Builtin:
+		var result object.Object
+		if fn.CallFn != nil {
+			result = fn.CallFn(func(f object.Object, args ...object.Object) object.Object {
+				return e.applyFunction(name, pos, f, args)
+			}, args...)
+		} else {
+			result = fn.Fn(args...)
+		}
+		// 組み込み関数の中の割り当ては見えないので、返した値の大きさで見積もる
+		if err := e.allocate(objectSize(result)); err != nil {
+			return err
+		}
+		// error() で作ったエラーの値には、作った位置を残す
+		if ev, ok := result.(*object.ErrorValue); ok && ev.Err.Pos == (token.Position{}) {
+			e.trace(ev.Err, pos)
+		}
+		return result
+
+	default:
+		return newError("not a function: %s", fn.Type())
+	}
+}
+
+func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
+	env := object.NewEnclosedEnvironment(fn.Env)
+
+	for i, param := range fn.Params {
+		env.Set(param.Value, args[i])
+	}
+
+	return env
+}
+
+// unwrapReturnValue 関数の中の return で呼び出し元まで抜けないよう、ここで値を取り出す。
+func unwrapReturnValue(obj object.Object) object.Object {
+	if rv, ok := obj.(*object.ReturnValue); ok {
+		return rv.Value
+	}
+	return obj
+}
+
 func isTruthy(obj object.Object) bool {
 	switch obj {
 	case NULL:
@@ -213,9 +836,18 @@ func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
+func newLimitError(kind object.ErrorKind) *object.Error {
+	return &object.Error{Kind: kind, Message: string(kind)}
+}
+
 func isError(obj object.Object) bool {
 	if obj != nil {
 		return obj.Type() == object.ERROR
 	}
 	return false
 }
+
+// isAbrupt エラーか、? で関数から抜ける途中の値か。どちらも残りの式を評価せずに上流へ返す。
+func isAbrupt(obj object.Object) bool {
+	return isError(obj) || obj != nil && obj.Type() == object.RETURN_VALUE
+}
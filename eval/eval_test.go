@@ -1,8 +1,13 @@
 package eval
 
 import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/ei1chi/sample-lang/ast"
 	"github.com/ei1chi/sample-lang/lexer"
 	"github.com/ei1chi/sample-lang/object"
 	"github.com/ei1chi/sample-lang/parser"
@@ -13,7 +18,9 @@ func testEval(input string) object.Object {
 	p := parser.NewParser(l)
 	program := p.ParseProgram()
 
-	return Eval(program)
+	env := object.NewEnvironment()
+
+	return Eval(program, env)
 }
 
 func TestEvalBooleanExpr(t *testing.T) {
@@ -53,6 +60,9 @@ func TestIfElseExpr(t *testing.T) {
 		{"if (1) {10}", 10},
 		{"if (1 < 2) {10}", 10},
 		{"if (1 > 2) { 10 } else { 20 }", 20},
+		{"if (true) {}", nil},
+		{"if (true) { let a = 1; }", nil},
+		{"let f = fn() {}; f()", nil},
 	}
 
 	for _, test := range tests {
@@ -105,6 +115,30 @@ func TestErrorHandling(t *testing.T) {
 			"true + false",
 			"unknown operator: BOOLEAN + BOOLEAN",
 		},
+		{
+			"foobar",
+			"identifier not found: foobar",
+		},
+		{
+			"let f = fn(x) { x }; f(1, 2)",
+			"wrong number of arguments. got=2, want=1",
+		},
+		{
+			"5(1)",
+			"not a function: INTEGER",
+		},
+		{
+			"10 / (5 - 5)",
+			"division by zero: 10 / 0",
+		},
+		{
+			"let f = fn() {}; f() + 1",
+			"type mismatch: NULL + INTEGER",
+		},
+		{
+			"let x = if (true) {}; x + 1",
+			"type mismatch: NULL + INTEGER",
+		},
 	}
 
 	for _, test := range tests {
@@ -199,3 +233,432 @@ func TestBangOperator(t *testing.T) {
 		testBooleanObject(t, evaled, test.expected)
 	}
 }
+
+func TestLetStmts(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let a = 5; a;", 5},
+		{"let a = 5 * 5; a;", 25},
+		{"let a = 5; let b = a; b;", 5},
+		{"let a = 5; let b = a; let c = a + b + 5; c;", 15},
+	}
+
+	for _, test := range tests {
+		testIntegerObject(t, testEval(test.input), test.expected)
+	}
+}
+
+func TestFuncObject(t *testing.T) {
+	input := "fn(x) { x + 2; };"
+
+	evaled := testEval(input)
+	fn, ok := evaled.(*object.Function)
+	if !ok {
+		t.Fatalf("object is not Function. got=%T (%+v)", evaled, evaled)
+	}
+
+	if len(fn.Params) != 1 {
+		t.Fatalf("function has wrong parameters. Params=%+v", fn.Params)
+	}
+
+	if fn.Params[0].String() != "x" {
+		t.Fatalf("parameter is not 'x'. got=%q", fn.Params[0])
+	}
+
+	if fn.Body.String() != "(x + 2)" {
+		t.Fatalf("body is not %q. got=%q", "(x + 2)", fn.Body.String())
+	}
+}
+
+func TestFuncApplication(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let identity = fn(x) { x; }; identity(5);", 5},
+		{"let identity = fn(x) { return x; }; identity(5);", 5},
+		{"let double = fn(x) { x * 2; }; double(5);", 10},
+		{"let add = fn(x, y) { x + y; }; add(5, 5);", 10},
+		{"let add = fn(x, y) { x + y; }; add(5 + 5, add(5, 5));", 20},
+		{"fn(x) { x; }(5)", 5},
+		{"let f = fn(x) { return x; 10; }; f(1) + 1;", 2},
+	}
+
+	for _, test := range tests {
+		testIntegerObject(t, testEval(test.input), test.expected)
+	}
+}
+
+func TestClosures(t *testing.T) {
+	input := `
+	let newAdder = fn(x) {
+		fn(y) { x + y };
+	};
+
+	let addTwo = newAdder(2);
+	addTwo(2);`
+
+	testIntegerObject(t, testEval(input), 4)
+}
+
+func TestBuiltinFunction(t *testing.T) {
+	l := lexer.NewLexer("twice(21)")
+	p := parser.NewParser(l)
+	program := p.ParseProgram()
+
+	env := object.NewEnvironment()
+	env.Set("twice", &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+	}})
+
+	testIntegerObject(t, Eval(program, env), 42)
+}
+
+func TestLimits(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		input    string
+		cfg      Config
+		expected object.ErrorKind
+	}{
+		{
+			"let loop = fn(n) { loop(n + 1) }; loop(0)",
+			Config{MaxSteps: 1000},
+			object.STEP_LIMIT_EXCEEDED,
+		},
+		{
+			"let loop = fn(n) { loop(n + 1) }; loop(0)",
+			Config{MaxDepth: 50},
+			object.DEPTH_LIMIT_EXCEEDED,
+		},
+		{
+			"let loop = fn(n) { loop(n + 1) }; loop(0)",
+			Config{MaxAlloc: 4096},
+			object.ALLOC_LIMIT_EXCEEDED,
+		},
+		{
+			// 制限しなくても、Go のスタックを使い切る前に止める
+			"let f = fn(n) { f(n + 1) }; f(0)",
+			Config{},
+			object.DEPTH_LIMIT_EXCEEDED,
+		},
+		{
+			"1 + 2",
+			Config{Context: canceled},
+			object.CONTEXT_CANCELED,
+		},
+		{
+			`import "strings"; strings.repeat("abcd", 10000)`,
+			Config{MaxAlloc: 4096},
+			object.ALLOC_LIMIT_EXCEEDED,
+		},
+		{
+			// 結果を作る前に止めないと、4 TiB を確保しようとする
+			`import "strings"; strings.repeat("abcd", 1099511627776)`,
+			Config{MaxAlloc: 4096},
+			object.ALLOC_LIMIT_EXCEEDED,
+		},
+	}
+
+	for _, test := range tests {
+		l := lexer.NewLexer(test.input)
+		p := parser.NewParser(l)
+		program := p.ParseProgram()
+
+		evaled := New(test.cfg).Eval(program, object.NewEnvironment())
+
+		errObj, ok := evaled.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaled, evaled)
+			continue
+		}
+		if errObj.Kind != test.expected {
+			t.Errorf("wrong error kind. expected=%q, got=%q", test.expected, errObj.Kind)
+		}
+	}
+}
+
+func TestLimitsNotExceeded(t *testing.T) {
+	input := `
+	let countdown = fn(n) { if (n > 0) { countdown(n - 1) } else { n } };
+	countdown(10)`
+
+	l := lexer.NewLexer(input)
+	p := parser.NewParser(l)
+	program := p.ParseProgram()
+
+	cfg := Config{MaxSteps: 10000, MaxDepth: 20, MaxAlloc: 1 << 20, Context: context.Background()}
+	testIntegerObject(t, New(cfg).Eval(program, object.NewEnvironment()), 0)
+}
+
+func TestHook(t *testing.T) {
+	input := `let double = fn(x) {
+	let y = x * 2;
+	y
+};
+let a = double(1);
+if (a > 1) { a }`
+
+	// 各文について "行 呼び出し中の関数" を並べる
+	expected := []string{
+		"1 main",
+		"5 main",
+		"2 main>double",
+		"3 main>double",
+		"6 main",
+		"6 main", // if の中の a
+	}
+
+	l := lexer.NewLexer(input)
+	p := parser.NewParser(l)
+	program := p.ParseProgram()
+
+	var got []string
+	hook := func(stmt ast.Stmt, frames []Frame) {
+		names := []string{}
+		for _, f := range frames {
+			names = append(names, f.Name)
+		}
+		if frames[len(frames)-1].Stmt != stmt {
+			t.Errorf("frame does not point at the statement %s", stmt)
+		}
+		got = append(got, fmt.Sprintf("%d %s", stmt.Pos().Line, strings.Join(names, ">")))
+	}
+
+	testIntegerObject(t, New(Config{Hook: hook}).Eval(program, object.NewEnvironment()), 2)
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong hook calls. want=%q, got=%q", expected, got)
+	}
+}
+
+func TestErrorStackTrace(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedPos   string
+		expectedTrace string
+	}{
+		{
+			"1 + true",
+			"1:3",
+			"    at main (test.sl:1:3)\n",
+		},
+		{
+			`let fib = fn(n) {
+  if (n < 2) { return n / 0; }
+  fib(n - 1) + fib(n - 2)
+};
+fib(3)`,
+			"2:25",
+			"    at fib (test.sl:2:25)\n" +
+				"    at fib (test.sl:3:6)\n" +
+				"    at fib (test.sl:3:6)\n" +
+				"    at main (test.sl:5:4)\n",
+		},
+		{
+			"let f = fn() { fn() { x }() }; f()",
+			"1:23",
+			"    at <anonymous> (test.sl:1:23)\n" +
+				"    at f (test.sl:1:26)\n" +
+				"    at main (test.sl:1:33)\n",
+		},
+		{
+			"let f = fn(a) { a }; f()",
+			"1:23",
+			"    at main (test.sl:1:23)\n",
+		},
+	}
+
+	for _, test := range tests {
+		evaled := testEval(test.input)
+
+		errObj, ok := evaled.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaled, evaled)
+			continue
+		}
+		if errObj.Pos.String() != test.expectedPos {
+			t.Errorf("wrong position. expected=%s, got=%s", test.expectedPos, errObj.Pos)
+		}
+		if trace := errObj.StackTrace("test.sl"); trace != test.expectedTrace {
+			t.Errorf("wrong stack trace. expected=\n%s\ngot=\n%s", test.expectedTrace, trace)
+		}
+	}
+}
+
+func TestTryCatch(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"try { 1 } catch (e) { 2 }", "1"},
+		{"try { 1 + true } catch (e) { e }", "error: type mismatch: INTEGER + BOOLEAN"},
+		{"try { throw 5; 1 } catch (e) { message(e) }", "5"},
+		{"try { throw 5; } catch (e) { trace(e) }", "at main (1:7)"},
+		{"let f = fn() { throw true; }; try { f() } catch (e) { trace(e) }", "at f (1:16)\nat main (1:38)"},
+		{"try { throw 1; } catch (e) { try { throw e; } catch (inner) { trace(inner) } }", "at main (1:7)"},
+		{"let x = 0; try { let x = 1; } finally { let x = x + 10; }; x", "11"},
+		{"let x = 1; try { throw 1; } catch (e) { let x = 2; } finally { let x = x * 5; }; x", "5"},
+		{"let e = 5; try { throw \"boom\" } catch (e) { 1 }; e", "5"},
+		{"try { throw 1; } catch (e) { 2 }; e", "ERROR: identifier not found: e"},
+		{"let x = 1; try { throw 1; } catch (e) { let x = x + 1; x }", "2"},
+		{"try { 1 } finally { 2 }", "1"},
+		{"let x = try { throw \"a\" } catch (e) { }; x + 1", "ERROR: type mismatch: NULL + INTEGER"},
+		{"try { } finally { }", "null"},
+		{"let f = fn() { try { return 1; } finally { return 2; } }; f()", "2"},
+		{"let f = fn() { try { return 1; } catch (e) { 3 } }; f()", "1"},
+		{"try { throw 1; } finally { 2 }", "ERROR: 1"},
+		{"try { 1 } finally { throw 2; }", "ERROR: 2"},
+		{"try { throw 1; } catch (e) { throw 2; }", "ERROR: 2"},
+		{"message(1)", "ERROR: argument to `message` must be ERROR_VALUE, got INTEGER"},
+		{"try { throw 1; } catch (e) { message(e, e) }", "ERROR: wrong number of arguments. got=2, want=1"},
+	}
+
+	for _, test := range tests {
+		evaled := testEval(test.input)
+		if evaled == nil {
+			t.Errorf("%q: got nil", test.input)
+			continue
+		}
+		if evaled.Inspect() != test.expected {
+			t.Errorf("%q: wrong result. expected=%q, got=%q", test.input, test.expected, evaled.Inspect())
+		}
+	}
+}
+
+func TestTryDoesNotCatchLimits(t *testing.T) {
+	input := "let loop = fn(n) { loop(n + 1) }; let x = 0; try { loop(0) } catch (e) { 1 } finally { let x = 1; }; x"
+
+	l := lexer.NewLexer(input)
+	p := parser.NewParser(l)
+	program := p.ParseProgram()
+
+	evaled := New(Config{MaxDepth: 50}).Eval(program, object.NewEnvironment())
+
+	errObj, ok := evaled.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaled, evaled)
+	}
+	if errObj.Kind != object.DEPTH_LIMIT_EXCEEDED {
+		t.Errorf("wrong error kind. expected=%q, got=%q", object.DEPTH_LIMIT_EXCEEDED, errObj.Kind)
+	}
+}
+
+func TestArrays(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"[]", "[]"},
+		{"[1, 2 * 3, true]", "[1, 6, true]"},
+		{"[1, 2, 3][0]", "1"},
+		{"let i = 1; [1, 2, 3][i + 1]", "3"},
+		{"let a = [[1, 2], [3]]; a[0][1] + a[1][0]", "5"},
+		{"let f = fn() { [fn(x) { x * 2 }] }; f()[0](4)", "8"},
+		{"[1, 2][2]", "ERROR: index out of range: 2 with length 2"},
+		{"[1, 2][-1]", "ERROR: index out of range: -1 with length 2"},
+		{"[1][true]", "ERROR: array index must be INTEGER, got BOOLEAN"},
+		{"1[0]", "ERROR: index operator not supported: INTEGER"},
+		{"[1, 2 + true]", "ERROR: type mismatch: INTEGER + BOOLEAN"},
+		{"let f = fn(x) { [x?, 2] }; f(error(1))", "error: 1"},
+	}
+
+	for _, test := range tests {
+		evaled := testEval(test.input)
+		if evaled.Inspect() != test.expected {
+			t.Errorf("%q: wrong result. expected=%q, got=%q", test.input, test.expected, evaled.Inspect())
+		}
+	}
+}
+
+func TestMaps(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"{}", "{}"},
+		{`{"a": 1, "b": 2 * 3}`, "{a: 1, b: 6}"},
+		{`{"b": 1, "a": 2}`, "{b: 1, a: 2}"},
+		{`{"a": 1, "a": 2}`, "{a: 2}"},
+		{`{"a": 1}["a"]`, "1"},
+		{`let k = "x"; {k: [1, 2]}["x"][1]`, "2"},
+		{`{"a": {"b": true}}["a"]["b"]`, "true"},
+		{`{"a": 1}["b"]`, "null"},
+		{`{1: 2}`, "ERROR: map key must be STRING, got INTEGER"},
+		{`{"a": 1}[1]`, "ERROR: map key must be STRING, got INTEGER"},
+		{`{"a": 1 + true}`, "ERROR: type mismatch: INTEGER + BOOLEAN"},
+	}
+
+	for _, test := range tests {
+		evaled := testEval(test.input)
+		if evaled.Inspect() != test.expected {
+			t.Errorf("%q: wrong result. expected=%q, got=%q", test.input, test.expected, evaled.Inspect())
+		}
+	}
+}
+
+func TestFloats(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`import "json"; json.parse("1.5")`, "1.5"},
+		{`import "json"; json.parse("2", "float")`, "2.0"},
+		{`import "json"; -json.parse("0.25")`, "-0.25"},
+		{`import "json"; json.parse("1.5") + 1`, "2.5"},
+		{`import "json"; 3 / json.parse("2.0")`, "1.5"},
+		{`import "json"; json.parse("1.5") * json.parse("2.0")`, "3.0"},
+		{`import "json"; json.parse("1.5") < 2`, "true"},
+		{`import "json"; json.parse("2.0") == 2`, "true"},
+		{`import "json"; json.parse("1e300") * json.parse("1e300")`, "+Inf"},
+		{`import "json"; 1 / json.parse("0.0")`, "ERROR: division by zero: 1.0 / 0.0"},
+	}
+
+	for _, test := range tests {
+		evaled := testEval(test.input)
+		if evaled.Inspect() != test.expected {
+			t.Errorf("%q: wrong result. expected=%q, got=%q", test.input, test.expected, evaled.Inspect())
+		}
+	}
+}
+
+func TestErrorValues(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"error(1)", "error: 1"},
+		{"is_error(error(1))", "true"},
+		{"is_error(1)", "false"},
+		{"try { throw 1; } catch (e) { is_error(e) }", "true"},
+		{"let e = error(1); 2", "2"},
+		{"let f = fn() { error(true) }; trace(f())", "at f (1:21)\nat main (1:38)"},
+		{"let f = fn(x) { x? + 1 }; f(2)", "3"},
+		{"let f = fn(x) { x? + 1 }; f(error(5))", "error: 5"},
+		{"let half = fn(n) { if (n / 2 * 2 == n) { n / 2 } else { error(n) } };" +
+			"let quarter = fn(n) { let h = half(n)?; half(h) }; quarter(8)", "2"},
+		{"let half = fn(n) { if (n / 2 * 2 == n) { n / 2 } else { error(n) } };" +
+			"let quarter = fn(n) { let h = half(n)?; half(h) }; quarter(6)", "error: 3"},
+		{"let half = fn(n) { if (n / 2 * 2 == n) { n / 2 } else { error(n) } };" +
+			"let quarter = fn(n) { let h = half(n)?; half(h) }; quarter(5)", "error: 5"},
+		{"let f = fn() { let x = error(7)?; 100 }; message(f())", "7"},
+		{"error(1)?; 2", "error: 1"},
+		{"try { throw error(3); } catch (e) { trace(e) }", "at main (1:18)"},
+		{"is_error()", "ERROR: wrong number of arguments. got=0, want=1"},
+	}
+
+	for _, test := range tests {
+		evaled := testEval(test.input)
+		if evaled == nil {
+			t.Errorf("%q: got nil", test.input)
+			continue
+		}
+		if evaled.Inspect() != test.expected {
+			t.Errorf("%q: wrong result. expected=%q, got=%q", test.input, test.expected, evaled.Inspect())
+		}
+	}
+}
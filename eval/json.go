@@ -0,0 +1,394 @@
+package eval
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ei1chi/sample-lang/object"
+	"github.com/ei1chi/sample-lang/token"
+)
+
+// jsonModule import "json" で使える JSON の変換。
+// オブジェクトは連想配列、配列は配列、null は null になる。
+var jsonModule = &object.Module{Path: "json", Exports: map[string]object.Object{
+	// parse(s, numbers) JSON の文字列 s を値にする。壊れた JSON ならエラーの値を返す。
+	// numbers は数の読み方で、省略すると "auto" になる。
+	//   "auto"  小数点も指数もなく int64 に収まれば整数、それ以外は浮動小数点数
+	//   "int"   すべて整数。整数でない数があればエラー
+	//   "float" すべて浮動小数点数
+	"parse": &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		mode := "auto"
+		if len(args) == 2 {
+			if err := checkArgs("parse", args, object.STRING, object.STRING); err != nil {
+				return err
+			}
+			mode = args[1].(*object.String).Value
+		} else if err := checkArgs("parse", args, object.STRING); err != nil {
+			return err
+		}
+		if mode != "auto" && mode != "int" && mode != "float" {
+			return newError(`unknown number mode %q: want "auto", "int" or "float"`, mode)
+		}
+
+		d := &jsonDecoder{src: args[0].(*object.String).Value, mode: mode}
+		val, err := d.decode()
+		if err != nil {
+			return &object.ErrorValue{Err: err}
+		}
+		return val
+	}},
+
+	// stringify(v, indent) v を JSON の文字列にする。indent が 0 より大きければ、その数の空白で字下げする
+	"stringify": &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		indent := int64(0)
+		if len(args) == 2 {
+			if args[1].Type() != object.INTEGER {
+				return newError("argument to `stringify` must be %s, got %s", object.INTEGER, args[1].Type())
+			}
+			indent = args[1].(*object.Integer).Value
+			if indent < 0 || indent > 16 {
+				return newError("indent must be between 0 and 16, got %d", indent)
+			}
+		} else if len(args) == 0 {
+			return newError("wrong number of arguments. got=0, want at least 1")
+		} else if len(args) > 2 {
+			return newError("wrong number of arguments. got=%d, want at most 2", len(args))
+		}
+
+		var buf bytes.Buffer
+		if err := encodeJSON(&buf, args[0]); err != nil {
+			return err
+		}
+		if indent > 0 {
+			var out bytes.Buffer
+			json.Indent(&out, buf.Bytes(), "", strings.Repeat(" ", int(indent)))
+			buf = out
+		}
+		return &object.String{Value: buf.String()}
+	}},
+}}
+
+// maxJSONDepth 入れ子の深さの上限。深すぎる入力で再帰が伸び続けないようにする。
+const maxJSONDepth = 10000
+
+// jsonDecoder src を先頭から読む。pos は次に読むバイトの位置。
+type jsonDecoder struct {
+	src   string
+	pos   int
+	mode  string
+	depth int
+}
+
+func (d *jsonDecoder) decode() (object.Object, *object.Error) {
+	val, err := d.value()
+	if err != nil {
+		return nil, err
+	}
+	d.skipSpace()
+	if d.pos < len(d.src) {
+		return nil, d.errorf(d.pos, "unexpected %s after top-level value", d.describe())
+	}
+	return val, nil
+}
+
+// errorf offset バイト目の位置を行と列にしてエラーにする。
+func (d *jsonDecoder) errorf(offset int, format string, a ...interface{}) *object.Error {
+	line := 1 + strings.Count(d.src[:offset], "\n")
+	lineStart := strings.LastIndexByte(d.src[:offset], '\n') + 1
+	pos := token.Position{Line: line, Column: 1 + utf8.RuneCountInString(d.src[lineStart:offset])}
+	return newError("invalid JSON at %s: %s", pos, fmt.Sprintf(format, a...))
+}
+
+// describe 次の文字をエラーメッセージ向けに書く。
+func (d *jsonDecoder) describe() string {
+	if d.pos >= len(d.src) {
+		return "end of input"
+	}
+	r, _ := utf8.DecodeRuneInString(d.src[d.pos:])
+	return fmt.Sprintf("character %q", r)
+}
+
+func (d *jsonDecoder) skipSpace() {
+	for d.pos < len(d.src) {
+		switch d.src[d.pos] {
+		case ' ', '\t', '\n', '\r':
+			d.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (d *jsonDecoder) value() (object.Object, *object.Error) {
+	d.skipSpace()
+	if d.pos >= len(d.src) {
+		return nil, d.errorf(d.pos, "unexpected end of input")
+	}
+
+	switch c := d.src[d.pos]; {
+	case c == '{':
+		return d.object()
+	case c == '[':
+		return d.array()
+	case c == '"':
+		s, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		return &object.String{Value: s}, nil
+	case c == '-' || '0' <= c && c <= '9':
+		return d.number()
+	case strings.HasPrefix(d.src[d.pos:], "true"):
+		d.pos += len("true")
+		return TRUE, nil
+	case strings.HasPrefix(d.src[d.pos:], "false"):
+		d.pos += len("false")
+		return FALSE, nil
+	case strings.HasPrefix(d.src[d.pos:], "null"):
+		d.pos += len("null")
+		return NULL, nil
+	}
+	return nil, d.errorf(d.pos, "unexpected %s", d.describe())
+}
+
+// enter 入れ子に入る。戻るときは d.depth-- する。
+func (d *jsonDecoder) enter() *object.Error {
+	d.depth++
+	if d.depth > maxJSONDepth {
+		return d.errorf(d.pos, "exceeded max depth %d", maxJSONDepth)
+	}
+	return nil
+}
+
+func (d *jsonDecoder) object() (object.Object, *object.Error) {
+	if err := d.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { d.depth-- }()
+
+	m := object.NewMap()
+	d.pos++ // {
+	d.skipSpace()
+	if d.pos < len(d.src) && d.src[d.pos] == '}' {
+		d.pos++
+		return m, nil
+	}
+
+	for {
+		d.skipSpace()
+		if d.pos >= len(d.src) || d.src[d.pos] != '"' {
+			return nil, d.errorf(d.pos, "expected object key, got %s", d.describe())
+		}
+		key, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+
+		d.skipSpace()
+		if d.pos >= len(d.src) || d.src[d.pos] != ':' {
+			return nil, d.errorf(d.pos, "expected ':' after object key, got %s", d.describe())
+		}
+		d.pos++
+
+		val, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		m.Set(key, val)
+
+		d.skipSpace()
+		if d.pos < len(d.src) && d.src[d.pos] == ',' {
+			d.pos++
+			continue
+		}
+		if d.pos < len(d.src) && d.src[d.pos] == '}' {
+			d.pos++
+			return m, nil
+		}
+		return nil, d.errorf(d.pos, "expected ',' or '}' in object, got %s", d.describe())
+	}
+}
+
+func (d *jsonDecoder) array() (object.Object, *object.Error) {
+	if err := d.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { d.depth-- }()
+
+	elems := []object.Object{}
+	d.pos++ // [
+	d.skipSpace()
+	if d.pos < len(d.src) && d.src[d.pos] == ']' {
+		d.pos++
+		return &object.Array{Elems: elems}, nil
+	}
+
+	for {
+		val, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, val)
+
+		d.skipSpace()
+		if d.pos < len(d.src) && d.src[d.pos] == ',' {
+			d.pos++
+			continue
+		}
+		if d.pos < len(d.src) && d.src[d.pos] == ']' {
+			d.pos++
+			return &object.Array{Elems: elems}, nil
+		}
+		return nil, d.errorf(d.pos, "expected ',' or ']' in array, got %s", d.describe())
+	}
+}
+
+// string 引用符で囲まれた文字列を読む。エスケープの解釈は encoding/json に任せる。
+func (d *jsonDecoder) string() (string, *object.Error) {
+	start := d.pos
+	d.pos++ // "
+	for d.pos < len(d.src) {
+		switch c := d.src[d.pos]; {
+		case c == '"':
+			d.pos++
+			var s string
+			if err := json.Unmarshal([]byte(d.src[start:d.pos]), &s); err != nil {
+				return "", d.errorf(start, "invalid string literal")
+			}
+			return s, nil
+		case c == '\\':
+			d.pos += 2
+		case c < 0x20:
+			return "", d.errorf(d.pos, "control character %q in string", c)
+		default:
+			d.pos++
+		}
+	}
+	return "", d.errorf(start, "unterminated string")
+}
+
+// number JSON の文法どおりに数を読み、モードに従って整数か浮動小数点数にする。
+func (d *jsonDecoder) number() (object.Object, *object.Error) {
+	start := d.pos
+	if d.src[d.pos] == '-' {
+		d.pos++
+	}
+	switch {
+	case d.pos < len(d.src) && d.src[d.pos] == '0':
+		d.pos++
+	case d.digits() == 0:
+		return nil, d.errorf(d.pos, "expected digit, got %s", d.describe())
+	}
+
+	integral := true
+	if d.pos < len(d.src) && d.src[d.pos] == '.' {
+		integral = false
+		d.pos++
+		if d.digits() == 0 {
+			return nil, d.errorf(d.pos, "expected digit after decimal point, got %s", d.describe())
+		}
+	}
+	if d.pos < len(d.src) && (d.src[d.pos] == 'e' || d.src[d.pos] == 'E') {
+		integral = false
+		d.pos++
+		if d.pos < len(d.src) && (d.src[d.pos] == '+' || d.src[d.pos] == '-') {
+			d.pos++
+		}
+		if d.digits() == 0 {
+			return nil, d.errorf(d.pos, "expected digit in exponent, got %s", d.describe())
+		}
+	}
+
+	lit := d.src[start:d.pos]
+	if d.mode != "float" && integral {
+		if i, err := strconv.ParseInt(lit, 10, 64); err == nil {
+			return &object.Integer{Value: i}, nil
+		}
+	}
+	if d.mode == "int" {
+		return nil, d.errorf(start, "number %s is not an integer in int64 range", lit)
+	}
+
+	f, err := strconv.ParseFloat(lit, 64)
+	if err != nil {
+		return nil, d.errorf(start, "number %s out of range", lit)
+	}
+	return &object.Float{Value: f}, nil
+}
+
+// digits 続く数字を読み、その数を返す。
+func (d *jsonDecoder) digits() int {
+	n := 0
+	for d.pos < len(d.src) && '0' <= d.src[d.pos] && d.src[d.pos] <= '9' {
+		d.pos++
+		n++
+	}
+	return n
+}
+
+// encodeJSON obj を空白なしの JSON にして buf に書く。連想配列のキーは入れた順に並べる。
+func encodeJSON(buf *bytes.Buffer, obj object.Object) *object.Error {
+	switch obj := obj.(type) {
+	case *object.Null:
+		buf.WriteString("null")
+
+	case *object.Boolean:
+		buf.WriteString(strconv.FormatBool(obj.Value))
+
+	case *object.Integer:
+		buf.WriteString(strconv.FormatInt(obj.Value, 10))
+
+	case *object.Float:
+		if math.IsInf(obj.Value, 0) || math.IsNaN(obj.Value) {
+			return newError("cannot convert %s to JSON", obj.Inspect())
+		}
+		// 整数と見分けられるよう Inspect と同じく ".0" を付ける
+		buf.WriteString(obj.Inspect())
+
+	case *object.String:
+		writeJSONString(buf, obj.Value)
+
+	case *object.Array:
+		buf.WriteByte('[')
+		for i, elem := range obj.Elems {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeJSON(buf, elem); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+
+	case *object.Map:
+		buf.WriteByte('{')
+		for i, key := range obj.Keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeJSONString(buf, key)
+			buf.WriteByte(':')
+			if err := encodeJSON(buf, obj.Values[key]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+
+	default:
+		return newError("cannot convert %s to JSON", obj.Type())
+	}
+	return nil
+}
+
+// writeJSONString s を JSON の文字列にする。HTML のための < > & のエスケープはしない。
+func writeJSONString(buf *bytes.Buffer, s string) {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	buf.Truncate(buf.Len() - 1) // Encode が付ける改行
+}
@@ -0,0 +1,51 @@
+package eval
+
+import "testing"
+
+func TestJSONModule(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`json.parse("1")`, "1"},
+		{`json.parse(" [1, -2.5, true, null, \"a\"] ")`, "[1, -2.5, true, null, a]"},
+		{`json.parse("{\"b\": 1, \"a\": {\"c\": []}}")`, "{b: 1, a: {c: []}}"},
+		{`json.parse("{\"a\": [1, 2]}")["a"][1]`, "2"},
+		{`json.parse("\"\\u65e5\\n\"") == "日\n"`, "true"},
+		{`json.parse("1e2")`, "100.0"},
+		{`json.parse("9223372036854775808")`, "9.223372036854776e+18"},
+		{`json.parse("[1, 2.0]", "float")`, "[1.0, 2.0]"},
+		{`json.parse("[1, 2]", "int")`, "[1, 2]"},
+		{`json.parse("1.5", "int")`, "error: invalid JSON at 1:1: number 1.5 is not an integer in int64 range"},
+		{`json.parse("1", "big")`, `ERROR: unknown number mode "big": want "auto", "int" or "float"`},
+		{`json.parse("")`, "error: invalid JSON at 1:1: unexpected end of input"},
+		{`json.parse("[1, 2")`, "error: invalid JSON at 1:6: expected ',' or ']' in array, got end of input"},
+		{`json.parse("[1,]")`, "error: invalid JSON at 1:4: unexpected character ']'"},
+		{`json.parse("{\n  \"日本\": 1,\n  x: 2}")`, "error: invalid JSON at 3:3: expected object key, got character 'x'"},
+		{`json.parse("{\"a\" 1}")`, "error: invalid JSON at 1:6: expected ':' after object key, got character '1'"},
+		{`json.parse("01")`, "error: invalid JSON at 1:2: unexpected character '1' after top-level value"},
+		{`json.parse("1.")`, "error: invalid JSON at 1:3: expected digit after decimal point, got end of input"},
+		{`json.parse("\"a")`, "error: invalid JSON at 1:1: unterminated string"},
+		{`json.parse("\"\\x\"")`, "error: invalid JSON at 1:1: invalid string literal"},
+		{`json.parse("tru")`, "error: invalid JSON at 1:1: unexpected character 't'"},
+		{`is_error(json.parse("{"))`, "true"},
+		{`json.parse(1)`, "ERROR: argument to `parse` must be STRING, got INTEGER"},
+		{`json.stringify({"b": [1, json.parse("2", "float")], "a": "x\"<>"})`, `{"b":[1,2.0],"a":"x\"<>"}`},
+		{`json.stringify(true)`, "true"},
+		{`json.stringify(json.parse(" [null] "))`, "[null]"},
+		{`json.stringify({"a": [1, {}]}, 2)`, "{\n  \"a\": [\n    1,\n    {}\n  ]\n}"},
+		{`json.stringify(json.parse("{\"z\": 1, \"y\": [0.5, \"日\"]}"))`, `{"z":1,"y":[0.5,"日"]}`},
+		{`json.stringify(json.parse("1e300") * json.parse("1e300"))`, "ERROR: cannot convert +Inf to JSON"},
+		{`json.stringify([fn() { 1 }])`, "ERROR: cannot convert FUNCTION to JSON"},
+		{`json.stringify(1, -1)`, "ERROR: indent must be between 0 and 16, got -1"},
+		{`json.stringify()`, "ERROR: wrong number of arguments. got=0, want at least 1"},
+		{`json.stringify(1, 2, 3)`, "ERROR: wrong number of arguments. got=3, want at most 2"},
+	}
+
+	for _, test := range tests {
+		evaled := testEval(`import "json"; ` + test.input)
+		if evaled.Inspect() != test.expected {
+			t.Errorf("%q: wrong result. expected=%q, got=%q", test.input, test.expected, evaled.Inspect())
+		}
+	}
+}
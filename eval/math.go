@@ -0,0 +1,249 @@
+package eval
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/ei1chi/sample-lang/object"
+)
+
+// mathModule import "math" で使える数の関数。ほとんどは整数だけを受け取る。
+// 結果が int64 に収まらないときや、定義されない引数を渡したときはエラーになる。
+// 浮動小数点数のリテラルはないので、floor, ceil, round は整数二つなら割り算の丸め方を選ぶ関数になる。
+// 一つなら json.parse などで得た浮動小数点数を整数に丸める。
+var mathModule = &object.Module{Path: "math", Exports: map[string]object.Object{
+	// pi, e 円周率と自然対数の底
+	"pi": &object.Float{Value: math.Pi},
+	"e":  &object.Float{Value: math.E},
+
+	// abs(x) x の絶対値
+	"abs": intBuiltin("abs", 1, func(call string, x []int64) object.Object {
+		if x[0] == math.MinInt64 {
+			return newError("integer overflow: %s", call)
+		}
+		if x[0] < 0 {
+			return &object.Integer{Value: -x[0]}
+		}
+		return &object.Integer{Value: x[0]}
+	}),
+
+	// min(x, ...) 一番小さい値
+	"min": intBuiltin("min", -1, func(call string, x []int64) object.Object {
+		m := x[0]
+		for _, v := range x[1:] {
+			if v < m {
+				m = v
+			}
+		}
+		return &object.Integer{Value: m}
+	}),
+
+	// max(x, ...) 一番大きい値
+	"max": intBuiltin("max", -1, func(call string, x []int64) object.Object {
+		m := x[0]
+		for _, v := range x[1:] {
+			if v > m {
+				m = v
+			}
+		}
+		return &object.Integer{Value: m}
+	}),
+
+	// pow(x, n) x の n 乗。n は 0 以上
+	"pow": intBuiltin("pow", 2, func(call string, x []int64) object.Object {
+		base, n := x[0], x[1]
+		if n < 0 {
+			return newError("negative exponent: %s", call)
+		}
+		result := int64(1)
+		for n > 0 {
+			var ok bool
+			if n&1 == 1 {
+				if result, ok = mulInt(result, base); !ok {
+					return newError("integer overflow: %s", call)
+				}
+			}
+			// 最後の一回は二乗しなくてよい。しても使わない値で溢れることがある
+			if n >>= 1; n > 0 {
+				if base, ok = mulInt(base, base); !ok {
+					return newError("integer overflow: %s", call)
+				}
+			}
+		}
+		return &object.Integer{Value: result}
+	}),
+
+	// sqrt(x) x の平方根。x が整数なら平方根の整数部分
+	"sqrt": floatBuiltin("sqrt", func(call string, x float64) object.Object {
+		if x < 0 {
+			return newError("square root of negative number: %s", call)
+		}
+		return &object.Float{Value: math.Sqrt(x)}
+	}, intBuiltin("sqrt", 1, func(call string, x []int64) object.Object {
+		if x[0] < 0 {
+			return newError("square root of negative number: %s", call)
+		}
+		// float64 の誤差で一つずれることがあるので整数で合わせる
+		r := int64(math.Sqrt(float64(x[0])))
+		for r > 0 && r > x[0]/r {
+			r--
+		}
+		for r+1 <= x[0]/(r+1) {
+			r++
+		}
+		return &object.Integer{Value: r}
+	})),
+
+	// floor(a, b) a / b を負の無限大の方向に丸める。floor(x) は x を同じ方向に丸めた整数
+	"floor": roundBuiltin("floor", math.Floor, func(a, b, q, r int64) int64 {
+		if r != 0 && (a < 0) != (b < 0) {
+			return q - 1
+		}
+		return q
+	}),
+
+	// ceil(a, b) a / b を正の無限大の方向に丸める。ceil(x) は x を同じ方向に丸めた整数
+	"ceil": roundBuiltin("ceil", math.Ceil, func(a, b, q, r int64) int64 {
+		if r != 0 && (a < 0) == (b < 0) {
+			return q + 1
+		}
+		return q
+	}),
+
+	// round(a, b) a / b を一番近い整数に丸める。ちょうど半分なら 0 から遠い方にする。round(x) は x を同じように丸めた整数
+	"round": roundBuiltin("round", math.Round, func(a, b, q, r int64) int64 {
+		if ur, ub := absUint(r), absUint(b); ur >= ub-ur {
+			if (a < 0) != (b < 0) {
+				return q - 1
+			}
+			return q + 1
+		}
+		return q
+	}),
+
+	// divmod(a, b) [a / b, a - a / b * b] の配列。商は / と同じく 0 の方向に丸める
+	"divmod": divBuiltin("divmod", nil),
+
+	// gcd(a, b) 最大公約数。符号は無視し、gcd(0, 0) は 0
+	"gcd": intBuiltin("gcd", 2, func(call string, x []int64) object.Object {
+		a, b := absUint(x[0]), absUint(x[1])
+		for b != 0 {
+			a, b = b, a%b
+		}
+		if a > math.MaxInt64 {
+			return newError("integer overflow: %s", call)
+		}
+		return &object.Integer{Value: int64(a)}
+	}),
+
+	// clamp(x, lo, hi) x を lo 以上 hi 以下に収める
+	"clamp": intBuiltin("clamp", 3, func(call string, x []int64) object.Object {
+		v, lo, hi := x[0], x[1], x[2]
+		if lo > hi {
+			return newError("lower bound is greater than upper bound: %s", call)
+		}
+		return &object.Integer{Value: min(max(v, lo), hi)}
+	}),
+}}
+
+// intBuiltin 整数だけを受け取る組み込み関数を作る。n が負なら一つ以上のいくつでも受け取る。
+// fn には "pow(2, 64)" のような呼び出しの表記も渡すので、エラーメッセージに使う。
+func intBuiltin(name string, n int, fn func(call string, x []int64) object.Object) *object.Builtin {
+	return &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		if n >= 0 && len(args) != n {
+			return newError("wrong number of arguments. got=%d, want=%d", len(args), n)
+		}
+		if n < 0 && len(args) == 0 {
+			return newError("wrong number of arguments. got=0, want at least 1")
+		}
+
+		x := make([]int64, len(args))
+		strs := make([]string, len(args))
+		for i, arg := range args {
+			v, ok := arg.(*object.Integer)
+			if !ok {
+				return newError("argument to `%s` must be %s, got %s", name, object.INTEGER, arg.Type())
+			}
+			x[i] = v.Value
+			strs[i] = fmt.Sprint(v.Value)
+		}
+		return fn(name+"("+strings.Join(strs, ", ")+")", x)
+	}}
+}
+
+// divBuiltin a / b の商を丸め直す組み込み関数を作る。q と r は Go の / と % の結果。
+// round が nil なら商と余りの配列を返す。
+func divBuiltin(name string, round func(a, b, q, r int64) int64) *object.Builtin {
+	return intBuiltin(name, 2, func(call string, x []int64) object.Object {
+		a, b := x[0], x[1]
+		if b == 0 {
+			return newError("division by zero: %s", call)
+		}
+		if a == math.MinInt64 && b == -1 {
+			return newError("integer overflow: %s", call)
+		}
+
+		q, r := a/b, a%b
+		if round == nil {
+			return &object.Array{Elems: []object.Object{&object.Integer{Value: q}, &object.Integer{Value: r}}}
+		}
+		return &object.Integer{Value: round(a, b, q, r)}
+	})
+}
+
+// floatBuiltin 浮動小数点数一つで呼ばれたら fn を、それ以外なら ib を呼ぶ組み込み関数を作る。
+func floatBuiltin(name string, fn func(call string, x float64) object.Object, ib *object.Builtin) *object.Builtin {
+	return &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		if len(args) == 1 {
+			if x, ok := args[0].(*object.Float); ok {
+				return fn(name+"("+x.Inspect()+")", x.Value)
+			}
+		}
+		return ib.Fn(args...)
+	}}
+}
+
+// roundBuiltin 引数が二つなら divBuiltin と同じ。一つなら浮動小数点数を roundFloat で丸めた整数にし、整数はそのまま返す。
+func roundBuiltin(name string, roundFloat func(float64) float64, round func(a, b, q, r int64) int64) *object.Builtin {
+	div := divBuiltin(name, round)
+	return floatBuiltin(name, func(call string, x float64) object.Object {
+		f := roundFloat(x)
+		if math.IsNaN(f) {
+			return newError("not a number: %s", call)
+		}
+		// -2^63 はちょうど表せるが 2^63 は int64 に収まらない
+		if f < math.MinInt64 || f >= math.MaxInt64 {
+			return newError("integer overflow: %s", call)
+		}
+		return &object.Integer{Value: int64(f)}
+	}, &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		if len(args) == 1 {
+			if x, ok := args[0].(*object.Integer); ok {
+				return x
+			}
+			return newError("argument to `%s` must be %s or %s, got %s", name, object.INTEGER, object.FLOAT, args[0].Type())
+		}
+		return div.Fn(args...)
+	}})
+}
+
+// mulInt 溢れなければ a * b を返す。
+func mulInt(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	c := a * b
+	if c/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		return 0, false
+	}
+	return c, true
+}
+
+// absUint x の絶対値。math.MinInt64 の絶対値も表せるよう uint64 で返す。
+func absUint(x int64) uint64 {
+	if x < 0 {
+		return uint64(-(x + 1)) + 1
+	}
+	return uint64(x)
+}
@@ -0,0 +1,98 @@
+package eval
+
+import "testing"
+
+func TestMathModule(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"math.abs(-5)", "5"},
+		{"math.abs(5)", "5"},
+		{"math.abs(-9223372036854775807 - 1)", "ERROR: integer overflow: abs(-9223372036854775808)"},
+		{"math.min(3, -1, 2)", "-1"},
+		{"math.max(3, -1, 2)", "3"},
+		{"math.max(7)", "7"},
+		{"math.min()", "ERROR: wrong number of arguments. got=0, want at least 1"},
+		{"math.pow(2, 10)", "1024"},
+		{"math.pow(-3, 3)", "-27"},
+		{"math.pow(0, 0)", "1"},
+		{"math.pow(2, 62)", "4611686018427387904"},
+		{"math.pow(-2, 63)", "-9223372036854775808"},
+		{"math.pow(2, 63)", "ERROR: integer overflow: pow(2, 63)"},
+		{"math.pow(3, 40)", "ERROR: integer overflow: pow(3, 40)"},
+		{"math.pow(2, -1)", "ERROR: negative exponent: pow(2, -1)"},
+		{"math.sqrt(0)", "0"},
+		{"math.sqrt(15)", "3"},
+		{"math.sqrt(16)", "4"},
+		{"math.sqrt(9223372036854775807)", "3037000499"},
+		{"math.sqrt(-4)", "ERROR: square root of negative number: sqrt(-4)"},
+		{"math.floor(7, 2)", "3"},
+		{"math.floor(-7, 2)", "-4"},
+		{"math.floor(-6, 2)", "-3"},
+		{"math.ceil(7, 2)", "4"},
+		{"math.ceil(-7, 2)", "-3"},
+		{"math.ceil(7, -2)", "-3"},
+		{"math.round(7, 2)", "4"},
+		{"math.round(-7, 2)", "-4"},
+		{"math.round(7, 3)", "2"},
+		{"math.round(8, 3)", "3"},
+		{"math.round(-8, 3)", "-3"},
+		{"math.floor(1, 0)", "ERROR: division by zero: floor(1, 0)"},
+		{"math.divmod(7, 2)", "[3, 1]"},
+		{"math.divmod(-7, 2)", "[-3, -1]"},
+		{"math.divmod(7, 2)[1]", "1"},
+		{"math.divmod(7, 0)", "ERROR: division by zero: divmod(7, 0)"},
+		{"math.divmod(-9223372036854775807 - 1, -1)", "ERROR: integer overflow: divmod(-9223372036854775808, -1)"},
+		{"math.gcd(12, 18)", "6"},
+		{"math.gcd(-12, 18)", "6"},
+		{"math.gcd(0, 0)", "0"},
+		{"math.gcd(-9223372036854775807 - 1, 0)", "ERROR: integer overflow: gcd(-9223372036854775808, 0)"},
+		{"math.clamp(5, 0, 3)", "3"},
+		{"math.clamp(-5, 0, 3)", "0"},
+		{"math.clamp(2, 0, 3)", "2"},
+		{"math.clamp(2, 3, 0)", "ERROR: lower bound is greater than upper bound: clamp(2, 3, 0)"},
+		{"math.abs(true)", "ERROR: argument to `abs` must be INTEGER, got BOOLEAN"},
+		{"math.pow(2)", "ERROR: wrong number of arguments. got=1, want=2"},
+	}
+
+	for _, test := range tests {
+		evaled := testEval(`import "math"; ` + test.input)
+		if evaled.Inspect() != test.expected {
+			t.Errorf("%q: wrong result. expected=%q, got=%q", test.input, test.expected, evaled.Inspect())
+		}
+	}
+}
+
+func TestMathFloats(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"math.pi", "3.141592653589793"},
+		{"math.e", "2.718281828459045"},
+		{"math.floor(math.pi)", "3"},
+		{"math.ceil(math.pi)", "4"},
+		{"math.round(math.e)", "3"},
+		{`math.floor(json.parse("-2.5"))`, "-3"},
+		{`math.ceil(json.parse("-2.5"))`, "-2"},
+		{`math.round(json.parse("-2.5"))`, "-3"},
+		{`math.round(json.parse("2.5"))`, "3"},
+		{"math.floor(7)", "7"},
+		{`math.floor(json.parse("1e19"))`, "ERROR: integer overflow: floor(1e+19)"},
+		{`math.round(json.parse("1e300") * json.parse("1e300") * 0)`, "ERROR: not a number: round(NaN)"},
+		{"math.floor(true)", "ERROR: argument to `floor` must be INTEGER or FLOAT, got BOOLEAN"},
+		{`math.floor(json.parse("2.5"), 2)`, "ERROR: argument to `floor` must be INTEGER, got FLOAT"},
+		{`math.sqrt(json.parse("2.25"))`, "1.5"},
+		{`math.sqrt(json.parse("-1.0"))`, "ERROR: square root of negative number: sqrt(-1.0)"},
+		{"math.sqrt(math.e * 0)", "0.0"},
+		{"math.abs(math.pi)", "ERROR: argument to `abs` must be INTEGER, got FLOAT"},
+	}
+
+	for _, test := range tests {
+		evaled := testEval(`import "math"; import "json"; ` + test.input)
+		if evaled.Inspect() != test.expected {
+			t.Errorf("%q: wrong result. expected=%q, got=%q", test.input, test.expected, evaled.Inspect())
+		}
+	}
+}
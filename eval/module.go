@@ -0,0 +1,157 @@
+package eval
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ei1chi/sample-lang/ast"
+	"github.com/ei1chi/sample-lang/lexer"
+	"github.com/ei1chi/sample-lang/object"
+	"github.com/ei1chi/sample-lang/parser"
+	"github.com/ei1chi/sample-lang/token"
+)
+
+// Ext モジュールのファイルの拡張子。import のパスには付けない。
+const Ext = ".sl"
+
+// stdlib 組み込みのモジュール。同じパスのファイルがあっても、こちらが優先する。
+// ファイルを読まないので、Loader がなくても import できる。
+// time と strings も組み込みだが、Config.Clock や割り当ての上限を使うので評価ごとに作る。
+var stdlib = map[string]*object.Module{
+	"json": jsonModule,
+	"math": mathModule,
+	"re":   reModule,
+}
+
+// Loader import されたモジュールを探して評価する。
+// 評価したモジュールはパスごとに覚えておき、もう一度 import されても評価し直さない。
+// 評価の途中の状態を持つので、同時に複数の評価から使ってはいけない。
+type Loader struct {
+	// Paths モジュールを探すディレクトリ。前から順に探す。空ならカレントディレクトリだけを探す。
+	Paths []string
+
+	// FS モジュールを読むファイルシステム。nil なら OS のファイルシステムを使う。
+	// FS を使うときの Paths は fs.ValidPath の形で書く。
+	FS fs.FS
+
+	modules map[string]*object.Module
+	loading []string // 評価中のモジュール。外側から順に並ぶ
+}
+
+// find import のパスからモジュールのファイルを探し、そのファイル名とソースを返す。
+func (l *Loader) find(importPath string) (string, []byte, error) {
+	if !fs.ValidPath(importPath) || importPath == "." {
+		return "", nil, fmt.Errorf("invalid import path %q", importPath)
+	}
+
+	dirs := l.Paths
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+
+	for _, dir := range dirs {
+		var file string
+		var src []byte
+		var err error
+		if l.FS != nil {
+			file = path.Join(dir, importPath+Ext)
+			src, err = fs.ReadFile(l.FS, file)
+		} else {
+			file = filepath.Join(dir, filepath.FromSlash(importPath)+Ext)
+			src, err = os.ReadFile(file)
+		}
+
+		if err == nil {
+			return file, src, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", nil, err
+		}
+	}
+
+	return "", nil, fmt.Errorf("cannot find module %q in %s", importPath, strings.Join(dirs, ", "))
+}
+
+// Import importPath のモジュールを import 文と同じ規則で返す。仮想マシンが import を実行するのに使う。
+func (e *Evaluator) Import(importPath string) object.Object {
+	return e.importModule(importPath, token.Position{})
+}
+
+// importModule pos の import 文のモジュールを返す。組み込みのモジュールでなく、まだ評価していなければ、新しい環境で評価する。
+func (e *Evaluator) importModule(importPath string, pos token.Position) object.Object {
+	l := e.cfg.Loader
+	if e.cfg.Importer != nil {
+		if mod := e.cfg.Importer(importPath); mod != nil {
+			return mod
+		}
+	}
+	if mod, ok := stdlib[importPath]; ok {
+		return mod
+	}
+	if importPath == "time" {
+		if e.timeModule == nil {
+			e.timeModule = NewTimeModule(e.cfg.Clock)
+		}
+		return e.timeModule
+	}
+	if importPath == "strings" {
+		if e.stringsModule == nil {
+			e.stringsModule = e.newStringsModule()
+		}
+		return e.stringsModule
+	}
+	if l == nil {
+		return newError("cannot import %q: modules are not available", importPath)
+	}
+
+	if mod, ok := l.modules[importPath]; ok {
+		return mod
+	}
+	for i, loading := range l.loading {
+		if loading == importPath {
+			cycle := append(append([]string{}, l.loading[i:]...), importPath)
+			return newError("import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	file, src, err := l.find(importPath)
+	if err != nil {
+		return newError("%s", err)
+	}
+
+	p := parser.NewParser(lexer.NewLexer(string(src)))
+	program := p.ParseProgram()
+	if errs := p.ErrorList(); len(errs) != 0 {
+		return newError("%s:%s", file, errs[0])
+	}
+
+	l.loading = append(l.loading, importPath)
+	defer func() { l.loading = l.loading[:len(l.loading)-1] }()
+
+	// モジュールは import した文から呼び出したように扱い、フレームにはモジュールのファイルを記録する
+	env := object.NewEnvironment()
+	e.frames = append(e.frames, Frame{Name: "<module " + importPath + ">", Call: pos, Env: env, File: file})
+	result := e.evalProgram(program.Stmts, env)
+	e.popFrame()
+	if isError(result) {
+		return result
+	}
+
+	mod := &object.Module{Path: importPath, Exports: map[string]object.Object{}}
+	for _, stmt := range program.Stmts {
+		if export, ok := stmt.(*ast.ExportStmt); ok {
+			mod.Exports[export.Let.Name.Value], _ = env.Get(export.Let.Name.Value)
+		}
+	}
+
+	if l.modules == nil {
+		l.modules = map[string]*object.Module{}
+	}
+	l.modules[importPath] = mod
+	return mod
+}
@@ -0,0 +1,81 @@
+package eval
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/ei1chi/sample-lang/lexer"
+	"github.com/ei1chi/sample-lang/object"
+	"github.com/ei1chi/sample-lang/parser"
+)
+
+var testModules = fstest.MapFS{
+	"lib.sl":       {Data: []byte("export let double = fn(x) { x * 2 };\nlet secret = 1;\nexport let name = \"lib\";")},
+	"util/math.sl": {Data: []byte("export let inc = fn(x) { x + 1 };")},
+	"reexport.sl":  {Data: []byte("import \"lib\";\nexport let m = lib;")},
+	"cycle/a.sl":   {Data: []byte("import \"cycle/b\";")},
+	"cycle/b.sl":   {Data: []byte("import \"cycle/a\";")},
+	"fail.sl":      {Data: []byte("let f = fn() { 1 / 0 };\nexport let x = f();")},
+	"bad.sl":       {Data: []byte("let = 1;")},
+}
+
+func testEvalModules(input string) object.Object {
+	p := parser.NewParser(lexer.NewLexer(input))
+	program := p.ParseProgram()
+
+	e := New(Config{Loader: &Loader{FS: testModules}})
+	return e.Eval(program, object.NewEnvironment())
+}
+
+func TestModules(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`import "lib"; lib.double(3)`, "6"},
+		{`import "lib"; lib.name == "lib"`, "true"},
+		{`import "lib"; lib`, `module "lib"`},
+		{`import "util/math"; math.inc(1)`, "2"},
+		{`import "lib"; import "reexport"; reexport.m == lib`, "true"},
+		{`let f = fn() { import "lib"; lib.double(1) }; f()`, "2"},
+		{`import "lib"; lib.secret`, `ERROR: module "lib" does not export secret`},
+		{`let x = 1; x.y`, "ERROR: cannot select y from INTEGER"},
+		{`import "missing"; 1`, `ERROR: cannot find module "missing" in .`},
+		{`import "cycle/a"; 1`, "ERROR: import cycle: cycle/a -> cycle/b -> cycle/a"},
+		{`import "bad"; 1`, "ERROR: bad.sl:1:5: expected next token to be IDENT, got = instead"},
+		{`"a" == "a"`, "true"},
+		{`"a" != "b"`, "true"},
+		{`"a" + "b"`, "ERROR: unknown operator: STRING + STRING"},
+	}
+
+	for _, test := range tests {
+		evaled := testEvalModules(test.input)
+		if evaled.Inspect() != test.expected {
+			t.Errorf("%q: wrong result. expected=%q, got=%q", test.input, test.expected, evaled.Inspect())
+		}
+	}
+}
+
+func TestModuleStackTrace(t *testing.T) {
+	evaled := testEvalModules("import \"fail\";\nfail.x")
+
+	errObj, ok := evaled.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaled, evaled)
+	}
+	expected := "    at f (fail.sl:1:18)\n" +
+		"    at <module fail> (fail.sl:2:17)\n" +
+		"    at main (test.sl:1:1)\n"
+	if trace := errObj.StackTrace("test.sl"); trace != expected {
+		t.Errorf("wrong stack trace. expected=\n%s\ngot=\n%s", expected, trace)
+	}
+}
+
+func TestImportWithoutLoader(t *testing.T) {
+	evaled := testEval(`import "lib"; 1`)
+
+	expected := `ERROR: cannot import "lib": modules are not available`
+	if evaled.Inspect() != expected {
+		t.Errorf("wrong result. expected=%q, got=%q", expected, evaled.Inspect())
+	}
+}
@@ -0,0 +1,39 @@
+package eval
+
+import (
+	"github.com/ei1chi/sample-lang/object"
+	"github.com/ei1chi/sample-lang/token"
+)
+
+// 仮想マシンのように評価器の外で、eval と同じ規則で演算するための関数。実行制限は数えない。
+
+// Infix 二項演算 left ope right の値。
+func Infix(ope string, left, right object.Object) object.Object {
+	return New(Config{}).evalInfixExpr(ope, left, right)
+}
+
+// Prefix 単項演算 ope right の値。
+func Prefix(ope string, right object.Object) object.Object {
+	return New(Config{}).evalPrefixExpr(ope, right)
+}
+
+// Index left[index] の値。
+func Index(left, index object.Object) object.Object {
+	return evalIndexExpr(left, index)
+}
+
+// Select モジュール x の export した変数 name の値。
+func Select(x object.Object, name string) object.Object {
+	return evalSelectorExpr(x, name)
+}
+
+// Throw throw 文が val を投げるときのエラー。
+func Throw(val object.Object) *object.Error {
+	return throw(val)
+}
+
+// Call fn を args で呼び出す。仮想マシンがモジュールの関数を呼ぶのに使う。
+// 関数の名前と呼び出した位置は分からないので、スタックトレースには残らない。
+func (e *Evaluator) Call(fn object.Object, args ...object.Object) object.Object {
+	return e.applyFunction("", token.Position{}, fn, args)
+}
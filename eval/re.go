@@ -0,0 +1,147 @@
+package eval
+
+import (
+	"errors"
+	"regexp"
+	"regexp/syntax"
+	"strconv"
+	"sync"
+
+	"github.com/ei1chi/sample-lang/object"
+)
+
+// reModule import "re" で使える正規表現の関数。文法は Go の regexp（RE2）と同じ。
+// 正規表現を受け取る引数には、compile の結果の代わりにパターンの文字列も渡せる。
+var reModule = &object.Module{Path: "re", Exports: map[string]object.Object{
+	// compile(pattern) pattern をコンパイルする。同じパターンなら同じ値を返す
+	"compile": &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		if err := checkArgs("compile", args, object.STRING); err != nil {
+			return err
+		}
+		re, err := compileRegex(args[0].(*object.String).Value)
+		if err != nil {
+			return err
+		}
+		return re
+	}},
+
+	// match(re, s) s が re に当てはまる部分を含むか
+	"match": regexBuiltin("match", 1, func(re *regexp.Regexp, s []string) object.Object {
+		return nativeBooleanObject(re.MatchString(s[0]))
+	}),
+
+	// find(re, s) s の中で re に最初に当てはまる部分。なければ null
+	"find": regexBuiltin("find", 1, func(re *regexp.Regexp, s []string) object.Object {
+		loc := re.FindStringIndex(s[0])
+		if loc == nil {
+			return NULL
+		}
+		return &object.String{Value: s[0][loc[0]:loc[1]]}
+	}),
+
+	// find_all(re, s) s の中で re に当てはまる部分をすべて並べた配列
+	"find_all": regexBuiltin("find_all", 1, func(re *regexp.Regexp, s []string) object.Object {
+		return stringArray(re.FindAllString(s[0], -1))
+	}),
+
+	// groups(re, s) 最初に当てはまった部分のグループを、番号と名前をキーにした連想配列にする。
+	// "0" は当てはまった部分全体。当てはまらなかったグループは null。s に当てはまらなければ null
+	"groups": regexBuiltin("groups", 1, func(re *regexp.Regexp, s []string) object.Object {
+		loc := re.FindStringSubmatchIndex(s[0])
+		if loc == nil {
+			return NULL
+		}
+		m := object.NewMap()
+		names := re.SubexpNames()
+		for i := range names {
+			m.Set(strconv.Itoa(i), submatch(s[0], loc, i))
+		}
+		for i, name := range names {
+			if name != "" {
+				m.Set(name, submatch(s[0], loc, i))
+			}
+		}
+		return m
+	}),
+
+	// replace(re, s, repl) s の中で re に当てはまる部分をすべて repl に置き換える。
+	// repl の中の $1 や ${name} はグループに当てはまった部分になる
+	"replace": regexBuiltin("replace", 2, func(re *regexp.Regexp, s []string) object.Object {
+		return &object.String{Value: re.ReplaceAllString(s[0], s[1])}
+	}),
+}}
+
+// regexCacheSize 覚えておくパターンの数。超えたら一度すべて忘れる。
+const regexCacheSize = 256
+
+// regexCache パターンごとのコンパイル結果。評価は並行して動くことがあるので Mutex で守る。
+var regexCache = struct {
+	sync.Mutex
+	m map[string]*object.Regex
+}{m: map[string]*object.Regex{}}
+
+// compileRegex pattern をコンパイルする。一度コンパイルしたパターンは覚えておく。
+func compileRegex(pattern string) (*object.Regex, *object.Error) {
+	regexCache.Lock()
+	defer regexCache.Unlock()
+
+	if re, ok := regexCache.m[pattern]; ok {
+		return re, nil
+	}
+
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		var serr *syntax.Error
+		if errors.As(err, &serr) {
+			return nil, newError("invalid regular expression %q: %s", pattern, serr.Code)
+		}
+		return nil, newError("invalid regular expression %q", pattern)
+	}
+
+	if len(regexCache.m) >= regexCacheSize {
+		regexCache.m = map[string]*object.Regex{}
+	}
+	re := &object.Regex{Value: compiled}
+	regexCache.m[pattern] = re
+	return re, nil
+}
+
+// regexBuiltin 正規表現と n 個の文字列を受け取る組み込み関数を作る。
+func regexBuiltin(name string, n int, fn func(re *regexp.Regexp, s []string) object.Object) *object.Builtin {
+	return &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		if len(args) != n+1 {
+			return newError("wrong number of arguments. got=%d, want=%d", len(args), n+1)
+		}
+
+		var re *object.Regex
+		switch arg := args[0].(type) {
+		case *object.Regex:
+			re = arg
+		case *object.String:
+			var err *object.Error
+			if re, err = compileRegex(arg.Value); err != nil {
+				return err
+			}
+		default:
+			return newError("argument to `%s` must be %s or %s, got %s", name, object.REGEX, object.STRING, arg.Type())
+		}
+
+		s := make([]string, n)
+		for i, arg := range args[1:] {
+			str, ok := arg.(*object.String)
+			if !ok {
+				return newError("argument to `%s` must be %s, got %s", name, object.STRING, arg.Type())
+			}
+			s[i] = str.Value
+		}
+		return fn(re.Value, s)
+	}}
+}
+
+// submatch loc が示す i 番目のグループに当てはまった部分。当てはまらなければ null。
+func submatch(s string, loc []int, i int) object.Object {
+	if loc[2*i] < 0 {
+		return NULL
+	}
+	return &object.String{Value: s[loc[2*i]:loc[2*i+1]]}
+}
@@ -0,0 +1,40 @@
+package eval
+
+import "testing"
+
+func TestRegexModule(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`re.compile("a+b")`, `regex "a+b"`},
+		{`re.compile("a+b") == re.compile("a+b")`, "true"},
+		{`re.compile("a+b") == re.compile("a*b")`, "false"},
+		{`re.compile("(a")`, `ERROR: invalid regular expression "(a": missing closing )`},
+		{`re.match("^[0-9]+$", "123")`, "true"},
+		{`re.match(re.compile("^[0-9]+$"), "12a")`, "false"},
+		{`re.find("[0-9]+", "ab 12 34")`, "12"},
+		{`re.find("[0-9]+", "abc")`, "null"},
+		{`re.find("", "abc") == ""`, "true"},
+		{`re.find_all("[0-9]+", "ab 12 34")`, "[12, 34]"},
+		{`re.find_all("x", "abc")`, "[]"},
+		{`re.find(".", "日本")`, "日"},
+		{`re.groups("(?P<key>\\w+)=(?P<val>\\w*)", "a=1 b=2")`, "{0: a=1, 1: a, 2: 1, key: a, val: 1}"},
+		{`re.groups("(?P<key>\\w+)=(?P<val>\\w*)", "a=1")["val"]`, "1"},
+		{`re.groups("(a)|(b)", "b")`, "{0: b, 1: null, 2: b}"},
+		{`re.groups("a", "b")`, "null"},
+		{`re.replace("[0-9]+", "a1b22", "#")`, "a#b#"},
+		{`re.replace("(?P<k>\\w+)=(\\w+)", "a=1 b=2", "$2=${k}")`, "1=a 2=b"},
+		{`re.match(1, "a")`, "ERROR: argument to `match` must be REGEX or STRING, got INTEGER"},
+		{`re.match("a", 1)`, "ERROR: argument to `match` must be STRING, got INTEGER"},
+		{`re.replace("a", "b")`, "ERROR: wrong number of arguments. got=2, want=3"},
+		{`re.find("[", "a")`, `ERROR: invalid regular expression "[": missing closing ]`},
+	}
+
+	for _, test := range tests {
+		evaled := testEval(`import "re"; ` + test.input)
+		if evaled.Inspect() != test.expected {
+			t.Errorf("%q: wrong result. expected=%q, got=%q", test.input, test.expected, evaled.Inspect())
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package eval
+
+import (
+	"math"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ei1chi/sample-lang/object"
+)
+
+// stringsFuncs import "strings" で使える文字列の関数のうち、repeat 以外。
+// 位置と長さはバイトではなく文字（rune）で数える。
+var stringsFuncs = map[string]object.Object{
+	// split(s, sep) s を sep で区切った文字列の配列。sep が空なら 1 文字ずつに分ける
+	"split": stringBuiltin("split", 2, func(s []string) object.Object {
+		return stringArray(strings.Split(s[0], s[1]))
+	}),
+
+	// join(a, sep) 文字列の配列 a を sep でつなぐ
+	"join": &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		if err := checkArgs("join", args, object.ARRAY, object.STRING); err != nil {
+			return err
+		}
+		elems := args[0].(*object.Array).Elems
+		strs := make([]string, len(elems))
+		for i, elem := range elems {
+			str, ok := elem.(*object.String)
+			if !ok {
+				return newError("argument to `join` must be ARRAY of %s, got %s at index %d", object.STRING, elem.Type(), i)
+			}
+			strs[i] = str.Value
+		}
+		return &object.String{Value: strings.Join(strs, args[1].(*object.String).Value)}
+	}},
+
+	// trim(s) 前後の空白を取り除く
+	"trim": stringBuiltin("trim", 1, func(s []string) object.Object {
+		return &object.String{Value: strings.TrimSpace(s[0])}
+	}),
+
+	// replace(s, old, new) s の中の old をすべて new に置き換える
+	"replace": stringBuiltin("replace", 3, func(s []string) object.Object {
+		return &object.String{Value: strings.ReplaceAll(s[0], s[1], s[2])}
+	}),
+
+	// contains(s, sub) s が sub を含むか
+	"contains": stringBuiltin("contains", 2, func(s []string) object.Object {
+		return nativeBooleanObject(strings.Contains(s[0], s[1]))
+	}),
+
+	// index(s, sub) s の中で sub が最初に現れる位置。なければ -1
+	"index": stringBuiltin("index", 2, func(s []string) object.Object {
+		i := strings.Index(s[0], s[1])
+		if i >= 0 {
+			i = utf8.RuneCountInString(s[0][:i])
+		}
+		return &object.Integer{Value: int64(i)}
+	}),
+
+	// upper(s) 大文字にする
+	"upper": stringBuiltin("upper", 1, func(s []string) object.Object {
+		return &object.String{Value: strings.ToUpper(s[0])}
+	}),
+
+	// lower(s) 小文字にする
+	"lower": stringBuiltin("lower", 1, func(s []string) object.Object {
+		return &object.String{Value: strings.ToLower(s[0])}
+	}),
+
+	// starts_with(s, prefix) s が prefix で始まるか
+	"starts_with": stringBuiltin("starts_with", 2, func(s []string) object.Object {
+		return nativeBooleanObject(strings.HasPrefix(s[0], s[1]))
+	}),
+
+	// ends_with(s, suffix) s が suffix で終わるか
+	"ends_with": stringBuiltin("ends_with", 2, func(s []string) object.Object {
+		return nativeBooleanObject(strings.HasSuffix(s[0], s[1]))
+	}),
+
+	// fields(s) s を空白で区切った文字列の配列。空の要素は含まない
+	"fields": stringBuiltin("fields", 1, func(s []string) object.Object {
+		return stringArray(strings.Fields(s[0]))
+	}),
+
+	// len(s) s の文字数
+	"len": stringBuiltin("len", 1, func(s []string) object.Object {
+		return &object.Integer{Value: int64(utf8.RuneCountInString(s[0]))}
+	}),
+
+	// slice(s, start, end) s の start 文字目から end 文字目の手前まで
+	"slice": &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		if err := checkArgs("slice", args, object.STRING, object.INTEGER, object.INTEGER); err != nil {
+			return err
+		}
+		s := args[0].(*object.String).Value
+		start, end := args[1].(*object.Integer).Value, args[2].(*object.Integer).Value
+		n := int64(utf8.RuneCountInString(s))
+		if start < 0 || end < start || end > n {
+			return newError("slice bounds out of range [%d:%d] with length %d", start, end, n)
+		}
+		from := runeOffset(s, int(start))
+		to := from + runeOffset(s[from:], int(end-start))
+		return &object.String{Value: s[from:to]}
+	}},
+}
+
+// newStringsModule stringsFuncs に repeat を加えたモジュール。repeat は結果を作る前に
+// e の割り当ての残りと比べるので、評価ごとに作る。
+func (e *Evaluator) newStringsModule() *object.Module {
+	exports := make(map[string]object.Object, len(stringsFuncs)+1)
+	for name, fn := range stringsFuncs {
+		exports[name] = fn
+	}
+
+	// repeat(s, n) s を n 回繰り返す
+	exports["repeat"] = &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		if err := checkArgs("repeat", args, object.STRING, object.INTEGER); err != nil {
+			return err
+		}
+		s, n := args[0].(*object.String).Value, args[1].(*object.Integer).Value
+		if n < 0 {
+			return newError("negative repeat count: %d", n)
+		}
+		if len(s) > 0 && n > math.MaxInt/int64(len(s)) {
+			return newError("repeat count too large: %d", n)
+		}
+		if err := e.checkAllocate(stringSize + int64(len(s))*n); err != nil {
+			return err
+		}
+		return &object.String{Value: strings.Repeat(s, int(n))}
+	}}
+
+	return &object.Module{Path: "strings", Exports: exports}
+}
+
+// checkArgs 組み込み関数 name の引数の数と型を確かめる。
+func checkArgs(name string, args []object.Object, types ...object.ObjectType) *object.Error {
+	if len(args) != len(types) {
+		return newError("wrong number of arguments. got=%d, want=%d", len(args), len(types))
+	}
+	for i, arg := range args {
+		if arg.Type() != types[i] {
+			return newError("argument to `%s` must be %s, got %s", name, types[i], arg.Type())
+		}
+	}
+	return nil
+}
+
+// stringBuiltin n 個の文字列を受け取る組み込み関数を作る。
+func stringBuiltin(name string, n int, fn func(s []string) object.Object) *object.Builtin {
+	types := make([]object.ObjectType, n)
+	for i := range types {
+		types[i] = object.STRING
+	}
+	return &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		if err := checkArgs(name, args, types...); err != nil {
+			return err
+		}
+		s := make([]string, len(args))
+		for i, arg := range args {
+			s[i] = arg.(*object.String).Value
+		}
+		return fn(s)
+	}}
+}
+
+func stringArray(strs []string) *object.Array {
+	elems := make([]object.Object, len(strs))
+	for i, s := range strs {
+		elems[i] = &object.String{Value: s}
+	}
+	return &object.Array{Elems: elems}
+}
+
+// runeOffset s の先頭から n 文字目のバイト位置。
+func runeOffset(s string, n int) int {
+	offset := 0
+	for ; n > 0; n-- {
+		_, size := utf8.DecodeRuneInString(s[offset:])
+		offset += size
+	}
+	return offset
+}
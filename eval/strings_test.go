@@ -0,0 +1,49 @@
+package eval
+
+import "testing"
+
+func TestStringsModule(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`strings.split("a,b,,c", ",")`, "[a, b, , c]"},
+		{`strings.split("日本語", "")`, "[日, 本, 語]"},
+		{`strings.split("a,b", ",")[1] == "b"`, "true"},
+		{`strings.join(["a", "b", "c"], "-")`, "a-b-c"},
+		{`strings.join([], "-") == ""`, "true"},
+		{`strings.join(strings.fields(" x  y\tz\n"), ",")`, "x,y,z"},
+		{`strings.join(["a", 1], "-")`, "ERROR: argument to `join` must be ARRAY of STRING, got INTEGER at index 1"},
+		{`strings.trim("  hi \n") == "hi"`, "true"},
+		{`strings.replace("aaa", "a", "bb")`, "bbbbbb"},
+		{`strings.contains("seafood", "foo")`, "true"},
+		{`strings.contains("seafood", "bar")`, "false"},
+		{`strings.index("chicken", "ken")`, "4"},
+		{`strings.index("日本語", "語")`, "2"},
+		{`strings.index("chicken", "dmr")`, "-1"},
+		{`strings.upper("héllo")`, "HÉLLO"},
+		{`strings.lower("ÀB")`, "àb"},
+		{`strings.repeat("ab", 3)`, "ababab"},
+		{`strings.repeat("ab", 0) == ""`, "true"},
+		{`strings.repeat("ab", -1)`, "ERROR: negative repeat count: -1"},
+		{`strings.repeat("ab", 9223372036854775807)`, "ERROR: repeat count too large: 9223372036854775807"},
+		{`strings.starts_with("golang", "go")`, "true"},
+		{`strings.ends_with("golang", "go")`, "false"},
+		{`strings.len("héllo")`, "5"},
+		{`strings.len("")`, "0"},
+		{`strings.slice("héllo", 1, 3)`, "él"},
+		{`strings.slice("日本語", 2, 3)`, "語"},
+		{`strings.slice("abc", 3, 3) == ""`, "true"},
+		{`strings.slice("abc", 2, 4)`, "ERROR: slice bounds out of range [2:4] with length 3"},
+		{`strings.slice("abc", 2, 1)`, "ERROR: slice bounds out of range [2:1] with length 3"},
+		{`strings.len(1)`, "ERROR: argument to `len` must be STRING, got INTEGER"},
+		{`strings.replace("a", "b")`, "ERROR: wrong number of arguments. got=2, want=3"},
+	}
+
+	for _, test := range tests {
+		evaled := testEval(`import "strings"; ` + test.input)
+		if evaled.Inspect() != test.expected {
+			t.Errorf("%q: wrong result. expected=%q, got=%q", test.input, test.expected, evaled.Inspect())
+		}
+	}
+}
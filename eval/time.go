@@ -0,0 +1,233 @@
+package eval
+
+import (
+	"time"
+	_ "time/tzdata" // タイムゾーンのデータベースがない環境でも in_zone などを使えるようにする
+
+	"github.com/ei1chi/sample-lang/object"
+)
+
+// timeExports now 以外の time モジュールの値。
+// layout は Go の time パッケージと同じく 2006-01-02 15:04:05 を例にした書式。
+var timeExports = map[string]object.Object{
+	"NANOSECOND":  &object.Duration{Value: time.Nanosecond},
+	"MICROSECOND": &object.Duration{Value: time.Microsecond},
+	"MILLISECOND": &object.Duration{Value: time.Millisecond},
+	"SECOND":      &object.Duration{Value: time.Second},
+	"MINUTE":      &object.Duration{Value: time.Minute},
+	"HOUR":        &object.Duration{Value: time.Hour},
+
+	// 名前に数字を使えないので、RFC 3339 は ISO、RFC 1123 は HTTP と呼ぶ
+	"ISO":       &object.String{Value: time.RFC3339},
+	"ISO_NANO":  &object.String{Value: time.RFC3339Nano},
+	"HTTP":      &object.String{Value: time.RFC1123},
+	"DATE_TIME": &object.String{Value: time.DateTime},
+	"DATE_ONLY": &object.String{Value: time.DateOnly},
+	"TIME_ONLY": &object.String{Value: time.TimeOnly},
+
+	// parse(layout, s, zone) s を layout に従って時刻にする。読めなければエラーの値を返す。
+	// s にタイムゾーンがなければ zone のものとし、zone を省略すると UTC にする
+	"parse": &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		loc := time.UTC
+		if len(args) == 3 {
+			if err := checkArgs("parse", args, object.STRING, object.STRING, object.STRING); err != nil {
+				return err
+			}
+			var err *object.Error
+			if loc, err = loadZone(args[2].(*object.String).Value); err != nil {
+				return err
+			}
+		} else if err := checkArgs("parse", args, object.STRING, object.STRING); err != nil {
+			return err
+		}
+
+		t, err := time.ParseInLocation(args[0].(*object.String).Value, args[1].(*object.String).Value, loc)
+		if err != nil {
+			return &object.ErrorValue{Err: newError("%s", err)}
+		}
+		return &object.Time{Value: t}
+	}},
+
+	// format(t, layout) t を layout に従って文字列にする
+	"format": &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		if err := checkArgs("format", args, object.TIME, object.STRING); err != nil {
+			return err
+		}
+		return &object.String{Value: args[0].(*object.Time).Value.Format(args[1].(*object.String).Value)}
+	}},
+
+	// in_zone(t, zone) t と同じ時刻を zone で表したもの
+	"in_zone": &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		if err := checkArgs("in_zone", args, object.TIME, object.STRING); err != nil {
+			return err
+		}
+		loc, err := loadZone(args[1].(*object.String).Value)
+		if err != nil {
+			return err
+		}
+		return &object.Time{Value: args[0].(*object.Time).Value.In(loc)}
+	}},
+
+	// zone(t) t のタイムゾーンの名前
+	"zone": &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		if err := checkArgs("zone", args, object.TIME); err != nil {
+			return err
+		}
+		return &object.String{Value: args[0].(*object.Time).Value.Location().String()}
+	}},
+
+	// unix(t) 1970-01-01T00:00:00Z からの秒数
+	"unix": timeField("unix", func(t time.Time) int64 { return t.Unix() }),
+
+	// from_unix(sec) 1970-01-01T00:00:00Z から sec 秒後の UTC の時刻
+	"from_unix": &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		if err := checkArgs("from_unix", args, object.INTEGER); err != nil {
+			return err
+		}
+		return &object.Time{Value: time.Unix(args[0].(*object.Integer).Value, 0).UTC()}
+	}},
+
+	// year(t), month(t), day(t), hour(t), minute(t), second(t) t の年、月、日、時、分、秒
+	"year":   timeField("year", func(t time.Time) int64 { return int64(t.Year()) }),
+	"month":  timeField("month", func(t time.Time) int64 { return int64(t.Month()) }),
+	"day":    timeField("day", func(t time.Time) int64 { return int64(t.Day()) }),
+	"hour":   timeField("hour", func(t time.Time) int64 { return int64(t.Hour()) }),
+	"minute": timeField("minute", func(t time.Time) int64 { return int64(t.Minute()) }),
+	"second": timeField("second", func(t time.Time) int64 { return int64(t.Second()) }),
+
+	// weekday(t) 曜日の英語の名前
+	"weekday": &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		if err := checkArgs("weekday", args, object.TIME); err != nil {
+			return err
+		}
+		return &object.String{Value: args[0].(*object.Time).Value.Weekday().String()}
+	}},
+
+	// parse_duration(s) "1h30m" のような文字列を時間の長さにする。読めなければエラーの値を返す
+	"parse_duration": &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		if err := checkArgs("parse_duration", args, object.STRING); err != nil {
+			return err
+		}
+		d, err := time.ParseDuration(args[0].(*object.String).Value)
+		if err != nil {
+			return &object.ErrorValue{Err: newError("%s", err)}
+		}
+		return &object.Duration{Value: d}
+	}},
+}
+
+// NewTimeModule import "time" で使える時刻の関数。now は clock の時刻を返し、clock が nil なら time.Now を使う。
+// 時刻どうしは比べられ、引くと時間の長さになる。時刻に時間の長さを足し引きできる。
+func NewTimeModule(clock func() time.Time) *object.Module {
+	if clock == nil {
+		clock = time.Now
+	}
+	exports := map[string]object.Object{
+		// now() 今の時刻
+		"now": &object.Builtin{Fn: func(args ...object.Object) object.Object {
+			if err := checkArgs("now", args); err != nil {
+				return err
+			}
+			return &object.Time{Value: clock()}
+		}},
+	}
+	for name, val := range timeExports {
+		exports[name] = val
+	}
+	return &object.Module{Path: "time", Exports: exports}
+}
+
+// timeField 時刻を一つ受け取り、整数を返す組み込み関数を作る。
+func timeField(name string, fn func(t time.Time) int64) *object.Builtin {
+	return &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		if err := checkArgs(name, args, object.TIME); err != nil {
+			return err
+		}
+		return &object.Integer{Value: fn(args[0].(*object.Time).Value)}
+	}}
+}
+
+// loadZone name のタイムゾーン。"Local" は動かす環境によって変わるので受け付けない。
+func loadZone(name string) (*time.Location, *object.Error) {
+	if name == "Local" {
+		return nil, newError("unknown time zone %q", name)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, newError("unknown time zone %q", name)
+	}
+	return loc, nil
+}
+
+// evalTimeInfixExpr 時刻と時間の長さの演算。どちらも時刻でも時間の長さでもなければ nil を返す。
+func (e *Evaluator) evalTimeInfixExpr(ope string, left, right object.Object) object.Object {
+	switch l := left.(type) {
+	case *object.Time:
+		switch r := right.(type) {
+		case *object.Time:
+			switch ope {
+			case "-":
+				return e.newDuration(l.Value.Sub(r.Value))
+			case "<":
+				return nativeBooleanObject(l.Value.Before(r.Value))
+			case ">":
+				return nativeBooleanObject(l.Value.After(r.Value))
+			case "==":
+				return nativeBooleanObject(l.Value.Equal(r.Value))
+			case "!=":
+				return nativeBooleanObject(!l.Value.Equal(r.Value))
+			}
+		case *object.Duration:
+			switch ope {
+			case "+":
+				return e.newTime(l.Value.Add(r.Value))
+			case "-":
+				return e.newTime(l.Value.Add(-r.Value))
+			}
+		}
+
+	case *object.Duration:
+		switch r := right.(type) {
+		case *object.Duration:
+			switch ope {
+			case "+":
+				return e.newDuration(l.Value + r.Value)
+			case "-":
+				return e.newDuration(l.Value - r.Value)
+			case "/":
+				if r.Value == 0 {
+					return newError("division by zero: %s / %s", l.Inspect(), r.Inspect())
+				}
+				return e.newInteger(int64(l.Value / r.Value))
+			case "<":
+				return nativeBooleanObject(l.Value < r.Value)
+			case ">":
+				return nativeBooleanObject(l.Value > r.Value)
+			case "==":
+				return nativeBooleanObject(l.Value == r.Value)
+			case "!=":
+				return nativeBooleanObject(l.Value != r.Value)
+			}
+		case *object.Time:
+			if ope == "+" {
+				return e.newTime(r.Value.Add(l.Value))
+			}
+		case *object.Integer:
+			switch ope {
+			case "*":
+				return e.newDuration(l.Value * time.Duration(r.Value))
+			case "/":
+				if r.Value == 0 {
+					return newError("division by zero: %s / %d", l.Inspect(), r.Value)
+				}
+				return e.newDuration(l.Value / time.Duration(r.Value))
+			}
+		}
+
+	case *object.Integer:
+		if r, ok := right.(*object.Duration); ok && ope == "*" {
+			return e.newDuration(time.Duration(l.Value) * r.Value)
+		}
+	}
+	return nil
+}
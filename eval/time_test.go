@@ -0,0 +1,72 @@
+package eval
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ei1chi/sample-lang/lexer"
+	"github.com/ei1chi/sample-lang/object"
+	"github.com/ei1chi/sample-lang/parser"
+)
+
+func TestTimeModule(t *testing.T) {
+	now := time.Date(2024, time.March, 10, 1, 30, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`time.now()`, "2024-03-10T01:30:00Z"},
+		{`time.now() == time.now()`, "true"},
+		{`time.parse(time.ISO, "2024-03-10T10:30:00+09:00") == time.now()`, "true"},
+		{`time.parse(time.DATE_TIME, "2024-03-10 01:30:00")`, "2024-03-10T01:30:00Z"},
+		{`time.parse(time.DATE_TIME, "2024-03-10 01:30:00", "America/New_York")`, "2024-03-10T01:30:00-05:00"},
+		{`time.parse(time.DATE_ONLY, "2024-13-01")`, `error: parsing time "2024-13-01": month out of range`},
+		{`is_error(time.parse(time.DATE_ONLY, "x"))`, "true"},
+		{`time.parse(time.DATE_ONLY, "2024-01-01", "Mars/Base")`, `ERROR: unknown time zone "Mars/Base"`},
+		{`time.format(time.now(), "2006/01/02 15:04")`, "2024/03/10 01:30"},
+		{`time.format(time.in_zone(time.now(), "Asia/Tokyo"), time.HTTP)`, "Sun, 10 Mar 2024 10:30:00 JST"},
+		{`time.in_zone(time.now(), "Asia/Tokyo")`, "2024-03-10T10:30:00+09:00"},
+		{`time.in_zone(time.now() + time.HOUR, "America/New_York")`, "2024-03-09T21:30:00-05:00"},
+		{`time.in_zone(time.now() + 2 * time.HOUR, "America/New_York")`, "2024-03-09T22:30:00-05:00"},
+		{`time.in_zone(time.now() + 7 * time.HOUR, "America/New_York")`, "2024-03-10T04:30:00-04:00"},
+		{`time.in_zone(time.now(), "Local")`, `ERROR: unknown time zone "Local"`},
+		{`time.zone(time.in_zone(time.now(), "Europe/Paris"))`, "Europe/Paris"},
+		{`time.unix(time.now())`, "1710034200"},
+		{`time.from_unix(0)`, "1970-01-01T00:00:00Z"},
+		{`let t = time.in_zone(time.now(), "Asia/Tokyo"); [time.year(t), time.month(t), time.day(t), time.hour(t), time.minute(t), time.second(t)]`, "[2024, 3, 10, 10, 30, 0]"},
+		{`time.weekday(time.now())`, "Sunday"},
+		{`time.parse_duration("1h30m")`, "1h30m0s"},
+		{`time.parse_duration("1x")`, `error: time: unknown unit "x" in duration "1x"`},
+		{`time.HOUR + 30 * time.MINUTE`, "1h30m0s"},
+		{`time.HOUR - time.SECOND * 90`, "58m30s"},
+		{`-time.SECOND`, "-1s"},
+		{`time.HOUR / time.MINUTE`, "60"},
+		{`time.HOUR / 4`, "15m0s"},
+		{`time.HOUR / 0`, "ERROR: division by zero: 1h0m0s / 0"},
+		{`time.HOUR / (time.SECOND - time.SECOND)`, "ERROR: division by zero: 1h0m0s / 0s"},
+		{`time.SECOND < time.MINUTE`, "true"},
+		{`time.MINUTE == 60 * time.SECOND`, "true"},
+		{`time.now() - time.parse(time.DATE_ONLY, "2024-03-09")`, "25h30m0s"},
+		{`time.now() - time.HOUR`, "2024-03-10T00:30:00Z"},
+		{`time.MINUTE + time.now()`, "2024-03-10T01:31:00Z"},
+		{`time.now() < time.now() + time.NANOSECOND`, "true"},
+		{`time.now() > time.now()`, "false"},
+		{`time.now() != time.in_zone(time.now(), "Asia/Tokyo")`, "false"},
+		{`time.now() == 1`, "false"},
+		{`time.now() + time.now()`, "ERROR: unknown operator: TIME + TIME"},
+		{`time.now() + 1`, "ERROR: type mismatch: TIME + INTEGER"},
+		{`time.now(1)`, "ERROR: wrong number of arguments. got=1, want=0"},
+		{`time.format(1, "")`, "ERROR: argument to `format` must be TIME, got INTEGER"},
+	}
+
+	for _, test := range tests {
+		p := parser.NewParser(lexer.NewLexer(`import "time"; ` + test.input))
+		program := p.ParseProgram()
+		evaled := New(Config{Clock: clock}).Eval(program, object.NewEnvironment())
+		if evaled.Inspect() != test.expected {
+			t.Errorf("%q: wrong result. expected=%q, got=%q", test.input, test.expected, evaled.Inspect())
+		}
+	}
+}
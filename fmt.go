@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ei1chi/sample-lang/format"
+)
+
+// runFmt sample-lang fmt [-w] files...
+// ファイルを整形して標準出力に書く。-w なら元のファイルを書き換える。ファイルがなければ標準入力を整形する。
+func runFmt(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	write := flags.Bool("w", false, "write result to (source) file instead of stdout")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: sample-lang fmt [-w] [files...]\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if flags.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "fmt: cannot use -w with standard input")
+			return 2
+		}
+		src, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fmt: %s\n", err)
+			return 1
+		}
+		return fmtSource("<stdin>", src, false)
+	}
+
+	status := 0
+	for _, path := range flags.Args() {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fmt: %s\n", err)
+			status = 1
+			continue
+		}
+		if s := fmtSource(path, src, *write); s != 0 {
+			status = s
+		}
+	}
+	return status
+}
+
+func fmtSource(path string, src []byte, write bool) int {
+	out, err := format.Source(src)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+		return 1
+	}
+
+	if !write {
+		os.Stdout.Write(out)
+		return 0
+	}
+
+	if bytes.Equal(src, out) {
+		return 0
+	}
+	if err := os.WriteFile(path, out, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "fmt: %s\n", err)
+		return 1
+	}
+	return 0
+}
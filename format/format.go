@@ -0,0 +1,421 @@
+package format
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/ei1chi/sample-lang/ast"
+	"github.com/ei1chi/sample-lang/lexer"
+	"github.com/ei1chi/sample-lang/parser"
+	"github.com/ei1chi/sample-lang/token"
+)
+
+// Source src を整形する。構文エラーがあれば整形せずにエラーを返す。
+// 元のソースで文の間に空行があれば、一つだけ残す。
+// コメントは前にある文の行末か、後ろにある文の前の行に書く。
+func Source(src []byte) ([]byte, error) {
+	l := lexer.NewLexer(string(src))
+	p := parser.NewParser(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, errors.New(strings.Join(p.Errors(), "\n"))
+	}
+
+	pr := &printer{lines: strings.Split(string(src), "\n")}
+	pr.comments, pr.closes = scanComments(string(src))
+	pr.program(program)
+	return pr.out.Bytes(), nil
+}
+
+// Node node を整形して w に書く。
+func Node(w io.Writer, node ast.Node) error {
+	pr := &printer{}
+
+	switch node := node.(type) {
+	case *ast.Program:
+		pr.program(node)
+	case ast.Stmt:
+		pr.stmt(node)
+		pr.out.WriteString("\n")
+	case ast.Expr:
+		pr.expr(node, LOWEST)
+	case ast.TypeExpr:
+		pr.typeExpr(node)
+	}
+
+	_, err := w.Write(pr.out.Bytes())
+	return err
+}
+
+type printer struct {
+	out    bytes.Buffer
+	indent int
+	lines  []string // 空行を残すための元のソース。なければ空行は入れない
+
+	comments []comment              // まだ書いていないコメント。出てくる順に並ぶ
+	closes   map[token.Position]int // ブロックの { の位置から、対応する } の行
+}
+
+// comment 元のソースの // から行末まで。
+type comment struct {
+	line     int
+	text     string
+	trailing bool // 同じ行でトークンの後ろにある
+}
+
+// scanComments src のコメントと、ブロックの { から } の行を引く表を作る。
+func scanComments(src string) ([]comment, map[token.Position]int) {
+	var comments []comment
+	closes := map[token.Position]int{}
+	var opens []token.Position
+
+	l := lexer.NewLosslessLexer(src)
+	for {
+		tok := l.NextToken()
+
+		// Leading は tok の行で終わる
+		line := tok.Pos.Line - strings.Count(tok.Leading, "\n")
+		for _, s := range strings.SplitAfter(tok.Leading, "\n") {
+			if i := strings.Index(s, "//"); i >= 0 {
+				comments = append(comments, comment{line: line, text: strings.TrimRight(s[i:], " \t\r\n")})
+			}
+			line++
+		}
+		if i := strings.Index(tok.Trailing, "//"); i >= 0 {
+			comments = append(comments, comment{line: tok.Pos.Line, text: strings.TrimRight(tok.Trailing[i:], " \t\r\n"), trailing: true})
+		}
+
+		switch tok.Type {
+		case token.LBRACE:
+			opens = append(opens, tok.Pos)
+		case token.RBRACE:
+			if len(opens) > 0 {
+				closes[opens[len(opens)-1]] = tok.Pos.Line
+				opens = opens[:len(opens)-1]
+			}
+		case token.EOF:
+			return comments, closes
+		}
+	}
+}
+
+func (p *printer) program(program *ast.Program) {
+	p.stmtList(program.Stmts, math.MaxInt)
+}
+
+// stmtList 文を一行ずつ書く。end は囲んでいるブロックの } の行で、それより前のコメントはすべて書く。
+func (p *printer) stmtList(stmts []ast.Stmt, end int) {
+	for i, s := range stmts {
+		if i > 0 && p.blankLineBetween(stmts[i-1], s) {
+			p.out.WriteString("\n")
+		}
+		p.commentsBefore(s.Pos().Line)
+		p.writeIndent()
+		p.stmt(s)
+
+		next := end
+		if i+1 < len(stmts) {
+			next = stmts[i+1].Pos().Line
+			if continues(s, stmts[i+1]) {
+				p.out.WriteString(";")
+			}
+		}
+		if len(p.comments) > 0 && p.comments[0].trailing && p.comments[0].line < next {
+			p.out.WriteString(" " + p.comments[0].text)
+			p.comments = p.comments[1:]
+		}
+		p.out.WriteString("\n")
+	}
+	p.commentsBefore(end)
+}
+
+// continues ; を書かないと、ブロックで終わる if や try の s に next が続けて読まれてしまうか。
+// -、(、[ で始まる文は、前の式への演算や呼び出し、添字として読まれる。
+func continues(s, next ast.Stmt) bool {
+	es, ok := s.(*ast.ExprStmt)
+	if !ok {
+		return false
+	}
+	switch es.Expr.(type) {
+	case *ast.IfExpr, *ast.TryExpr:
+	default:
+		return false
+	}
+
+	pr := &printer{}
+	pr.stmt(next)
+	out := pr.out.Bytes()
+	return len(out) > 0 && strings.IndexByte("-([", out[0]) >= 0
+}
+
+// commentsBefore line より前の行のコメントを、一つずつ行にして書く。
+func (p *printer) commentsBefore(line int) {
+	for len(p.comments) > 0 && p.comments[0].line < line {
+		p.writeIndent()
+		p.out.WriteString(p.comments[0].text + "\n")
+		p.comments = p.comments[1:]
+	}
+}
+
+// blankLineBetween 元のソースで二つの文の間に空行があったか。
+func (p *printer) blankLineBetween(prev, next ast.Stmt) bool {
+	from, to := prev.Pos().Line, next.Pos().Line
+	if from <= 0 || to > len(p.lines) {
+		return false
+	}
+	for line := from + 1; line < to; line++ {
+		if strings.TrimSpace(p.lines[line-1]) == "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *printer) writeIndent() {
+	p.out.WriteString(strings.Repeat("\t", p.indent))
+}
+
+func (p *printer) stmt(s ast.Stmt) {
+	switch s := s.(type) {
+	case *ast.LetStmt:
+		p.out.WriteString("let ")
+		p.out.WriteString(s.Name.Value)
+		if s.Type != nil {
+			p.out.WriteString(": ")
+			p.typeExpr(s.Type)
+		}
+		p.out.WriteString(" = ")
+		p.expr(s.Value, LOWEST)
+		p.out.WriteString(";")
+
+	case *ast.ReturnStmt:
+		p.out.WriteString("return ")
+		p.expr(s.ReturnValue, LOWEST)
+		p.out.WriteString(";")
+
+	case *ast.ThrowStmt:
+		p.out.WriteString("throw ")
+		p.expr(s.Value, LOWEST)
+		p.out.WriteString(";")
+
+	case *ast.ImportStmt:
+		p.out.WriteString("import ")
+		p.expr(s.Path, LOWEST)
+		p.out.WriteString(";")
+
+	case *ast.ExportStmt:
+		p.out.WriteString("export ")
+		p.stmt(s.Let)
+
+	case *ast.ExprStmt:
+		p.expr(s.Expr, LOWEST)
+		// ブロックで終わる if と try は文として区切らなくてよい。続く文によっては stmtList が ; を書く
+		switch s.Expr.(type) {
+		case *ast.IfExpr, *ast.TryExpr:
+		default:
+			p.out.WriteString(";")
+		}
+
+	case *ast.BlockStmt:
+		p.block(s)
+	}
+}
+
+func (p *printer) block(b *ast.BlockStmt) {
+	end := p.closes[b.Pos()]
+	if len(b.Stmts) == 0 && (len(p.comments) == 0 || p.comments[0].line >= end) {
+		p.out.WriteString("{}")
+		return
+	}
+
+	p.out.WriteString("{\n")
+	p.indent++
+	p.stmtList(b.Stmts, end)
+	p.indent--
+	p.writeIndent()
+	p.out.WriteString("}")
+}
+
+// 優先順位は parser と同じ並び。
+const (
+	_ int = iota
+	LOWEST
+	EQUALS
+	COMPARE
+	SUM
+	PRODUCT
+	PREFIX
+	CALL
+)
+
+var precs = map[string]int{
+	"==": EQUALS,
+	"!=": EQUALS,
+	"<":  COMPARE,
+	">":  COMPARE,
+	"+":  SUM,
+	"-":  SUM,
+	"/":  PRODUCT,
+	"*":  PRODUCT,
+}
+
+// exprPrec 式をそのまま書いたときの結合の強さ。
+func exprPrec(e ast.Expr) int {
+	switch e := e.(type) {
+	case *ast.InfixExpr:
+		return precs[e.Operator]
+	case *ast.PrefixExpr:
+		return PREFIX
+	case *ast.PostfixExpr, *ast.CallExpr, *ast.IndexExpr, *ast.SelectorExpr:
+		return CALL
+	}
+	return CALL + 1
+}
+
+// expr e を書く。e の結合が outer より弱ければ括弧で囲む。
+func (p *printer) expr(e ast.Expr, outer int) {
+	if exprPrec(e) < outer {
+		p.out.WriteString("(")
+		defer p.out.WriteString(")")
+	}
+
+	switch e := e.(type) {
+	case *ast.Ident:
+		p.out.WriteString(e.Value)
+
+	case *ast.IntLiteral:
+		p.out.WriteString(e.String())
+
+	case *ast.StringLiteral:
+		// エスケープの書き方はソースのまま残す
+		p.out.WriteString(e.String())
+
+	case *ast.Boolean:
+		p.out.WriteString(e.String())
+
+	case *ast.PrefixExpr:
+		p.out.WriteString(e.Operator)
+		p.expr(e.Right, PREFIX)
+
+	case *ast.PostfixExpr:
+		p.expr(e.Left, CALL)
+		p.out.WriteString(e.Operator)
+
+	case *ast.InfixExpr:
+		// 左結合なので、同じ強さの演算子は右側だけ括弧が要る
+		prec := precs[e.Operator]
+		p.expr(e.Left, prec)
+		p.out.WriteString(" " + e.Operator + " ")
+		p.expr(e.Right, prec+1)
+
+	case *ast.IfExpr:
+		p.out.WriteString("if (")
+		p.expr(e.Cond, LOWEST)
+		p.out.WriteString(") ")
+		p.block(e.Cons)
+		if e.Alt != nil {
+			p.out.WriteString(" else ")
+			p.block(e.Alt)
+		}
+
+	case *ast.TryExpr:
+		p.out.WriteString("try ")
+		p.block(e.Body)
+		if e.Catch != nil {
+			p.out.WriteString(" catch (" + e.Param.Value + ") ")
+			p.block(e.Catch)
+		}
+		if e.Finally != nil {
+			p.out.WriteString(" finally ")
+			p.block(e.Finally)
+		}
+
+	case *ast.FuncLiteral:
+		p.out.WriteString("fn(")
+		for i, param := range e.Params {
+			if i > 0 {
+				p.out.WriteString(", ")
+			}
+			p.out.WriteString(param.Value)
+			if i < len(e.ParamTypes) && e.ParamTypes[i] != nil {
+				p.out.WriteString(": ")
+				p.typeExpr(e.ParamTypes[i])
+			}
+		}
+		p.out.WriteString(")")
+		if e.ResultType != nil {
+			p.out.WriteString(": ")
+			p.typeExpr(e.ResultType)
+		}
+		p.out.WriteString(" ")
+		p.block(e.Body)
+
+	case *ast.SelectorExpr:
+		p.expr(e.X, CALL)
+		p.out.WriteString("." + e.Sel.Value)
+
+	case *ast.CallExpr:
+		p.expr(e.Fn, CALL)
+		p.out.WriteString("(")
+		p.exprList(e.Args)
+		p.out.WriteString(")")
+
+	case *ast.IndexExpr:
+		p.expr(e.Left, CALL)
+		p.out.WriteString("[")
+		p.expr(e.Index, LOWEST)
+		p.out.WriteString("]")
+
+	case *ast.ArrayLiteral:
+		p.out.WriteString("[")
+		p.exprList(e.Elems)
+		p.out.WriteString("]")
+
+	case *ast.MapLiteral:
+		p.out.WriteString("{")
+		for i, k := range e.Keys {
+			if i > 0 {
+				p.out.WriteString(", ")
+			}
+			p.expr(k, LOWEST)
+			p.out.WriteString(": ")
+			p.expr(e.Values[i], LOWEST)
+		}
+		p.out.WriteString("}")
+	}
+}
+
+// exprList 引数や配列の要素をカンマで区切って書く。
+func (p *printer) exprList(list []ast.Expr) {
+	for i, e := range list {
+		if i > 0 {
+			p.out.WriteString(", ")
+		}
+		p.expr(e, LOWEST)
+	}
+}
+
+func (p *printer) typeExpr(t ast.TypeExpr) {
+	switch t := t.(type) {
+	case *ast.NamedType:
+		p.out.WriteString(t.Name)
+
+	case *ast.FuncType:
+		p.out.WriteString("fn(")
+		for i, param := range t.Params {
+			if i > 0 {
+				p.out.WriteString(", ")
+			}
+			p.typeExpr(param)
+		}
+		p.out.WriteString(")")
+		if t.Result != nil {
+			p.out.WriteString(": ")
+			p.typeExpr(t.Result)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package format
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ei1chi/sample-lang/ast"
+	"github.com/ei1chi/sample-lang/lexer"
+	"github.com/ei1chi/sample-lang/parser"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x=5", "let x = 5;\n"},
+		{"1+2*3", "1 + 2 * 3;\n"},
+		{"(1+2)*3", "(1 + 2) * 3;\n"},
+		{"((1))", "1;\n"},
+		{"1-(2-3)", "1 - (2 - 3);\n"},
+		{"(1-2)-3", "1 - 2 - 3;\n"},
+		{"-(1+2)", "-(1 + 2);\n"},
+		{"!(a==b)", "!(a == b);\n"},
+		{"-f(x)", "-f(x);\n"},
+		{"(a < b) == (c > d)", "a < b == c > d;\n"},
+		{"a == (b == c)", "a == (b == c);\n"},
+		{"return  x+1", "return x + 1;\n"},
+		{"if(x<y){x}else{y}", "if (x < y) {\n\tx;\n} else {\n\ty;\n}\n"},
+		{"if (x) {}", "if (x) {}\n"},
+		{"let f=fn(a,b){return a+b;};f(1,2)", "let f = fn(a, b) {\n\treturn a + b;\n};\nf(1, 2);\n"},
+		{"fn(x){x}(5)", "fn(x) {\n\tx;\n}(5);\n"},
+		{
+			"let f = fn(n) { if (n < 2) { n } else { f(n - 1) + f(n - 2) } };",
+			"let f = fn(n) {\n\tif (n < 2) {\n\t\tn;\n\t} else {\n\t\tf(n - 1) + f(n - 2);\n\t}\n};\n",
+		},
+		{"let a = 1;\n\n\n\nlet b = 2;\nlet c = 3;", "let a = 1;\n\nlet b = 2;\nlet c = 3;\n"},
+		{"let x:int=5", "let x: int = 5;\n"},
+		{"let f=fn(a:int,b):fn(  ):bool{a}", "let f = fn(a: int, b): fn(): bool {\n\ta;\n};\n"},
+		{"try{f()}catch(e){throw e}", "try {\n\tf();\n} catch (e) {\n\tthrow e;\n}\n"},
+		{"let v=f(x)?*2", "let v = f(x)? * 2;\n"},
+		{"import   \"lib/util\"\nexport let x=util.f(\"a\\tb\")", "import \"lib/util\";\nexport let x = util.f(\"a\\tb\");\n"},
+		{"let a=[ 1,2*3 ,[] ]", "let a = [1, 2 * 3, []];\n"},
+		{"(-a)[0] + (a[(1)])", "(-a)[0] + a[1];\n"},
+		{"let m={\"a\" :1,\"b\":{}}", "let m = {\"a\": 1, \"b\": {}};\n"},
+		{"(m).x.y", "m.x.y;\n"},
+		{"(-m).x", "(-m).x;\n"},
+		{"(-x)?", "(-x)?;\n"},
+		{"let x=try{1}finally{}", "let x = try {\n\t1;\n} finally {};\n"},
+		{"// head\nlet x=1 // one\n// two\nx", "// head\nlet x = 1; // one\n// two\nx;\n"},
+		{"if(x){ // open\n1 // last\n// end\n}", "if (x) {\n\t// open\n\t1; // last\n\t// end\n}\n"},
+		{"let f=fn(){\n// todo\n}", "let f = fn() {\n\t// todo\n};\n"},
+		{"f(1, // a\n2)", "f(1, 2); // a\n"},
+		{"// only  \r\n", "// only\n"},
+		{"if(x){a};-y;if(x){a};y", "if (x) {\n\ta;\n};\n-y;\nif (x) {\n\ta;\n}\ny;\n"},
+		{"if(x){a};[1] // c", "if (x) {\n\ta;\n};\n[1]; // c\n"},
+	}
+
+	for _, test := range tests {
+		out, err := Source([]byte(test.input))
+		if err != nil {
+			t.Errorf("%q: Source returned error: %s", test.input, err)
+			continue
+		}
+		if string(out) != test.expected {
+			t.Errorf("%q: wrong output.\nwant=%q\ngot=%q", test.input, test.expected, string(out))
+		}
+	}
+}
+
+func TestSourceIdempotent(t *testing.T) {
+	input := "let add = fn(a, b) {\n\ta + b;\n};\n\nif (add(1, 2) > 2) {\n\treturn -(1 + 2);\n}\n"
+
+	out, err := Source([]byte(input))
+	if err != nil {
+		t.Fatalf("Source returned error: %s", err)
+	}
+	if string(out) != input {
+		t.Errorf("formatted source changed.\nwant=%q\ngot=%q", input, string(out))
+	}
+}
+
+func TestSourceParseError(t *testing.T) {
+	if _, err := Source([]byte("let = 5")); err == nil {
+		t.Errorf("expected parse error")
+	}
+}
+
+// 整形前後で同じ AST になることを確かめる。
+func TestRoundTrip(t *testing.T) {
+	inputs := []string{
+		"let x = 5; let y = x; x + y * 2 - -3;",
+		"(1 + (2 * 3)) / ((4 - 5) * 6) == !true != false",
+		"a + b + c; a + (b + c); a * (b + c); (a + b) * c; a < b == b > c",
+		"- -5; !-a; -(!b); -f(1)(2); (-f)(1)",
+		"if (a) { b } else { if (c) { d } }; if (x < y) { return x; 1 }",
+		"let f = fn() {}; let g = fn(a, b, c) { let d = a; fn(e) { d + e } }; g(1, 2, 3)(4)",
+		"fn(x) { x }(5); if (true) { f } else { g }(1)",
+		"let fib = fn(n) { if (n < 2) { return n; } fib(n - 1) + fib(n - 2) }; fib(10);",
+		"let x: int = 1; let f = fn(g: fn(int, bool): int, b): bool { g(1, b) == x }",
+		"import \"lib/util\"; export let s = \"a\\\"b\"; util.f(s)?.x; (-util).y",
+		"[1, [2, -3]][a + 1][0]; (-a)[f(x)]; m.divmod(7, 2)[1]",
+		"{\"a\": [1], k: {}}[\"a\"][0]; {}",
+		"let y = 1; if (y) { 2 }; -y",
+		"if (x) { a }; [1, 2];",
+		"try { a } finally { b }; (c); if (x) { try { a } catch (e) { b }; (-c)[0] }",
+	}
+
+	for _, input := range inputs {
+		out, err := Source([]byte(input))
+		if err != nil {
+			t.Errorf("%q: Source returned error: %s", input, err)
+			continue
+		}
+
+		expected := dump(t, input)
+		got := dump(t, string(out))
+		if expected != got {
+			t.Errorf("%q: AST changed after formatting to %q.\nwant=%s\ngot=%s", input, out, expected, got)
+		}
+	}
+}
+
+// dump 位置と括弧の有無によらない AST の表現。
+func dump(t *testing.T, src string) string {
+	l := lexer.NewLexer(src)
+	p := parser.NewParser(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", src, p.Errors())
+	}
+
+	var out strings.Builder
+	ast.Inspect(program, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case nil:
+			out.WriteString(")")
+		case *ast.ExprStmt, *ast.Program, *ast.BlockStmt:
+			// 先頭のトークンは括弧で変わるので型だけ見る
+			fmt.Fprintf(&out, "(%T", n)
+		default:
+			fmt.Fprintf(&out, "(%T %q", n, n.TokenLiteral())
+		}
+		return true
+	})
+	return out.String()
+}
@@ -0,0 +1,107 @@
+package interp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ei1chi/sample-lang/eval"
+	"github.com/ei1chi/sample-lang/object"
+)
+
+// Capability 組み込み関数が呼び出しに必要とする権限。ホストが独自に定義してもよい。
+type Capability string
+
+const (
+	CAP_FILE Capability = "file"
+	CAP_TIME Capability = "time"
+	CAP_ENV  Capability = "env"
+)
+
+// AuditEvent 権限の必要な組み込み関数が呼ばれたときに Audit に渡される。
+type AuditEvent struct {
+	Func    string
+	Caps    []Capability
+	Args    []object.Object
+	Allowed bool
+}
+
+// Grant 権限を許可する。新しい Interpreter は何も許可していない。
+func (i *Interpreter) Grant(caps ...Capability) {
+	for _, c := range caps {
+		i.caps[c] = true
+	}
+}
+
+// Revoke 許可した権限を取り消す。
+func (i *Interpreter) Revoke(caps ...Capability) {
+	for _, c := range caps {
+		delete(i.caps, c)
+	}
+}
+
+func (i *Interpreter) Allowed(c Capability) bool {
+	return i.caps[c]
+}
+
+// importer 権限の要る組み込みのモジュールを、権限を確かめてから返す。
+// import "time" と time.now は時計を読むので CAP_TIME が要る。time のほかの関数は時計を読まないが、
+// import できなければ使えない。
+func (i *Interpreter) importer() func(path string) object.Object {
+	var timeModule *object.Module
+	return func(path string) object.Object {
+		if path != "time" {
+			return nil
+		}
+		if err := i.check(`import "time"`, []Capability{CAP_TIME}, nil); err != nil {
+			return err
+		}
+		if timeModule == nil {
+			timeModule = eval.NewTimeModule(i.Clock)
+			i.guard("time.now", []Capability{CAP_TIME}, timeModule.Exports["now"].(*object.Builtin))
+		}
+		return timeModule
+	}
+}
+
+// guard 呼び出しのたびに権限を確認し、監査フックに通知するよう組み込み関数 b を包む。
+func (i *Interpreter) guard(name string, caps []Capability, b *object.Builtin) {
+	fn, callFn := b.Fn, b.CallFn
+	b.Fn = func(args ...object.Object) object.Object {
+		if err := i.check(name, caps, args); err != nil {
+			return err
+		}
+		return fn(args...)
+	}
+	if callFn != nil {
+		b.CallFn = func(call object.Caller, args ...object.Object) object.Object {
+			if err := i.check(name, caps, args); err != nil {
+				return err
+			}
+			return callFn(call, args...)
+		}
+	}
+}
+
+// check caps がすべて許可されているか確かめ、監査フックに通知する。
+func (i *Interpreter) check(name string, caps []Capability, args []object.Object) *object.Error {
+	var missing []string
+	for _, c := range caps {
+		if !i.Allowed(c) {
+			missing = append(missing, string(c))
+		}
+	}
+	sort.Strings(missing)
+
+	if i.Audit != nil {
+		i.Audit(AuditEvent{Func: name, Caps: caps, Args: args, Allowed: len(missing) == 0})
+	}
+
+	if len(missing) != 0 {
+		return &object.Error{
+			Kind:    object.PERMISSION_DENIED,
+			Message: fmt.Sprintf("permission denied: %s requires capability %s", name, strings.Join(missing, ", ")),
+		}
+	}
+	return nil
+}
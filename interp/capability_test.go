@@ -0,0 +1,118 @@
+package interp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ei1chi/sample-lang/object"
+)
+
+func TestCapabilities(t *testing.T) {
+	i := New()
+
+	var events []AuditEvent
+	i.Audit = func(ev AuditEvent) { events = append(events, ev) }
+
+	i.RegisterFunc("getenv", func(name string) int { return 1 }, CAP_ENV)
+	i.RegisterFunc("now", func() int64 { return 100 }, CAP_TIME)
+	i.RegisterFunc("readAt", func() int64 { return 0 }, CAP_FILE, CAP_TIME)
+	i.RegisterFunc("pure", func(n int64) int64 { return n })
+
+	evaled := testEval(t, i, "now()")
+	errObj, ok := evaled.(*object.Error)
+	if !ok || errObj.Kind != object.PERMISSION_DENIED {
+		t.Fatalf("expected permission denied. got=%s", evaled.Inspect())
+	}
+	if errObj.Message != "permission denied: now requires capability time" {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+
+	i.Grant(CAP_TIME)
+	if evaled := testEval(t, i, "now()"); evaled.Inspect() != "100" {
+		t.Errorf("expected=100, got=%s", evaled.Inspect())
+	}
+
+	evaled = testEval(t, i, "readAt()")
+	if evaled.Inspect() != "ERROR: permission denied: readAt requires capability file" {
+		t.Errorf("wrong result. got=%s", evaled.Inspect())
+	}
+
+	i.Revoke(CAP_TIME)
+	if evaled := testEval(t, i, "now()"); !isPermissionDenied(evaled) {
+		t.Errorf("expected permission denied after Revoke. got=%s", evaled.Inspect())
+	}
+
+	// 権限の要らない関数は監査されない
+	if evaled := testEval(t, i, "pure(3)"); evaled.Inspect() != "3" {
+		t.Errorf("expected=3, got=%s", evaled.Inspect())
+	}
+
+	expected := []struct {
+		fn      string
+		allowed bool
+	}{
+		{"now", false},
+		{"now", true},
+		{"readAt", false},
+		{"now", false},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("wrong number of audit events. expected=%d, got=%d", len(expected), len(events))
+	}
+	for n, ev := range expected {
+		if events[n].Func != ev.fn || events[n].Allowed != ev.allowed {
+			t.Errorf("events[%d] wrong. expected=%+v, got=%+v", n, ev, events[n])
+		}
+	}
+}
+
+func TestTimeCapability(t *testing.T) {
+	i := New()
+	i.Clock = func() time.Time { return time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC) }
+
+	var events []AuditEvent
+	i.Audit = func(ev AuditEvent) { events = append(events, ev) }
+
+	// 何も許可していなければ時計は読めない
+	evaled := testEval(t, i, `import "time"; time.now()`)
+	if evaled.Inspect() != `ERROR: permission denied: import "time" requires capability time` {
+		t.Errorf("wrong result. got=%s", evaled.Inspect())
+	}
+
+	i.Grant(CAP_TIME)
+	if evaled := testEval(t, i, `import "time"; time.year(time.now())`); evaled.Inspect() != "2024" {
+		t.Errorf("expected=2024, got=%s", evaled.Inspect())
+	}
+
+	// import したモジュールが残っていても、取り消せば now は呼べない
+	i.Revoke(CAP_TIME)
+	if evaled := testEval(t, i, "time.now()"); !isPermissionDenied(evaled) {
+		t.Errorf("expected permission denied after Revoke. got=%s", evaled.Inspect())
+	}
+	if evaled := testEval(t, i, "time.year(time.from_unix(0))"); evaled.Inspect() != "1970" {
+		t.Errorf("expected=1970, got=%s", evaled.Inspect())
+	}
+
+	expected := []struct {
+		fn      string
+		allowed bool
+	}{
+		{`import "time"`, false},
+		{`import "time"`, true},
+		{"time.now", true},
+		{"time.now", false},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("wrong number of audit events. expected=%d, got=%d", len(expected), len(events))
+	}
+	for n, ev := range expected {
+		if events[n].Func != ev.fn || events[n].Allowed != ev.allowed {
+			t.Errorf("events[%d] wrong. expected=%+v, got=%+v", n, ev, events[n])
+		}
+	}
+}
+
+func isPermissionDenied(obj object.Object) bool {
+	errObj, ok := obj.(*object.Error)
+	return ok && errObj.Kind == object.PERMISSION_DENIED
+}
@@ -0,0 +1,99 @@
+package interp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ei1chi/sample-lang/eval"
+	"github.com/ei1chi/sample-lang/lexer"
+	"github.com/ei1chi/sample-lang/object"
+	"github.com/ei1chi/sample-lang/parser"
+)
+
+// Interpreter ホストのプログラムから言語を埋め込んで使うための入口。
+// 登録した組み込み関数と let で束縛した値は Eval をまたいで保持される。
+type Interpreter struct {
+	// Limits Eval のたびに適用する実行制限。
+	Limits Limits
+
+	// Loader import するモジュールを探す。nil ならファイルのモジュールは import できない。
+	Loader *eval.Loader
+
+	// Hook 文を評価する直前に呼ばれる。
+	Hook eval.Hook
+
+	// Clock time.now が返す今の時刻。nil なら time.Now。CAP_TIME を許可していなければ呼ばれない。
+	Clock func() time.Time
+
+	// Audit 権限の必要な組み込み関数が呼ばれるたびに、許可されたかどうかとともに呼ばれる。
+	Audit func(AuditEvent)
+
+	env  *object.Environment
+	caps map[Capability]bool
+}
+
+// Limits 実行制限。ゼロの項目は制限しない。ただし MaxDepth は eval.DefaultMaxDepth になる。
+type Limits struct {
+	MaxSteps int   // 評価するノード数の上限
+	MaxDepth int   // 関数呼び出しの深さの上限
+	MaxAlloc int64 // 割り当てるオブジェクトの推定バイト数の上限
+}
+
+func New() *Interpreter {
+	return &Interpreter{
+		env:  object.NewEnvironment(),
+		caps: make(map[Capability]bool),
+	}
+}
+
+// RegisterFunc Go の関数 fn を name という名前の組み込み関数として登録する。
+// 引数と返り値の変換は object.NewGoFunc に従う。
+// caps を指定すると、呼び出し時にそれらがすべて許可されていなければエラーになる。
+func (i *Interpreter) RegisterFunc(name string, fn any, caps ...Capability) error {
+	builtin, err := object.NewGoFunc(fn)
+	if err != nil {
+		return fmt.Errorf("register %s: %w", name, err)
+	}
+	if len(caps) != 0 {
+		i.guard(name, caps, builtin)
+	}
+	i.env.Set(name, builtin)
+	return nil
+}
+
+// Eval src を解析して評価する。構文エラーは error として返し、実行時エラーは object.Error として返す。
+func (i *Interpreter) Eval(src string) (object.Object, error) {
+	return i.eval(i.config(nil), src)
+}
+
+// EvalContext ctx がキャンセルされたら評価を中断する Eval。
+func (i *Interpreter) EvalContext(ctx context.Context, src string) (object.Object, error) {
+	return i.eval(i.config(ctx), src)
+}
+
+func (i *Interpreter) config(ctx context.Context) eval.Config {
+	return eval.Config{
+		MaxSteps: i.Limits.MaxSteps,
+		MaxDepth: i.Limits.MaxDepth,
+		MaxAlloc: i.Limits.MaxAlloc,
+		Context:  ctx,
+		Hook:     i.Hook,
+		Loader:   i.Loader,
+		Importer: i.importer(),
+	}
+}
+
+func (i *Interpreter) eval(cfg eval.Config, src string) (object.Object, error) {
+	l := lexer.NewLexer(src)
+	p := parser.NewParser(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, errors.New(strings.Join(p.Errors(), "\n"))
+	}
+
+	return eval.New(cfg).Eval(program, i.env), nil
+}
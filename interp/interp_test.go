@@ -0,0 +1,211 @@
+package interp
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/ei1chi/sample-lang/object"
+)
+
+func testEval(t *testing.T, i *Interpreter, input string) object.Object {
+	obj, err := i.Eval(input)
+	if err != nil {
+		t.Fatalf("Eval(%q) returned error: %s", input, err)
+	}
+	return obj
+}
+
+func TestRegisterFunc(t *testing.T) {
+	i := New()
+
+	funcs := map[string]any{
+		"add":  func(a, b int64) int64 { return a + b },
+		"even": func(n int) bool { return n%2 == 0 },
+		"sum": func(ns ...int) int {
+			total := 0
+			for _, n := range ns {
+				total += n
+			}
+			return total
+		},
+		"nothing": func() {},
+		"check": func(n int64, strict bool) (bool, error) {
+			if strict && n < 0 {
+				return false, errors.New("negative number")
+			}
+			return n > 10, nil
+		},
+	}
+	for name, fn := range funcs {
+		if err := i.RegisterFunc(name, fn); err != nil {
+			t.Fatalf("RegisterFunc(%q) returned error: %s", name, err)
+		}
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"add(1, 2)", "3"},
+		{"add(add(1, 2), 3) * 2", "12"},
+		{"even(4)", "true"},
+		{"sum()", "0"},
+		{"sum(1, 2, 3)", "6"},
+		{"nothing()", "null"},
+		{"check(11, true)", "true"},
+		{"let f = fn(x) { add(x, 1) }; f(41)", "42"},
+		{"check(-1, true)", "ERROR: negative number"},
+		{"add(1)", "ERROR: wrong number of arguments. got=1, want=2"},
+		{"add(1, true)", "ERROR: argument 2: cannot convert BOOLEAN to int64"},
+		{"check(1)", "ERROR: wrong number of arguments. got=1, want=2"},
+	}
+
+	for _, test := range tests {
+		evaled := testEval(t, i, test.input)
+		if evaled.Inspect() != test.expected {
+			t.Errorf("%q: expected=%q, got=%q", test.input, test.expected, evaled.Inspect())
+		}
+	}
+}
+
+func TestRegisterFuncConversion(t *testing.T) {
+	type point struct {
+		X int64 `sl:"x"`
+		Y int64 `sl:"y"`
+	}
+
+	i := New()
+	funcs := map[string]any{
+		"f": func(a int64, s string) (bool, error) {
+			if a < 0 {
+				return false, errors.New("negative")
+			}
+			return int64(len(s)) == a, nil
+		},
+		"total": func(xs []int64) int64 {
+			var sum int64
+			for _, x := range xs {
+				sum += x
+			}
+			return sum
+		},
+		"keys": func(m map[string]int64) []string {
+			keys := make([]string, 0, len(m))
+			for k := range m {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			return keys
+		},
+		"move": func(p point, dx int64) point { return point{p.X + dx, p.Y} },
+		"half": func(x float64) float64 { return x / 2 },
+		"apply": func(xs []int64, f func(int64) int64) []int64 {
+			out := make([]int64, len(xs))
+			for i, x := range xs {
+				out[i] = f(x)
+			}
+			return out
+		},
+		"try_apply": func(f func() (string, error)) string {
+			s, err := f()
+			if err != nil {
+				return "failed: " + err.Error()
+			}
+			return s
+		},
+	}
+	for name, fn := range funcs {
+		if err := i.RegisterFunc(name, fn); err != nil {
+			t.Fatalf("RegisterFunc(%q) returned error: %s", name, err)
+		}
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`f(2, "ab")`, "true"},
+		{`f(3, "ab")`, "false"},
+		{`f(-1, "ab")`, "ERROR: negative"},
+		{`f("ab", 2)`, "ERROR: argument 1: cannot convert STRING to int64"},
+		{`total([1, 2, 3])`, "6"},
+		{`total([])`, "0"},
+		{`total([1, true])`, "ERROR: argument 1: index 1: cannot convert BOOLEAN to int64"},
+		{`keys({"b": 1, "a": 2})`, "[a, b]"},
+		{`move({"x": 1, "y": 2}, 3)`, "{x: 4, y: 2}"},
+		{`half(3)`, "1.5"},
+		{`apply([1, 2], fn(x) { x * 10 })`, "[10, 20]"},
+		{`apply([1], half)`, "ERROR: result: cannot convert FLOAT to int64"},
+		{`apply([1, 2], fn(x) { x / 0 })`, "ERROR: division by zero: 1 / 0"},
+		{`try_apply(fn() { "ok" })`, "ok"},
+		{`try_apply(fn() { throw "boom" })`, "failed: boom"},
+	}
+
+	for _, test := range tests {
+		evaled := testEval(t, i, test.input)
+		if evaled.Inspect() != test.expected {
+			t.Errorf("%q: expected=%q, got=%q", test.input, test.expected, evaled.Inspect())
+		}
+	}
+
+	// 関数に渡した言語の関数も実行制限を受ける
+	i.Limits.MaxSteps = 200
+	evaled := testEval(t, i, "let loop = fn(x) { loop(x) }; apply([1], loop)")
+	if errObj, ok := evaled.(*object.Error); !ok || errObj.Kind != object.STEP_LIMIT_EXCEEDED {
+		t.Errorf("expected step limit error. got=%s", evaled.Inspect())
+	}
+}
+
+func TestRegisterFuncErrors(t *testing.T) {
+	tests := []any{
+		42,
+		func() (int, int) { return 0, 0 },
+		func() (int, bool, error) { return 0, false, nil },
+	}
+
+	for _, test := range tests {
+		if err := New().RegisterFunc("f", test); err == nil {
+			t.Errorf("RegisterFunc(%T) expected error", test)
+		}
+	}
+}
+
+func TestEnvironmentPersists(t *testing.T) {
+	i := New()
+
+	testEval(t, i, "let x = 5;")
+	if evaled := testEval(t, i, "x * 2"); evaled.Inspect() != "10" {
+		t.Errorf("expected=10, got=%s", evaled.Inspect())
+	}
+
+	if _, err := i.Eval("let = 5;"); err == nil {
+		t.Errorf("expected parse error")
+	}
+}
+
+func TestLimits(t *testing.T) {
+	i := New()
+	i.Limits.MaxSteps = 500
+
+	evaled := testEval(t, i, "let loop = fn() { loop() }; loop()")
+	if errObj, ok := evaled.(*object.Error); !ok || errObj.Kind != object.STEP_LIMIT_EXCEEDED {
+		t.Errorf("expected step limit error. got=%s", evaled.Inspect())
+	}
+
+	// カウンタは Eval ごとにリセットされる
+	if evaled := testEval(t, i, "1 + 1"); evaled.Inspect() != "2" {
+		t.Errorf("expected=2, got=%s", evaled.Inspect())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	evaled, err := i.EvalContext(ctx, "1 + 1")
+	if err != nil {
+		t.Fatalf("EvalContext returned error: %s", err)
+	}
+	if errObj, ok := evaled.(*object.Error); !ok || errObj.Kind != object.CONTEXT_CANCELED {
+		t.Errorf("expected context canceled error. got=%s", evaled.Inspect())
+	}
+}
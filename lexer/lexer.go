@@ -12,15 +12,35 @@ type Lexer struct {
 	pos     int  // 現在の位置
 	readPos int  // 現在の文字の次
 	ch      rune // 現在検査中の文字
+	line    int  // ch の行
+	col     int  // ch の列
+
+	lossless bool // トリビアを集めるか
+	start    int  // 読んでいるトークンの先頭
 }
 
 func NewLexer(input string) *Lexer {
-	l := &Lexer{input: input}
+	l := &Lexer{input: input, line: 1}
 	l.readChar()
 	return l
 }
 
+// NewLosslessLexer トークンの前後の空白とコメントをトリビアとして Leading と Trailing に残す。
+// 全トークンの Leading + Literal + Trailing を繋げると入力と一致する。
+func NewLosslessLexer(input string) *Lexer {
+	l := NewLexer(input)
+	l.lossless = true
+	return l
+}
+
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.col = 1
+	} else {
+		l.col++
+	}
+
 	r, size := utf8.DecodeRuneInString(l.input[l.readPos:])
 	if size == 0 {
 		l.ch = 0
@@ -43,9 +63,36 @@ func (l *Lexer) peekChar() rune {
 }
 
 func (l *Lexer) NextToken() token.Token {
+	if !l.lossless {
+		return l.next()
+	}
+
+	begin := l.pos
+	tok := l.next()
+	tok.Leading = l.input[begin:l.start]
+
+	begin = l.pos
+	for l.ch == ' ' || l.ch == '\t' || l.ch == '\r' {
+		l.readChar()
+	}
+	if l.atComment() {
+		l.skipComment()
+	}
+	if l.ch == '\n' {
+		l.readChar()
+	}
+	tok.Trailing = l.input[begin:l.pos]
+
+	return tok
+}
+
+func (l *Lexer) next() token.Token {
 	var tok token.Token
 
 	l.skipWhitespace()
+	l.start = l.pos
+
+	pos := token.Position{Line: l.line, Column: l.col}
 
 	switch l.ch {
 	case '=':
@@ -72,6 +119,8 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.ASTERISK, l.ch)
 	case '/':
 		tok = newToken(token.SLASH, l.ch)
+	case '?':
+		tok = newToken(token.QUESTION, l.ch)
 	case '<':
 		tok = newToken(token.LT, l.ch)
 	case '>':
@@ -84,36 +133,84 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.RPAREN, l.ch)
 	case ',':
 		tok = newToken(token.COMMA, l.ch)
+	case ':':
+		tok = newToken(token.COLON, l.ch)
+	case '.':
+		tok = newToken(token.DOT, l.ch)
+	case '"':
+		var ok bool
+		tok.Type = token.STRING
+		tok.Literal, ok = l.readString()
+		if !ok {
+			tok.Type = token.ILLEGAL
+		}
+		tok.Pos = pos
+		return tok
 	case '{':
 		tok = newToken(token.LBRACE, l.ch)
 	case '}':
 		tok = newToken(token.RBRACE, l.ch)
+	case '[':
+		tok = newToken(token.LBRACKET, l.ch)
+	case ']':
+		tok = newToken(token.RBRACKET, l.ch)
 	case 0:
+		if l.pos < len(l.input) {
+			tok = l.illegalToken()
+			break
+		}
 		tok.Literal = ""
 		tok.Type = token.EOF
 	default:
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdent()
 			tok.Type = token.LookupIdent(tok.Literal)
+			tok.Pos = pos
 			return tok
 		} else if isDigit(l.ch) {
 			tok.Type = token.INT
 			tok.Literal = l.readNumber()
+			tok.Pos = pos
 			return tok
 		}
-		tok = newToken(token.ILLEGAL, l.ch)
+		tok = l.illegalToken()
 	}
 
 	l.readChar()
+	tok.Pos = pos
 	return tok
 }
 
+// skipWhitespace 空白とコメントを読み飛ばす。
 func (l *Lexer) skipWhitespace() {
-	for unicode.IsSpace(l.ch) {
+	for {
+		for unicode.IsSpace(l.ch) {
+			l.readChar()
+		}
+		if !l.atComment() {
+			return
+		}
+		l.skipComment()
+	}
+}
+
+// atComment // から行末まではコメント。
+func (l *Lexer) atComment() bool {
+	return l.ch == '/' && l.peekChar() == '/'
+}
+
+// skipComment 改行の手前まで読む。改行は読まない。
+func (l *Lexer) skipComment() {
+	for l.ch != '\n' && !l.atEOF() {
 		l.readChar()
 	}
 }
 
+// illegalToken 不正な UTF-8 でも元のバイト列を残す。
+func (l *Lexer) illegalToken() token.Token {
+	return token.Token{Type: token.ILLEGAL, Literal: l.input[l.pos:l.readPos]}
+}
+
 func newToken(tokenType token.TokenType, ch rune) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch)}
 }
@@ -130,6 +227,28 @@ func isLetter(ch rune) bool {
 	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
 }
 
+// readString 閉じる " までを引用符ごと読む。エスケープはそのまま残し、構文解析器が解釈する。
+// 閉じる前に改行か入力の終わりが来たら、そこまでを返して ok を false にする。
+func (l *Lexer) readString() (lit string, ok bool) {
+	begin := l.pos
+	l.readChar()
+	for l.ch != '"' {
+		if l.ch == '\\' {
+			l.readChar()
+		}
+		if l.ch == '\n' || l.atEOF() {
+			return l.input[begin:l.pos], false
+		}
+		l.readChar()
+	}
+	l.readChar()
+	return l.input[begin:l.pos], true
+}
+
+func (l *Lexer) atEOF() bool {
+	return l.pos >= len(l.input)
+}
+
 func (l *Lexer) readNumber() string {
 	begin := l.pos
 	for isDigit(l.ch) {
@@ -7,7 +7,7 @@ import (
 )
 
 func TestNextToken(t *testing.T) {
-	input := `let five = 5; !-/*; if a return true else false; 10 == 10; 10 != 9;`
+	input := `let five = 5; !-/*; if a return true else false; 10 == 10; 10 != 9; x?; [1];`
 
 	tests := []struct {
 		expectedType    token.TokenType
@@ -38,6 +38,13 @@ func TestNextToken(t *testing.T) {
 		{token.NOT_EQ, "!="},
 		{token.INT, "9"},
 		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.QUESTION, "?"},
+		{token.SEMICOLON, ";"},
+		{token.LBRACKET, "["},
+		{token.INT, "1"},
+		{token.RBRACKET, "]"},
+		{token.SEMICOLON, ";"},
 	}
 
 	l := NewLexer(input)
@@ -52,3 +59,176 @@ func TestNextToken(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 10;\n  if (x) {\n\tx ==\n5 }"
+
+	tests := []struct {
+		expectedLiteral string
+		expectedLine    int
+		expectedColumn  int
+	}{
+		{"let", 1, 1},
+		{"x", 1, 5},
+		{"=", 1, 7},
+		{"10", 1, 9},
+		{";", 1, 11},
+		{"if", 2, 3},
+		{"(", 2, 6},
+		{"x", 2, 7},
+		{")", 2, 8},
+		{"{", 2, 10},
+		{"x", 3, 2},
+		{"==", 3, 4},
+		{"5", 4, 1},
+		{"}", 4, 3},
+		{"", 4, 4},
+	}
+
+	l := NewLexer(input)
+	for i, test := range tests {
+		tok := l.NextToken()
+
+		if tok.Literal != test.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong, expected=%q, got=%q", i, test.expectedLiteral, tok.Literal)
+		}
+		if tok.Pos.Line != test.expectedLine || tok.Pos.Column != test.expectedColumn {
+			t.Fatalf("tests[%d] - position wrong, expected=%d:%d, got=%s", i, test.expectedLine, test.expectedColumn, tok.Pos)
+		}
+	}
+}
+
+func TestLosslessTrivia(t *testing.T) {
+	input := "  let x =\t1;  \n\n\tx\r\n"
+
+	tests := []struct {
+		expectedLeading  string
+		expectedLiteral  string
+		expectedTrailing string
+	}{
+		{"  ", "let", " "},
+		{"", "x", " "},
+		{"", "=", "\t"},
+		{"", "1", ""},
+		{"", ";", "  \n"},
+		{"\n\t", "x", "\r\n"},
+		{"", "", ""},
+	}
+
+	l := NewLosslessLexer(input)
+	var out string
+	for i, test := range tests {
+		tok := l.NextToken()
+
+		if tok.Leading != test.expectedLeading || tok.Literal != test.expectedLiteral || tok.Trailing != test.expectedTrailing {
+			t.Fatalf("tests[%d] - trivia wrong, expected=%q %q %q, got=%q %q %q", i,
+				test.expectedLeading, test.expectedLiteral, test.expectedTrailing,
+				tok.Leading, tok.Literal, tok.Trailing)
+		}
+		out += tok.Leading + tok.Literal + tok.Trailing
+	}
+
+	if out != input {
+		t.Errorf("tokens do not reproduce input. got=%q", out)
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := "// head\nlet x = 1; // one\n  // two\nx / 2 //\n// tail"
+
+	l := NewLexer(input)
+	for _, expected := range []string{"let", "x", "=", "1", ";", "x", "/", "2", ""} {
+		tok := l.NextToken()
+		if tok.Literal != expected {
+			t.Fatalf("literal wrong, expected=%q, got=%q", expected, tok.Literal)
+		}
+	}
+
+	// Lossless モードではコメントもトリビアに残る
+	tests := []struct {
+		expectedLeading  string
+		expectedLiteral  string
+		expectedTrailing string
+	}{
+		{"// head\n", "let", " "},
+		{"", "x", " "},
+		{"", "=", " "},
+		{"", "1", ""},
+		{"", ";", " // one\n"},
+		{"  // two\n", "x", " "},
+		{"", "/", " "},
+		{"", "2", " //\n"},
+		{"// tail", "", ""},
+	}
+
+	l = NewLosslessLexer(input)
+	var out string
+	for i, test := range tests {
+		tok := l.NextToken()
+
+		if tok.Leading != test.expectedLeading || tok.Literal != test.expectedLiteral || tok.Trailing != test.expectedTrailing {
+			t.Fatalf("tests[%d] - trivia wrong, expected=%q %q %q, got=%q %q %q", i,
+				test.expectedLeading, test.expectedLiteral, test.expectedTrailing,
+				tok.Leading, tok.Literal, tok.Trailing)
+		}
+		out += tok.Leading + tok.Literal + tok.Trailing
+	}
+
+	if out != input {
+		t.Errorf("tokens do not reproduce input. got=%q", out)
+	}
+}
+
+func TestIllegalBytesPreserved(t *testing.T) {
+	input := "1\x00\xff2"
+
+	l := NewLexer(input)
+	for _, expected := range []string{"1", "\x00", "\xff", "2", ""} {
+		tok := l.NextToken()
+		if tok.Literal != expected {
+			t.Fatalf("literal wrong, expected=%q, got=%q", expected, tok.Literal)
+		}
+	}
+}
+
+func TestStrings(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{`"hello"`, token.STRING, `"hello"`},
+		{`""`, token.STRING, `""`},
+		{`"a\"b\\"`, token.STRING, `"a\"b\\"`},
+		{`"日本語"`, token.STRING, `"日本語"`},
+		{`"open`, token.ILLEGAL, `"open`},
+		{"\"line\nbreak\"", token.ILLEGAL, `"line`},
+		{`"escaped end\"`, token.ILLEGAL, `"escaped end\"`},
+	}
+
+	for _, test := range tests {
+		tok := NewLexer(test.input).NextToken()
+
+		if tok.Type != test.expectedType || tok.Literal != test.expectedLiteral {
+			t.Errorf("%q: expected=%s %q, got=%s %q", test.input, test.expectedType, test.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestImportTokens(t *testing.T) {
+	input := `import "lib/util"; export let x = util.f;`
+
+	expected := []token.TokenType{
+		token.IMPORT, token.STRING, token.SEMICOLON,
+		token.EXPORT, token.LET, token.IDENT, token.ASSIGN, token.IDENT, token.DOT, token.IDENT, token.SEMICOLON,
+		token.EOF,
+	}
+
+	l := NewLexer(input)
+	for i, typ := range expected {
+		tok := l.NextToken()
+		if tok.Type != typ {
+			t.Fatalf("tokens[%d] - tokentype wrong, expected=%q, got=%q", i, typ, tok.Type)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ei1chi/sample-lang/lexer"
+	"github.com/ei1chi/sample-lang/lint"
+	"github.com/ei1chi/sample-lang/parser"
+)
+
+// runLint sample-lang lint [-enable rules] [-disable rules] [files...]
+// 指摘を file:line:col の形で標準出力に書く。指摘があれば終了コードは 1。
+func runLint(args []string) int {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	enable := flags.String("enable", "", "comma-separated rules to run (default: all)")
+	disable := flags.String("disable", "", "comma-separated rules to skip")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: sample-lang lint [-enable rules] [-disable rules] [files...]\n")
+		flags.PrintDefaults()
+		fmt.Fprintf(flags.Output(), "\nrules:\n")
+		for _, r := range lint.Rules {
+			fmt.Fprintf(flags.Output(), "  %-14s %s\n", r.Name, r.Doc)
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	rules, err := lintRules(*enable, *disable)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lint: %s\n", err)
+		return 2
+	}
+
+	if flags.NArg() == 0 {
+		src, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "lint: %s\n", err)
+			return 1
+		}
+		return lintSource("<stdin>", src, rules)
+	}
+
+	status := 0
+	for _, path := range flags.Args() {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "lint: %s\n", err)
+			status = 1
+			continue
+		}
+		if s := lintSource(path, src, rules); s != 0 {
+			status = s
+		}
+	}
+	return status
+}
+
+// lintRules -enable と -disable から使う規則を決める。
+func lintRules(enable, disable string) ([]*lint.Rule, error) {
+	rules := lint.Rules
+	if enable != "" {
+		rules = nil
+		for _, name := range strings.Split(enable, ",") {
+			r := lint.Lookup(strings.TrimSpace(name))
+			if r == nil {
+				return nil, fmt.Errorf("unknown rule %q", name)
+			}
+			rules = append(rules, r)
+		}
+	}
+
+	skip := map[*lint.Rule]bool{}
+	if disable != "" {
+		for _, name := range strings.Split(disable, ",") {
+			r := lint.Lookup(strings.TrimSpace(name))
+			if r == nil {
+				return nil, fmt.Errorf("unknown rule %q", name)
+			}
+			skip[r] = true
+		}
+	}
+
+	var result []*lint.Rule
+	for _, r := range rules {
+		if !skip[r] {
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
+func lintSource(path string, src []byte, rules []*lint.Rule) int {
+	p := parser.NewParser(lexer.NewLexer(string(src)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		for _, msg := range p.Errors() {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, msg)
+		}
+		return 1
+	}
+
+	diags := lint.Check(program, rules)
+	for _, d := range diags {
+		fmt.Printf("%s:%s\n", path, d)
+	}
+	if len(diags) != 0 {
+		return 1
+	}
+	return 0
+}
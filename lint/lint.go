@@ -0,0 +1,84 @@
+// Package lint は AST を調べて、誤りの可能性が高い書き方を指摘する。
+package lint
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ei1chi/sample-lang/ast"
+	"github.com/ei1chi/sample-lang/token"
+)
+
+// Diagnostic 指摘一つ。
+type Diagnostic struct {
+	Pos     token.Position
+	Rule    string
+	Message string
+}
+
+// String "line:col: message (rule)" の形。ファイル名は呼び出し側で前につける。
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s (%s)", d.Pos, d.Message, d.Rule)
+}
+
+// Rule 検査の規則。Name で個別に有効・無効を切り替える。
+type Rule struct {
+	Name string
+	Doc  string
+	run  func(p *pass)
+}
+
+// Rules すべての規則。
+var Rules = []*Rule{
+	UnusedLet,
+	UnusedImport,
+	UnusedParam,
+	Shadow,
+	Unreachable,
+	ConstantCond,
+	SelfCompare,
+}
+
+// Lookup 名前から規則を探す。なければ nil。
+func Lookup(name string) *Rule {
+	for _, r := range Rules {
+		if r.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// Check program に rules を適用し、位置の順に並べた指摘を返す。
+func Check(program *ast.Program, rules []*Rule) []Diagnostic {
+	var diags []Diagnostic
+
+	for _, r := range rules {
+		p := &pass{program: program, rule: r, diags: &diags}
+		r.run(p)
+	}
+
+	sort.SliceStable(diags, func(i, j int) bool {
+		a, b := diags[i].Pos, diags[j].Pos
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return diags
+}
+
+// pass 一つの規則を一つのプログラムに適用する間の状態。
+type pass struct {
+	program *ast.Program
+	rule    *Rule
+	diags   *[]Diagnostic
+}
+
+func (p *pass) report(pos token.Position, format string, a ...interface{}) {
+	*p.diags = append(*p.diags, Diagnostic{
+		Pos:     pos,
+		Rule:    p.rule.Name,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
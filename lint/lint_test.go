@@ -0,0 +1,99 @@
+package lint
+
+import (
+	"testing"
+
+	"github.com/ei1chi/sample-lang/ast"
+	"github.com/ei1chi/sample-lang/lexer"
+	"github.com/ei1chi/sample-lang/parser"
+)
+
+func TestRules(t *testing.T) {
+	tests := []struct {
+		rule     *Rule
+		input    string
+		expected []string
+	}{
+		{UnusedLet, "let x = 1; let y = 2; y", []string{"1:5: x declared and not used (unused-let)"}},
+		{UnusedLet, "let f = fn() { let a = 1; 2 }; f()", []string{"1:20: a declared and not used (unused-let)"}},
+		{UnusedLet, "let x = 1; let x = x + 1; x", nil},
+		{UnusedLet, "let x = 1; let f = fn() { x }; f()", nil},
+		{UnusedLet, "if (true) { let x = 1; }; x", nil},
+		{UnusedLet, "export let x = 1;", nil},
+		{UnusedLet, "let e = 1; try { 1 } catch (e) { e }", []string{"1:5: e declared and not used (unused-let)"}},
+		{UnusedLet, "try { 1 } catch (e) { let x = 1; }", []string{"1:27: x declared and not used (unused-let)"}},
+		{UnusedImport, "import \"lib/strs\"; import \"util\"; util.f()", []string{"1:8: strs imported and not used (unused-import)"}},
+		{UnusedImport, "import \"util\"; let f = fn() { util.x }; f()", nil},
+		{UnusedParam, "let f = fn(a, b) { a }; f(1, 2)", []string{"1:15: parameter b is never used (unused-param)"}},
+		{UnusedParam, "let f = fn(a) { fn() { a } }; f(1)", nil},
+		{Shadow, "let x = 1; let f = fn(x) { let y = x; y }; f(x)", []string{"1:23: declaration of x shadows declaration at 1:5 (shadow)"}},
+		{Shadow, "let x = 1; let x = 2;", nil},
+		{Shadow, "let e = 1; try { 1 } catch (e) { e }; e", []string{"1:29: declaration of e shadows declaration at 1:5 (shadow)"}},
+		{Unreachable, "let f = fn() { return 1; 2; 3 }; f()", []string{"1:26: unreachable code (unreachable)"}},
+		{Unreachable, "return 1;\nlet x = 2;", []string{"2:1: unreachable code (unreachable)"}},
+		{Unreachable, "if (x) { return 1 } 2", nil},
+		{ConstantCond, "if (true) { 1 }", []string{"1:5: if condition true is always true (constant-cond)"}},
+		{ConstantCond, "if (1 > 2) { 1 }", []string{"1:7: if condition (1 > 2) is always false (constant-cond)"}},
+		{ConstantCond, "if (x) { 1 }", nil},
+		{SelfCompare, "x == x", []string{"1:3: comparison of x with itself is always true (self-compare)"}},
+		{SelfCompare, "a + 1 < a + 1", []string{"1:7: comparison of (a + 1) with itself is always false (self-compare)"}},
+		{SelfCompare, "f() == f()", nil},
+		{SelfCompare, "x + x", nil},
+	}
+
+	for _, test := range tests {
+		diags := Check(parse(t, test.input), []*Rule{test.rule})
+
+		if len(diags) != len(test.expected) {
+			t.Errorf("%s: wrong number of diagnostics for %q. want=%d, got=%v", test.rule.Name, test.input, len(test.expected), diags)
+			continue
+		}
+		for i, d := range diags {
+			if d.String() != test.expected[i] {
+				t.Errorf("%s: wrong diagnostic. want=%q, got=%q", test.rule.Name, test.expected[i], d.String())
+			}
+		}
+	}
+}
+
+func TestCheckSortsByPosition(t *testing.T) {
+	input := "let f = fn(a) {\n\treturn 1;\n\tif (a == a) { 2 }\n};\n"
+
+	diags := Check(parse(t, input), Rules)
+
+	expected := []string{
+		"1:5: f declared and not used (unused-let)",
+		"3:2: unreachable code (unreachable)",
+		"3:8: comparison of a with itself is always true (self-compare)",
+	}
+	if len(diags) != len(expected) {
+		t.Fatalf("wrong number of diagnostics. want=%d, got=%v", len(expected), diags)
+	}
+	for i, d := range diags {
+		if d.String() != expected[i] {
+			t.Errorf("diags[%d] wrong. want=%q, got=%q", i, expected[i], d.String())
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	for _, r := range Rules {
+		if Lookup(r.Name) != r {
+			t.Errorf("Lookup(%q) did not return the rule", r.Name)
+		}
+	}
+	if Lookup("no-such-rule") != nil {
+		t.Errorf("Lookup returned a rule for an unknown name")
+	}
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+
+	p := parser.NewParser(lexer.NewLexer(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
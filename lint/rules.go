@@ -0,0 +1,159 @@
+package lint
+
+import (
+	"github.com/ei1chi/sample-lang/ast"
+	"github.com/ei1chi/sample-lang/eval"
+	"github.com/ei1chi/sample-lang/object"
+)
+
+var UnusedLet = &Rule{
+	Name: "unused-let",
+	Doc:  "let で束縛したが一度も参照していない",
+	run: func(p *pass) {
+		for _, b := range bindings(p.program) {
+			if b.kind == letBinding && !b.used {
+				p.report(b.sym.Decl.Pos(), "%s declared and not used", b.sym.Name)
+			}
+		}
+	},
+}
+
+var UnusedImport = &Rule{
+	Name: "unused-import",
+	Doc:  "import したモジュールを一度も参照していない",
+	run: func(p *pass) {
+		for _, b := range bindings(p.program) {
+			if b.kind == importBinding && !b.used {
+				p.report(b.sym.Decl.Pos(), "%s imported and not used", b.sym.Name)
+			}
+		}
+	},
+}
+
+var UnusedParam = &Rule{
+	Name: "unused-param",
+	Doc:  "関数の仮引数を本体で使っていない",
+	run: func(p *pass) {
+		for _, b := range bindings(p.program) {
+			if b.kind == paramBinding && !b.used {
+				p.report(b.sym.Decl.Pos(), "parameter %s is never used", b.sym.Name)
+			}
+		}
+	},
+}
+
+var Shadow = &Rule{
+	Name: "shadow",
+	Doc:  "外側のスコープの変数と同じ名前で宣言している",
+	run: func(p *pass) {
+		for _, b := range bindings(p.program) {
+			if b.shadowed != nil {
+				p.report(b.sym.Decl.Pos(), "declaration of %s shadows declaration at %s", b.sym.Name, b.shadowed.Decl.Pos())
+			}
+		}
+	},
+}
+
+var Unreachable = &Rule{
+	Name: "unreachable",
+	Doc:  "return や throw の後にあって実行されない文",
+	run: func(p *pass) {
+		check := func(stmts []ast.Stmt) {
+			for i, stmt := range stmts {
+				switch stmt.(type) {
+				case *ast.ReturnStmt, *ast.ThrowStmt:
+					if i+1 < len(stmts) {
+						p.report(stmts[i+1].Pos(), "unreachable code")
+						return
+					}
+				}
+			}
+		}
+
+		check(p.program.Stmts)
+		ast.Inspect(p.program, func(node ast.Node) bool {
+			if block, ok := node.(*ast.BlockStmt); ok {
+				check(block.Stmts)
+			}
+			return true
+		})
+	},
+}
+
+var ConstantCond = &Rule{
+	Name: "constant-cond",
+	Doc:  "if の条件が定数で、常に同じ枝を通る",
+	run: func(p *pass) {
+		ast.Inspect(p.program, func(node ast.Node) bool {
+			ie, ok := node.(*ast.IfExpr)
+			if !ok || ie.Cond == nil || !isConstant(ie.Cond) {
+				return true
+			}
+
+			// 定数の式は副作用がないので、そのまま評価して確かめる
+			switch val := eval.Eval(ie.Cond, object.NewEnvironment()); val {
+			case object.FalseValue, object.NullValue:
+				p.report(ie.Cond.Pos(), "if condition %s is always false", ie.Cond)
+			default:
+				if _, isErr := val.(*object.Error); !isErr {
+					p.report(ie.Cond.Pos(), "if condition %s is always true", ie.Cond)
+				}
+			}
+			return true
+		})
+	},
+}
+
+var SelfCompare = &Rule{
+	Name: "self-compare",
+	Doc:  "同じ式どうしを比較している",
+	run: func(p *pass) {
+		ast.Inspect(p.program, func(node ast.Node) bool {
+			ie, ok := node.(*ast.InfixExpr)
+			if !ok || ie.Left == nil || ie.Right == nil {
+				return true
+			}
+
+			var result bool
+			switch ie.Operator {
+			case "==":
+				result = true
+			case "!=", "<", ">":
+				result = false
+			default:
+				return true
+			}
+
+			if hasCall(ie.Left) || ie.Left.String() != ie.Right.String() {
+				return true
+			}
+			p.report(ie.Pos(), "comparison of %s with itself is always %t", ie.Left, result)
+			return true
+		})
+	},
+}
+
+// isConstant リテラルと、それだけからなる演算か。
+func isConstant(expr ast.Expr) bool {
+	switch expr := expr.(type) {
+	case *ast.IntLiteral, *ast.Boolean:
+		return true
+	case *ast.PrefixExpr:
+		return expr.Right != nil && isConstant(expr.Right)
+	case *ast.InfixExpr:
+		return expr.Left != nil && expr.Right != nil && isConstant(expr.Left) && isConstant(expr.Right)
+	}
+	return false
+}
+
+// hasCall 呼び出しを含む式は評価のたびに値が変わりうる。
+func hasCall(expr ast.Expr) bool {
+	found := false
+	ast.Inspect(expr, func(node ast.Node) bool {
+		if _, ok := node.(*ast.CallExpr); ok {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
@@ -0,0 +1,90 @@
+package lint
+
+import (
+	"sort"
+
+	"github.com/ei1chi/sample-lang/ast"
+	"github.com/ei1chi/sample-lang/resolve"
+)
+
+// 変数のスコープは resolve で解決する。ここでは規則が使う宣言の種類と、使われたかどうかを加える。
+
+type bindingKind int
+
+const (
+	letBinding bindingKind = iota
+	paramBinding
+	catchBinding  // catch (e) の e。使わなくてもよいので未使用の指摘はしない
+	importBinding // import したモジュール
+	exportBinding // export let。モジュールの外で使うので未使用の指摘はしない
+)
+
+// binding 宣言一つ。同じスコープで同じ名前を let し直したものは resolve と同じく一つにまとめる。
+type binding struct {
+	sym      *resolve.Symbol
+	kind     bindingKind // 最初の宣言の種類
+	used     bool
+	shadowed *resolve.Symbol // 外側のスコープにある同名の宣言
+}
+
+// bindings program の宣言を、宣言の位置の順に並べる。
+func bindings(program *ast.Program) []*binding {
+	info := resolve.Resolve(program, nil)
+
+	used := map[*resolve.Symbol]bool{}
+	for _, ref := range info.Uses {
+		used[ref.Symbol] = true
+	}
+
+	kinds := declKinds(program)
+	var bs []*binding
+	add := func(t *resolve.Table) {
+		for _, sym := range t.Symbols {
+			outer, _ := t.Outer.Lookup(sym.Name)
+			bs = append(bs, &binding{sym: sym, kind: kinds[sym.Decl], used: used[sym], shadowed: outer})
+		}
+	}
+	add(info.Global)
+	for _, t := range info.Funcs {
+		add(t)
+	}
+	for _, t := range info.Catches {
+		add(t)
+	}
+
+	sort.Slice(bs, func(i, j int) bool {
+		a, b := bs[i].sym.Decl.Pos(), bs[j].sym.Decl.Pos()
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return bs
+}
+
+// declKinds let 以外の宣言をしている識別子の種類。
+func declKinds(program *ast.Program) map[*ast.Ident]bindingKind {
+	kinds := map[*ast.Ident]bindingKind{}
+	ast.Inspect(program, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.FuncLiteral:
+			for _, param := range node.Params {
+				kinds[param] = paramBinding
+			}
+		case *ast.ExportStmt:
+			if node.Let != nil && node.Let.Name != nil {
+				kinds[node.Let.Name] = exportBinding
+			}
+		case *ast.ImportStmt:
+			if node.Name != nil {
+				kinds[node.Name] = importBinding
+			}
+		case *ast.TryExpr:
+			if node.Param != nil {
+				kinds[node.Param] = catchBinding
+			}
+		}
+		return true
+	})
+	return kinds
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ei1chi/sample-lang/lsp"
+)
+
+// runLSP sample-lang lsp
+// 標準入出力で Language Server Protocol を話す。
+func runLSP(args []string) int {
+	flags := flag.NewFlagSet("lsp", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: sample-lang lsp\n")
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if err := lsp.Serve(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "lsp: %s\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,153 @@
+package lsp
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ei1chi/sample-lang/ast"
+	"github.com/ei1chi/sample-lang/eval"
+	"github.com/ei1chi/sample-lang/lexer"
+	"github.com/ei1chi/sample-lang/lint"
+	"github.com/ei1chi/sample-lang/parser"
+	"github.com/ei1chi/sample-lang/resolve"
+	"github.com/ei1chi/sample-lang/token"
+	"github.com/ei1chi/sample-lang/types"
+)
+
+// document 開いているファイルと、その解析結果。
+// 構文エラーがあれば program 以降の解析はしない。
+type document struct {
+	uri     string
+	version int
+	text    string
+	lines   []string
+
+	program     *ast.Program
+	parseErrors []*parser.Error
+
+	resolved *resolve.Info
+	checkErr []*types.Error
+	inferred *types.Inference
+	lints    []lint.Diagnostic
+}
+
+func newDocument(uri string, version int, text string) *document {
+	d := &document{
+		uri:     uri,
+		version: version,
+		text:    text,
+		lines:   strings.Split(text, "\n"),
+	}
+
+	p := parser.NewParser(lexer.NewLexer(text))
+	d.program = p.ParseProgram()
+	d.parseErrors = p.ErrorList()
+	if len(d.parseErrors) != 0 {
+		return d
+	}
+
+	d.resolved = resolve.Resolve(d.program, eval.BuiltinNames())
+	_, d.checkErr = types.Check(d.program)
+	d.inferred, _ = types.Infer(d.program)
+	d.lints = lint.Check(d.program, lint.Rules)
+	return d
+}
+
+// diagnostics 構文エラーと、実行すると失敗する誤りはエラー、lint の指摘は警告にする。
+func (d *document) diagnostics() []Diagnostic {
+	diags := []Diagnostic{}
+
+	add := func(pos token.Position, severity int, code, msg string) {
+		diags = append(diags, Diagnostic{
+			Range:    d.rangeOf(pos, 1),
+			Severity: severity,
+			Code:     code,
+			Source:   "sample-lang",
+			Message:  msg,
+		})
+	}
+
+	for _, err := range d.parseErrors {
+		add(err.Pos, SeverityError, "", err.Message)
+	}
+	if d.resolved != nil {
+		for _, err := range d.resolved.Errors {
+			add(err.Pos, SeverityError, "", err.Message)
+		}
+		// 組み込み関数でもなければ、実行すると identifier not found になる
+		for _, ident := range d.resolved.Unresolved {
+			add(ident.Pos(), SeverityError, "", "identifier not found: "+ident.Value)
+		}
+	}
+	for _, err := range d.checkErr {
+		add(err.Pos, SeverityError, "", err.Message)
+	}
+	for _, l := range d.lints {
+		add(l.Pos, SeverityWarning, l.Rule, l.Message)
+	}
+
+	return diags
+}
+
+// identAt 位置 pos にある識別子。宣言も参照も含む。
+func (d *document) identAt(pos Position) *ast.Ident {
+	if d.resolved == nil {
+		return nil
+	}
+
+	var found *ast.Ident
+	ast.Inspect(d.program, func(node ast.Node) bool {
+		if found != nil {
+			return false
+		}
+		if ident, ok := node.(*ast.Ident); ok {
+			r := d.rangeOf(ident.Pos(), utf8.RuneCountInString(ident.Value))
+			if r.Start.Line == pos.Line && r.Start.Character <= pos.Character && pos.Character < r.End.Character {
+				found = ident
+			}
+		}
+		return true
+	})
+	return found
+}
+
+// lspPos 行と列（1 始まり、rune 単位）を LSP の位置（0 始まり、UTF-16 単位）にする。
+func (d *document) lspPos(pos token.Position) Position {
+	line := pos.Line - 1
+	if line < 0 || line >= len(d.lines) {
+		return Position{Line: line}
+	}
+
+	text := d.lines[line]
+	char := 0
+	for col := 1; col < pos.Column && len(text) > 0; col++ {
+		r, size := utf8.DecodeRuneInString(text)
+		char += utf16Len(r)
+		text = text[size:]
+	}
+	return Position{Line: line, Character: char}
+}
+
+// rangeOf pos から runes 文字分の範囲。
+func (d *document) rangeOf(pos token.Position, runes int) Range {
+	end := pos
+	end.Column += runes
+	return Range{Start: d.lspPos(pos), End: d.lspPos(end)}
+}
+
+// end 文書の末尾の位置。
+func (d *document) end() Position {
+	last := len(d.lines) - 1
+	char := 0
+	for _, r := range d.lines[last] {
+		char += utf16Len(r)
+	}
+	return Position{Line: last, Character: char}
+}
+
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,278 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const uri = "file:///test.sl"
+
+// session requests を順に送り、サーバーが書いたメッセージを返す。
+func session(t *testing.T, requests ...string) []*message {
+	t.Helper()
+
+	var in bytes.Buffer
+	for _, req := range requests {
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(req), req)
+	}
+
+	var out bytes.Buffer
+	if err := Serve(&in, &out); err != nil {
+		t.Fatalf("Serve failed: %s", err)
+	}
+
+	c := newConn(bufio.NewReader(&out), nil)
+	var msgs []*message
+	for out.Len() > 0 || c.r.Buffered() > 0 {
+		msg, err := c.read()
+		if err != nil {
+			t.Fatalf("bad response: %s", err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func initialize() string {
+	return `{"jsonrpc":"2.0","id":0,"method":"initialize","params":{}}`
+}
+
+func didOpen(text string) string {
+	b, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  "textDocument/didOpen",
+		"params": DidOpenTextDocumentParams{
+			TextDocument: TextDocumentItem{URI: uri, LanguageID: "sample-lang", Version: 1, Text: text},
+		},
+	})
+	return string(b)
+}
+
+func request(id int, method string, params interface{}) string {
+	b, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"method":  method,
+		"params":  params,
+	})
+	return string(b)
+}
+
+func atPosition(line, char int) TextDocumentPositionParams {
+	return TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     Position{Line: line, Character: char},
+	}
+}
+
+// response id の要求への応答の result を v に読む。
+func response(t *testing.T, msgs []*message, id int, v interface{}) {
+	t.Helper()
+
+	for _, msg := range msgs {
+		if msg.ID == nil || string(*msg.ID) != fmt.Sprint(id) {
+			continue
+		}
+		if msg.Error != nil {
+			t.Fatalf("request %d failed: %s", id, msg.Error.Message)
+		}
+		b, _ := json.Marshal(msg.Result)
+		if err := json.Unmarshal(b, v); err != nil {
+			t.Fatalf("bad result %s: %s", b, err)
+		}
+		return
+	}
+	t.Fatalf("no response to request %d", id)
+}
+
+func TestLifecycle(t *testing.T) {
+	msgs := session(t,
+		request(1, "textDocument/hover", atPosition(0, 0)),
+		initialize(),
+		`{"jsonrpc":"2.0","method":"initialized","params":{}}`,
+		`{"jsonrpc":"2.0","method":"$/cancelRequest","params":{"id":1}}`,
+		request(2, "workspace/symbol", map[string]string{}),
+		request(3, "shutdown", nil),
+		`{"jsonrpc":"2.0","method":"exit"}`,
+		request(4, "shutdown", nil), // exit の後は読まない
+	)
+
+	if len(msgs) != 4 {
+		t.Fatalf("wrong number of messages. got=%d, want=4", len(msgs))
+	}
+	if msgs[0].Error == nil || msgs[0].Error.Code != codeServerNotInitialized {
+		t.Errorf("request before initialize: want error %d, got %+v", codeServerNotInitialized, msgs[0].Error)
+	}
+
+	var init struct {
+		Capabilities map[string]interface{} `json:"capabilities"`
+	}
+	response(t, msgs, 0, &init)
+	for _, name := range []string{"hoverProvider", "definitionProvider", "documentSymbolProvider", "documentFormattingProvider", "semanticTokensProvider"} {
+		if _, ok := init.Capabilities[name]; !ok {
+			t.Errorf("capability %s missing", name)
+		}
+	}
+
+	if msgs[2].Error == nil || msgs[2].Error.Code != codeMethodNotFound {
+		t.Errorf("unknown method: want error %d, got %+v", codeMethodNotFound, msgs[2].Error)
+	}
+	if msgs[3].Error != nil || string(*msgs[3].ID) != "3" {
+		t.Errorf("shutdown: want null result, got %+v", msgs[3])
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string // "line:char severity message"
+	}{
+		{"let x = 1;\nx + 1;", []string{}},
+		{"let x = ;", []string{"0:8 1 no prefix parse function for ; found"}},
+		{"let x = 1 + true;\nx;", []string{"0:10 1 cannot add int and bool"}},
+		{"y + 1;", []string{"0:0 1 identifier not found: y"}},
+		{"let f = fn(a) { 1 };\nf(2);", []string{"0:11 2 parameter a is never used"}},
+	}
+
+	for _, test := range tests {
+		msgs := session(t, initialize(), didOpen(test.input))
+
+		var params PublishDiagnosticsParams
+		for _, msg := range msgs {
+			if msg.Method == "textDocument/publishDiagnostics" {
+				if err := json.Unmarshal(msg.Params, &params); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if params.URI != uri {
+			t.Fatalf("%q: no diagnostics published", test.input)
+		}
+
+		got := []string{}
+		for _, d := range params.Diagnostics {
+			got = append(got, fmt.Sprintf("%d:%d %d %s", d.Range.Start.Line, d.Range.Start.Character, d.Severity, d.Message))
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%q: want %q, got %q", test.input, test.expected, got)
+		}
+	}
+}
+
+func TestHoverAndDefinition(t *testing.T) {
+	input := "let id = fn(x) { x };\nlet n = id(1);\n"
+
+	tests := []struct {
+		line, char    int
+		expectedHover string // 空なら null
+		expectedDef   string // "line:char"。空なら null
+	}{
+		{0, 4, "id: fn('a): 'a", "0:4"},
+		{0, 17, "x: 'a", "0:12"},
+		{1, 9, "id: fn(int): int", "0:4"},
+		{1, 4, "n: int", "1:4"},
+		{1, 12, "", ""}, // 数値の上
+	}
+
+	for _, test := range tests {
+		msgs := session(t, initialize(), didOpen(input),
+			request(1, "textDocument/hover", atPosition(test.line, test.char)),
+			request(2, "textDocument/definition", atPosition(test.line, test.char)),
+		)
+
+		var hover *Hover
+		response(t, msgs, 1, &hover)
+		got := ""
+		if hover != nil {
+			got = strings.Trim(hover.Contents.Value, "`\n")
+		}
+		if got != test.expectedHover {
+			t.Errorf("hover %d:%d: want %q, got %q", test.line, test.char, test.expectedHover, got)
+		}
+
+		var loc *Location
+		response(t, msgs, 2, &loc)
+		got = ""
+		if loc != nil {
+			got = fmt.Sprintf("%d:%d", loc.Range.Start.Line, loc.Range.Start.Character)
+		}
+		if got != test.expectedDef {
+			t.Errorf("definition %d:%d: want %q, got %q", test.line, test.char, test.expectedDef, got)
+		}
+	}
+}
+
+func TestDocumentSymbol(t *testing.T) {
+	input := "let add = fn(a, b) {\n  let sum = a + b;\n  sum\n};\nlet one = 1;\n"
+	msgs := session(t, initialize(), didOpen(input),
+		request(1, "textDocument/documentSymbol", DocumentParams{TextDocument: TextDocumentIdentifier{URI: uri}}),
+	)
+
+	var syms []DocumentSymbol
+	response(t, msgs, 1, &syms)
+
+	if len(syms) != 2 {
+		t.Fatalf("wrong number of symbols. got=%d, want=2", len(syms))
+	}
+	if syms[0].Name != "add" || syms[0].Kind != SymbolFunction || syms[0].Detail != "fn(int, int): int" {
+		t.Errorf("want add function, got %+v", syms[0])
+	}
+	if len(syms[0].Children) != 1 || syms[0].Children[0].Name != "sum" {
+		t.Errorf("want child sum, got %+v", syms[0].Children)
+	}
+	if syms[1].Name != "one" || syms[1].Kind != SymbolVariable {
+		t.Errorf("want one variable, got %+v", syms[1])
+	}
+}
+
+func TestSemanticTokens(t *testing.T) {
+	input := "let x: int = 1;\nx == 2"
+	msgs := session(t, initialize(), didOpen(input),
+		request(1, "textDocument/semanticTokens/full", DocumentParams{TextDocument: TextDocumentIdentifier{URI: uri}}),
+	)
+
+	var tokens SemanticTokens
+	response(t, msgs, 1, &tokens)
+
+	expected := []int{
+		0, 0, 3, tokenKeyword, 0, // let
+		0, 4, 1, tokenVariable, 0, // x
+		0, 3, 3, tokenType, 0, // int
+		0, 4, 1, tokenOperator, 0, // =
+		0, 2, 1, tokenNumber, 0, // 1
+		1, 0, 1, tokenVariable, 0, // x
+		0, 2, 2, tokenOperator, 0, // ==
+		0, 3, 1, tokenNumber, 0, // 2
+	}
+	if !reflect.DeepEqual(tokens.Data, expected) {
+		t.Errorf("want %v, got %v", expected, tokens.Data)
+	}
+}
+
+func TestFormatting(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []TextEdit // nil なら null
+	}{
+		{"let x=1", []TextEdit{{Range: Range{End: Position{Line: 0, Character: 7}}, NewText: "let x = 1;\n"}}},
+		{"let x = 1;\n", []TextEdit{}},
+		{"let x = ", nil},
+	}
+
+	for _, test := range tests {
+		msgs := session(t, initialize(), didOpen(test.input),
+			request(1, "textDocument/formatting", DocumentParams{TextDocument: TextDocumentIdentifier{URI: uri}}),
+		)
+
+		var edits []TextEdit
+		response(t, msgs, 1, &edits)
+		if !reflect.DeepEqual(edits, test.expected) {
+			t.Errorf("%q: want %+v, got %+v", test.input, test.expected, edits)
+		}
+	}
+}
@@ -0,0 +1,395 @@
+// Package lsp は標準入出力で JSON-RPC を話す Language Server。
+//
+// 対応しているのは診断、ホバー、定義へのジャンプ、ドキュメントシンボル、
+// セマンティックトークン、整形。文書の同期は常に全文を送ってもらう。
+package lsp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"github.com/ei1chi/sample-lang/ast"
+	"github.com/ei1chi/sample-lang/format"
+	"github.com/ei1chi/sample-lang/lexer"
+	"github.com/ei1chi/sample-lang/token"
+)
+
+// セマンティックトークンの種類。添字がトークンの種類の番号になる。
+var tokenTypes = []string{"keyword", "variable", "number", "operator", "type", "string", "property"}
+
+const (
+	tokenKeyword = iota
+	tokenVariable
+	tokenNumber
+	tokenOperator
+	tokenType
+	tokenString
+	tokenProperty
+)
+
+// Serve r から要求を読み、w に応答を書く。exit 通知を受けるか r が終わると戻る。
+func Serve(r io.Reader, w io.Writer) error {
+	s := &server{conn: newConn(r, w), docs: map[string]*document{}}
+	return s.run()
+}
+
+type server struct {
+	conn        *conn
+	docs        map[string]*document
+	initialized bool
+	shutdown    bool
+}
+
+// handler 要求の処理。通知なら結果は捨てる。
+type handler func(s *server, params json.RawMessage) (interface{}, error)
+
+var handlers = map[string]handler{
+	"initialize":                       (*server).initialize,
+	"initialized":                      (*server).noop,
+	"shutdown":                         (*server).shutdownRequest,
+	"textDocument/didOpen":             (*server).didOpen,
+	"textDocument/didChange":           (*server).didChange,
+	"textDocument/didClose":            (*server).didClose,
+	"textDocument/hover":               (*server).hover,
+	"textDocument/definition":          (*server).definition,
+	"textDocument/documentSymbol":      (*server).documentSymbol,
+	"textDocument/semanticTokens/full": (*server).semanticTokens,
+	"textDocument/formatting":          (*server).formatting,
+}
+
+func (s *server) run() error {
+	for {
+		msg, err := s.conn.read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		var rerr *responseError
+		if errors.As(err, &rerr) {
+			s.conn.reply(nil, nil, rerr)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if msg.Method == "exit" {
+			return nil
+		}
+		if err := s.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *server) handle(msg *message) error {
+	isRequest := msg.ID != nil
+
+	h, ok := handlers[msg.Method]
+	if !ok {
+		if isRequest {
+			return s.conn.reply(msg.ID, nil, &responseError{Code: codeMethodNotFound, Message: "method not found: " + msg.Method})
+		}
+		return nil // 知らない通知は無視してよい
+	}
+
+	if !s.initialized && msg.Method != "initialize" {
+		if isRequest {
+			return s.conn.reply(msg.ID, nil, &responseError{Code: codeServerNotInitialized, Message: "server not initialized"})
+		}
+		return nil
+	}
+
+	result, err := h(s, msg.Params)
+	if !isRequest {
+		return nil
+	}
+	if err != nil {
+		var rerr *responseError
+		if !errors.As(err, &rerr) {
+			rerr = &responseError{Code: codeInvalidParams, Message: err.Error()}
+		}
+		return s.conn.reply(msg.ID, nil, rerr)
+	}
+	return s.conn.reply(msg.ID, result, nil)
+}
+
+func (s *server) noop(json.RawMessage) (interface{}, error) {
+	return nil, nil
+}
+
+func (s *server) initialize(json.RawMessage) (interface{}, error) {
+	s.initialized = true
+
+	return map[string]interface{}{
+		"capabilities": map[string]interface{}{
+			"textDocumentSync":           1, // 全文
+			"hoverProvider":              true,
+			"definitionProvider":         true,
+			"documentSymbolProvider":     true,
+			"documentFormattingProvider": true,
+			"semanticTokensProvider": map[string]interface{}{
+				"legend": map[string]interface{}{
+					"tokenTypes":     tokenTypes,
+					"tokenModifiers": []string{},
+				},
+				"full": true,
+			},
+		},
+		"serverInfo": map[string]string{"name": "sample-lang"},
+	}, nil
+}
+
+func (s *server) shutdownRequest(json.RawMessage) (interface{}, error) {
+	s.shutdown = true
+	return nil, nil
+}
+
+func (s *server) didOpen(raw json.RawMessage) (interface{}, error) {
+	var params DidOpenTextDocumentParams
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, err
+	}
+
+	item := params.TextDocument
+	return nil, s.update(newDocument(item.URI, item.Version, item.Text))
+}
+
+func (s *server) didChange(raw json.RawMessage) (interface{}, error) {
+	var params DidChangeTextDocumentParams
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, err
+	}
+	if len(params.ContentChanges) == 0 {
+		return nil, nil
+	}
+
+	text := params.ContentChanges[len(params.ContentChanges)-1].Text
+	return nil, s.update(newDocument(params.TextDocument.URI, params.TextDocument.Version, text))
+}
+
+func (s *server) didClose(raw json.RawMessage) (interface{}, error) {
+	var params DidCloseTextDocumentParams
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, err
+	}
+
+	delete(s.docs, params.TextDocument.URI)
+	// 閉じたファイルの診断は消す
+	return nil, s.conn.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+		URI:         params.TextDocument.URI,
+		Diagnostics: []Diagnostic{},
+	})
+}
+
+func (s *server) update(d *document) error {
+	s.docs[d.uri] = d
+	return s.conn.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+		URI:         d.uri,
+		Version:     d.version,
+		Diagnostics: d.diagnostics(),
+	})
+}
+
+func (s *server) document(uri string) (*document, error) {
+	d, ok := s.docs[uri]
+	if !ok {
+		return nil, fmt.Errorf("document not open: %s", uri)
+	}
+	return d, nil
+}
+
+// positionParams 位置を受け取る要求の、文書と位置にある識別子。
+func (s *server) positionParams(raw json.RawMessage) (*document, *ast.Ident, error) {
+	var params TextDocumentPositionParams
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, nil, err
+	}
+
+	d, err := s.document(params.TextDocument.URI)
+	if err != nil {
+		return nil, nil, err
+	}
+	return d, d.identAt(params.Position), nil
+}
+
+func (s *server) hover(raw json.RawMessage) (interface{}, error) {
+	d, ident, err := s.positionParams(raw)
+	if err != nil || ident == nil {
+		return nil, err
+	}
+
+	var typ string
+	if scheme, ok := d.inferred.Defs[ident]; ok {
+		typ = scheme.String()
+	} else if t, ok := d.inferred.Types[ident]; ok {
+		typ = t.String()
+	} else {
+		return nil, nil
+	}
+
+	return Hover{
+		Contents: MarkupContent{
+			Kind:  "markdown",
+			Value: fmt.Sprintf("```\n%s: %s\n```", ident.Value, typ),
+		},
+		Range: d.rangeOf(ident.Pos(), utf8.RuneCountInString(ident.Value)),
+	}, nil
+}
+
+func (s *server) definition(raw json.RawMessage) (interface{}, error) {
+	d, ident, err := s.positionParams(raw)
+	if err != nil || ident == nil {
+		return nil, err
+	}
+
+	sym := d.resolved.Lookup(ident)
+	if sym == nil || sym.Decl == nil {
+		return nil, nil
+	}
+
+	return Location{
+		URI:   d.uri,
+		Range: d.rangeOf(sym.Decl.Pos(), utf8.RuneCountInString(sym.Decl.Value)),
+	}, nil
+}
+
+func (s *server) documentSymbol(raw json.RawMessage) (interface{}, error) {
+	var params DocumentParams
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, err
+	}
+
+	d, err := s.document(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	if d.inferred == nil {
+		return []DocumentSymbol{}, nil
+	}
+	return d.symbols(d.program.Stmts), nil
+}
+
+// symbols stmts の let を、関数の中の let を子にして並べる。export した let も含める。
+func (d *document) symbols(stmts []ast.Stmt) []DocumentSymbol {
+	syms := []DocumentSymbol{}
+
+	for _, stmt := range stmts {
+		if export, ok := stmt.(*ast.ExportStmt); ok {
+			stmt = export.Let
+		}
+		let, ok := stmt.(*ast.LetStmt)
+		if !ok {
+			continue
+		}
+
+		sym := DocumentSymbol{
+			Name:           let.Name.Value,
+			Kind:           SymbolVariable,
+			SelectionRange: d.rangeOf(let.Name.Pos(), utf8.RuneCountInString(let.Name.Value)),
+		}
+		sym.Range = Range{Start: d.lspPos(let.Pos()), End: sym.SelectionRange.End}
+		if scheme, ok := d.inferred.Defs[let.Name]; ok {
+			sym.Detail = scheme.String()
+		}
+		if fl, ok := let.Value.(*ast.FuncLiteral); ok {
+			sym.Kind = SymbolFunction
+			if fl.Body != nil {
+				sym.Children = d.symbols(fl.Body.Stmts)
+			}
+		}
+
+		syms = append(syms, sym)
+	}
+
+	return syms
+}
+
+// semanticTokens 字句解析の結果から色分けの情報を作る。
+func (s *server) semanticTokens(raw json.RawMessage) (interface{}, error) {
+	var params DocumentParams
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, err
+	}
+
+	d, err := s.document(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	data := []int{}
+	var prev Position
+	var prevType token.TokenType
+
+	l := lexer.NewLexer(d.text)
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		typ, ok := semanticType(tok.Type, prevType)
+		prevType = tok.Type
+		if !ok {
+			continue
+		}
+
+		r := d.rangeOf(tok.Pos, utf8.RuneCountInString(tok.Literal))
+		deltaLine := r.Start.Line - prev.Line
+		deltaChar := r.Start.Character
+		if deltaLine == 0 {
+			deltaChar -= prev.Character
+		}
+		data = append(data, deltaLine, deltaChar, r.End.Character-r.Start.Character, typ, 0)
+		prev = r.Start
+	}
+
+	return SemanticTokens{Data: data}, nil
+}
+
+func semanticType(t, prev token.TokenType) (int, bool) {
+	switch t {
+	case token.FUNCTION, token.LET, token.TRUE, token.FALSE, token.IF, token.ELSE, token.RETURN,
+		token.TRY, token.CATCH, token.FINALLY, token.THROW, token.IMPORT, token.EXPORT:
+		return tokenKeyword, true
+	case token.IDENT:
+		if prev == token.COLON {
+			return tokenType, true
+		}
+		if prev == token.DOT {
+			return tokenProperty, true
+		}
+		return tokenVariable, true
+	case token.INT:
+		return tokenNumber, true
+	case token.STRING:
+		return tokenString, true
+	case token.ASSIGN, token.PLUS, token.MINUS, token.BANG, token.ASTERISK, token.SLASH,
+		token.LT, token.GT, token.EQ, token.NOT_EQ, token.QUESTION:
+		return tokenOperator, true
+	}
+	return 0, false
+}
+
+func (s *server) formatting(raw json.RawMessage) (interface{}, error) {
+	var params DocumentParams
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, err
+	}
+
+	d, err := s.document(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := format.Source([]byte(d.text))
+	if err != nil {
+		// 構文エラーは診断で知らせているので、整形しないだけにする
+		return nil, nil
+	}
+	if string(out) == d.text {
+		return []TextEdit{}, nil
+	}
+
+	return []TextEdit{{
+		Range:   Range{End: d.end()},
+		NewText: string(out),
+	}}, nil
+}
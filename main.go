@@ -1,13 +1,30 @@
-package main
-
-import (
-	"fmt"
-	"os"
-
-	"github.com/ei1chi/sample-lang/repl"
-)
-
-func main() {
-	fmt.Printf("Hello! This is my language!\n")
-	repl.Start(os.Stdin, os.Stdout)
-}
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ei1chi/sample-lang/repl"
+)
+
+// commands サブコマンド。引数がなければ REPL を起動する。
+var commands = map[string]func(args []string) int{
+	"build": runBuild,
+	"check": runCheck,
+	"dap":   runDAP,
+	"fmt":   runFmt,
+	"lint":  runLint,
+	"lsp":   runLSP,
+	"run":   runRun,
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:]))
+		}
+	}
+
+	fmt.Printf("Hello! This is my language!\n")
+	repl.Start(os.Stdin, os.Stdout)
+}
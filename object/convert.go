@@ -0,0 +1,461 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+var objectType = reflect.TypeOf((*Object)(nil)).Elem()
+
+// FromGo Go の値を対応するオブジェクトに変換する。
+//   - 整数、真偽値、文字列、浮動小数点数はそれぞれの値になる
+//   - スライスと配列は配列、キーが文字列のマップは連想配列になる。マップのキーは辞書順に並ぶ
+//   - 構造体は公開されたフィールドの連想配列になる。キーは `sl:"name"` タグの名前で、タグがなければフィールド名。`sl:"-"` のフィールドは含めない
+//   - 関数は NewGoFunc と同じ規則で組み込み関数になる
+//   - nil のポインタ、スライス、マップ、関数は null になる
+func FromGo(v any) (Object, error) {
+	if v == nil {
+		return NullValue, nil
+	}
+	return fromValue(reflect.ValueOf(v))
+}
+
+func fromValue(v reflect.Value) (Object, error) {
+	if !v.IsValid() {
+		return NullValue, nil
+	}
+
+	if v.Type().Implements(objectType) {
+		if v.Kind() == reflect.Interface || v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return NullValue, nil
+			}
+		}
+		return v.Interface().(Object), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return TrueValue, nil
+		}
+		return FalseValue, nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Integer{Value: v.Int()}, nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := v.Uint()
+		if u > math.MaxInt64 {
+			return nil, fmt.Errorf("cannot convert %d to %s: out of range", u, INTEGER)
+		}
+		return &Integer{Value: int64(u)}, nil
+
+	case reflect.Float32, reflect.Float64:
+		return &Float{Value: v.Float()}, nil
+
+	case reflect.String:
+		return &String{Value: v.String()}, nil
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return NullValue, nil
+		}
+		elems := make([]Object, v.Len())
+		for i := range elems {
+			elem, err := fromValue(v.Index(i))
+			if err != nil {
+				return nil, fmt.Errorf("index %d: %w", i, err)
+			}
+			elems[i] = elem
+		}
+		return &Array{Elems: elems}, nil
+
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("cannot convert Go value of type %s to object: map key must be string", v.Type())
+		}
+		if v.IsNil() {
+			return NullValue, nil
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		m := NewMap()
+		for _, key := range keys {
+			val, err := fromValue(v.MapIndex(key))
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", key.String(), err)
+			}
+			m.Set(key.String(), val)
+		}
+		return m, nil
+
+	case reflect.Struct:
+		m := NewMap()
+		for _, f := range structFields(v.Type()) {
+			val, err := fromValue(v.Field(f.index))
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", f.goName, err)
+			}
+			m.Set(f.name, val)
+		}
+		return m, nil
+
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return NullValue, nil
+		}
+		return fromValue(v.Elem())
+
+	case reflect.Func:
+		if v.IsNil() {
+			return NullValue, nil
+		}
+		return goFunc(v)
+	}
+
+	return nil, fmt.Errorf("cannot convert Go value of type %s to object", v.Type())
+}
+
+// structField 連想配列と対応させる構造体のフィールド。
+type structField struct {
+	name   string // 連想配列のキー
+	goName string
+	index  int
+}
+
+// structFields t の公開されたフィールドを宣言の順に並べる。
+func structFields(t reflect.Type) []structField {
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("sl"); ok {
+			if tag == "-" {
+				continue
+			}
+			if tag != "" {
+				name = tag
+			}
+		}
+		fields = append(fields, structField{name: name, goName: f.Name, index: i})
+	}
+	return fields
+}
+
+// ToGo オブジェクトを target（ポインタ）の指す Go の値に変換して格納する。FromGo の逆で、加えて
+//   - 浮動小数点数には整数も入れられる
+//   - 構造体には連想配列を入れる。連想配列にないフィールドはゼロ値のままで、対応するフィールドのないキーは無視する
+//   - null はポインタ、スライス、マップ、関数のゼロ値になる
+//   - any には Go の値を入れる。配列は []any、連想配列は map[string]any になり、要素も同じように変換する
+//   - 組み込み関数は Go の関数になる。言語の関数は評価器を通さないと呼べないので、組み込み関数の引数としてだけ変換できる
+func ToGo(obj Object, target any) error {
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("target must be a non-nil pointer, got %T", target)
+	}
+
+	v, err := toValue(obj, rv.Elem().Type(), nil)
+	if err != nil {
+		return err
+	}
+	rv.Elem().Set(v)
+	return nil
+}
+
+// toValue obj を型 t の値にする。call は言語の関数を Go の関数に変換したとき、それを呼ぶのに使う。
+func toValue(obj Object, t reflect.Type, call Caller) (reflect.Value, error) {
+	if obj == nil {
+		obj = NullValue
+	}
+
+	// any などには素直な Go の値を入れる
+	if t.Kind() == reflect.Interface && t.NumMethod() == 0 {
+		return nativeValue(obj, t)
+	}
+
+	// Object 型（もしくはそれを満たすインターフェース）ならそのまま渡す
+	if reflect.TypeOf(obj).AssignableTo(t) {
+		return reflect.ValueOf(obj), nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		if b, ok := obj.(*Boolean); ok {
+			return reflect.ValueOf(b.Value).Convert(t), nil
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := obj.(*Integer); ok {
+			v := reflect.New(t).Elem()
+			if v.OverflowInt(i.Value) {
+				return reflect.Value{}, fmt.Errorf("cannot convert %d to %s: out of range", i.Value, t)
+			}
+			v.SetInt(i.Value)
+			return v, nil
+		}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if i, ok := obj.(*Integer); ok {
+			v := reflect.New(t).Elem()
+			if i.Value < 0 || v.OverflowUint(uint64(i.Value)) {
+				return reflect.Value{}, fmt.Errorf("cannot convert %d to %s: out of range", i.Value, t)
+			}
+			v.SetUint(uint64(i.Value))
+			return v, nil
+		}
+
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		switch obj := obj.(type) {
+		case *Float:
+			f = obj.Value
+		case *Integer:
+			f = float64(obj.Value)
+		default:
+			return reflect.Value{}, fmt.Errorf("cannot convert %s to %s", obj.Type(), t)
+		}
+		v := reflect.New(t).Elem()
+		if v.OverflowFloat(f) {
+			return reflect.Value{}, fmt.Errorf("cannot convert %g to %s: out of range", f, t)
+		}
+		v.SetFloat(f)
+		return v, nil
+
+	case reflect.String:
+		if s, ok := obj.(*String); ok {
+			return reflect.ValueOf(s.Value).Convert(t), nil
+		}
+
+	case reflect.Slice:
+		if obj == NullValue {
+			return reflect.Zero(t), nil
+		}
+		if a, ok := obj.(*Array); ok {
+			v := reflect.MakeSlice(t, len(a.Elems), len(a.Elems))
+			if err := setElems(v, a.Elems, call); err != nil {
+				return reflect.Value{}, err
+			}
+			return v, nil
+		}
+
+	case reflect.Array:
+		if a, ok := obj.(*Array); ok {
+			if len(a.Elems) != t.Len() {
+				return reflect.Value{}, fmt.Errorf("cannot convert %s of length %d to %s", ARRAY, len(a.Elems), t)
+			}
+			v := reflect.New(t).Elem()
+			if err := setElems(v, a.Elems, call); err != nil {
+				return reflect.Value{}, err
+			}
+			return v, nil
+		}
+
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return reflect.Value{}, fmt.Errorf("cannot convert %s to %s: map key must be string", obj.Type(), t)
+		}
+		if obj == NullValue {
+			return reflect.Zero(t), nil
+		}
+		if m, ok := obj.(*Map); ok {
+			v := reflect.MakeMapWithSize(t, len(m.Keys))
+			for _, key := range m.Keys {
+				val, err := toValue(m.Values[key], t.Elem(), call)
+				if err != nil {
+					return reflect.Value{}, fmt.Errorf("key %q: %w", key, err)
+				}
+				v.SetMapIndex(reflect.ValueOf(key).Convert(t.Key()), val)
+			}
+			return v, nil
+		}
+
+	case reflect.Struct:
+		if m, ok := obj.(*Map); ok {
+			v := reflect.New(t).Elem()
+			for _, f := range structFields(t) {
+				val, ok := m.Values[f.name]
+				if !ok {
+					continue
+				}
+				fv, err := toValue(val, t.Field(f.index).Type, call)
+				if err != nil {
+					return reflect.Value{}, fmt.Errorf("field %s: %w", f.goName, err)
+				}
+				v.Field(f.index).Set(fv)
+			}
+			return v, nil
+		}
+
+	case reflect.Pointer:
+		if obj == NullValue {
+			return reflect.Zero(t), nil
+		}
+		elem, err := toValue(obj, t.Elem(), call)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		v := reflect.New(t.Elem())
+		v.Elem().Set(elem)
+		return v, nil
+
+	case reflect.Func:
+		if obj == NullValue {
+			return reflect.Zero(t), nil
+		}
+		return funcValue(obj, t, call)
+	}
+
+	return reflect.Value{}, fmt.Errorf("cannot convert %s to %s", obj.Type(), t)
+}
+
+// setElems elems を変換して v（スライスか配列）の要素にする。
+func setElems(v reflect.Value, elems []Object, call Caller) error {
+	for i, elem := range elems {
+		ev, err := toValue(elem, v.Type().Elem(), call)
+		if err != nil {
+			return fmt.Errorf("index %d: %w", i, err)
+		}
+		v.Index(i).Set(ev)
+	}
+	return nil
+}
+
+// nativeValue any などの型 t に、obj を素直な Go の値にして入れる。
+func nativeValue(obj Object, t reflect.Type) (reflect.Value, error) {
+	native := toNative(obj)
+	if native == nil {
+		return reflect.Zero(t), nil
+	}
+
+	v := reflect.New(t).Elem()
+	v.Set(reflect.ValueOf(native))
+	return v, nil
+}
+
+// toNative 配列は []any、連想配列は map[string]any にして、要素も同じように変換する。
+// Go の値にならない関数などはオブジェクトのまま返す。null は nil。
+func toNative(obj Object) any {
+	switch obj := obj.(type) {
+	case *Integer:
+		return obj.Value
+	case *Boolean:
+		return obj.Value
+	case *String:
+		return obj.Value
+	case *Float:
+		return obj.Value
+	case *Null:
+		return nil
+	case *Array:
+		elems := make([]any, len(obj.Elems))
+		for i, elem := range obj.Elems {
+			elems[i] = toNative(elem)
+		}
+		return elems
+	case *Map:
+		m := make(map[string]any, len(obj.Keys))
+		for _, key := range obj.Keys {
+			m[key] = toNative(obj.Values[key])
+		}
+		return m
+	default:
+		return obj
+	}
+}
+
+// callbackPanic error を返さない Go の関数の中で、呼んだ言語の関数がエラーになったことを伝える。
+// 組み込み関数の呼び出しから戻るところで recover してエラーオブジェクトに戻す。
+type callbackPanic struct {
+	err *Error
+}
+
+// funcValue 関数 fn を Go の関数型 t の値にする。
+// 返した関数は引数をオブジェクトにして fn を呼び、結果を t の返り値に変換する。
+// fn がエラーになったら、t の最後の返り値が error ならそれで返し、そうでなければ callbackPanic で抜ける。
+func funcValue(fn Object, t reflect.Type, call Caller) (reflect.Value, error) {
+	switch fn.(type) {
+	case *Builtin:
+	case *Function, *Closure:
+		if call == nil {
+			return reflect.Value{}, fmt.Errorf("cannot convert %s to %s: no evaluator to call it", fn.Type(), t)
+		}
+	default:
+		return reflect.Value{}, fmt.Errorf("cannot convert %s to %s", fn.Type(), t)
+	}
+
+	numOut := t.NumOut()
+	returnsErr := numOut > 0 && t.Out(numOut-1) == errorType
+	if numOut > 2 || (numOut == 2 && !returnsErr) {
+		return reflect.Value{}, fmt.Errorf("cannot convert %s to %s: must return at most one value and an optional error", fn.Type(), t)
+	}
+
+	return reflect.MakeFunc(t, func(in []reflect.Value) []reflect.Value {
+		fail := func(err *Error) []reflect.Value {
+			if !returnsErr {
+				panic(callbackPanic{err})
+			}
+			out := make([]reflect.Value, numOut)
+			for i := 0; i < numOut-1; i++ {
+				out[i] = reflect.Zero(t.Out(i))
+			}
+			out[numOut-1] = reflect.ValueOf(error(err))
+			return out
+		}
+
+		// 可変長引数はスライスで渡されるので展開する
+		if t.IsVariadic() {
+			last := in[len(in)-1]
+			in = in[:len(in)-1]
+			for i := 0; i < last.Len(); i++ {
+				in = append(in, last.Index(i))
+			}
+		}
+		args := make([]Object, len(in))
+		for i, v := range in {
+			arg, err := fromValue(v)
+			if err != nil {
+				return fail(&Error{Message: fmt.Sprintf("argument %d: %s", i+1, err)})
+			}
+			args[i] = arg
+		}
+
+		var result Object
+		if call != nil {
+			result = call(fn, args...)
+		} else {
+			result = fn.(*Builtin).Fn(args...)
+		}
+		if errObj, ok := result.(*Error); ok {
+			return fail(errObj)
+		}
+
+		var out []reflect.Value
+		if numOut == 2 || (numOut == 1 && !returnsErr) {
+			v, err := toValue(result, t.Out(0), call)
+			if err != nil {
+				return fail(&Error{Message: fmt.Sprintf("result: %s", err)})
+			}
+			out = append(out, v)
+		}
+		if returnsErr {
+			out = append(out, reflect.Zero(errorType))
+		}
+		return out
+	}), nil
+}
+
+// asError Go の関数が返した error をエラーオブジェクトにする。言語の関数のエラーならそのまま返す。
+func asError(err error) *Error {
+	var errObj *Error
+	if errors.As(err, &errObj) {
+		return errObj
+	}
+	return &Error{Message: err.Error()}
+}
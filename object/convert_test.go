@@ -0,0 +1,260 @@
+package object
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFromGo(t *testing.T) {
+	type myInt int32
+
+	tests := []struct {
+		input    any
+		expected Object
+	}{
+		{nil, NullValue},
+		{5, &Integer{Value: 5}},
+		{int8(-3), &Integer{Value: -3}},
+		{uint16(7), &Integer{Value: 7}},
+		{myInt(42), &Integer{Value: 42}},
+		{true, TrueValue},
+		{false, FalseValue},
+		{(*int)(nil), NullValue},
+		{&Integer{Value: 1}, &Integer{Value: 1}},
+		{"hi", &String{Value: "hi"}},
+		{1.5, &Float{Value: 1.5}},
+		{float32(2), &Float{Value: 2}},
+		{[]int{1, 2}, &Array{Elems: []Object{&Integer{Value: 1}, &Integer{Value: 2}}}},
+		{[2]string{"a", "b"}, &Array{Elems: []Object{&String{Value: "a"}, &String{Value: "b"}}}},
+		{[]int(nil), NullValue},
+		{map[string]int{"b": 2, "a": 1}, &Map{Keys: []string{"a", "b"}, Values: map[string]Object{"a": &Integer{Value: 1}, "b": &Integer{Value: 2}}}},
+		{map[string]bool(nil), NullValue},
+		{struct {
+			A    int `sl:"a"`
+			Name string
+			Skip bool `sl:"-"`
+			priv int
+		}{1, "x", true, 2}, &Map{Keys: []string{"a", "Name"}, Values: map[string]Object{"a": &Integer{Value: 1}, "Name": &String{Value: "x"}}}},
+	}
+
+	for _, test := range tests {
+		obj, err := FromGo(test.input)
+		if err != nil {
+			t.Errorf("FromGo(%#v) returned error: %s", test.input, err)
+			continue
+		}
+		if obj.Type() != test.expected.Type() || obj.Inspect() != test.expected.Inspect() {
+			t.Errorf("FromGo(%#v) wrong. expected=%s, got=%s", test.input, test.expected.Inspect(), obj.Inspect())
+		}
+	}
+
+	n := 3
+	obj, err := FromGo(&n)
+	if err != nil || obj.Inspect() != "3" {
+		t.Errorf("FromGo(&n) wrong. got=%v, err=%v", obj, err)
+	}
+
+	// 真偽値は共有インスタンスでなければならない
+	if obj, _ := FromGo(true); obj != TrueValue {
+		t.Errorf("FromGo(true) is not TrueValue. got=%p", obj)
+	}
+}
+
+func TestFromGoErrors(t *testing.T) {
+	tests := []any{
+		uint64(1) << 63,
+		map[int]int{1: 2},
+		[]any{1, make(chan int)},
+		struct{ C chan int }{},
+	}
+
+	for _, test := range tests {
+		if _, err := FromGo(test); err == nil {
+			t.Errorf("FromGo(%#v) expected error", test)
+		}
+	}
+}
+
+func TestToGo(t *testing.T) {
+	var i int
+	if err := ToGo(&Integer{Value: 10}, &i); err != nil || i != 10 {
+		t.Errorf("ToGo int wrong. got=%d, err=%v", i, err)
+	}
+
+	var i8 int8
+	if err := ToGo(&Integer{Value: 300}, &i8); err == nil {
+		t.Errorf("ToGo int8 expected overflow error. got=%d", i8)
+	}
+
+	var u uint
+	if err := ToGo(&Integer{Value: -1}, &u); err == nil {
+		t.Errorf("ToGo uint expected range error. got=%d", u)
+	}
+
+	var b bool
+	if err := ToGo(TrueValue, &b); err != nil || !b {
+		t.Errorf("ToGo bool wrong. got=%t, err=%v", b, err)
+	}
+
+	if err := ToGo(TrueValue, &i); err == nil {
+		t.Errorf("ToGo BOOLEAN into int expected error")
+	}
+
+	var p *int
+	if err := ToGo(&Integer{Value: 4}, &p); err != nil || p == nil || *p != 4 {
+		t.Errorf("ToGo *int wrong. got=%v, err=%v", p, err)
+	}
+	if err := ToGo(NullValue, &p); err != nil || p != nil {
+		t.Errorf("ToGo NullValue into *int wrong. got=%v, err=%v", p, err)
+	}
+
+	var a any
+	if err := ToGo(&Integer{Value: 8}, &a); err != nil || a != int64(8) {
+		t.Errorf("ToGo any wrong. got=%#v, err=%v", a, err)
+	}
+
+	var o Object
+	if err := ToGo(FalseValue, &o); err != nil || o != FalseValue {
+		t.Errorf("ToGo Object wrong. got=%v, err=%v", o, err)
+	}
+
+	if err := ToGo(TrueValue, b); err == nil {
+		t.Errorf("ToGo into non-pointer expected error")
+	}
+}
+
+type testConfig struct {
+	Name    string            `sl:"name"`
+	Port    int               `sl:"port"`
+	Ratio   float64           `sl:"ratio"`
+	Tags    []string          `sl:"tags"`
+	Limits  map[string]int64  `sl:"limits"`
+	Inner   *testInner        `sl:"inner"`
+	Labels  map[string]string `sl:"labels"`
+	Ignored string            `sl:"-"`
+}
+
+type testInner struct {
+	Enabled bool `sl:"enabled"`
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []any{
+		"héllo",
+		int64(-7),
+		2.5,
+		[]string{"a", "b"},
+		[]int64{},
+		[3]bool{true, false, true},
+		map[string]int{"x": 1, "y": 2},
+		[]map[string][]int{{"a": {1, 2}}, {}},
+		map[string]any{"a": []any{int64(1), "x", map[string]any{"b": true, "n": nil}}, "c": 2.5},
+		[]any{[]any{}, map[string]any{}, []any{[]any{int64(2)}}},
+		testConfig{
+			Name:   "svc",
+			Port:   8080,
+			Ratio:  0.5,
+			Tags:   []string{"a"},
+			Limits: map[string]int64{"cpu": 2},
+			Inner:  &testInner{Enabled: true},
+		},
+	}
+
+	for _, test := range tests {
+		obj, err := FromGo(test)
+		if err != nil {
+			t.Errorf("FromGo(%#v) returned error: %s", test, err)
+			continue
+		}
+		target := reflect.New(reflect.TypeOf(test))
+		if err := ToGo(obj, target.Interface()); err != nil {
+			t.Errorf("ToGo(%s) returned error: %s", obj.Inspect(), err)
+			continue
+		}
+		if got := target.Elem().Interface(); !reflect.DeepEqual(got, test) {
+			t.Errorf("round trip wrong. expected=%#v, got=%#v", test, got)
+		}
+	}
+}
+
+func TestToGoComposite(t *testing.T) {
+	m := NewMap()
+	m.Set("name", &String{Value: "svc"})
+	m.Set("port", &Integer{Value: 80})
+	m.Set("unknown", TrueValue)
+	var cfg testConfig
+	if err := ToGo(m, &cfg); err != nil || cfg.Name != "svc" || cfg.Port != 80 || cfg.Tags != nil {
+		t.Errorf("ToGo struct wrong. got=%+v, err=%v", cfg, err)
+	}
+
+	m.Set("port", &String{Value: "80"})
+	if err := ToGo(m, &cfg); err == nil || err.Error() != "field Port: cannot convert STRING to int" {
+		t.Errorf("ToGo struct expected field error. got=%v", err)
+	}
+
+	var f float64
+	if err := ToGo(&Integer{Value: 3}, &f); err != nil || f != 3 {
+		t.Errorf("ToGo INTEGER into float64 wrong. got=%g, err=%v", f, err)
+	}
+
+	var xs []int8
+	arr := &Array{Elems: []Object{&Integer{Value: 1}, &Integer{Value: 300}}}
+	if err := ToGo(arr, &xs); err == nil || err.Error() != "index 1: cannot convert 300 to int8: out of range" {
+		t.Errorf("ToGo []int8 expected index error. got=%v", err)
+	}
+
+	var pair [2]int
+	if err := ToGo(&Array{Elems: []Object{&Integer{Value: 1}}}, &pair); err == nil {
+		t.Errorf("ToGo [2]int expected length error")
+	}
+
+	var a any
+	if err := ToGo(&String{Value: "s"}, &a); err != nil || a != "s" {
+		t.Errorf("ToGo STRING into any wrong. got=%#v, err=%v", a, err)
+	}
+
+	// any には配列も連想配列も Go の値にして入れる
+	nested := NewMap()
+	nested.Set("xs", &Array{Elems: []Object{&Integer{Value: 1}, NullValue}})
+	expected := map[string]any{"xs": []any{int64(1), nil}}
+	if err := ToGo(nested, &a); err != nil || !reflect.DeepEqual(a, expected) {
+		t.Errorf("ToGo MAP into any wrong. expected=%#v, got=%#v, err=%v", expected, a, err)
+	}
+}
+
+func TestFuncConversion(t *testing.T) {
+	double, err := NewGoFunc(func(n int64) int64 { return n * 2 })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fn func(int64) int64
+	if err := ToGo(double, &fn); err != nil {
+		t.Fatalf("ToGo BUILTIN into func returned error: %s", err)
+	}
+	if got := fn(21); got != 42 {
+		t.Errorf("converted func wrong. expected=42, got=%d", got)
+	}
+
+	var fnErr func(string) (int64, error)
+	if err := ToGo(double, &fnErr); err != nil {
+		t.Fatalf("ToGo BUILTIN into func returned error: %s", err)
+	}
+	if _, err := fnErr("x"); err == nil || err.Error() != "argument 1: cannot convert STRING to int64" {
+		t.Errorf("converted func expected error. got=%v", err)
+	}
+
+	// 言語の関数は評価器がないと呼べない
+	if err := ToGo(&Function{}, &fn); err == nil {
+		t.Errorf("ToGo FUNCTION without evaluator expected error")
+	}
+
+	obj, err := FromGo(strings.ToUpper)
+	if err != nil {
+		t.Fatalf("FromGo(func) returned error: %s", err)
+	}
+	if got := obj.(*Builtin).Fn(&String{Value: "go"}); got.Inspect() != "GO" {
+		t.Errorf("FromGo(func) wrong. expected=GO, got=%s", got.Inspect())
+	}
+}
@@ -0,0 +1,101 @@
+package object
+
+import (
+	"fmt"
+	"reflect"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// NewGoFunc 任意の Go の関数を組み込み関数にする。
+// 引数と返り値は FromGo と ToGo の規則で変換し、最後の返り値が error ならエラーオブジェクトとして返す。
+// 関数型の引数には言語の関数も渡せる。評価器から CallFn で呼ばれたときだけ、その評価器で呼び出す。
+func NewGoFunc(fn any) (*Builtin, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return nil, fmt.Errorf("not a function: %T", fn)
+	}
+	return goFunc(v)
+}
+
+func goFunc(fn reflect.Value) (*Builtin, error) {
+	t := fn.Type()
+
+	numOut := t.NumOut()
+	returnsErr := numOut > 0 && t.Out(numOut-1) == errorType
+	if numOut > 2 || (numOut == 2 && !returnsErr) {
+		return nil, fmt.Errorf("function %s must return at most one value and an optional error", t)
+	}
+
+	callFn := func(call Caller, args ...Object) (result Object) {
+		in, errObj := goArgs(t, args, call)
+		if errObj != nil {
+			return errObj
+		}
+
+		// 引数に渡した言語の関数がエラーになると、error を返さない関数からは panic で抜けてくる
+		defer func() {
+			if r := recover(); r != nil {
+				cp, ok := r.(callbackPanic)
+				if !ok {
+					panic(r)
+				}
+				result = cp.err
+			}
+		}()
+
+		out := fn.Call(in)
+		if returnsErr {
+			if err := out[len(out)-1]; !err.IsNil() {
+				return asError(err.Interface().(error))
+			}
+			out = out[:len(out)-1]
+		}
+
+		if len(out) == 0 {
+			return NullValue
+		}
+
+		result, err := fromValue(out[0])
+		if err != nil {
+			return &Error{Message: err.Error()}
+		}
+		return result
+	}
+
+	return &Builtin{
+		Fn:     func(args ...Object) Object { return callFn(nil, args...) },
+		CallFn: callFn,
+	}, nil
+}
+
+// goArgs 呼び出し時の引数を関数の引数の型に合わせて変換する。言語の関数は call で呼ぶ Go の関数になる。
+func goArgs(t reflect.Type, args []Object, call Caller) ([]reflect.Value, *Error) {
+	numIn := t.NumIn()
+
+	if t.IsVariadic() {
+		if len(args) < numIn-1 {
+			return nil, &Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want at least %d", len(args), numIn-1)}
+		}
+	} else if len(args) != numIn {
+		return nil, &Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=%d", len(args), numIn)}
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var pt reflect.Type
+		if t.IsVariadic() && i >= numIn-1 {
+			pt = t.In(numIn - 1).Elem()
+		} else {
+			pt = t.In(i)
+		}
+
+		v, err := toValue(arg, pt, call)
+		if err != nil {
+			return nil, &Error{Message: fmt.Sprintf("argument %d: %s", i+1, err)}
+		}
+		in[i] = v
+	}
+
+	return in, nil
+}
@@ -1,6 +1,16 @@
 package object
 
-import "fmt"
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ei1chi/sample-lang/ast"
+	"github.com/ei1chi/sample-lang/code"
+	"github.com/ei1chi/sample-lang/token"
+)
 
 type ObjectType string
 
@@ -10,6 +20,26 @@ const (
 	NULL         = "NULL"
 	RETURN_VALUE = "RETURN_VALUE"
 	ERROR        = "ERROR"
+	FUNCTION     = "FUNCTION"
+	BUILTIN      = "BUILTIN"
+	STRING       = "STRING"
+	ERROR_VALUE  = "ERROR_VALUE"
+	MODULE       = "MODULE"
+	ARRAY        = "ARRAY"
+	MAP          = "MAP"
+	FLOAT        = "FLOAT"
+	REGEX        = "REGEX"
+	TIME         = "TIME"
+	DURATION     = "DURATION"
+
+	COMPILED_FUNCTION = "COMPILED_FUNCTION"
+)
+
+// 真偽値と null は同じインスタンスを共有する。
+var (
+	TrueValue  = &Boolean{Value: true}
+	FalseValue = &Boolean{Value: false}
+	NullValue  = &Null{}
 )
 
 type Object interface {
@@ -25,6 +55,22 @@ func (i *Integer) Inspect() string { return fmt.Sprintf("%d", i.Value) }
 
 func (i *Integer) Type() ObjectType { return INTEGER }
 
+// Float 浮動小数点数。リテラルはなく、JSON の数などから作られる。
+type Float struct {
+	Value float64
+}
+
+// Inspect 整数と見分けられるよう、小数点も指数もなければ ".0" を付ける。
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
+func (f *Float) Type() ObjectType { return FLOAT }
+
 type Boolean struct {
 	Value bool
 }
@@ -39,6 +85,84 @@ func (n *Null) Type() ObjectType { return NULL }
 
 func (n *Null) Inspect() string { return "null" }
 
+type String struct {
+	Value string
+}
+
+func (s *String) Type() ObjectType { return STRING }
+
+func (s *String) Inspect() string { return s.Value }
+
+// Array 配列。要素は作ったあとで変わらない。
+type Array struct {
+	Elems []Object
+}
+
+func (a *Array) Type() ObjectType { return ARRAY }
+
+func (a *Array) Inspect() string {
+	elems := make([]string, len(a.Elems))
+	for i, e := range a.Elems {
+		elems[i] = e.Inspect()
+	}
+	return "[" + strings.Join(elems, ", ") + "]"
+}
+
+// Map 文字列をキーにした連想配列。キーは入れた順に並ぶ。
+type Map struct {
+	Keys   []string
+	Values map[string]Object
+}
+
+func NewMap() *Map {
+	return &Map{Values: make(map[string]Object)}
+}
+
+// Set key に val を対応させる。新しいキーなら最後に加える。
+func (m *Map) Set(key string, val Object) {
+	if _, ok := m.Values[key]; !ok {
+		m.Keys = append(m.Keys, key)
+	}
+	m.Values[key] = val
+}
+
+func (m *Map) Type() ObjectType { return MAP }
+
+func (m *Map) Inspect() string {
+	pairs := make([]string, len(m.Keys))
+	for i, key := range m.Keys {
+		pairs[i] = key + ": " + m.Values[key].Inspect()
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// Regex コンパイルした正規表現。
+type Regex struct {
+	Value *regexp.Regexp
+}
+
+func (r *Regex) Type() ObjectType { return REGEX }
+
+func (r *Regex) Inspect() string { return fmt.Sprintf("regex %q", r.Value.String()) }
+
+// Time 時刻。タイムゾーンも持つ。
+type Time struct {
+	Value time.Time
+}
+
+func (t *Time) Type() ObjectType { return TIME }
+
+func (t *Time) Inspect() string { return t.Value.Format(time.RFC3339Nano) }
+
+// Duration 時間の長さ。ナノ秒単位の整数。
+type Duration struct {
+	Value time.Duration
+}
+
+func (d *Duration) Type() ObjectType { return DURATION }
+
+func (d *Duration) Inspect() string { return d.Value.String() }
+
 // ReturnValue 返り値を上流に返していくオブジェクト。
 type ReturnValue struct {
 	Value Object
@@ -48,10 +172,159 @@ func (r *ReturnValue) Type() ObjectType { return RETURN_VALUE }
 
 func (r *ReturnValue) Inspect() string { return r.Value.Inspect() }
 
+// ErrorKind エラーの種類。空文字列は通常の実行時エラーを表す。
+type ErrorKind string
+
+const (
+	STEP_LIMIT_EXCEEDED  ErrorKind = "step limit exceeded"
+	DEPTH_LIMIT_EXCEEDED ErrorKind = "call depth limit exceeded"
+	ALLOC_LIMIT_EXCEEDED ErrorKind = "allocation limit exceeded"
+	CONTEXT_CANCELED     ErrorKind = "context canceled"
+	PERMISSION_DENIED    ErrorKind = "permission denied"
+)
+
 type Error struct {
+	Kind    ErrorKind
 	Message string
+	Pos     token.Position // エラーが起きた式の位置。分からなければゼロ値
+	Stack   []StackFrame   // エラーが起きたときに呼び出し中だった関数。内側から順に並ぶ
+}
+
+// StackFrame 呼び出し中の関数と、その中で評価していた位置。
+// 一番内側ではエラーが起きた位置、それ以外では内側の関数を呼び出した位置になる。
+type StackFrame struct {
+	Name string // 関数の名前。名前で呼ばなかった関数なら空
+	Pos  token.Position
+	File string // Pos のあるモジュールのファイル。評価したプログラムそのものなら空
 }
 
 func (e *Error) Type() ObjectType { return ERROR }
 
 func (e *Error) Inspect() string { return "ERROR: " + e.Message }
+
+// Error error として Go の関数から返せるようにする。
+func (e *Error) Error() string { return e.Message }
+
+// StackTrace Stack を "    at fib (file:4:10)" の形で 1 行ずつ並べる。
+// file は評価したプログラムのファイル名で、モジュールの中のフレームにはそのモジュールのファイル名を使う。
+func (e *Error) StackTrace(file string) string {
+	var out strings.Builder
+	for _, f := range e.Stack {
+		out.WriteString("    " + f.Describe(file) + "\n")
+	}
+	return out.String()
+}
+
+// Describe "at fib (file:4:10)" の形。モジュールの外のフレームで file が空なら位置だけを書く。
+func (f StackFrame) Describe(file string) string {
+	name := f.Name
+	if name == "" {
+		name = "<anonymous>"
+	}
+	if f.File != "" {
+		file = f.File
+	}
+	loc := f.Pos.String()
+	if file != "" {
+		loc = file + ":" + loc
+	}
+	return fmt.Sprintf("at %s (%s)", name, loc)
+}
+
+// Catchable try で捕まえてよいエラーか。実行制限によるエラーは捕まえられない。
+func (e *Error) Catchable() bool {
+	switch e.Kind {
+	case STEP_LIMIT_EXCEEDED, DEPTH_LIMIT_EXCEEDED, ALLOC_LIMIT_EXCEEDED, CONTEXT_CANCELED:
+		return false
+	}
+	return true
+}
+
+// ErrorValue 値としてのエラー。catch で受け取るか error() で作る。
+// 評価を止めずに変数に入れたり渡したりでき、? で呼び出し元に返せる。
+type ErrorValue struct {
+	Err *Error
+}
+
+func (e *ErrorValue) Type() ObjectType { return ERROR_VALUE }
+
+func (e *ErrorValue) Inspect() string { return "error: " + e.Err.Message }
+
+// Module import したモジュール。Exports は export した変数とその値。
+type Module struct {
+	Path    string
+	Exports map[string]Object
+}
+
+func (m *Module) Type() ObjectType { return MODULE }
+
+func (m *Module) Inspect() string { return fmt.Sprintf("module %q", m.Path) }
+
+type Function struct {
+	Params []*ast.Ident
+	Body   *ast.BlockStmt
+	Env    *Environment
+	File   string // 定義したモジュールのファイル。評価したプログラムそのものなら空
+}
+
+func (f *Function) Type() ObjectType { return FUNCTION }
+
+func (f *Function) Inspect() string {
+	var out strings.Builder
+
+	params := []string{}
+	for _, p := range f.Params {
+		params = append(params, p.String())
+	}
+
+	out.WriteString("fn(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") {\n")
+	out.WriteString(f.Body.String())
+	out.WriteString("\n}")
+
+	return out.String()
+}
+
+type BuiltinFunction func(args ...Object) Object
+
+// Caller 組み込み関数から、引数に受け取った言語の関数を呼ぶ。評価器が用意する。
+type Caller func(fn Object, args ...Object) Object
+
+type Builtin struct {
+	Fn BuiltinFunction
+
+	// CallFn 言語の関数を呼ぶことのある組み込み関数。評価器は nil でなければ Fn の代わりにこちらを呼ぶ。
+	CallFn func(call Caller, args ...Object) Object
+}
+
+func (b *Builtin) Type() ObjectType { return BUILTIN }
+
+func (b *Builtin) Inspect() string { return "builtin function" }
+
+// CompiledFunction コンパイル済みの関数本体。定数プールに置かれる。
+type CompiledFunction struct {
+	Instructions code.Instructions
+	Positions    code.PosTable
+	NumLocals    int
+	NumParams    int
+	Name         string // let で束縛した名前。スタックトレースに使う。無名の関数なら空
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION }
+
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+// Closure 仮想マシン上の関数。言語からは Function と同じく関数として見える。
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+}
+
+func (c *Closure) Type() ObjectType { return FUNCTION }
+
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
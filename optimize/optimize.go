@@ -0,0 +1,294 @@
+package optimize
+
+import (
+	"strconv"
+
+	"github.com/ei1chi/sample-lang/ast"
+	"github.com/ei1chi/sample-lang/token"
+)
+
+// Program 定数式を畳み込み、条件が定数の if の使われない分岐を取り除く。
+// program をその場で書き換えて返す。
+// 実行時エラーになる式（0 除算や型の合わない演算）は畳み込まず、そのまま残す。
+func Program(program *ast.Program) *ast.Program {
+	program.Stmts = stmts(program.Stmts)
+	return program
+}
+
+func stmts(list []ast.Stmt) []ast.Stmt {
+	result := []ast.Stmt{}
+
+	for i, s := range list {
+		s = stmt(s)
+
+		// 文としての if は、選ばれる分岐の文をそのまま並べる。
+		// ブロックはスコープを作らないので、最後の文の値が変わらなければ意味は同じ
+		if es, ok := s.(*ast.ExprStmt); ok {
+			if ie, ok := es.Expr.(*ast.IfExpr); ok {
+				if chosen, ok := chooseBranch(ie); ok {
+					last := i == len(list)-1
+					if !last || endsWithValue(chosen) {
+						if chosen != nil {
+							result = append(result, chosen.Stmts...)
+						}
+						continue
+					}
+				}
+			}
+		}
+
+		result = append(result, s)
+	}
+
+	return result
+}
+
+func stmt(s ast.Stmt) ast.Stmt {
+	switch s := s.(type) {
+	case *ast.LetStmt:
+		s.Value = expr(s.Value)
+	case *ast.ReturnStmt:
+		s.ReturnValue = expr(s.ReturnValue)
+	case *ast.ExprStmt:
+		s.Expr = expr(s.Expr)
+	case *ast.ThrowStmt:
+		s.Value = expr(s.Value)
+	case *ast.ExportStmt:
+		s.Let.Value = expr(s.Let.Value)
+	case *ast.BlockStmt:
+		return block(s)
+	}
+	return s
+}
+
+func block(b *ast.BlockStmt) *ast.BlockStmt {
+	if b != nil {
+		b.Stmts = stmts(b.Stmts)
+	}
+	return b
+}
+
+func expr(e ast.Expr) ast.Expr {
+	switch e := e.(type) {
+	case *ast.PrefixExpr:
+		e.Right = expr(e.Right)
+		if folded := foldPrefix(e); folded != nil {
+			return folded
+		}
+
+	case *ast.PostfixExpr:
+		e.Left = expr(e.Left)
+
+	case *ast.SelectorExpr:
+		e.X = expr(e.X)
+
+	case *ast.InfixExpr:
+		e.Left = expr(e.Left)
+		e.Right = expr(e.Right)
+		if folded := foldInfix(e); folded != nil {
+			return folded
+		}
+
+	case *ast.IfExpr:
+		return ifExpr(e)
+
+	case *ast.TryExpr:
+		e.Body = block(e.Body)
+		e.Catch = block(e.Catch)
+		e.Finally = block(e.Finally)
+
+	case *ast.FuncLiteral:
+		e.Body = block(e.Body)
+
+	case *ast.CallExpr:
+		e.Fn = expr(e.Fn)
+		for i, a := range e.Args {
+			e.Args[i] = expr(a)
+		}
+
+	case *ast.IndexExpr:
+		e.Left = expr(e.Left)
+		e.Index = expr(e.Index)
+
+	case *ast.ArrayLiteral:
+		for i, elem := range e.Elems {
+			e.Elems[i] = expr(elem)
+		}
+
+	case *ast.MapLiteral:
+		for i := range e.Keys {
+			e.Keys[i] = expr(e.Keys[i])
+			e.Values[i] = expr(e.Values[i])
+		}
+	}
+
+	return e
+}
+
+func ifExpr(ie *ast.IfExpr) ast.Expr {
+	ie.Cond = expr(ie.Cond)
+	ie.Cons = block(ie.Cons)
+	ie.Alt = block(ie.Alt)
+
+	chosen, ok := chooseBranch(ie)
+	if !ok {
+		return ie
+	}
+
+	// 式が一つだけのブロックなら、その式がそのまま if の値になる
+	if chosen != nil && len(chosen.Stmts) == 1 {
+		if es, ok := chosen.Stmts[0].(*ast.ExprStmt); ok && es.Expr != nil {
+			return es.Expr
+		}
+	}
+
+	// 値は変えずに、使われない分岐だけ落とす
+	if chosen == nil {
+		return &ast.IfExpr{
+			Token: ie.Token,
+			Cond:  newBoolean(false, ie.Cond.Pos()),
+			Cons:  &ast.BlockStmt{Token: ie.Cons.Token, Stmts: []ast.Stmt{}},
+		}
+	}
+	return &ast.IfExpr{
+		Token: ie.Token,
+		Cond:  newBoolean(true, ie.Cond.Pos()),
+		Cons:  chosen,
+	}
+}
+
+// chooseBranch 条件がリテラルなら実行される分岐を返す。else のない偽の if は nil を返す。
+func chooseBranch(ie *ast.IfExpr) (*ast.BlockStmt, bool) {
+	var truthy bool
+
+	switch cond := ie.Cond.(type) {
+	case *ast.Boolean:
+		truthy = cond.Value
+	case *ast.IntLiteral:
+		truthy = true
+	default:
+		return nil, false
+	}
+
+	if truthy {
+		return ie.Cons, true
+	}
+	return ie.Alt, true
+}
+
+// endsWithValue ブロックを並べ直しても、最後の文の値が if の値と同じになるか。
+func endsWithValue(b *ast.BlockStmt) bool {
+	if b == nil || len(b.Stmts) == 0 {
+		return false
+	}
+
+	switch b.Stmts[len(b.Stmts)-1].(type) {
+	case *ast.ExprStmt, *ast.ReturnStmt:
+		return true
+	}
+	return false
+}
+
+// =========================================
+// Folding
+// =========================================
+
+func foldPrefix(p *ast.PrefixExpr) ast.Expr {
+	switch right := p.Right.(type) {
+	case *ast.IntLiteral:
+		switch p.Operator {
+		case "-":
+			return newInt(-right.Value, p.Pos())
+		case "!":
+			// 整数はすべて真
+			return newBoolean(false, p.Pos())
+		}
+
+	case *ast.Boolean:
+		if p.Operator == "!" {
+			return newBoolean(!right.Value, p.Pos())
+		}
+	}
+
+	return nil
+}
+
+func foldInfix(i *ast.InfixExpr) ast.Expr {
+	pos := i.Left.Pos()
+
+	switch left := i.Left.(type) {
+	case *ast.IntLiteral:
+		if right, ok := i.Right.(*ast.IntLiteral); ok {
+			return foldIntegerInfix(i.Operator, left.Value, right.Value, pos)
+		}
+	}
+
+	if !isLiteral(i.Left) || !isLiteral(i.Right) {
+		return nil
+	}
+
+	// 整数どうし以外の == と != は、真偽値なら値、それ以外は型が違うので偽になる
+	switch i.Operator {
+	case "==":
+		return newBoolean(sameLiteral(i.Left, i.Right), pos)
+	case "!=":
+		return newBoolean(!sameLiteral(i.Left, i.Right), pos)
+	}
+
+	return nil
+}
+
+func foldIntegerInfix(ope string, lval, rval int64, pos token.Position) ast.Expr {
+	switch ope {
+	case "+":
+		return newInt(lval+rval, pos)
+	case "-":
+		return newInt(lval-rval, pos)
+	case "*":
+		return newInt(lval*rval, pos)
+	case "/":
+		if rval == 0 {
+			return nil
+		}
+		return newInt(lval/rval, pos)
+
+	case "<":
+		return newBoolean(lval < rval, pos)
+	case ">":
+		return newBoolean(lval > rval, pos)
+	case "==":
+		return newBoolean(lval == rval, pos)
+	case "!=":
+		return newBoolean(lval != rval, pos)
+	}
+	return nil
+}
+
+func isLiteral(e ast.Expr) bool {
+	switch e.(type) {
+	case *ast.IntLiteral, *ast.Boolean:
+		return true
+	}
+	return false
+}
+
+func sameLiteral(left, right ast.Expr) bool {
+	l, lok := left.(*ast.Boolean)
+	r, rok := right.(*ast.Boolean)
+	return lok && rok && l.Value == r.Value
+}
+
+func newInt(value int64, pos token.Position) *ast.IntLiteral {
+	return &ast.IntLiteral{
+		Token: token.Token{Type: token.INT, Literal: strconv.FormatInt(value, 10), Pos: pos},
+		Value: value,
+	}
+}
+
+func newBoolean(value bool, pos token.Position) *ast.Boolean {
+	tok := token.Token{Type: token.FALSE, Literal: "false", Pos: pos}
+	if value {
+		tok = token.Token{Type: token.TRUE, Literal: "true", Pos: pos}
+	}
+	return &ast.Boolean{Token: tok, Value: value}
+}
@@ -0,0 +1,97 @@
+package optimize
+
+import (
+	"testing"
+
+	"github.com/ei1chi/sample-lang/ast"
+	"github.com/ei1chi/sample-lang/eval"
+	"github.com/ei1chi/sample-lang/lexer"
+	"github.com/ei1chi/sample-lang/object"
+	"github.com/ei1chi/sample-lang/parser"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	l := lexer.NewLexer(input)
+	p := parser.NewParser(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+func TestProgram(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 + 2 * 3", "7"},
+		{"-(2 - 5)", "3"},
+		{"!true; !5; !!false", "falsefalsefalse"},
+		{"1 < 2 == true", "true"},
+		{"true != false", "true"},
+		{"1 == true", "false"},
+		{"let x = 10 / 2; x * (2 + 2)", "let x = 5;(x * 4)"},
+		{"fn(a) { a + (2 * 3) }", "fn(a) (a + 6)"},
+		{"f(1 + 1, 2 > 3)", "f(2, false)"},
+		{"[1 + 1, -2][0 * 1]", "([2, -2][0])"},
+
+		// 実行時エラーになる式は残す
+		{"1 / 0", "(1 / 0)"},
+		{"1 / (2 - 2)", "(1 / 0)"},
+		{"-true", "(-true)"},
+		{"true + 1", "(true + 1)"},
+		{"true < false", "(true < false)"},
+
+		// 定数条件の if
+		{"if (true) { 1 } else { 2 }", "1"},
+		{"if (1 > 2) { 1 } else { 2 }", "2"},
+		{"let x = if (0) { 1 } else { 2 }; x", "let x = 1;x"},
+		{"if (false) { a }; b", "b"},
+		{"if (true) { let a = 1; a }", "let a = 1;a"},
+		{"if (true) { let a = 1; }", "iftrue let a = 1;"},
+		{"if (false) { a }", "iffalse "},
+		{"let f = fn() { if (false) { return 1 }; 2 }", "let f = fn() 2;"},
+		{"if (x) { 1 + 1 } else { if (true) { 3 } }", "ifx 2else 3"},
+	}
+
+	for _, test := range tests {
+		program := Program(parse(t, test.input))
+		if program.String() != test.expected {
+			t.Errorf("%q: expected=%q, got=%q", test.input, test.expected, program.String())
+		}
+	}
+}
+
+// 最適化の前後で評価結果が変わらないことを確かめる。
+func TestPreservesSemantics(t *testing.T) {
+	inputs := []string{
+		"1 + 2 * 3 - 4 / 2",
+		"let x = 2; x * (3 + 4)",
+		"if (1 < 2) { 10 } else { 20 }",
+		"if (false) { 10 }",
+		"if (true) { let a = 5; } ",
+		"let f = fn(n) { if (true) { return n * 2 }; 0 }; f(4)",
+		"let f = fn() { if (1 > 2) { 1 } }; f()",
+		"if (false) { 1 }; if (true) { 2 }; 3",
+		"1 / 0",
+		"-true",
+		"true + 1 * 2",
+		"!(1 == 1)",
+	}
+
+	for _, input := range inputs {
+		expected := eval.Eval(parse(t, input), object.NewEnvironment())
+		got := eval.Eval(Program(parse(t, input)), object.NewEnvironment())
+
+		if expected == nil || got == nil {
+			if expected != got {
+				t.Errorf("%q: expected=%v, got=%v", input, expected, got)
+			}
+			continue
+		}
+		if expected.Inspect() != got.Inspect() {
+			t.Errorf("%q: expected=%s, got=%s", input, expected.Inspect(), got.Inspect())
+		}
+	}
+}
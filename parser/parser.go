@@ -2,9 +2,11 @@ package parser
 
 import (
 	"fmt"
+	"path"
 	"strconv"
 
 	"github.com/ei1chi/sample-lang/ast"
+	"github.com/ei1chi/sample-lang/cst"
 	"github.com/ei1chi/sample-lang/lexer"
 	"github.com/ei1chi/sample-lang/token"
 )
@@ -17,12 +19,19 @@ type (
 type Parser struct {
 	l      *lexer.Lexer
 	errors []string
+	errPos []token.Position // errors と同じ順の位置
 
 	curToken  token.Token
 	peekToken token.Token
 
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
+
+	blocks int // 解析中のブロックの深さ。0 ならプログラムの直下
+
+	// ParseCST のときだけ使う
+	cst        *cst.Builder
+	curEmitted bool // curToken を cst に記録したか
 }
 
 func NewParser(l *lexer.Lexer) *Parser {
@@ -41,13 +50,17 @@ func NewParser(l *lexer.Lexer) *Parser {
 	prefixes := map[token.TokenType]prefixParseFn{
 		token.IDENT:    p.parseIdent,
 		token.INT:      p.parseIntLiteral,
+		token.STRING:   p.parseStringLiteral,
 		token.BANG:     p.parsePrefixExpr,
 		token.MINUS:    p.parsePrefixExpr,
 		token.TRUE:     p.parseBoolean,
 		token.FALSE:    p.parseBoolean,
 		token.LPAREN:   p.parseGroupedExpr,
 		token.IF:       p.parseIfExpr,
+		token.TRY:      p.parseTryExpr,
 		token.FUNCTION: p.parseFuncLiteral,
+		token.LBRACKET: p.parseArrayLiteral,
+		token.LBRACE:   p.parseMapLiteral,
 	}
 	for tok, fn := range prefixes {
 		p.prefixParseFns[tok] = fn
@@ -66,6 +79,9 @@ func NewParser(l *lexer.Lexer) *Parser {
 		token.LT:       p.parseInfixExpr,
 		token.GT:       p.parseInfixExpr,
 		token.LPAREN:   p.parseCallExpr,
+		token.QUESTION: p.parsePostfixExpr,
+		token.DOT:      p.parseSelectorExpr,
+		token.LBRACKET: p.parseIndexExpr,
 	}
 	for tok, fn := range infixes {
 		p.infixParseFns[tok] = fn
@@ -78,19 +94,103 @@ func (p *Parser) Errors() []string {
 	return p.errors
 }
 
+// Error 位置つきの構文エラー。
+type Error struct {
+	Pos     token.Position
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+}
+
+// ErrorList Errors と同じエラーを位置つきで返す。
+func (p *Parser) ErrorList() []*Error {
+	list := make([]*Error, len(p.errors))
+	for i, msg := range p.errors {
+		list[i] = &Error{Pos: p.errPos[i], Message: msg}
+	}
+	return list
+}
+
+func (p *Parser) errorAt(pos token.Position, msg string) {
+	p.errors = append(p.errors, msg)
+	p.errPos = append(p.errPos, pos)
+}
+
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
 	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	p.errorAt(p.curToken.Pos, msg)
 }
 
 func (p *Parser) peekError(t token.TokenType) {
 	msg := fmt.Sprintf("expected next token to be %s, got %s instead", t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.errorAt(p.peekToken.Pos, msg)
 }
 
 func (p *Parser) nextToken() {
+	p.emitCurToken()
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+	p.curEmitted = false
+}
+
+// ParseCST ParseProgram の代わりに呼び、具象構文木を返す。AST は根の AST フィールドにある。
+// 字句解析器を lexer.NewLosslessLexer で作れば、木の Text は入力と一致する。
+func (p *Parser) ParseCST() *cst.Node {
+	p.cst = &cst.Builder{}
+
+	id := p.startNode()
+	program := p.ParseProgram()
+	p.finishNode(id, program) // EOF のトークンもここで記録する
+
+	return p.cst.Tree()
+}
+
+// emitCurToken curToken を読み終えたので cst に記録する。
+// ノードの最後のトークンは finishNode が先に記録する。
+func (p *Parser) emitCurToken() {
+	if p.cst == nil || p.curEmitted {
+		return
+	}
+	p.cst.Token(p.curToken)
+	p.curEmitted = true
+}
+
+// startNode curToken から始まるノードを開始する。
+func (p *Parser) startNode() int {
+	if p.cst == nil {
+		return 0
+	}
+	return p.cst.Start()
+}
+
+// finishNode curToken までをノードとして閉じる。種類は n から決める。
+func (p *Parser) finishNode(id int, n ast.Node) {
+	p.finishNodeKind(id, cst.KindOf(n), n)
+}
+
+func (p *Parser) finishNodeKind(id int, kind cst.Kind, n ast.Node) {
+	if p.cst == nil {
+		return
+	}
+	p.emitCurToken()
+	p.cst.Finish(id, kind, n)
+}
+
+func (p *Parser) markNode() int {
+	if p.cst == nil {
+		return 0
+	}
+	return p.cst.Mark()
+}
+
+// precedeNode mark から始まった式を左辺として包むノードを開始する。
+func (p *Parser) precedeNode(mark int) int {
+	if p.cst == nil {
+		return 0
+	}
+	return p.cst.Precede(mark)
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
@@ -109,14 +209,26 @@ func (p *Parser) ParseProgram() *ast.Program {
 }
 
 func (p *Parser) parseStmt() ast.Stmt {
+	id := p.startNode()
+
+	var stmt ast.Stmt
 	switch p.curToken.Type {
 	case token.LET:
-		return p.parseLetStmt()
+		stmt = p.parseLetStmt()
 	case token.RETURN:
-		return p.parseReturnStmt()
+		stmt = p.parseReturnStmt()
+	case token.THROW:
+		stmt = p.parseThrowStmt()
+	case token.IMPORT:
+		stmt = p.parseImportStmt()
+	case token.EXPORT:
+		stmt = p.parseExportStmt()
 	default:
-		return p.parseExprStmt()
+		stmt = p.parseExprStmt()
 	}
+
+	p.finishNode(id, stmt)
+	return stmt
 }
 
 func (p *Parser) parseLetStmt() *ast.LetStmt {
@@ -126,7 +238,15 @@ func (p *Parser) parseLetStmt() *ast.LetStmt {
 		return nil
 	}
 
-	stmt.Name = &ast.Ident{Token: p.curToken, Value: p.curToken.Literal}
+	stmt.Name = p.parseIdentNode()
+
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		stmt.Type = p.parseTypeAnnotation()
+		if stmt.Type == nil {
+			return nil
+		}
+	}
 
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
@@ -157,6 +277,78 @@ func (p *Parser) parseReturnStmt() *ast.ReturnStmt {
 	return stmt
 }
 
+func (p *Parser) parseThrowStmt() *ast.ThrowStmt {
+	stmt := &ast.ThrowStmt{Token: p.curToken}
+
+	p.nextToken()
+
+	stmt.Value = p.parseExpr(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// IMPORT STRING
+func (p *Parser) parseImportStmt() *ast.ImportStmt {
+	stmt := &ast.ImportStmt{Token: p.curToken}
+
+	if !p.expectPeek(token.STRING) {
+		return nil
+	}
+	id := p.startNode()
+	lit := p.parseStringLiteral()
+	p.finishNode(id, lit)
+	if lit == nil {
+		return nil
+	}
+	stmt.Path = lit.(*ast.StringLiteral)
+
+	// パスの最後の要素が束縛する名前になる
+	name := path.Base(stmt.Path.Value)
+	if !isIdent(name) {
+		p.errorAt(stmt.Path.Pos(), fmt.Sprintf("invalid import path %s: %q is not an identifier", stmt.Path, name))
+		return nil
+	}
+	stmt.Name = &ast.Ident{Token: token.Token{Type: token.IDENT, Literal: name, Pos: stmt.Path.Pos()}, Value: name}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// isIdent s 全体が一つの識別子として読めるか。
+func isIdent(s string) bool {
+	tok := lexer.NewLexer(s).NextToken()
+	return tok.Type == token.IDENT && tok.Literal == s
+}
+
+// EXPORT LetStmt
+func (p *Parser) parseExportStmt() *ast.ExportStmt {
+	stmt := &ast.ExportStmt{Token: p.curToken}
+
+	if p.blocks > 0 {
+		p.errorAt(p.curToken.Pos, "export is only allowed at the top level")
+	}
+
+	if !p.expectPeek(token.LET) {
+		return nil
+	}
+	id := p.startNode()
+	let := p.parseLetStmt()
+	p.finishNode(id, let)
+	if let == nil {
+		return nil
+	}
+	stmt.Let = let
+
+	return stmt
+}
+
 func (p *Parser) parseExprStmt() *ast.ExprStmt {
 	stmt := &ast.ExprStmt{Token: p.curToken}
 
@@ -170,9 +362,13 @@ func (p *Parser) parseExprStmt() *ast.ExprStmt {
 }
 
 func (p *Parser) parseBlockStmt() *ast.BlockStmt {
+	id := p.startNode()
 	block := &ast.BlockStmt{Token: p.curToken}
 	block.Stmts = []ast.Stmt{}
 
+	p.blocks++
+	defer func() { p.blocks-- }()
+
 	p.nextToken()
 
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
@@ -183,6 +379,7 @@ func (p *Parser) parseBlockStmt() *ast.BlockStmt {
 		p.nextToken()
 	}
 
+	p.finishNode(id, block)
 	return block
 }
 
@@ -228,6 +425,9 @@ var precs = map[token.TokenType]int{
 	token.SLASH:    PRODUCT,
 	token.ASTERISK: PRODUCT,
 	token.LPAREN:   CALL,
+	token.QUESTION: CALL,
+	token.DOT:      CALL,
+	token.LBRACKET: CALL,
 }
 
 func (p *Parser) peekPrec() int {
@@ -253,7 +453,16 @@ func (p *Parser) parseExpr(lowerPrec int) ast.Expr {
 		p.noPrefixParseFnError(p.curToken.Type)
 		return nil
 	}
+
+	mark := p.markNode()
+	id := p.startNode()
+	grouped := p.curTokenIs(token.LPAREN)
 	exp := prefix()
+	if grouped && exp != nil {
+		p.finishNodeKind(id, cst.PAREN_EXPR, exp)
+	} else {
+		p.finishNode(id, exp)
+	}
 
 	for {
 		// セミコロンが来たら解析終了
@@ -275,7 +484,9 @@ func (p *Parser) parseExpr(lowerPrec int) ast.Expr {
 
 		p.nextToken()
 
+		id := p.precedeNode(mark)
 		exp = infix(exp)
+		p.finishNode(id, exp)
 	}
 
 	return exp
@@ -294,6 +505,15 @@ func (p *Parser) parsePrefixExpr() ast.Expr {
 	return expr
 }
 
+// parsePostfixExpr 後置演算子は右側を読まないので、中置の解析関数として登録する。
+func (p *Parser) parsePostfixExpr(left ast.Expr) ast.Expr {
+	return &ast.PostfixExpr{
+		Token:    p.curToken,
+		Operator: p.curToken.Literal,
+		Left:     left,
+	}
+}
+
 func (p *Parser) parseInfixExpr(left ast.Expr) ast.Expr {
 	expr := &ast.InfixExpr{
 		Token:    p.curToken,
@@ -311,13 +531,21 @@ func (p *Parser) parseIdent() ast.Expr {
 	return &ast.Ident{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+// parseIdentNode let や仮引数の名前。式ではないので parseExpr を通らない。
+func (p *Parser) parseIdentNode() *ast.Ident {
+	id := p.startNode()
+	ident := &ast.Ident{Token: p.curToken, Value: p.curToken.Literal}
+	p.finishNode(id, ident)
+	return ident
+}
+
 func (p *Parser) parseIntLiteral() ast.Expr {
 	lit := &ast.IntLiteral{Token: p.curToken}
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
 		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.errorAt(p.curToken.Pos, msg)
 		return nil
 	}
 
@@ -326,6 +554,17 @@ func (p *Parser) parseIntLiteral() ast.Expr {
 	return lit
 }
 
+func (p *Parser) parseStringLiteral() ast.Expr {
+	value, err := strconv.Unquote(p.curToken.Literal)
+	if err != nil {
+		msg := fmt.Sprintf("could not parse %s as string", p.curToken.Literal)
+		p.errorAt(p.curToken.Pos, msg)
+		return nil
+	}
+
+	return &ast.StringLiteral{Token: p.curToken, Value: value}
+}
+
 func (p *Parser) parseGroupedExpr() ast.Expr {
 	p.nextToken()
 
@@ -337,6 +576,47 @@ func (p *Parser) parseGroupedExpr() ast.Expr {
 	return expr
 }
 
+// LBRACKET Elements RBRACKET
+func (p *Parser) parseArrayLiteral() ast.Expr {
+	al := &ast.ArrayLiteral{Token: p.curToken}
+	al.Elems = p.parseExprList(token.RBRACKET)
+	if al.Elems == nil {
+		return nil
+	}
+	return al
+}
+
+// LBRACE (EXPR COLON EXPR (COMMA EXPR COLON EXPR)*)? RBRACE
+func (p *Parser) parseMapLiteral() ast.Expr {
+	ml := &ast.MapLiteral{Token: p.curToken}
+
+	if p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		return ml
+	}
+
+	for {
+		p.nextToken()
+		key := p.parseExpr(LOWEST)
+		if !p.expectPeek(token.COLON) {
+			return nil
+		}
+		p.nextToken()
+		ml.Keys = append(ml.Keys, key)
+		ml.Values = append(ml.Values, p.parseExpr(LOWEST))
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	return ml
+}
+
 func (p *Parser) parseBoolean() ast.Expr {
 	return &ast.Boolean{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
 }
@@ -374,7 +654,47 @@ func (p *Parser) parseIfExpr() ast.Expr {
 	return ie
 }
 
-// FN LPAREN Parameters RPAREN LBRACE BlockStmt RBRACE
+// TRY BlockStmt [CATCH LPAREN IDENT RPAREN BlockStmt] [FINALLY BlockStmt]
+func (p *Parser) parseTryExpr() ast.Expr {
+	te := &ast.TryExpr{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	te.Body = p.parseBlockStmt()
+
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+
+		if !p.expectPeek(token.LPAREN) || !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		te.Param = p.parseIdentNode()
+
+		if !p.expectPeek(token.RPAREN) || !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		te.Catch = p.parseBlockStmt()
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		te.Finally = p.parseBlockStmt()
+	}
+
+	if te.Catch == nil && te.Finally == nil {
+		p.errorAt(p.peekToken.Pos, fmt.Sprintf("expected catch or finally after try block, got %s instead", p.peekToken.Type))
+		return nil
+	}
+
+	return te
+}
+
+// FN LPAREN Parameters RPAREN [COLON Type] LBRACE BlockStmt RBRACE
 func (p *Parser) parseFuncLiteral() ast.Expr {
 	fl := &ast.FuncLiteral{Token: p.curToken}
 
@@ -382,7 +702,18 @@ func (p *Parser) parseFuncLiteral() ast.Expr {
 		return nil
 	}
 
-	fl.Params = p.parseFuncParams()
+	fl.Params, fl.ParamTypes = p.parseFuncParams()
+	if fl.Params == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		fl.ResultType = p.parseTypeAnnotation()
+		if fl.ResultType == nil {
+			return nil
+		}
+	}
 
 	if !p.expectPeek(token.LBRACE) {
 		return nil
@@ -393,44 +724,145 @@ func (p *Parser) parseFuncLiteral() ast.Expr {
 	return fl
 }
 
-func (p *Parser) parseFuncParams() []*ast.Ident {
+// parseFuncParams 仮引数と、その型注釈（なければ nil）を同じ長さで返す。
+func (p *Parser) parseFuncParams() ([]*ast.Ident, []ast.TypeExpr) {
 	idents := []*ast.Ident{}
+	types := []ast.TypeExpr{}
 
 	if p.peekTokenIs(token.RPAREN) {
 		p.nextToken()
-		return idents
+		return idents, types
 	}
 
-	p.nextToken()
-
-	ident := &ast.Ident{Token: p.curToken, Value: p.curToken.Literal}
-	idents = append(idents, ident)
+	for {
+		if !p.expectPeek(token.IDENT) {
+			return nil, nil
+		}
+		idents = append(idents, p.parseIdentNode())
+
+		var typ ast.TypeExpr
+		if p.peekTokenIs(token.COLON) {
+			p.nextToken()
+			if typ = p.parseTypeAnnotation(); typ == nil {
+				return nil, nil
+			}
+		}
+		types = append(types, typ)
 
-	for p.peekTokenIs(token.COMMA) {
-		p.nextToken()
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
 		p.nextToken()
-		ident = &ast.Ident{Token: p.curToken, Value: p.curToken.Literal}
-		idents = append(idents, ident)
 	}
 
 	if !p.expectPeek(token.RPAREN) {
+		return nil, nil
+	}
+
+	return idents, types
+}
+
+// parseTypeAnnotation curToken が COLON のとき、続く型を読む。
+func (p *Parser) parseTypeAnnotation() ast.TypeExpr {
+	p.nextToken()
+	return p.parseType()
+}
+
+// IDENT | FN LPAREN [Type {COMMA Type}] RPAREN [COLON Type]
+func (p *Parser) parseType() ast.TypeExpr {
+	id := p.startNode()
+	var typ ast.TypeExpr
+
+	switch p.curToken.Type {
+	case token.IDENT:
+		typ = &ast.NamedType{Token: p.curToken, Name: p.curToken.Literal}
+	case token.FUNCTION:
+		typ = p.parseFuncType()
+	default:
+		msg := fmt.Sprintf("expected type, got %s instead", p.curToken.Type)
+		p.errorAt(p.curToken.Pos, msg)
+	}
+
+	p.finishNode(id, typ)
+	return typ
+}
+
+func (p *Parser) parseFuncType() ast.TypeExpr {
+	ft := &ast.FuncType{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
 		return nil
 	}
 
-	return idents
+	if p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+	} else {
+		for {
+			p.nextToken()
+			param := p.parseType()
+			if param == nil {
+				return nil
+			}
+			ft.Params = append(ft.Params, param)
+
+			if !p.peekTokenIs(token.COMMA) {
+				break
+			}
+			p.nextToken()
+		}
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+	}
+
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		if ft.Result = p.parseTypeAnnotation(); ft.Result == nil {
+			return nil
+		}
+	}
+
+	return ft
 }
 
 // EXPR LPAREN Parameters RPAREN
 func (p *Parser) parseCallExpr(fn ast.Expr) ast.Expr {
 	ce := &ast.CallExpr{Token: p.curToken, Fn: fn}
-	ce.Args = p.parseCallArgs()
+	ce.Args = p.parseExprList(token.RPAREN)
 	return ce
 }
 
-func (p *Parser) parseCallArgs() []ast.Expr {
+// EXPR LBRACKET EXPR RBRACKET
+func (p *Parser) parseIndexExpr(left ast.Expr) ast.Expr {
+	ie := &ast.IndexExpr{Token: p.curToken, Left: left}
+
+	p.nextToken()
+	ie.Index = p.parseExpr(LOWEST)
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+
+	return ie
+}
+
+// EXPR DOT IDENT
+func (p *Parser) parseSelectorExpr(x ast.Expr) ast.Expr {
+	se := &ast.SelectorExpr{Token: p.curToken, X: x}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	se.Sel = p.parseIdentNode()
+
+	return se
+}
+
+// parseExprList 呼び出しの引数や配列の要素のように、end までカンマで区切って並ぶ式。
+func (p *Parser) parseExprList(end token.TokenType) []ast.Expr {
 	args := []ast.Expr{}
 
-	if p.peekTokenIs(token.RPAREN) {
+	if p.peekTokenIs(end) {
 		p.nextToken()
 		return args
 	}
@@ -444,7 +876,7 @@ func (p *Parser) parseCallArgs() []ast.Expr {
 		args = append(args, p.parseExpr(LOWEST))
 	}
 
-	if !p.expectPeek(token.RPAREN) {
+	if !p.expectPeek(end) {
 		return nil
 	}
 
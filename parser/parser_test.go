@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/ei1chi/sample-lang/ast"
+	"github.com/ei1chi/sample-lang/cst"
 	"github.com/ei1chi/sample-lang/lexer"
 )
 
@@ -539,3 +540,415 @@ func TestCallExprParsing(t *testing.T) {
 	testInfixExpr(t, ce.Args[1], 2, "*", 3)
 	testInfixExpr(t, ce.Args[2], 4, "+", 5)
 }
+
+func TestTypeAnnotations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x: int = 5;", "let x: int = 5;"},
+		{"let f = fn(a: int, b): bool { a };", "let f = fn(a: int, b): bool a;"},
+		{"fn(g: fn(int, bool): int, h: fn()) { g }", "fn(g: fn(int, bool): int, h: fn()) g"},
+		{"let f: fn(int): int = fn(x) { x };", "let f: fn(int): int = fn(x) x;"},
+	}
+
+	for _, test := range tests {
+		p := NewParser(lexer.NewLexer(test.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != test.expected {
+			t.Errorf("expected=%q, got=%q", test.expected, program.String())
+		}
+	}
+}
+
+func TestTypeAnnotationFields(t *testing.T) {
+	p := NewParser(lexer.NewLexer("fn(a, b: int): bool { a }"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	fl, ok := program.Stmts[0].(*ast.ExprStmt).Expr.(*ast.FuncLiteral)
+	if !ok {
+		t.Fatalf("expr is not *ast.FuncLiteral. got=%T", program.Stmts[0].(*ast.ExprStmt).Expr)
+	}
+
+	if len(fl.ParamTypes) != len(fl.Params) {
+		t.Fatalf("len(ParamTypes) wrong. want=%d, got=%d", len(fl.Params), len(fl.ParamTypes))
+	}
+	if fl.ParamTypes[0] != nil {
+		t.Errorf("ParamTypes[0] is not nil. got=%s", fl.ParamTypes[0])
+	}
+	named, ok := fl.ParamTypes[1].(*ast.NamedType)
+	if !ok || named.Name != "int" {
+		t.Errorf("ParamTypes[1] is not int. got=%v", fl.ParamTypes[1])
+	}
+	if fl.ResultType == nil || fl.ResultType.String() != "bool" {
+		t.Errorf("ResultType is not bool. got=%v", fl.ResultType)
+	}
+}
+
+func TestTypeAnnotationErrors(t *testing.T) {
+	tests := []string{
+		"let x: = 5;",
+		"let x: 5 = 5;",
+		"fn(a:) { a }",
+		"fn(1) { 1 }",
+		"fn(g: fn(int) { g }",
+	}
+
+	for _, input := range tests {
+		p := NewParser(lexer.NewLexer(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected parser errors for %q", input)
+		}
+	}
+}
+
+func TestTryExpr(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"try { f(x) } catch (e) { e }", "try f(x) catch (e) e"},
+		{"try { a } finally { b }", "try a finally b"},
+		{"let x = try { a } catch (err) { b } finally { c };", "let x = try a catch (err) b finally c;"},
+		{"throw x + 1;", "throw (x + 1);"},
+	}
+
+	for _, test := range tests {
+		p := NewParser(lexer.NewLexer(test.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != test.expected {
+			t.Errorf("expected=%q, got=%q", test.expected, program.String())
+		}
+	}
+}
+
+func TestPostfixExpr(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x?", "(x?)"},
+		{"f(x)?", "(f(x)?)"},
+		{"-a?", "(-(a?))"},
+		{"a + b?", "(a + (b?))"},
+		{"f(x)?(y)", "(f(x)?)(y)"},
+		{"let v = g()? * 2;", "let v = ((g()?) * 2);"},
+	}
+
+	for _, test := range tests {
+		p := NewParser(lexer.NewLexer(test.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != test.expected {
+			t.Errorf("expected=%q, got=%q", test.expected, program.String())
+		}
+	}
+}
+
+func TestArrayAndMapLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"[]", "[]"},
+		{"[1, 2 * 2, -3]", "[1, (2 * 2), (-3)]"},
+		{"a[1 + 1]", "(a[(1 + 1)])"},
+		{"a[0][1]", "((a[0])[1])"},
+		{"-a[0] * 2", "((-(a[0])) * 2)"},
+		{"f(x)[0]", "(f(x)[0])"},
+		{"m.divmod(7, 2)[1]", "(m.divmod(7, 2)[1])"},
+		{"[[1], [2, 3]][0]", "([[1], [2, 3]][0])"},
+		{"{}", "{}"},
+		{`{"a": 1, "b" : [2]}["a"]`, `({"a": 1, "b": [2]}["a"])`},
+		{`{k: -v, "n": {}}`, `{k: (-v), "n": {}}`},
+	}
+
+	for _, test := range tests {
+		p := NewParser(lexer.NewLexer(test.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != test.expected {
+			t.Errorf("expected=%q, got=%q", test.expected, program.String())
+		}
+	}
+}
+
+func TestModules(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`import "lib/util";`, `import "lib/util";`},
+		{`import "util"`, `import "util";`},
+		{`export let x = 1;`, `export let x = 1;`},
+		{`util.f(x)`, `util.f(x)`},
+		{`a.b.c`, `a.b.c`},
+		{`-m.x * 2`, `((-m.x) * 2)`},
+		{`m.f()?`, `(m.f()?)`},
+		{`let s = "a\tb";`, `let s = "a\tb";`},
+	}
+
+	for _, test := range tests {
+		p := NewParser(lexer.NewLexer(test.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != test.expected {
+			t.Errorf("expected=%q, got=%q", test.expected, program.String())
+		}
+	}
+}
+
+func TestImportStmt(t *testing.T) {
+	p := NewParser(lexer.NewLexer(`import "lib/str_util";`))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Stmts[0].(*ast.ImportStmt)
+	if !ok {
+		t.Fatalf("program.Stmts[0] is not ast.ImportStmt. got=%T", program.Stmts[0])
+	}
+	if stmt.Path.Value != "lib/str_util" {
+		t.Errorf("stmt.Path.Value not %q. got=%q", "lib/str_util", stmt.Path.Value)
+	}
+	if !testIdent(t, stmt.Name, "str_util") {
+		return
+	}
+	if stmt.Name.Pos() != stmt.Path.Pos() {
+		t.Errorf("stmt.Name.Pos not %s. got=%s", stmt.Path.Pos(), stmt.Name.Pos())
+	}
+}
+
+func TestStringLiteral(t *testing.T) {
+	p := NewParser(lexer.NewLexer(`"hello \"world\"\n"`))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Stmts[0].(*ast.ExprStmt)
+	lit, ok := stmt.Expr.(*ast.StringLiteral)
+	if !ok {
+		t.Fatalf("stmt.Expr is not ast.StringLiteral. got=%T", stmt.Expr)
+	}
+	if lit.Value != "hello \"world\"\n" {
+		t.Errorf("lit.Value wrong. got=%q", lit.Value)
+	}
+}
+
+func TestModuleErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`import util;`, "1:8: expected next token to be STRING, got IDENT instead"},
+		{`import "lib/my-util";`, `1:8: invalid import path "lib/my-util": "my-util" is not an identifier`},
+		{`import "lib/if";`, `1:8: invalid import path "lib/if": "if" is not an identifier`},
+		{`export 1;`, "1:8: expected next token to be LET, got INT instead"},
+		{`let f = fn() { export let x = 1; };`, "1:16: export is only allowed at the top level"},
+		{`m.1`, "1:3: expected next token to be IDENT, got INT instead"},
+		{`"\q"`, `1:1: could not parse "\q" as string`},
+		{`"open`, "1:1: no prefix parse function for ILLEGAL found"},
+		{`[1, 2`, "1:6: expected next token to be ], got EOF instead"},
+		{`a[1)`, "1:4: expected next token to be ], got ) instead"},
+		{`{"a" 1}`, "1:6: expected next token to be :, got INT instead"},
+		{`{"a": 1,}`, "1:9: no prefix parse function for } found"},
+		{`{"a": 1 "b": 2}`, "1:9: expected next token to be }, got STRING instead"},
+	}
+
+	for _, test := range tests {
+		p := NewParser(lexer.NewLexer(test.input))
+		p.ParseProgram()
+
+		list := p.ErrorList()
+		if len(list) == 0 {
+			t.Errorf("expected parser errors for %q", test.input)
+			continue
+		}
+		if list[0].Error() != test.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%q", test.input, test.expected, list[0].Error())
+		}
+	}
+}
+
+func TestTryExprFields(t *testing.T) {
+	p := NewParser(lexer.NewLexer("try { 1 } catch (e) { 2 }"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Stmts[0].(*ast.ExprStmt)
+	te, ok := stmt.Expr.(*ast.TryExpr)
+	if !ok {
+		t.Fatalf("stmt.Expr is not ast.TryExpr. got=%T", stmt.Expr)
+	}
+	if len(te.Body.Stmts) != 1 || len(te.Catch.Stmts) != 1 {
+		t.Errorf("wrong blocks. body=%s, catch=%s", te.Body, te.Catch)
+	}
+	if !testIdent(t, te.Param, "e") {
+		return
+	}
+	if te.Finally != nil {
+		t.Errorf("te.Finally was not nil. got=%+v", te.Finally)
+	}
+}
+
+func TestTryExprErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"try { 1 };", "1:10: expected catch or finally after try block, got ; instead"},
+		{"try { 1 } catch { 2 }", "1:17: expected next token to be (, got { instead"},
+		{"try { 1 } catch (1) { 2 }", "1:18: expected next token to be IDENT, got INT instead"},
+	}
+
+	for _, test := range tests {
+		p := NewParser(lexer.NewLexer(test.input))
+		p.ParseProgram()
+
+		list := p.ErrorList()
+		if len(list) == 0 {
+			t.Errorf("expected parser errors for %q", test.input)
+			continue
+		}
+		if list[0].Error() != test.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%q", test.input, test.expected, list[0].Error())
+		}
+	}
+}
+
+func TestErrorList(t *testing.T) {
+	p := NewParser(lexer.NewLexer("let x = 1;\nlet = 2;\n)"))
+	p.ParseProgram()
+
+	expected := []string{
+		"2:5: expected next token to be IDENT, got = instead",
+		"2:5: no prefix parse function for = found",
+		"3:1: no prefix parse function for ) found",
+	}
+
+	list := p.ErrorList()
+	if len(list) != len(expected) {
+		t.Fatalf("wrong number of errors. want=%d, got=%v", len(expected), p.Errors())
+	}
+	for i, err := range list {
+		if err.Error() != expected[i] {
+			t.Errorf("errors[%d] wrong. want=%q, got=%q", i, expected[i], err.Error())
+		}
+		if err.Message != p.Errors()[i] {
+			t.Errorf("errors[%d] message differs from Errors(). got=%q", i, err.Message)
+		}
+	}
+}
+
+func TestParseCSTRoundTrip(t *testing.T) {
+	tests := []string{
+		"",
+		"  \n",
+		"let x = 5;",
+		"let  add = fn(a ,b) {\n\treturn a+b;\n};\n\nadd( 1, 2 * (3 - 4) )\n",
+		"if (x < y) { x } else {\n  y\n}",
+		"-a * !b == ((c))",
+		"let = ;\n)(\n5",
+		"1 \x00 \xff",
+		"let f : fn( int ):int = fn(a :int) : int { a };",
+		"import  \"lib/util\" ;\nexport let  x = util . f( \"a\\\"b\" );",
+		"import util;\nexport 1; \"open",
+		"let a = [ 1 ,[ 2 ] ];\na [0][ 1 ]",
+		"let m = { \"a\" :1 ,\"b\":{ } };\nm[ \"a\" ]",
+		"{\"a\" 1}",
+		"[1, 2\n",
+		"// head\nlet x = 1; // one\n\n  // two\nx / 2 //\n// tail",
+		"fn() { // open\n\t1 // last\n}\r\n// end\r\n",
+	}
+
+	for _, input := range tests {
+		p := NewParser(lexer.NewLosslessLexer(input))
+		root := p.ParseCST()
+
+		if root.Text() != input {
+			t.Errorf("CST does not reproduce input. expected=%q, got=%q", input, root.Text())
+		}
+	}
+}
+
+func TestParseComments(t *testing.T) {
+	input := "// head\nlet x = 1; // one\n// two\nx / 2 // three"
+
+	program := NewParser(lexer.NewLexer(input)).ParseProgram()
+	expected := NewParser(lexer.NewLexer("let x = 1; x / 2")).ParseProgram()
+	if program.String() != expected.String() {
+		t.Errorf("comments changed AST. expected=%q, got=%q", expected.String(), program.String())
+	}
+}
+
+func TestParseCSTAST(t *testing.T) {
+	input := "let f = fn(x) { x * (1 + 2) };\nf(3) - -1\n"
+
+	root := NewParser(lexer.NewLosslessLexer(input)).ParseCST()
+	if root.Kind != cst.PROGRAM {
+		t.Fatalf("root is not PROGRAM. got=%s", root.Kind)
+	}
+
+	program, ok := root.AST.(*ast.Program)
+	if !ok {
+		t.Fatalf("root.AST is not *ast.Program. got=%T", root.AST)
+	}
+
+	expected := NewParser(lexer.NewLexer(input)).ParseProgram()
+	if program.String() != expected.String() {
+		t.Errorf("AST wrong. expected=%q, got=%q", expected.String(), program.String())
+	}
+
+	// 各ノードの AST とテキストが対応していること
+	tests := []struct {
+		kind         cst.Kind
+		expectedText string
+	}{
+		{cst.LET_STMT, "let f = fn(x) { x * (1 + 2) };\n"},
+		{cst.FUNC_LITERAL, "fn(x) { x * (1 + 2) }"},
+		{cst.INFIX_EXPR, "x * (1 + 2) "},
+		{cst.PAREN_EXPR, "(1 + 2) "},
+		{cst.EXPR_STMT, "x * (1 + 2) "},
+		{cst.CALL_EXPR, "f(3) "},
+		{cst.PREFIX_EXPR, "-1\n"},
+	}
+
+	var nodes []*cst.Node
+	var collect func(n *cst.Node)
+	collect = func(n *cst.Node) {
+		nodes = append(nodes, n)
+		for _, c := range n.Children {
+			if c, ok := c.(*cst.Node); ok {
+				collect(c)
+			}
+		}
+	}
+	collect(root)
+
+	for _, test := range tests {
+		var found *cst.Node
+		for _, n := range nodes {
+			if n.Kind == test.kind {
+				found = n
+				break
+			}
+		}
+		if found == nil {
+			t.Errorf("no %s node", test.kind)
+			continue
+		}
+		if found.Text() != test.expectedText {
+			t.Errorf("%s text wrong. expected=%q, got=%q", test.kind, test.expectedText, found.Text())
+		}
+		if found.AST == nil {
+			t.Errorf("%s has no AST", test.kind)
+		}
+	}
+}
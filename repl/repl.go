@@ -7,6 +7,7 @@ import (
 
 	"github.com/ei1chi/sample-lang/eval"
 	"github.com/ei1chi/sample-lang/lexer"
+	"github.com/ei1chi/sample-lang/object"
 	"github.com/ei1chi/sample-lang/parser"
 	"github.com/ei1chi/sample-lang/token"
 )
@@ -15,6 +16,9 @@ const PROMPT = "(´・ω・`)っ "
 
 func Start(in io.Reader, out io.Writer) {
 	scanner := bufio.NewScanner(in)
+	env := object.NewEnvironment()
+	// モジュールはカレントディレクトリから探し、一度 import したものは行をまたいで使い回す
+	cfg := eval.Config{Loader: &eval.Loader{}}
 
 	for {
 		fmt.Printf(PROMPT)
@@ -36,10 +40,13 @@ func Start(in io.Reader, out io.Writer) {
 			continue
 		}
 
-		evaled := eval.Eval(program)
+		evaled := eval.New(cfg).Eval(program, env)
 		if evaled != nil {
 			io.WriteString(out, evaled.Inspect())
 			io.WriteString(out, "\n")
+			if err, ok := evaled.(*object.Error); ok {
+				io.WriteString(out, err.StackTrace("<stdin>"))
+			}
 		}
 
 		io.WriteString(out, program.String())
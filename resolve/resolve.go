@@ -0,0 +1,315 @@
+// Package resolve は識別子をその宣言に結びつける。
+//
+// スコープはプログラム全体（グローバル）と関数ごと、catch のブロックごとにあり、if のブロックは新しいスコープを作らない。
+// 宣言は let と import と関数の仮引数と catch の引数で、コンパイラと同じくソース上の順番に有効になる。
+// ただし関数の本体は呼び出されたときに評価されるので、外側で後から宣言される名前も参照できる。
+// catch のスコープの変数は、その catch を含む関数のスロットを使う。
+//
+// lint、types、lsp はスコープを自分で持たず、この結果を使う。
+package resolve
+
+import (
+	"fmt"
+
+	"github.com/ei1chi/sample-lang/ast"
+	"github.com/ei1chi/sample-lang/token"
+)
+
+type Kind string
+
+const (
+	LOCAL     Kind = "LOCAL"     // 同じ関数の変数
+	ENCLOSING Kind = "ENCLOSING" // 外側の関数の変数
+	GLOBAL    Kind = "GLOBAL"
+	BUILTIN   Kind = "BUILTIN"
+)
+
+// Symbol 宣言一つ。同じスコープで同じ名前を let し直しても同じ Symbol になる。
+type Symbol struct {
+	Name  string
+	Kind  Kind       // 宣言された場所。LOCAL、GLOBAL、BUILTIN のどれか
+	Index int        // スロット番号。LOCAL なら関数の中、GLOBAL なら全体、BUILTIN なら組み込み関数の一覧での番号
+	Decl  *ast.Ident // 最初の宣言。BUILTIN なら nil
+	Table *Table
+}
+
+// Ref 識別子の使用から見た宣言。
+type Ref struct {
+	Symbol *Symbol
+	Kind   Kind // 使っている場所から見た種類
+	Depth  int  // ENCLOSING のとき、何段外側の関数か
+}
+
+// Table 一つのスコープの記号表。
+type Table struct {
+	Outer    *Table
+	Func     *ast.FuncLiteral // グローバルなら nil
+	Try      *ast.TryExpr     // catch のスコープならその try 式
+	Symbols  []*Symbol        // 宣言した順
+	NumSlots int              // catch のスコープなら 0。変数は含む関数のスロットに数える
+
+	names map[string]*Symbol
+}
+
+func newTable(outer *Table, fn *ast.FuncLiteral) *Table {
+	return &Table{Outer: outer, Func: fn, names: map[string]*Symbol{}}
+}
+
+// Lookup name を t から外側に向かって探す。depth は何段外側で見つかったか。
+func (t *Table) Lookup(name string) (sym *Symbol, depth int) {
+	for ; t != nil; t = t.Outer {
+		if sym, ok := t.names[name]; ok {
+			return sym, depth
+		}
+		depth++
+	}
+	return nil, 0
+}
+
+// funcTable t を含む関数（またはグローバル）のスコープ。catch のスコープでなければ t 自身。
+func (t *Table) funcTable() *Table {
+	for t.Try != nil {
+		t = t.Outer
+	}
+	return t
+}
+
+func (t *Table) define(ident *ast.Ident) *Symbol {
+	if sym, ok := t.names[ident.Value]; ok {
+		return sym
+	}
+
+	fn := t.funcTable()
+	kind := LOCAL
+	if fn.Outer == nil {
+		kind = GLOBAL
+	}
+	sym := &Symbol{Name: ident.Value, Kind: kind, Index: fn.NumSlots, Decl: ident, Table: t}
+	fn.NumSlots++
+	t.names[ident.Value] = sym
+	t.Symbols = append(t.Symbols, sym)
+	return sym
+}
+
+// encloses t が inner か、その外側か。
+func (t *Table) encloses(inner *Table) bool {
+	for ; inner != nil; inner = inner.Outer {
+		if inner == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Error 解析で見つかった誤り。
+type Error struct {
+	Pos     token.Position
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+}
+
+// Info 解析の結果。
+type Info struct {
+	Global  *Table
+	Funcs   map[*ast.FuncLiteral]*Table
+	Catches map[*ast.TryExpr]*Table
+
+	Defs map[*ast.Ident]*Symbol // 宣言している識別子
+	Uses map[*ast.Ident]*Ref    // 参照している識別子。未定義の名前は含まない
+
+	Unresolved []*ast.Ident // 宣言も組み込み関数も見つからなかった識別子
+	Errors     []*Error
+}
+
+// Lookup ident が宣言でも参照でもその Symbol を返す。
+func (info *Info) Lookup(ident *ast.Ident) *Symbol {
+	if sym, ok := info.Defs[ident]; ok {
+		return sym
+	}
+	if ref, ok := info.Uses[ident]; ok {
+		return ref.Symbol
+	}
+	return nil
+}
+
+// Resolve program の識別子を解決する。builtins は組み込み関数の名前で、添字がそのままスロット番号になる。
+func Resolve(program *ast.Program, builtins []string) *Info {
+	r := &resolver{
+		info: &Info{
+			Funcs:   map[*ast.FuncLiteral]*Table{},
+			Catches: map[*ast.TryExpr]*Table{},
+			Defs:    map[*ast.Ident]*Symbol{},
+			Uses:    map[*ast.Ident]*Ref{},
+		},
+		builtins: map[string]*Symbol{},
+	}
+	for i, name := range builtins {
+		if _, ok := r.builtins[name]; !ok {
+			r.builtins[name] = &Symbol{Name: name, Kind: BUILTIN, Index: i}
+		}
+	}
+
+	r.table = newTable(nil, nil)
+	r.info.Global = r.table
+	r.stmts(program.Stmts)
+
+	// 最後まで宣言されなかった名前は組み込み関数か未定義
+	for _, use := range r.pending {
+		if sym, ok := r.builtins[use.ident.Value]; ok {
+			r.info.Uses[use.ident] = &Ref{Symbol: sym, Kind: BUILTIN}
+		} else {
+			r.info.Unresolved = append(r.info.Unresolved, use.ident)
+		}
+	}
+
+	return r.info
+}
+
+// pendingUse その時点でまだ宣言が見つかっていない参照。
+type pendingUse struct {
+	ident *ast.Ident
+	table *Table
+}
+
+type resolver struct {
+	info     *Info
+	table    *Table
+	builtins map[string]*Symbol
+	pending  []pendingUse
+}
+
+func (r *resolver) stmts(stmts []ast.Stmt) {
+	for _, stmt := range stmts {
+		r.node(stmt)
+	}
+}
+
+func (r *resolver) node(node ast.Node) {
+	ast.Inspect(node, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.LetStmt:
+			// 右辺を先に評価するので、右辺ではまだ新しい名前は見えない
+			if node.Value != nil {
+				r.node(node.Value)
+			}
+			if node.Name != nil {
+				r.declare(node.Name)
+			}
+			return false
+
+		case *ast.ImportStmt:
+			if node.Name != nil {
+				r.declare(node.Name)
+			}
+			return false
+
+		case *ast.SelectorExpr:
+			// 選ぶ名前はモジュールの中の名前なので、ここでは解決しない
+			if node.X != nil {
+				r.node(node.X)
+			}
+			return false
+
+		case *ast.TryExpr:
+			// catch の引数と catch の中の let は catch のブロックの中だけで見える
+			r.node(node.Body)
+			if node.Catch != nil {
+				r.catch(node)
+			}
+			if node.Finally != nil {
+				r.node(node.Finally)
+			}
+			return false
+
+		case *ast.FuncLiteral:
+			r.funcLiteral(node)
+			return false
+
+		case *ast.Ident:
+			r.use(node)
+		}
+		return true
+	})
+}
+
+func (r *resolver) funcLiteral(fn *ast.FuncLiteral) {
+	outer := r.table
+	r.table = newTable(outer, fn)
+	r.info.Funcs[fn] = r.table
+
+	for _, param := range fn.Params {
+		r.declare(param)
+	}
+	if fn.Body != nil {
+		r.stmts(fn.Body.Stmts)
+	}
+
+	r.table = outer
+}
+
+func (r *resolver) catch(te *ast.TryExpr) {
+	outer := r.table
+	r.table = newTable(outer, nil)
+	r.table.Try = te
+	r.info.Catches[te] = r.table
+
+	if te.Param != nil {
+		r.declare(te.Param)
+	}
+	r.node(te.Catch)
+
+	r.table = outer
+}
+
+func (r *resolver) declare(ident *ast.Ident) {
+	sym := r.table.define(ident)
+	r.info.Defs[ident] = sym
+
+	// 先に出てきた同名の参照を解決する
+	rest := r.pending[:0]
+	for _, use := range r.pending {
+		if use.ident.Value != ident.Value || !r.table.encloses(use.table) {
+			rest = append(rest, use)
+			continue
+		}
+
+		if use.table.funcTable() == r.table.funcTable() {
+			r.info.Errors = append(r.info.Errors, &Error{
+				Pos:     use.ident.Pos(),
+				Message: fmt.Sprintf("%s used before declaration at %s", ident.Value, ident.Pos()),
+			})
+			continue
+		}
+		r.info.Uses[use.ident] = newRef(sym, use.table)
+	}
+	r.pending = rest
+}
+
+func (r *resolver) use(ident *ast.Ident) {
+	sym, _ := r.table.Lookup(ident.Value)
+	if sym == nil {
+		r.pending = append(r.pending, pendingUse{ident: ident, table: r.table})
+		return
+	}
+	r.info.Uses[ident] = newRef(sym, r.table)
+}
+
+// newRef table の中から sym を参照する。
+func newRef(sym *Symbol, table *Table) *Ref {
+	switch {
+	case sym.Kind == GLOBAL:
+		return &Ref{Symbol: sym, Kind: GLOBAL}
+	case sym.Table.funcTable() == table.funcTable():
+		return &Ref{Symbol: sym, Kind: LOCAL}
+	}
+
+	// catch のスコープは関数の段に数えない
+	depth := 0
+	for t := table.funcTable(); t != sym.Table.funcTable(); t = t.Outer.funcTable() {
+		depth++
+	}
+	return &Ref{Symbol: sym, Kind: ENCLOSING, Depth: depth}
+}
@@ -0,0 +1,219 @@
+package resolve
+
+import (
+	"testing"
+
+	"github.com/ei1chi/sample-lang/ast"
+	"github.com/ei1chi/sample-lang/lexer"
+	"github.com/ei1chi/sample-lang/parser"
+	"github.com/ei1chi/sample-lang/token"
+)
+
+func TestResolveUses(t *testing.T) {
+	input := `let a = 1;
+let f = fn(x, y) {
+	let b = x;
+	fn(z) { a + b + y + z + f + g + len(z) }
+};
+let g = 2;
+let a = a + 1;
+`
+
+	tests := []struct {
+		line, col     int
+		expectedKind  Kind
+		expectedIndex int
+		expectedDepth int
+		expectedDecl  string // 宣言の位置。BUILTIN なら空
+	}{
+		{3, 10, LOCAL, 0, 0, "2:12"},     // x
+		{4, 10, GLOBAL, 0, 0, "1:5"},     // a
+		{4, 14, ENCLOSING, 2, 1, "3:6"},  // b
+		{4, 18, ENCLOSING, 1, 1, "2:15"}, // y
+		{4, 22, LOCAL, 0, 0, "4:5"},      // z
+		{4, 26, GLOBAL, 1, 0, "2:5"},     // f
+		{4, 30, GLOBAL, 2, 0, "6:5"},     // g（後から宣言されたグローバル）
+		{4, 34, BUILTIN, 1, 0, ""},       // len
+		{7, 9, GLOBAL, 0, 0, "1:5"},      // 再宣言の右辺の a
+	}
+
+	program := parse(t, input)
+	info := Resolve(program, []string{"puts", "len"})
+	if len(info.Errors) != 0 {
+		t.Fatalf("unexpected errors: %v", info.Errors)
+	}
+
+	for _, test := range tests {
+		pos := token.Position{Line: test.line, Column: test.col}
+		ident := identAt(program, pos)
+		if ident == nil {
+			t.Fatalf("no identifier at %s", pos)
+		}
+
+		ref, ok := info.Uses[ident]
+		if !ok {
+			t.Errorf("%s at %s not resolved", ident.Value, pos)
+			continue
+		}
+		if ref.Kind != test.expectedKind {
+			t.Errorf("%s at %s has wrong kind. want=%s, got=%s", ident.Value, pos, test.expectedKind, ref.Kind)
+		}
+		if ref.Symbol.Index != test.expectedIndex {
+			t.Errorf("%s at %s has wrong index. want=%d, got=%d", ident.Value, pos, test.expectedIndex, ref.Symbol.Index)
+		}
+		if ref.Depth != test.expectedDepth {
+			t.Errorf("%s at %s has wrong depth. want=%d, got=%d", ident.Value, pos, test.expectedDepth, ref.Depth)
+		}
+
+		var decl string
+		if ref.Symbol.Decl != nil {
+			decl = ref.Symbol.Decl.Pos().String()
+		}
+		if decl != test.expectedDecl {
+			t.Errorf("%s at %s has wrong declaration. want=%q, got=%q", ident.Value, pos, test.expectedDecl, decl)
+		}
+	}
+
+	if info.Global.NumSlots != 3 {
+		t.Errorf("wrong number of global slots. want=3, got=%d", info.Global.NumSlots)
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"x; let x = 1;", []string{"1:1: x used before declaration at 1:8"}},
+		{"let x = x;", []string{"1:9: x used before declaration at 1:5"}},
+		{"let f = fn() { y; let y = 1; };", []string{"1:16: y used before declaration at 1:23"}},
+		{"let f = fn() { g() }; let g = fn() { 1 };", nil},
+		{"let f = fn(n) { f(n) };", nil},
+		{"if (true) { let x = 1; } x;", nil},
+		{"try { 1 } catch (e) { let x = 1; }; x;", nil},
+		{"try { 1 } catch (e) { x; let x = 1; };", []string{"1:23: x used before declaration at 1:30"}},
+	}
+
+	for _, test := range tests {
+		info := Resolve(parse(t, test.input), nil)
+
+		if len(info.Errors) != len(test.expected) {
+			t.Errorf("wrong number of errors for %q. want=%d, got=%v", test.input, len(test.expected), info.Errors)
+			continue
+		}
+		for i, err := range info.Errors {
+			if err.Error() != test.expected[i] {
+				t.Errorf("wrong error. want=%q, got=%q", test.expected[i], err.Error())
+			}
+		}
+	}
+}
+
+func TestResolveUnresolved(t *testing.T) {
+	info := Resolve(parse(t, "puts(x)"), []string{"puts"})
+
+	if len(info.Unresolved) != 1 || info.Unresolved[0].Value != "x" {
+		t.Fatalf("wrong unresolved identifiers. got=%v", info.Unresolved)
+	}
+}
+
+func TestLocalSlots(t *testing.T) {
+	program := parse(t, "fn(a, b) { let c = 1; let a = 2; let d = 3; }")
+	info := Resolve(program, nil)
+
+	fn := program.Stmts[0].(*ast.ExprStmt).Expr.(*ast.FuncLiteral)
+	table, ok := info.Funcs[fn]
+	if !ok {
+		t.Fatalf("no table for function")
+	}
+
+	expected := []string{"a", "b", "c", "d"}
+	if len(table.Symbols) != len(expected) {
+		t.Fatalf("wrong number of symbols. want=%d, got=%d", len(expected), len(table.Symbols))
+	}
+	for i, name := range expected {
+		sym := table.Symbols[i]
+		if sym.Name != name || sym.Index != i || sym.Kind != LOCAL {
+			t.Errorf("symbols[%d] wrong. want=%s LOCAL %d, got=%s %s %d", i, name, i, sym.Name, sym.Kind, sym.Index)
+		}
+	}
+}
+
+func TestCatchScope(t *testing.T) {
+	input := `let e = 5;
+let f = fn() {
+	try { 1 } catch (e) { let x = e; fn() { x + e } }
+};
+e;
+`
+
+	tests := []struct {
+		line, col     int
+		expectedKind  Kind
+		expectedIndex int
+		expectedDepth int
+		expectedDecl  string
+	}{
+		{3, 32, LOCAL, 0, 0, "3:19"},     // catch の中の e
+		{3, 42, ENCLOSING, 1, 1, "3:28"}, // x。catch のスコープは段に数えない
+		{3, 46, ENCLOSING, 0, 1, "3:19"}, // 内側の関数の e
+		{5, 1, GLOBAL, 0, 0, "1:5"},      // catch の外の e
+	}
+
+	program := parse(t, input)
+	info := Resolve(program, nil)
+	if len(info.Errors) != 0 {
+		t.Fatalf("unexpected errors: %v", info.Errors)
+	}
+
+	for _, test := range tests {
+		pos := token.Position{Line: test.line, Column: test.col}
+		ident := identAt(program, pos)
+		if ident == nil {
+			t.Fatalf("no identifier at %s", pos)
+		}
+
+		ref, ok := info.Uses[ident]
+		if !ok {
+			t.Errorf("%s at %s not resolved", ident.Value, pos)
+			continue
+		}
+		if ref.Kind != test.expectedKind || ref.Symbol.Index != test.expectedIndex || ref.Depth != test.expectedDepth {
+			t.Errorf("%s at %s wrong. want=%s %d depth %d, got=%s %d depth %d", ident.Value, pos,
+				test.expectedKind, test.expectedIndex, test.expectedDepth, ref.Kind, ref.Symbol.Index, ref.Depth)
+		}
+		if decl := ref.Symbol.Decl.Pos().String(); decl != test.expectedDecl {
+			t.Errorf("%s at %s has wrong declaration. want=%q, got=%q", ident.Value, pos, test.expectedDecl, decl)
+		}
+	}
+
+	fn := program.Stmts[1].(*ast.LetStmt).Value.(*ast.FuncLiteral)
+	if n := info.Funcs[fn].NumSlots; n != 2 {
+		t.Errorf("catch variables not counted in the function. want=2 slots, got=%d", n)
+	}
+	if len(info.Catches) != 1 {
+		t.Errorf("wrong number of catch scopes. want=1, got=%d", len(info.Catches))
+	}
+}
+
+func identAt(program *ast.Program, pos token.Position) *ast.Ident {
+	var found *ast.Ident
+	ast.Inspect(program, func(node ast.Node) bool {
+		if ident, ok := node.(*ast.Ident); ok && ident.Pos() == pos {
+			found = ident
+		}
+		return found == nil
+	})
+	return found
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+
+	p := parser.NewParser(lexer.NewLexer(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ei1chi/sample-lang/compiler"
+	"github.com/ei1chi/sample-lang/eval"
+	"github.com/ei1chi/sample-lang/lexer"
+	"github.com/ei1chi/sample-lang/object"
+	"github.com/ei1chi/sample-lang/parser"
+	"github.com/ei1chi/sample-lang/vm"
+)
+
+// runRun sample-lang run [-path dirs] [file]
+// ファイルを評価して結果を標準出力に書く。ファイルがなければ標準入力を評価する。
+// 実行時エラーはスタックトレースとともに標準エラー出力に書き、終了コードは 1。
+// import するモジュールはファイルと同じディレクトリから探し、なければ -path のディレクトリを順に探す。
+// build で作ったコンパイル済みのファイルなら、読み込むときに検査してから仮想マシンで実行する。
+func runRun(args []string) int {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	searchPath := flags.String("path", "", "module search `dirs`, separated by "+string(os.PathListSeparator))
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: sample-lang run [-path dirs] [file]\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return 2
+	}
+
+	path := "<stdin>"
+	var src []byte
+	var err error
+	if flags.NArg() == 0 {
+		src, err = io.ReadAll(os.Stdin)
+	} else {
+		path = flags.Arg(0)
+		src, err = os.ReadFile(path)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "run: %s\n", err)
+		return 1
+	}
+
+	loader := &eval.Loader{Paths: []string{"."}}
+	if flags.NArg() != 0 {
+		loader.Paths[0] = filepath.Dir(path)
+	}
+	if *searchPath != "" {
+		loader.Paths = append(loader.Paths, filepath.SplitList(*searchPath)...)
+	}
+
+	if bytes.HasPrefix(src, []byte(compiler.Magic)) {
+		return runBytecode(path, src, loader)
+	}
+	return runSource(path, src, loader)
+}
+
+func runSource(path string, src []byte, loader *eval.Loader) int {
+	p := parser.NewParser(lexer.NewLexer(string(src)))
+	program := p.ParseProgram()
+	if errs := p.ErrorList(); len(errs) != 0 {
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "%s:%s\n", path, err)
+		}
+		return 1
+	}
+
+	cfg := eval.Config{Loader: loader}
+	switch result := eval.New(cfg).Eval(program, object.NewEnvironment()).(type) {
+	case *object.Error:
+		fmt.Fprintf(os.Stderr, "%s\n%s", result.Inspect(), result.StackTrace(path))
+		return 1
+	case nil, *object.Null:
+	default:
+		fmt.Println(result.Inspect())
+	}
+	return 0
+}
+
+// runBytecode コンパイル済みのプログラムを実行する。import はソースと同じく loader で探して評価器で評価する。
+func runBytecode(path string, data []byte, loader *eval.Loader) int {
+	bytecode, err := compiler.ReadBytecode(bytes.NewReader(data))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+		return 1
+	}
+
+	machine := vm.New(bytecode, eval.Builtins())
+	machine.Evaluator = eval.New(eval.Config{Loader: loader})
+	if err := machine.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+		return 1
+	}
+
+	switch result := machine.Result().(type) {
+	case *object.Error:
+		fmt.Fprintf(os.Stderr, "%s\n%s", result.Inspect(), result.StackTrace(path))
+		return 1
+	case nil, *object.Null:
+	default:
+		fmt.Println(result.Inspect())
+	}
+	return 0
+}
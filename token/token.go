@@ -1,10 +1,33 @@
 package token
 
+import "fmt"
+
 type TokenType string
 
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Position
+
+	// トークン前後の空白とコメント（トリビア）。Lossless モードの字句解析でだけ設定される。
+	// Trailing は同じ行の改行までで、それ以降は次のトークンの Leading になる。
+	Leading  string
+	Trailing string
+}
+
+// Position ソース上の位置。行と列は 1 始まりで、列は文字（rune）単位。
+type Position struct {
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// IsValid 位置が設定されているか。
+func (p Position) IsValid() bool {
+	return p.Line > 0
 }
 
 const (
@@ -12,8 +35,9 @@ const (
 	EOF     = "EOF"
 
 	// 識別子＋リテラル
-	IDENT = "IDENT"
-	INT   = "INT"
+	IDENT  = "IDENT"
+	INT    = "INT"
+	STRING = "STRING"
 
 	// 演算子
 	ASSIGN   = "="
@@ -22,6 +46,7 @@ const (
 	BANG     = "!"
 	ASTERISK = "*"
 	SLASH    = "/"
+	QUESTION = "?"
 
 	LT     = "<"
 	GT     = ">"
@@ -31,11 +56,15 @@ const (
 	// デリミタ
 	COMMA     = ","
 	SEMICOLON = ";"
+	COLON     = ":"
+	DOT       = "."
 
-	LPAREN = "("
-	RPAREN = ")"
-	LBRACE = "{"
-	RBRACE = "}"
+	LPAREN   = "("
+	RPAREN   = ")"
+	LBRACE   = "{"
+	RBRACE   = "}"
+	LBRACKET = "["
+	RBRACKET = "]"
 
 	// キーワード
 	FUNCTION = "FUNCTION"
@@ -45,16 +74,28 @@ const (
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	FINALLY  = "FINALLY"
+	THROW    = "THROW"
+	IMPORT   = "IMPORT"
+	EXPORT   = "EXPORT"
 )
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
+	"fn":      FUNCTION,
+	"let":     LET,
+	"true":    TRUE,
+	"false":   FALSE,
+	"if":      IF,
+	"else":    ELSE,
+	"return":  RETURN,
+	"try":     TRY,
+	"catch":   CATCH,
+	"finally": FINALLY,
+	"throw":   THROW,
+	"import":  IMPORT,
+	"export":  EXPORT,
 }
 
 func LookupIdent(ident string) TokenType {
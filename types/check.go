@@ -0,0 +1,492 @@
+package types
+
+import (
+	"fmt"
+
+	"github.com/ei1chi/sample-lang/ast"
+	"github.com/ei1chi/sample-lang/resolve"
+	"github.com/ei1chi/sample-lang/token"
+)
+
+// Error 型の誤り。
+type Error struct {
+	Pos     token.Position
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+}
+
+// Info 検査の結果。
+type Info struct {
+	Types map[ast.Expr]Type   // 式の型
+	Defs  map[*ast.Ident]Type // let と仮引数で宣言した変数の型
+}
+
+// Check program の型を調べる。誤りがなければ errors は空。
+// 識別子がどの宣言を指すかは resolve に従う。
+func Check(program *ast.Program) (*Info, []*Error) {
+	c := &checker{
+		info: &Info{
+			Types: map[ast.Expr]Type{},
+			Defs:  map[*ast.Ident]Type{},
+		},
+		res:  resolve.Resolve(program, nil),
+		vars: map[*resolve.Symbol]Type{},
+		sigs: map[*ast.FuncLiteral]*Func{},
+	}
+	c.stmts(program.Stmts)
+	return c.info, c.errors
+}
+
+// function 検査中の関数。
+type function struct {
+	result  Type   // 注釈された返り値の型。なければ nil
+	returns []Type // return 文の値の型
+}
+
+type checker struct {
+	info   *Info
+	errors []*Error
+	res    *resolve.Info
+	vars   map[*resolve.Symbol]Type // 変数の今の型。同じ変数を let し直すと変わる
+	fn     *function                // プログラムの直下なら nil
+	sigs   map[*ast.FuncLiteral]*Func
+}
+
+func (c *checker) errorf(pos token.Position, format string, a ...interface{}) {
+	c.errors = append(c.errors, &Error{Pos: pos, Message: fmt.Sprintf(format, a...)})
+}
+
+func (c *checker) declare(ident *ast.Ident, t Type) {
+	if sym := c.res.Defs[ident]; sym != nil {
+		c.vars[sym] = t
+	}
+	c.info.Defs[ident] = t
+}
+
+// stmts 文を順に調べ、最後の式文の型を返す。値を残さずに終われば nil。
+func (c *checker) stmts(stmts []ast.Stmt) Type {
+	var last Type
+	for _, stmt := range stmts {
+		last = c.stmt(stmt)
+	}
+	return last
+}
+
+func (c *checker) stmt(stmt ast.Stmt) Type {
+	switch stmt := stmt.(type) {
+	case *ast.LetStmt:
+		c.letStmt(stmt)
+
+	case *ast.ExportStmt:
+		c.letStmt(stmt.Let)
+
+	case *ast.ImportStmt:
+		// モジュールの中身は調べないので、選んだ値はどれも Any になる
+		c.declare(stmt.Name, Any)
+
+	case *ast.ReturnStmt:
+		if stmt.ReturnValue == nil {
+			return nil
+		}
+		t := c.expr(stmt.ReturnValue)
+		if c.fn != nil {
+			c.fn.returns = append(c.fn.returns, t)
+			if c.fn.result != nil && !Consistent(t, c.fn.result) {
+				c.errorf(stmt.ReturnValue.Pos(), "cannot use %s as %s in return", t, c.fn.result)
+			}
+		}
+
+	case *ast.ExprStmt:
+		if stmt.Expr == nil {
+			return nil
+		}
+		return c.expr(stmt.Expr)
+
+	case *ast.ThrowStmt:
+		if stmt.Value != nil {
+			c.expr(stmt.Value)
+		}
+
+	case *ast.BlockStmt:
+		return c.stmts(stmt.Stmts)
+	}
+	return nil
+}
+
+func (c *checker) letStmt(stmt *ast.LetStmt) {
+	var declared Type
+	if stmt.Type != nil {
+		declared = c.typeExpr(stmt.Type)
+	}
+
+	// 再帰できるよう、関数は本体を調べる前に注釈どおりの型で宣言しておく
+	if fl, ok := stmt.Value.(*ast.FuncLiteral); ok && declared == nil {
+		c.declare(stmt.Name, c.signature(fl))
+	}
+
+	if stmt.Value == nil {
+		return
+	}
+	t := c.expr(stmt.Value)
+
+	if declared != nil {
+		if !Consistent(t, declared) {
+			c.errorf(stmt.Value.Pos(), "cannot use %s as %s in let %s", t, declared, stmt.Name.Value)
+		}
+		t = declared
+	}
+	c.declare(stmt.Name, t)
+}
+
+func (c *checker) expr(expr ast.Expr) Type {
+	t := c.exprType(expr)
+	c.info.Types[expr] = t
+	return t
+}
+
+func (c *checker) exprType(expr ast.Expr) Type {
+	switch expr := expr.(type) {
+	case *ast.IntLiteral:
+		return Int
+
+	case *ast.Boolean:
+		return Bool
+
+	case *ast.StringLiteral:
+		return String
+
+	case *ast.Ident:
+		if ref, ok := c.res.Uses[expr]; ok {
+			if t, ok := c.vars[ref.Symbol]; ok {
+				return t
+			}
+		}
+		// 組み込み関数や、後から宣言されるグローバル変数
+		return Any
+
+	case *ast.PrefixExpr:
+		return c.prefixExpr(expr)
+
+	case *ast.PostfixExpr:
+		// エラーの値で抜けるときの型は表せないので、? は被演算子の型をそのまま返す
+		if expr.Left == nil {
+			return Any
+		}
+		return c.expr(expr.Left)
+
+	case *ast.InfixExpr:
+		return c.infixExpr(expr)
+
+	case *ast.IfExpr:
+		return c.ifExpr(expr)
+
+	case *ast.TryExpr:
+		return c.tryExpr(expr)
+
+	case *ast.FuncLiteral:
+		return c.funcLiteral(expr)
+
+	case *ast.CallExpr:
+		return c.callExpr(expr)
+
+	case *ast.ArrayLiteral:
+		return c.arrayLiteral(expr)
+
+	case *ast.MapLiteral:
+		return c.mapLiteral(expr)
+
+	case *ast.IndexExpr:
+		return c.indexExpr(expr)
+
+	case *ast.SelectorExpr:
+		if expr.X != nil {
+			c.expr(expr.X)
+		}
+		return Any
+	}
+
+	return Any
+}
+
+func (c *checker) prefixExpr(expr *ast.PrefixExpr) Type {
+	if expr.Right == nil {
+		return Any
+	}
+	right := c.expr(expr.Right)
+
+	switch expr.Operator {
+	case "!":
+		return Bool
+	case "-":
+		if !Consistent(right, Int) {
+			c.errorf(expr.Pos(), "cannot negate %s", right)
+		}
+		return Int
+	}
+	return Any
+}
+
+// 算術演算子の動詞
+var verbs = map[string]string{
+	"+": "add",
+	"-": "subtract",
+	"*": "multiply",
+	"/": "divide",
+}
+
+func (c *checker) infixExpr(expr *ast.InfixExpr) Type {
+	if expr.Left == nil || expr.Right == nil {
+		return Any
+	}
+	left := c.expr(expr.Left)
+	right := c.expr(expr.Right)
+
+	switch expr.Operator {
+	case "+", "-", "*", "/":
+		if !Consistent(left, Int) || !Consistent(right, Int) {
+			c.errorf(expr.Pos(), "cannot %s %s and %s", verbs[expr.Operator], left, right)
+		}
+		return Int
+
+	case "<", ">":
+		if !Consistent(left, Int) || !Consistent(right, Int) {
+			c.errorf(expr.Pos(), "cannot compare %s and %s with %s", left, right, expr.Operator)
+		}
+		return Bool
+
+	case "==", "!=":
+		// 型が違えば等しくないだけで、エラーにはならない
+		return Bool
+	}
+	return Any
+}
+
+func (c *checker) ifExpr(expr *ast.IfExpr) Type {
+	if expr.Cond != nil {
+		c.expr(expr.Cond)
+	}
+
+	var cons, alt Type
+	if expr.Cons != nil {
+		cons = c.stmts(expr.Cons.Stmts)
+	}
+	if expr.Alt == nil {
+		// 条件が偽なら null になる
+		return Any
+	}
+	alt = c.stmts(expr.Alt.Stmts)
+
+	if cons == nil || alt == nil {
+		return Any
+	}
+	return join(cons, alt)
+}
+
+// tryExpr 値は本体か catch のどちらかになる。finally の値は使わない。
+func (c *checker) tryExpr(expr *ast.TryExpr) Type {
+	var body, catch Type
+	if expr.Body != nil {
+		body = c.stmts(expr.Body.Stmts)
+	}
+	if expr.Catch != nil {
+		// 受け取ったエラーの型は注釈に書けないので Any
+		c.declare(expr.Param, Any)
+		catch = c.stmts(expr.Catch.Stmts)
+	}
+	if expr.Finally != nil {
+		c.stmts(expr.Finally.Stmts)
+	}
+
+	if body == nil || (expr.Catch != nil && catch == nil) {
+		return Any
+	}
+	if expr.Catch == nil {
+		return body
+	}
+	return join(body, catch)
+}
+
+func (c *checker) funcLiteral(fl *ast.FuncLiteral) Type {
+	sig := c.signature(fl)
+
+	outerFn := c.fn
+	c.fn = &function{}
+	if fl.ResultType != nil {
+		c.fn.result = sig.Result
+	}
+
+	for i, param := range fl.Params {
+		c.declare(param, sig.Params[i])
+	}
+
+	var last Type
+	if fl.Body != nil {
+		last = c.stmts(fl.Body.Stmts)
+	}
+	if last != nil && c.fn.result != nil && !Consistent(last, c.fn.result) {
+		stmts := fl.Body.Stmts
+		c.errorf(stmts[len(stmts)-1].Pos(), "cannot use %s as %s in return", last, c.fn.result)
+	}
+
+	// 注釈がなければ本体から返り値の型を決める
+	if fl.ResultType == nil {
+		results := c.fn.returns
+		if last != nil {
+			results = append(results, last)
+		}
+		if len(results) > 0 {
+			sig.Result = results[0]
+			for _, t := range results[1:] {
+				sig.Result = join(sig.Result, t)
+			}
+		}
+	}
+
+	c.fn = outerFn
+	return sig
+}
+
+// signature 注釈だけから分かる関数の型。注釈のないところは Any。
+// 同じ関数には同じ値を返すので、注釈の誤りは一度だけ報告される。
+func (c *checker) signature(fl *ast.FuncLiteral) *Func {
+	if sig, ok := c.sigs[fl]; ok {
+		return sig
+	}
+
+	sig := &Func{Result: Any}
+	c.sigs[fl] = sig
+	for i := range fl.Params {
+		var t Type = Any
+		if i < len(fl.ParamTypes) && fl.ParamTypes[i] != nil {
+			t = c.typeExpr(fl.ParamTypes[i])
+		}
+		sig.Params = append(sig.Params, t)
+	}
+	if fl.ResultType != nil {
+		sig.Result = c.typeExpr(fl.ResultType)
+	}
+	return sig
+}
+
+func (c *checker) callExpr(expr *ast.CallExpr) Type {
+	var fn Type = Any
+	if expr.Fn != nil {
+		fn = c.expr(expr.Fn)
+	}
+
+	args := []Type{}
+	for _, a := range expr.Args {
+		args = append(args, c.expr(a))
+	}
+
+	switch fn := fn.(type) {
+	case *Func:
+		if len(args) != len(fn.Params) {
+			c.errorf(expr.Pos(), "wrong number of arguments. got=%d, want=%d", len(args), len(fn.Params))
+			return fn.Result
+		}
+		for i, arg := range args {
+			if !Consistent(arg, fn.Params[i]) {
+				c.errorf(expr.Args[i].Pos(), "cannot use %s as %s in argument %d", arg, fn.Params[i], i+1)
+			}
+		}
+		return fn.Result
+
+	default:
+		if fn != Any {
+			c.errorf(expr.Pos(), "cannot call non-function %s", fn)
+		}
+		return Any
+	}
+}
+
+// arrayLiteral 要素の型がそろっていなければ Any の配列になる。
+func (c *checker) arrayLiteral(expr *ast.ArrayLiteral) Type {
+	var elem Type
+	for _, e := range expr.Elems {
+		t := c.expr(e)
+		if elem == nil {
+			elem = t
+		} else {
+			elem = join(elem, t)
+		}
+	}
+	if elem == nil {
+		elem = Any
+	}
+	return &Array{Elem: elem}
+}
+
+// mapLiteral 値の型がそろっていなければ Any の連想配列になる。
+func (c *checker) mapLiteral(expr *ast.MapLiteral) Type {
+	var val Type
+	for i, k := range expr.Keys {
+		if key := c.expr(k); !Consistent(key, String) {
+			c.errorf(k.Pos(), "cannot use %s as map key", key)
+		}
+		t := c.expr(expr.Values[i])
+		if val == nil {
+			val = t
+		} else {
+			val = join(val, t)
+		}
+	}
+	if val == nil {
+		val = Any
+	}
+	return &Map{Value: val}
+}
+
+func (c *checker) indexExpr(expr *ast.IndexExpr) Type {
+	var left, index Type = Any, Any
+	if expr.Left != nil {
+		left = c.expr(expr.Left)
+	}
+	if expr.Index != nil {
+		index = c.expr(expr.Index)
+	}
+
+	switch left := left.(type) {
+	case *Array:
+		if !Consistent(index, Int) {
+			c.errorf(expr.Index.Pos(), "cannot use %s as array index", index)
+		}
+		return left.Elem
+	case *Map:
+		if !Consistent(index, String) {
+			c.errorf(expr.Index.Pos(), "cannot use %s as map key", index)
+		}
+		return left.Value
+	default:
+		if left != Any {
+			c.errorf(expr.Pos(), "cannot index %s", left)
+		}
+		return Any
+	}
+}
+
+// typeExpr 注釈を型にする。知らない名前は Any として扱う。
+func (c *checker) typeExpr(t ast.TypeExpr) Type {
+	switch t := t.(type) {
+	case *ast.NamedType:
+		if b, ok := basics[t.Name]; ok {
+			return b
+		}
+		c.errorf(t.Pos(), "unknown type %s", t.Name)
+		return Any
+
+	case *ast.FuncType:
+		fn := &Func{Result: Any}
+		for _, p := range t.Params {
+			fn.Params = append(fn.Params, c.typeExpr(p))
+		}
+		if t.Result != nil {
+			fn.Result = c.typeExpr(t.Result)
+		}
+		return fn
+	}
+	return Any
+}
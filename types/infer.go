@@ -0,0 +1,726 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ei1chi/sample-lang/ast"
+	"github.com/ei1chi/sample-lang/resolve"
+	"github.com/ei1chi/sample-lang/token"
+)
+
+// Infer は注釈がなくても使えるよう、Hindley–Milner の方法で型を推論する。
+// Check と違って Any で妥協しないので、型の揃わない == なども誤りになる。
+
+// Null 値を残さない文や、else のない if の値の型。推論でだけ使う。
+var Null = &Basic{Name: "null"}
+
+// TypeVar 推論中のまだ決まっていない型。
+type TypeVar struct {
+	id  int
+	ref Type // 決まればその型
+}
+
+func (v *TypeVar) String() string {
+	return typeStrings(v)[0].(string)
+}
+
+// Scheme let で束縛した多相な型。Vars は使うたびに新しい型変数に置き換える。
+type Scheme struct {
+	Vars []*TypeVar
+	Type Type
+}
+
+func (s *Scheme) String() string {
+	return typeStrings(s.Type)[0].(string)
+}
+
+// Inference Infer の結果。型変数は決まった型に置き換えてある。
+type Inference struct {
+	Types map[ast.Expr]Type
+	Defs  map[*ast.Ident]*Scheme // let と仮引数で宣言した変数の型
+}
+
+// Infer program の型を推論する。
+func Infer(program *ast.Program) (*Inference, []*Error) {
+	in := &inferrer{
+		info: &Inference{
+			Types: map[ast.Expr]Type{},
+			Defs:  map[*ast.Ident]*Scheme{},
+		},
+		res: resolve.Resolve(program, nil),
+		env: &env{names: map[*resolve.Symbol]*Scheme{}},
+	}
+	in.stmts(program.Stmts)
+
+	for expr, t := range in.info.Types {
+		in.info.Types[expr] = resolveType(t)
+	}
+	for ident, s := range in.info.Defs {
+		in.info.Defs[ident] = &Scheme{Vars: s.Vars, Type: resolveType(s.Type)}
+	}
+
+	return in.info, in.errors
+}
+
+// env 関数ごとの変数の型。変数は resolve の Symbol で区別する。
+type env struct {
+	outer *env
+	names map[*resolve.Symbol]*Scheme
+}
+
+func (e *env) lookup(sym *resolve.Symbol) (*Scheme, bool) {
+	for ; e != nil; e = e.outer {
+		if s, ok := e.names[sym]; ok {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+type inferrer struct {
+	info   *Inference
+	errors []*Error
+	res    *resolve.Info
+	env    *env
+	result Type // 推論中の関数の返り値。プログラムの直下なら nil
+	nextID int
+}
+
+func (in *inferrer) errorf(pos token.Position, format string, a ...interface{}) {
+	in.errors = append(in.errors, &Error{Pos: pos, Message: fmt.Sprintf(format, a...)})
+}
+
+func (in *inferrer) fresh() *TypeVar {
+	in.nextID++
+	return &TypeVar{id: in.nextID}
+}
+
+func (in *inferrer) declare(ident *ast.Ident, s *Scheme) {
+	if sym := in.res.Defs[ident]; sym != nil {
+		in.env.names[sym] = s
+	}
+	in.info.Defs[ident] = s
+}
+
+// stmts 文を順に推論し、ブロックの値の型を返す。
+func (in *inferrer) stmts(stmts []ast.Stmt) Type {
+	var last Type = Null
+	for _, stmt := range stmts {
+		last = in.stmt(stmt)
+	}
+	return last
+}
+
+func (in *inferrer) stmt(stmt ast.Stmt) Type {
+	switch stmt := stmt.(type) {
+	case *ast.LetStmt:
+		in.letStmt(stmt)
+		return Null
+
+	case *ast.ExportStmt:
+		in.letStmt(stmt.Let)
+		return Null
+
+	case *ast.ImportStmt:
+		// モジュールの中身は調べないので、選んだ値はどれも使い方から決まる型になる
+		in.declare(stmt.Name, &Scheme{Type: in.fresh()})
+		return Null
+
+	case *ast.ReturnStmt:
+		if stmt.ReturnValue == nil {
+			return in.fresh()
+		}
+		t := in.expr(stmt.ReturnValue)
+		if in.result != nil {
+			if err := unify(t, in.result); err != nil {
+				in.errorf(stmt.ReturnValue.Pos(), "cannot use %s as %s in return", typeStrings(t, in.result)...)
+			}
+		}
+		// return の後には進まないので、ブロックの値はどの型でもよい
+		return in.fresh()
+
+	case *ast.ExprStmt:
+		if stmt.Expr == nil {
+			return in.fresh()
+		}
+		return in.expr(stmt.Expr)
+
+	case *ast.ThrowStmt:
+		if stmt.Value != nil {
+			in.expr(stmt.Value)
+		}
+		// throw の後にも進まない
+		return in.fresh()
+
+	case *ast.BlockStmt:
+		return in.stmts(stmt.Stmts)
+	}
+	return Null
+}
+
+func (in *inferrer) letStmt(stmt *ast.LetStmt) {
+	if stmt.Value == nil {
+		return
+	}
+
+	// 再帰呼び出しは単相として扱う
+	var self *TypeVar
+	sym := in.res.Defs[stmt.Name]
+	prev, hasPrev := in.env.names[sym]
+	if _, ok := stmt.Value.(*ast.FuncLiteral); ok && sym != nil {
+		self = in.fresh()
+		in.env.names[sym] = &Scheme{Type: self}
+	}
+
+	t := in.expr(stmt.Value)
+	if self != nil {
+		// 再帰呼び出しとの食い違いは呼び出し側で報告済み
+		unify(self, t)
+
+		// 一般化の邪魔にならないよう、仮の束縛を外しておく
+		if hasPrev {
+			in.env.names[sym] = prev
+		} else {
+			delete(in.env.names, sym)
+		}
+	}
+
+	if stmt.Type != nil {
+		declared := in.typeExpr(stmt.Type)
+		if err := unify(t, declared); err != nil {
+			in.errorf(stmt.Value.Pos(), "cannot use %s as %s in let %s", append(typeStrings(t, declared), stmt.Name.Value)...)
+		}
+	}
+
+	in.declare(stmt.Name, in.generalize(t))
+}
+
+func (in *inferrer) expr(expr ast.Expr) Type {
+	t := in.exprType(expr)
+	in.info.Types[expr] = t
+	return t
+}
+
+func (in *inferrer) exprType(expr ast.Expr) Type {
+	switch expr := expr.(type) {
+	case *ast.IntLiteral:
+		return Int
+
+	case *ast.Boolean:
+		return Bool
+
+	case *ast.StringLiteral:
+		return String
+
+	case *ast.Ident:
+		if ref, ok := in.res.Uses[expr]; ok {
+			if s, ok := in.env.lookup(ref.Symbol); ok {
+				return in.instantiate(s)
+			}
+		}
+		// 組み込み関数や、後から宣言されるグローバル変数
+		return in.fresh()
+
+	case *ast.PostfixExpr:
+		// エラーの値で抜けるときの型は表せないので、? は被演算子の型をそのまま返す
+		if expr.Left == nil {
+			return in.fresh()
+		}
+		return in.expr(expr.Left)
+
+	case *ast.PrefixExpr:
+		if expr.Right == nil {
+			return in.fresh()
+		}
+		right := in.expr(expr.Right)
+		if expr.Operator == "-" {
+			if err := unify(right, Int); err != nil {
+				in.errorf(expr.Pos(), "cannot negate %s", typeStrings(right)...)
+			}
+			return Int
+		}
+		return Bool
+
+	case *ast.InfixExpr:
+		return in.infixExpr(expr)
+
+	case *ast.IfExpr:
+		return in.ifExpr(expr)
+
+	case *ast.TryExpr:
+		return in.tryExpr(expr)
+
+	case *ast.FuncLiteral:
+		return in.funcLiteral(expr)
+
+	case *ast.CallExpr:
+		return in.callExpr(expr)
+
+	case *ast.ArrayLiteral:
+		return in.arrayLiteral(expr)
+
+	case *ast.MapLiteral:
+		return in.mapLiteral(expr)
+
+	case *ast.IndexExpr:
+		return in.indexExpr(expr)
+
+	case *ast.SelectorExpr:
+		if expr.X != nil {
+			in.expr(expr.X)
+		}
+		return in.fresh()
+	}
+
+	return in.fresh()
+}
+
+func (in *inferrer) infixExpr(expr *ast.InfixExpr) Type {
+	if expr.Left == nil || expr.Right == nil {
+		return in.fresh()
+	}
+	left := in.expr(expr.Left)
+	right := in.expr(expr.Right)
+
+	switch expr.Operator {
+	case "+", "-", "*", "/":
+		if unify(left, Int) != nil || unify(right, Int) != nil {
+			in.errorf(expr.Pos(), "cannot %s %s and %s", append([]interface{}{verbs[expr.Operator]}, typeStrings(left, right)...)...)
+		}
+		return Int
+
+	case "<", ">":
+		if unify(left, Int) != nil || unify(right, Int) != nil {
+			in.errorf(expr.Pos(), "cannot compare %s and %s with %s", append(typeStrings(left, right), expr.Operator)...)
+		}
+		return Bool
+
+	case "==", "!=":
+		if unify(left, right) != nil {
+			in.errorf(expr.Pos(), "cannot compare %s and %s with %s", append(typeStrings(left, right), expr.Operator)...)
+		}
+		return Bool
+	}
+	return in.fresh()
+}
+
+func (in *inferrer) ifExpr(expr *ast.IfExpr) Type {
+	if expr.Cond != nil {
+		in.expr(expr.Cond)
+	}
+
+	var cons Type = Null
+	if expr.Cons != nil {
+		cons = in.stmts(expr.Cons.Stmts)
+	}
+	if expr.Alt == nil {
+		// 条件が偽なら null になる
+		return Null
+	}
+	alt := in.stmts(expr.Alt.Stmts)
+
+	if err := unify(cons, alt); err != nil {
+		in.errorf(expr.Pos(), "if branches have different types %s and %s", typeStrings(cons, alt)...)
+	}
+	return cons
+}
+
+// tryExpr 値は本体か catch のどちらかになる。finally の値は使わない。
+func (in *inferrer) tryExpr(expr *ast.TryExpr) Type {
+	var body Type = Null
+	if expr.Body != nil {
+		body = in.stmts(expr.Body.Stmts)
+	}
+
+	if expr.Catch != nil {
+		// 受け取ったエラーの型は表せないので、使い方から決まる型にしておく
+		in.declare(expr.Param, &Scheme{Type: in.fresh()})
+		catch := in.stmts(expr.Catch.Stmts)
+		if err := unify(body, catch); err != nil {
+			in.errorf(expr.Pos(), "try and catch have different types %s and %s", typeStrings(body, catch)...)
+		}
+	}
+
+	if expr.Finally != nil {
+		in.stmts(expr.Finally.Stmts)
+	}
+	return body
+}
+
+func (in *inferrer) funcLiteral(fl *ast.FuncLiteral) Type {
+	fn := &Func{}
+	for i := range fl.Params {
+		if i < len(fl.ParamTypes) && fl.ParamTypes[i] != nil {
+			fn.Params = append(fn.Params, in.typeExpr(fl.ParamTypes[i]))
+		} else {
+			fn.Params = append(fn.Params, in.fresh())
+		}
+	}
+	if fl.ResultType != nil {
+		fn.Result = in.typeExpr(fl.ResultType)
+	} else {
+		fn.Result = in.fresh()
+	}
+
+	outerEnv, outerResult := in.env, in.result
+	in.env = &env{outer: outerEnv, names: map[*resolve.Symbol]*Scheme{}}
+	in.result = fn.Result
+
+	for i, param := range fl.Params {
+		in.declare(param, &Scheme{Type: fn.Params[i]})
+	}
+
+	if fl.Body != nil {
+		last := in.stmts(fl.Body.Stmts)
+		if err := unify(last, fn.Result); err != nil {
+			pos := fl.Body.Pos()
+			if n := len(fl.Body.Stmts); n > 0 {
+				pos = fl.Body.Stmts[n-1].Pos()
+			}
+			in.errorf(pos, "cannot use %s as %s in return", typeStrings(last, fn.Result)...)
+		}
+	}
+
+	in.env, in.result = outerEnv, outerResult
+	return fn
+}
+
+func (in *inferrer) callExpr(expr *ast.CallExpr) Type {
+	var callee Type = in.fresh()
+	if expr.Fn != nil {
+		callee = in.expr(expr.Fn)
+	}
+
+	args := []Type{}
+	for _, a := range expr.Args {
+		args = append(args, in.expr(a))
+	}
+
+	switch fn := prune(callee).(type) {
+	case *Func:
+		if len(args) != len(fn.Params) {
+			in.errorf(expr.Pos(), "wrong number of arguments. got=%d, want=%d", len(args), len(fn.Params))
+			return fn.Result
+		}
+		for i, arg := range args {
+			if err := unify(arg, fn.Params[i]); err != nil {
+				in.errorf(expr.Args[i].Pos(), "cannot use %s as %s in argument %d", append(typeStrings(arg, fn.Params[i]), i+1)...)
+			}
+		}
+		return fn.Result
+
+	case *TypeVar:
+		result := in.fresh()
+		want := &Func{Params: args, Result: result}
+		if err := unify(fn, want); err != nil {
+			in.errorf(expr.Pos(), "infinite type: %s occurs in %s", typeStrings(fn, want)...)
+		}
+		return result
+
+	default:
+		in.errorf(expr.Pos(), "cannot call non-function %s", typeStrings(fn)...)
+		return in.fresh()
+	}
+}
+
+// arrayLiteral 要素はすべて同じ型でなければならない。
+func (in *inferrer) arrayLiteral(expr *ast.ArrayLiteral) Type {
+	elem := in.fresh()
+	for _, e := range expr.Elems {
+		if e == nil {
+			continue
+		}
+		t := in.expr(e)
+		if err := unify(elem, t); err != nil {
+			in.errorf(e.Pos(), "array elements have different types %s and %s", typeStrings(elem, t)...)
+		}
+	}
+	return &Array{Elem: elem}
+}
+
+// mapLiteral キーは文字列で、値はすべて同じ型でなければならない。
+func (in *inferrer) mapLiteral(expr *ast.MapLiteral) Type {
+	val := in.fresh()
+	for i, k := range expr.Keys {
+		if k != nil {
+			if t := in.expr(k); unify(t, String) != nil {
+				in.errorf(k.Pos(), "cannot use %s as map key", typeStrings(t)...)
+			}
+		}
+		if v := expr.Values[i]; v != nil {
+			t := in.expr(v)
+			if err := unify(val, t); err != nil {
+				in.errorf(v.Pos(), "map values have different types %s and %s", typeStrings(val, t)...)
+			}
+		}
+	}
+	return &Map{Value: val}
+}
+
+// indexExpr 添字が文字列なら連想配列、そうでなければ配列とみなす。
+func (in *inferrer) indexExpr(expr *ast.IndexExpr) Type {
+	var left, index Type = in.fresh(), Int
+	if expr.Left != nil {
+		left = in.expr(expr.Left)
+	}
+	if expr.Index != nil {
+		index = in.expr(expr.Index)
+	}
+
+	elem := in.fresh()
+	var want Type = &Map{Value: elem}
+	if prune(index) != String {
+		want = &Array{Elem: elem}
+		if err := unify(index, Int); err != nil {
+			in.errorf(expr.Index.Pos(), "cannot use %s as array index", typeStrings(index)...)
+		}
+	}
+	if err := unify(left, want); err != nil {
+		in.errorf(expr.Pos(), "cannot index %s", typeStrings(left)...)
+	}
+	return elem
+}
+
+// typeExpr 注釈を型にする。any は新しい型変数になる。
+func (in *inferrer) typeExpr(t ast.TypeExpr) Type {
+	switch t := t.(type) {
+	case *ast.NamedType:
+		switch t.Name {
+		case "int":
+			return Int
+		case "bool":
+			return Bool
+		case "string":
+			return String
+		case "any":
+			return in.fresh()
+		}
+		in.errorf(t.Pos(), "unknown type %s", t.Name)
+		return in.fresh()
+
+	case *ast.FuncType:
+		fn := &Func{}
+		for _, p := range t.Params {
+			fn.Params = append(fn.Params, in.typeExpr(p))
+		}
+		if t.Result != nil {
+			fn.Result = in.typeExpr(t.Result)
+		} else {
+			fn.Result = in.fresh()
+		}
+		return fn
+	}
+	return in.fresh()
+}
+
+// instantiate s の型変数を新しいものに置き換える。
+func (in *inferrer) instantiate(s *Scheme) Type {
+	if len(s.Vars) == 0 {
+		return s.Type
+	}
+
+	subst := map[*TypeVar]Type{}
+	for _, v := range s.Vars {
+		subst[v] = in.fresh()
+	}
+	return substitute(s.Type, subst)
+}
+
+// generalize 環境に現れない t の型変数を多相にする。
+func (in *inferrer) generalize(t Type) *Scheme {
+	bound := map[*TypeVar]bool{}
+	for e := in.env; e != nil; e = e.outer {
+		for _, s := range e.names {
+			for _, v := range freeVars(s.Type, nil) {
+				bound[v] = true
+			}
+		}
+	}
+
+	s := &Scheme{Type: t}
+	for _, v := range freeVars(t, nil) {
+		if !bound[v] {
+			s.Vars = append(s.Vars, v)
+		}
+	}
+	return s
+}
+
+// prune 決まった型変数を辿る。
+func prune(t Type) Type {
+	for {
+		v, ok := t.(*TypeVar)
+		if !ok || v.ref == nil {
+			return t
+		}
+		t = v.ref
+	}
+}
+
+// resolveType 型変数を決まった型に置き換えた型。
+func resolveType(t Type) Type {
+	switch t := prune(t).(type) {
+	case *Func:
+		fn := &Func{Result: resolveType(t.Result)}
+		for _, p := range t.Params {
+			fn.Params = append(fn.Params, resolveType(p))
+		}
+		return fn
+	case *Array:
+		return &Array{Elem: resolveType(t.Elem)}
+	case *Map:
+		return &Map{Value: resolveType(t.Value)}
+	default:
+		return t
+	}
+}
+
+func substitute(t Type, subst map[*TypeVar]Type) Type {
+	switch t := prune(t).(type) {
+	case *TypeVar:
+		if s, ok := subst[t]; ok {
+			return s
+		}
+		return t
+	case *Func:
+		fn := &Func{Result: substitute(t.Result, subst)}
+		for _, p := range t.Params {
+			fn.Params = append(fn.Params, substitute(p, subst))
+		}
+		return fn
+	case *Array:
+		return &Array{Elem: substitute(t.Elem, subst)}
+	case *Map:
+		return &Map{Value: substitute(t.Value, subst)}
+	default:
+		return t
+	}
+}
+
+// freeVars t に現れる決まっていない型変数を、現れた順に acc に加える。
+func freeVars(t Type, acc []*TypeVar) []*TypeVar {
+	switch t := prune(t).(type) {
+	case *TypeVar:
+		for _, v := range acc {
+			if v == t {
+				return acc
+			}
+		}
+		return append(acc, t)
+	case *Func:
+		for _, p := range t.Params {
+			acc = freeVars(p, acc)
+		}
+		return freeVars(t.Result, acc)
+	case *Array:
+		return freeVars(t.Elem, acc)
+	case *Map:
+		return freeVars(t.Value, acc)
+	}
+	return acc
+}
+
+// unify a と b を同じ型にする。
+func unify(a, b Type) error {
+	a, b = prune(a), prune(b)
+
+	if v, ok := a.(*TypeVar); ok {
+		if a == b {
+			return nil
+		}
+		for _, w := range freeVars(b, nil) {
+			if w == v {
+				return errInfinite
+			}
+		}
+		v.ref = b
+		return nil
+	}
+	if _, ok := b.(*TypeVar); ok {
+		return unify(b, a)
+	}
+
+	switch a := a.(type) {
+	case *Basic:
+		if a == b {
+			return nil
+		}
+
+	case *Func:
+		b, ok := b.(*Func)
+		if !ok || len(a.Params) != len(b.Params) {
+			break
+		}
+		for i := range a.Params {
+			if err := unify(a.Params[i], b.Params[i]); err != nil {
+				return err
+			}
+		}
+		return unify(a.Result, b.Result)
+
+	case *Array:
+		b, ok := b.(*Array)
+		if !ok {
+			break
+		}
+		return unify(a.Elem, b.Elem)
+
+	case *Map:
+		b, ok := b.(*Map)
+		if !ok {
+			break
+		}
+		return unify(a.Value, b.Value)
+	}
+
+	return errMismatch
+}
+
+var (
+	errMismatch = errors.New("type mismatch")
+	errInfinite = errors.New("infinite type")
+)
+
+// typeStrings 型を文字列にする。型変数は全体を通して現れた順に 'a, 'b, ... と名付ける。
+func typeStrings(types ...Type) []interface{} {
+	names := map[*TypeVar]string{}
+
+	var str func(t Type) string
+	str = func(t Type) string {
+		switch t := prune(t).(type) {
+		case *TypeVar:
+			if _, ok := names[t]; !ok {
+				names[t] = varName(len(names))
+			}
+			return names[t]
+		case *Func:
+			params := []string{}
+			for _, p := range t.Params {
+				params = append(params, str(p))
+			}
+			return "fn(" + strings.Join(params, ", ") + "): " + str(t.Result)
+		case *Array:
+			return "[" + str(t.Elem) + "]"
+		case *Map:
+			return "{string: " + str(t.Value) + "}"
+		default:
+			return t.String()
+		}
+	}
+
+	result := []interface{}{}
+	for _, t := range types {
+		result = append(result, str(t))
+	}
+	return result
+}
+
+func varName(i int) string {
+	name := string(rune('a' + i%26))
+	if i >= 26 {
+		name += fmt.Sprint(i / 26)
+	}
+	return "'" + name
+}
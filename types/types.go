@@ -0,0 +1,133 @@
+// Package types は型注釈を手がかりに式の型を求め、実行前に型の誤りを見つける。
+//
+// 注釈は任意で、注釈のない引数や分からない値は Any になる。
+// Any はどの型とも組み合わせられるので、注釈を増やすほど多くの誤りが見つかる。
+package types
+
+type Type interface {
+	String() string
+}
+
+// Basic 名前だけで決まる型。
+type Basic struct {
+	Name string
+}
+
+func (b *Basic) String() string {
+	return b.Name
+}
+
+var (
+	Int    = &Basic{Name: "int"}
+	Bool   = &Basic{Name: "bool"}
+	String = &Basic{Name: "string"}
+	Any    = &Basic{Name: "any"} // 静的には分からない
+)
+
+// basics 注釈に書ける名前
+var basics = map[string]*Basic{
+	"int":    Int,
+	"bool":   Bool,
+	"string": String,
+	"any":    Any,
+}
+
+// Func 関数の型。
+type Func struct {
+	Params []Type
+	Result Type
+}
+
+func (f *Func) String() string {
+	return typeStrings(f)[0].(string)
+}
+
+// Array 配列の型。要素はすべて Elem 型になる。
+type Array struct {
+	Elem Type
+}
+
+func (a *Array) String() string {
+	return typeStrings(a)[0].(string)
+}
+
+// Map 文字列をキーにした連想配列の型。値はすべて Value 型になる。
+type Map struct {
+	Value Type
+}
+
+func (m *Map) String() string {
+	return typeStrings(m)[0].(string)
+}
+
+// Consistent a と b を組み合わせてよいか。Any はどの型とも組み合わせられる。
+func Consistent(a, b Type) bool {
+	if a == Any || b == Any {
+		return true
+	}
+
+	switch a := a.(type) {
+	case *Basic:
+		return a == b
+
+	case *Func:
+		b, ok := b.(*Func)
+		if !ok || len(a.Params) != len(b.Params) {
+			return false
+		}
+		for i := range a.Params {
+			if !Consistent(a.Params[i], b.Params[i]) {
+				return false
+			}
+		}
+		return Consistent(a.Result, b.Result)
+
+	case *Array:
+		b, ok := b.(*Array)
+		return ok && Consistent(a.Elem, b.Elem)
+
+	case *Map:
+		b, ok := b.(*Map)
+		return ok && Consistent(a.Value, b.Value)
+	}
+
+	return false
+}
+
+// Identical a と b が同じ型か。
+func Identical(a, b Type) bool {
+	switch a := a.(type) {
+	case *Basic:
+		return a == b
+
+	case *Func:
+		b, ok := b.(*Func)
+		if !ok || len(a.Params) != len(b.Params) {
+			return false
+		}
+		for i := range a.Params {
+			if !Identical(a.Params[i], b.Params[i]) {
+				return false
+			}
+		}
+		return Identical(a.Result, b.Result)
+
+	case *Array:
+		b, ok := b.(*Array)
+		return ok && Identical(a.Elem, b.Elem)
+
+	case *Map:
+		b, ok := b.(*Map)
+		return ok && Identical(a.Value, b.Value)
+	}
+
+	return false
+}
+
+// join 二つの枝のどちらかになる値の型。同じでなければ Any。
+func join(a, b Type) Type {
+	if Identical(a, b) {
+		return a
+	}
+	return Any
+}
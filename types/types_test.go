@@ -0,0 +1,235 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/ei1chi/sample-lang/ast"
+	"github.com/ei1chi/sample-lang/lexer"
+	"github.com/ei1chi/sample-lang/parser"
+)
+
+func TestCheckErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"1 + true", []string{"1:3: cannot add int and bool"}},
+		{"let b: bool = true; b * 2", []string{"1:23: cannot multiply bool and int"}},
+		{"true < false", []string{"1:6: cannot compare bool and bool with <"}},
+		{"-true", []string{"1:1: cannot negate bool"}},
+		{"let x: int = true;", []string{"1:14: cannot use bool as int in let x"}},
+		{"let f = fn(a: int, b: bool): int { a }; f(true, false)", []string{"1:43: cannot use bool as int in argument 1"}},
+		{"let f = fn(a: int): int { a }; f(1, 2)", []string{"1:33: wrong number of arguments. got=2, want=1"}},
+		{"let f = fn(a: int): bool { if (a > 0) { return 1; }; true }", []string{"1:48: cannot use int as bool in return"}},
+		{"let f = fn(): int { false }", []string{"1:21: cannot use bool as int in return"}},
+		{"let x = 1; x(2)", []string{"1:13: cannot call non-function int"}},
+		{"let x: text = 1;", []string{"1:8: unknown type text"}},
+		{"let x: string = 1;", []string{"1:17: cannot use int as string in let x"}},
+		{"\"a\" + 1", []string{"1:5: cannot add string and int"}},
+		{"import \"lib/util\"; util.f(1) + util.x", nil},
+		{"[1, 2][0] + true", []string{"1:11: cannot add int and bool"}},
+		{"[1, 2][true]", []string{"1:8: cannot use bool as array index"}},
+		{"let x = 1; x[0]", []string{"1:13: cannot index int"}},
+		{"[1, true][0] + 1", nil},
+		{`{"a": 1}["a"] + true`, []string{"1:15: cannot add int and bool"}},
+		{`{"a": 1}[0]`, []string{"1:10: cannot use int as map key"}},
+		{`{1: 1}`, []string{"1:2: cannot use int as map key"}},
+		{`let f = fn(m) { m["a"] }; f(1)`, nil},
+		{"let f = fn(g: fn(int): int): int { g(true) }", []string{"1:38: cannot use bool as int in argument 1"}},
+		{"let apply = fn(g: fn(int): int) { g(1) }; apply(fn(x: bool): bool { x })", []string{"1:49: cannot use fn(bool): bool as fn(int): int in argument 1"}},
+
+		// 注釈がなければ誤りにしない
+		{"let f = fn(a) { a + 1 }; f(true)", nil},
+		{"let f = fn(a, b) { a + b }; f(1, 2) + 3", nil},
+		{"1 == true", nil},
+		{"let x = if (true) { 1 } else { false }; x + 1", nil},
+		{"puts(1 + 2)", nil},
+		{"let fib = fn(n: int): int { if (n < 2) { return n; }; fib(n - 1) + fib(n - 2) }; fib(10)", nil},
+	}
+
+	for _, test := range tests {
+		_, errs := Check(parse(t, test.input))
+
+		if len(errs) != len(test.expected) {
+			t.Errorf("wrong number of errors for %q. want=%d, got=%v", test.input, len(test.expected), errs)
+			continue
+		}
+		for i, err := range errs {
+			if err.Error() != test.expected[i] {
+				t.Errorf("wrong error for %q. want=%q, got=%q", test.input, test.expected[i], err.Error())
+			}
+		}
+	}
+}
+
+func TestInferredTypes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 + 2", "int"},
+		{"1 < 2", "bool"},
+		{"!5", "bool"},
+		{"if (true) { 1 } else { 2 }", "int"},
+		{"if (true) { 1 }", "any"},
+		{"if (true) { 1 } else { false }", "any"},
+		{"fn(a: int, b) { a }", "fn(int, any): int"},
+		{"fn(a: int) { if (a > 0) { return true; }; false }", "fn(int): bool"},
+		{"fn(a: int) { if (a > 0) { return true; }; 1 }", "fn(int): any"},
+		{"let f = fn(): bool { true }; f()", "bool"},
+		{"let x = 1; x", "int"},
+		{"unknown", "any"},
+		{"[1, 2]", "[int]"},
+		{"[[true], [false]]", "[[bool]]"},
+		{"[1, true]", "[any]"},
+		{`{"a": 1, "b": 2}`, "{string: int}"},
+		{`{"a": 1, "b": false}`, "{string: any}"},
+		{`{"a": [1]}["a"]`, "[int]"},
+	}
+
+	for _, test := range tests {
+		program := parse(t, test.input)
+		info, errs := Check(program)
+		if len(errs) != 0 {
+			t.Errorf("unexpected errors for %q: %v", test.input, errs)
+			continue
+		}
+
+		last := program.Stmts[len(program.Stmts)-1].(*ast.ExprStmt).Expr
+		got, ok := info.Types[last]
+		if !ok {
+			t.Errorf("no type recorded for %q", test.input)
+			continue
+		}
+		if got.String() != test.expected {
+			t.Errorf("wrong type for %q. want=%s, got=%s", test.input, test.expected, got)
+		}
+	}
+}
+
+func TestConsistent(t *testing.T) {
+	intToInt := &Func{Params: []Type{Int}, Result: Int}
+	anyToInt := &Func{Params: []Type{Any}, Result: Int}
+	boolToInt := &Func{Params: []Type{Bool}, Result: Int}
+
+	tests := []struct {
+		a, b     Type
+		expected bool
+	}{
+		{Int, Int, true},
+		{Int, Bool, false},
+		{Int, Any, true},
+		{Any, Bool, true},
+		{intToInt, anyToInt, true},
+		{intToInt, boolToInt, false},
+		{intToInt, Int, false},
+		{intToInt, Any, true},
+	}
+
+	for _, test := range tests {
+		if got := Consistent(test.a, test.b); got != test.expected {
+			t.Errorf("Consistent(%s, %s) wrong. want=%t, got=%t", test.a, test.b, test.expected, got)
+		}
+	}
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+
+	p := parser.NewParser(lexer.NewLexer(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+func TestInferSignatures(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // 最後の let の型
+	}{
+		{"let inc = fn(x) { x + 1 };", "fn(int): int"},
+		{"let id = fn(x) { x };", "fn('a): 'a"},
+		{"let k = fn(x, y) { x };", "fn('a, 'b): 'a"},
+		{"let eq = fn(x, y) { x == y };", "fn('a, 'a): bool"},
+		{"let apply = fn(f, x) { f(x) };", "fn(fn('a): 'b, 'a): 'b"},
+		{"let compose = fn(f, g) { fn(x) { f(g(x)) } };", "fn(fn('a): 'b, fn('c): 'a): fn('c): 'b"},
+		{"let fib = fn(n) { if (n < 2) { return n; }; fib(n - 1) + fib(n - 2) };", "fn(int): int"},
+		{"let f = fn(b) { if (b) { 1 } else { 2 } };", "fn('a): int"},
+		{"let f = fn() { if (true) { 1 } };", "fn(): null"},
+		{"let f = fn(x: bool) { x };", "fn(bool): bool"},
+		{"let f = fn(x): int { x };", "fn(int): int"},
+		{"let id = fn(x) { x }; let a = id(1); let b = id(true);", "bool"},
+		{"let id = fn(x) { x }; let f = fn(a, b) { if (id(a) == 1) { id(b) } else { b } };", "fn(int, 'a): 'a"},
+		{"let first = fn(a) { a[0] };", "fn(['a]): 'a"},
+		{"let pair = fn(x) { [x, x + 1] };", "fn(int): [int]"},
+		{`let get = fn(m) { m["k"] };`, "fn({string: 'a}): 'a"},
+		{`let wrap = fn(k, v) { {k: v} };`, "fn(string, 'a): {string: 'a}"},
+	}
+
+	for _, test := range tests {
+		program := parse(t, test.input)
+		info, errs := Infer(program)
+		if len(errs) != 0 {
+			t.Errorf("unexpected errors for %q: %v", test.input, errs)
+			continue
+		}
+
+		var last *ast.LetStmt
+		for _, stmt := range program.Stmts {
+			if let, ok := stmt.(*ast.LetStmt); ok {
+				last = let
+			}
+		}
+		got := info.Defs[last.Name].String()
+		if got != test.expected {
+			t.Errorf("wrong type for %q. want=%s, got=%s", test.input, test.expected, got)
+		}
+	}
+}
+
+func TestInferErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"let f = fn(x) { x + true };", []string{"1:19: cannot add int and bool"}},
+		{"let inc = fn(x) { x + 1 }; inc(true)", []string{"1:32: cannot use bool as int in argument 1"}},
+		{"let f = fn(x) { x(x) };", []string{"1:18: infinite type: 'a occurs in fn('a): 'b"}},
+		{"let f = fn(x) { if (x) { 1 } else { false } };", []string{"1:17: if branches have different types int and bool"}},
+		{"1 == true", []string{"1:3: cannot compare int and bool with =="}},
+		{"let id = fn(x) { x }; id(1, 2)", []string{"1:25: wrong number of arguments. got=2, want=1"}},
+		{"let x = 1; x()", []string{"1:13: cannot call non-function int"}},
+		{"let f = fn(x) { if (x > 0) { return true; }; 1 };", []string{"1:46: cannot use int as bool in return"}},
+		{"let x: bool = 1;", []string{"1:15: cannot use int as bool in let x"}},
+		{"let x = try { 1 } catch (e) { true };", []string{"1:9: try and catch have different types int and bool"}},
+		{"let f = fn(x) { throw x; }; try { f(1) + 1 } finally { 0 };", nil},
+		{"let f = fn(x) { x? + 1 }; f(2) * 3;", nil},
+		{"let f = fn(x) { x? + true };", []string{"1:20: cannot add int and bool"}},
+		{"let a = [1, true];", []string{"1:13: array elements have different types int and bool"}},
+		{"let f = fn(a) { a[0] + a[true] };", []string{"1:26: cannot use bool as array index"}},
+		{"let x = 1; x[0]", []string{"1:13: cannot index int"}},
+		{`let m = {"a": 1, "b": true};`, []string{"1:23: map values have different types int and bool"}},
+		{`let f = fn(m) { m["a"] + m[0] };`, []string{"1:27: cannot index {string: 'a}"}},
+
+		// 多相な let は使うたびに別の型になれる
+		{"let id = fn(x) { x }; id(1); id(true);", nil},
+		// 仮引数は単相
+		{"let f = fn(g) { g(1); g(true) };", []string{"1:25: cannot use bool as int in argument 1"}},
+	}
+
+	for _, test := range tests {
+		_, errs := Infer(parse(t, test.input))
+
+		if len(errs) != len(test.expected) {
+			t.Errorf("wrong number of errors for %q. want=%d, got=%v", test.input, len(test.expected), errs)
+			continue
+		}
+		for i, err := range errs {
+			if err.Error() != test.expected[i] {
+				t.Errorf("wrong error for %q. want=%q, got=%q", test.input, test.expected[i], err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package vm
+
+import (
+	"github.com/ei1chi/sample-lang/code"
+	"github.com/ei1chi/sample-lang/object"
+)
+
+// Frame 関数呼び出し一回分の実行状態。
+type Frame struct {
+	cl          *object.Closure
+	ip          int
+	basePointer int // ローカル変数の先頭。戻るときにここまでスタックを戻す
+}
+
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{cl: cl, ip: -1, basePointer: basePointer}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
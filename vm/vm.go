@@ -0,0 +1,486 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/ei1chi/sample-lang/code"
+	"github.com/ei1chi/sample-lang/compiler"
+	"github.com/ei1chi/sample-lang/eval"
+	"github.com/ei1chi/sample-lang/object"
+	"github.com/ei1chi/sample-lang/token"
+)
+
+const (
+	StackSize   = 2048
+	GlobalsSize = 65536
+	MaxFrames   = 1024
+)
+
+var (
+	TRUE  = object.TrueValue
+	FALSE = object.FalseValue
+	NULL  = object.NullValue
+)
+
+type VM struct {
+	constants []object.Object
+
+	stack []object.Object
+	sp    int // 次に積む位置。スタックの一番上は stack[sp-1]
+
+	globals     []object.Object
+	globalNames []string
+	globalSlots map[string]int
+	names       []string
+	builtins    map[string]object.Object
+
+	frames      []*Frame
+	framesIndex int
+	handlers    []handler
+
+	lastPopped object.Object
+	result     object.Object
+
+	// Evaluator import と、import したモジュールの関数の呼び出しに使う。
+	// New は組み込みのモジュールだけを import できる評価器を入れる。
+	Evaluator *eval.Evaluator
+}
+
+// handler try のエラーハンドラ。エラーが起きたら、積んだときのフレームとスタックの高さに戻して target へ飛ぶ。
+type handler struct {
+	framesIndex int
+	sp          int
+	target      int
+}
+
+// New bytecode を実行する仮想マシンを作る。builtins はコンパイル時に解決できなかった名前の引き先。
+// 組み込み関数は Fn で呼ぶので、言語の関数を受け取って呼び出す組み込み関数には渡せない。
+func New(bytecode *compiler.Bytecode, builtins map[string]object.Object) *VM {
+	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions, Positions: bytecode.Positions}
+	mainClosure := &object.Closure{Fn: mainFn}
+	mainFrame := NewFrame(mainClosure, 0)
+
+	frames := make([]*Frame, MaxFrames)
+	frames[0] = mainFrame
+
+	globalSlots := make(map[string]int, len(bytecode.Globals))
+	for i, name := range bytecode.Globals {
+		globalSlots[name] = i
+	}
+
+	return &VM{
+		constants: bytecode.Constants,
+
+		stack: make([]object.Object, StackSize),
+		sp:    0,
+
+		globals:     make([]object.Object, GlobalsSize),
+		globalNames: bytecode.Globals,
+		globalSlots: globalSlots,
+		names:       bytecode.Names,
+		builtins:    builtins,
+
+		frames:      frames,
+		framesIndex: 1,
+
+		Evaluator: eval.New(eval.Config{}),
+	}
+}
+
+// Result 実行結果。最後に評価した文の値か、実行時エラーならその object.Error。
+func (vm *VM) Result() object.Object {
+	if vm.result != nil {
+		return vm.result
+	}
+	return vm.lastPopped
+}
+
+// Run 実行する。言語としての実行時エラーは Result で返し、
+// スタックあふれなど仮想マシン自体が続行できない場合だけ error を返す。
+func (vm *VM) Run() error {
+	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		vm.currentFrame().ip++
+
+		ip := vm.currentFrame().ip
+		ins := vm.currentFrame().Instructions()
+		op := code.Opcode(ins[ip])
+
+		// result が nil でなければ push し、エラーなら実行を止める
+		var result object.Object
+
+		switch op {
+		case code.OpConstant:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			result = vm.constants[constIndex]
+
+		case code.OpPop:
+			vm.lastPopped = vm.pop()
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv,
+			code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan:
+			right := vm.pop()
+			left := vm.pop()
+			result = eval.Infix(operators[op], left, right)
+
+		case code.OpTrue:
+			result = TRUE
+		case code.OpFalse:
+			result = FALSE
+		case code.OpNull:
+			result = NULL
+
+		case code.OpBang:
+			result = eval.Prefix("!", vm.pop())
+		case code.OpMinus:
+			result = eval.Prefix("-", vm.pop())
+
+		case code.OpArray:
+			n := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			elems := make([]object.Object, n)
+			copy(elems, vm.stack[vm.sp-n:vm.sp])
+			vm.sp -= n
+			result = &object.Array{Elems: elems}
+
+		case code.OpMap:
+			n := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+			result = vm.buildMap(n)
+
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+			result = eval.Index(left, index)
+
+		case code.OpSelect:
+			nameIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			result = eval.Select(vm.pop(), vm.names[nameIndex])
+
+		case code.OpImport:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			result = vm.Evaluator.Import(vm.constants[constIndex].(*object.String).Value)
+
+		case code.OpThrow:
+			result = eval.Throw(vm.pop())
+
+		case code.OpPushHandler:
+			target := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+			vm.handlers = append(vm.handlers, handler{framesIndex: vm.framesIndex, sp: vm.sp, target: target})
+
+		case code.OpPopHandler:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+		case code.OpJump:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip = pos - 1
+
+		case code.OpJumpNotTruthy:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			if !isTruthy(vm.pop()) {
+				vm.currentFrame().ip = pos - 1
+			}
+
+		case code.OpJumpNotErrorValue:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			if vm.stack[vm.sp-1].Type() != object.ERROR_VALUE {
+				vm.currentFrame().ip = pos - 1
+			}
+
+		case code.OpSetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			vm.globals[globalIndex] = vm.pop()
+
+		case code.OpGetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			result = vm.globals[globalIndex]
+			if result == nil {
+				result = newError("identifier not found: %s", vm.globalNames[globalIndex])
+			}
+
+		case code.OpSetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			vm.stack[vm.currentFrame().basePointer+int(localIndex)] = vm.pop()
+
+		case code.OpGetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			result = vm.stack[vm.currentFrame().basePointer+int(localIndex)]
+			if result == nil {
+				result = NULL
+			}
+
+		case code.OpGetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			result = vm.currentFrame().cl.Free[freeIndex]
+			if result == nil {
+				result = NULL
+			}
+
+		case code.OpGetName:
+			nameIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			result = vm.lookupName(vm.names[nameIndex])
+
+		case code.OpCurrentClosure:
+			result = vm.currentFrame().cl
+
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			numFree := code.ReadUint8(ins[ip+3:])
+			vm.currentFrame().ip += 3
+			result = vm.newClosure(int(constIndex), int(numFree))
+
+		case code.OpCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			errObj, err := vm.executeCall(int(numArgs))
+			if err != nil {
+				return err
+			}
+			result = errObj
+
+		case code.OpReturnValue:
+			returnValue := vm.pop()
+			if vm.framesIndex == 1 {
+				// トップレベルの return はプログラムを終える
+				vm.result = returnValue
+				return nil
+			}
+
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+			result = returnValue
+
+		case code.OpReturn:
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+			result = NULL
+
+		default:
+			return fmt.Errorf("unknown opcode %d", op)
+		}
+
+		if result == nil {
+			continue
+		}
+		if errObj, ok := result.(*object.Error); ok {
+			if !vm.raise(errObj) {
+				return nil
+			}
+			// ハンドラへ飛んだので、エラーの値を積む
+			result = &object.ErrorValue{Err: errObj}
+		}
+		if err := vm.push(result); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// raise err が起きた位置を記録し、捕まえる try があればそのハンドラまで戻る。
+// なければ err を結果にして false を返す。
+func (vm *VM) raise(err *object.Error) bool {
+	if err.Pos == (token.Position{}) {
+		vm.trace(err)
+	}
+	if len(vm.handlers) == 0 || !err.Catchable() {
+		vm.result = err
+		return false
+	}
+
+	h := vm.handlers[len(vm.handlers)-1]
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+	vm.framesIndex = h.framesIndex
+	vm.sp = h.sp
+	vm.currentFrame().ip = h.target - 1
+	return true
+}
+
+// trace err が今の命令で起きたことと、そのときの呼び出しの列を記録する。
+// 関数の名前は let で束縛した名前で、無名の関数なら空。一番外側は main。
+func (vm *VM) trace(err *object.Error) {
+	err.Stack = make([]object.StackFrame, 0, vm.framesIndex)
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		f := vm.frames[i]
+		frame := object.StackFrame{Name: f.cl.Fn.Name, Pos: f.cl.Fn.Positions.Lookup(f.ip)}
+		if i == 0 {
+			frame.Name = "main"
+		}
+		err.Stack = append(err.Stack, frame)
+	}
+	err.Pos = err.Stack[0].Pos
+}
+
+// buildMap スタックに積んだキーと値の組 n 個から連想配列を作る。
+func (vm *VM) buildMap(n int) object.Object {
+	pairs := vm.stack[vm.sp-2*n : vm.sp]
+	vm.sp -= 2 * n
+
+	m := object.NewMap()
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(*object.String)
+		if !ok {
+			return newError("map key must be %s, got %s", object.STRING, pairs[i].Type())
+		}
+		m.Set(key.Value, pairs[i+1])
+	}
+	return m
+}
+
+func (vm *VM) lookupName(name string) object.Object {
+	if slot, ok := vm.globalSlots[name]; ok && vm.globals[slot] != nil {
+		return vm.globals[slot]
+	}
+	if b, ok := vm.builtins[name]; ok {
+		return b
+	}
+	return newError("identifier not found: %s", name)
+}
+
+func (vm *VM) newClosure(constIndex, numFree int) object.Object {
+	fn, ok := vm.constants[constIndex].(*object.CompiledFunction)
+	if !ok {
+		return newError("not a function: %s", vm.constants[constIndex].Type())
+	}
+
+	free := make([]object.Object, numFree)
+	copy(free, vm.stack[vm.sp-numFree:vm.sp])
+	vm.sp = vm.sp - numFree
+
+	return &object.Closure{Fn: fn, Free: free}
+}
+
+// executeCall 関数を呼び出す。クロージャならフレームを積むだけで、結果は返さない。
+func (vm *VM) executeCall(numArgs int) (object.Object, error) {
+	callee := vm.stack[vm.sp-1-numArgs]
+
+	switch callee := callee.(type) {
+	case *object.Closure:
+		if numArgs != callee.Fn.NumParams {
+			return newError("wrong number of arguments. got=%d, want=%d", numArgs, callee.Fn.NumParams), nil
+		}
+
+		if vm.framesIndex >= MaxFrames {
+			return nil, fmt.Errorf("frame overflow")
+		}
+
+		frame := NewFrame(callee, vm.sp-numArgs)
+		vm.pushFrame(frame)
+
+		if frame.basePointer+callee.Fn.NumLocals >= StackSize {
+			return nil, fmt.Errorf("stack overflow")
+		}
+		// 引数以外のローカル変数を未定義に戻す
+		for i := vm.sp; i < frame.basePointer+callee.Fn.NumLocals; i++ {
+			vm.stack[i] = nil
+		}
+		vm.sp = frame.basePointer + callee.Fn.NumLocals
+		return nil, nil
+
+	case *object.Builtin:
+		args := make([]object.Object, numArgs)
+		copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+		vm.sp = vm.sp - numArgs - 1
+
+		result := callee.Fn(args...)
+		if result == nil {
+			return NULL, nil
+		}
+		// error() で作ったエラーの値には、作った位置を残す
+		if ev, ok := result.(*object.ErrorValue); ok && ev.Err.Pos == (token.Position{}) {
+			vm.trace(ev.Err)
+		}
+		return result, nil
+
+	case *object.Function:
+		// import したモジュールの関数は評価器で呼ぶ
+		args := make([]object.Object, numArgs)
+		copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+		vm.sp = vm.sp - numArgs - 1
+		if result := vm.Evaluator.Call(callee, args...); result != nil {
+			return result, nil
+		}
+		return NULL, nil
+
+	default:
+		return newError("not a function: %s", callee.Type()), nil
+	}
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) {
+	vm.frames[vm.framesIndex] = f
+	vm.framesIndex++
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
+func (vm *VM) push(o object.Object) error {
+	if vm.sp >= StackSize {
+		return fmt.Errorf("stack overflow")
+	}
+
+	vm.stack[vm.sp] = o
+	vm.sp++
+
+	return nil
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
+
+// =========================================
+// Operations
+// =========================================
+
+var operators = map[code.Opcode]string{
+	code.OpAdd:         "+",
+	code.OpSub:         "-",
+	code.OpMul:         "*",
+	code.OpDiv:         "/",
+	code.OpEqual:       "==",
+	code.OpNotEqual:    "!=",
+	code.OpGreaterThan: ">",
+	code.OpLessThan:    "<",
+}
+
+func isTruthy(obj object.Object) bool {
+	switch obj {
+	case NULL:
+		return false
+	case TRUE:
+		return true
+	case FALSE:
+		return false
+	default:
+		return true
+	}
+}
+
+func newError(format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...)}
+}
@@ -0,0 +1,245 @@
+package vm
+
+import (
+	"bytes"
+	"testing"
+	"testing/fstest"
+
+	"github.com/ei1chi/sample-lang/ast"
+	"github.com/ei1chi/sample-lang/compiler"
+	"github.com/ei1chi/sample-lang/eval"
+	"github.com/ei1chi/sample-lang/lexer"
+	"github.com/ei1chi/sample-lang/object"
+	"github.com/ei1chi/sample-lang/parser"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	l := lexer.NewLexer(input)
+	p := parser.NewParser(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+func testRun(t *testing.T, input string, builtins map[string]object.Object) object.Object {
+	program := parse(t, input)
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error for %q: %s", input, err)
+	}
+
+	// コンパイラの出力は読み込み時の検査も通るはず
+	var buf bytes.Buffer
+	if _, err := comp.Bytecode().WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo returned error for %q: %s", input, err)
+	}
+	bytecode, err := compiler.ReadBytecode(&buf)
+	if err != nil {
+		t.Fatalf("ReadBytecode returned error for %q: %s", input, err)
+	}
+
+	vm := New(bytecode, builtins)
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error for %q: %s", input, err)
+	}
+
+	return vm.Result()
+}
+
+var twice = &object.Builtin{Fn: func(args ...object.Object) object.Object {
+	return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+}}
+
+// eval のテストと同じ入力を両方で実行し、結果が一致することを確かめる。
+func TestMatchesEval(t *testing.T) {
+	inputs := []string{
+		// 整数
+		"5", "10", "-5", "-10",
+		"5 + 5 + 5 + 5 - 10", "2*2*2", "-50 + 100 + -50",
+		"5 * 2 + 10", "5 + 2 * 10", "3 * (3 * 3) + 10",
+
+		// 真偽値
+		"true", "false",
+		"1 < 2", "1 > 2", "1 < 1", "1 > 1",
+		"1 == 1", "1 != 1", "1 == 2", "1 != 2",
+		"true == true", "true != false", "true == false", "(1 < 2) == true",
+		"!true", "!false", "!5", "!!true", "!!false", "!!5",
+
+		// if
+		"if (true) { 10 }", "if (false) {10}", "if (1) {10}",
+		"if (1 < 2) {10}", "if (1 > 2) { 10 } else { 20 }",
+
+		// return
+		"return 10;", "return 10; 9;", "return 2 * 5; 9;", "9; return 2 * 5; 9;",
+		"if (10 > 1) { if (10 > 1) { return 10; } return 1; }",
+
+		// エラー
+		"5 + true", "true + false", "-true", "foobar",
+		"let f = fn(x) { x }; f(1, 2)", "5(1)",
+		"5 + true; 5", "10 / (5 - 5)", "if (10 > 1) { true + false; 1 }",
+		"let f = fn() {}; f() + 1", "let x = if (true) {}; x + 1", "if (true) { let a = 1; }",
+
+		// let と関数
+		"let a = 5; a;", "let a = 5 * 5; a;", "let a = 5; let b = a; b;",
+		"let a = 5; let b = a; let c = a + b + 5; c;",
+		"let a = 1; let a = a + 1; a",
+		"let identity = fn(x) { x; }; identity(5);",
+		"let identity = fn(x) { return x; }; identity(5);",
+		"let double = fn(x) { x * 2; }; double(5);",
+		"let add = fn(x, y) { x + y; }; add(5, 5);",
+		"let add = fn(x, y) { x + y; }; add(5 + 5, add(5, 5));",
+		"fn(x) { x; }(5)",
+		"let f = fn(x) { return x; 10; }; f(1) + 1;",
+		"let f = fn() { let a = 1; let b = 2; a + b }; f()",
+		"let f = fn(x) { if (x > 0) { return 1 } 0 }; f(1) + f(0)",
+		"let newAdder = fn(x) { fn(y) { x + y }; }; let addTwo = newAdder(2); addTwo(2);",
+		"let a = fn() { fn() { fn() { 1 } } }; a()()()",
+		"let f = fn() { g() }; let g = fn() { 7 }; f()",
+		"let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(15)",
+		"let f = fn() { let loop = fn(n) { if (n == 0) { 0 } else { loop(n - 1) } }; loop(5) }; f()",
+		"let f = fn(x) { x }; f == f",
+		"twice(21)", "let twice = fn(x) { x }; twice(21)", "twice(twice(1) + 1)",
+
+		// 文字列・配列・連想配列
+		`"hello"`, `"a" == "a"`, `"a" != "b"`, `"a" + "b"`, `-"a"`,
+		"[]", "[1, 2 * 3, 4 + 5]", "[1, 2, 3][1]", "[1, 2, 3][3]", "[1][true]",
+		"let a = [fn(x) { x * 2 }]; a[0](4)",
+		`{"a": 1, "b": 2}`, `{"a": 1, "b": 2}["b"]`, `{"a": 1}["z"]`, `{1: 2}`, `{"a": 1}[1]`, `1[0]`,
+
+		// import とモジュールの変数
+		`import "math"; math.max(1, 5)`, `import "math"; math.nope`, `import "json"; json.parse("[1, 2]")[1]`,
+		`import "json"; json.parse("2.5") * 2`, `import "nope"; 1`, `1.x`,
+		`let f = fn() { import "math"; math.min(3, 4) }; f()`,
+
+		// throw と try
+		"throw 1; 2", `throw "boom"`, "try { 1 } catch (e) { 2 }", "try { throw 1; 2 } catch (e) { e }",
+		"try { 1 + true } catch (e) { message(e) }", "try { foobar } catch (e) { is_error(e) }",
+		"let x = try { throw 1 } catch (e) { 5 }; x + 1",
+		"let x = 1; try { throw 2 } catch (e) { let x = 3; x }; x",
+		"try { throw 1 } catch (e) { let y = 3; y }; y",
+		"let e = 7; try { throw 1 } catch (e) { e }; e",
+		"let f = fn() { let x = 1; try { throw 2 } catch (e) { let x = 3; x } + x }; f()",
+		"let f = fn() { throw 3 }; let g = fn() { f() + 1 }; try { g() } catch (e) { e }",
+		"let f = fn(x) { try { x } catch (e) { 0 } }; f(1) + f(2)",
+		"try { try { throw 1 } catch (e) { throw 2 } } catch (e) { e }",
+		"try { try { throw 1 } finally { 5 } } catch (e) { e }",
+		"let x = 1; try { 2 } finally { let x = 3; }; x",
+		"let x = 1; try { throw 2 } catch (e) { 4 } finally { let x = 3; }; x",
+		"let x = 1; try { throw 2 } catch (e) { throw 4 } finally { let x = 3; }",
+		"let f = fn() { try { return 1; } finally { return 2; } }; f()",
+		"let f = fn() { try { throw 1 } catch (e) { return 1; } finally { 2 } }; f()",
+		"let f = fn() { try { return 1 } finally { throw 2 } }; try { f() } catch (e) { e }",
+		"let f = fn() { try { try { return 1 } finally { throw 2 } } catch (e) { 3 } }; f()",
+		"try { 1 } finally { throw 2 }", "return try { 1 } finally { 2 }",
+		"let x = try { throw \"a\" } catch (e) { }; x + 1", "try { } finally { }", "try { throw 1 } catch (e) { let y = e; }",
+		"let n = 0; let f = fn() { try { throw 1 } catch (e) { fn() { e } } }; f()()",
+		"let f = fn(n) { if (n == 0) { throw 9 } try { f(n - 1) } finally { 1 } }; try { f(3) } catch (e) { e }",
+
+		// エラーの値と ?
+		"error(1)", "is_error(error(1))", "message(error(\"x\"))", "throw error(1)",
+		"let f = fn() { let v = error(1)?; 2 }; f()", "let f = fn() { let v = 1?; v + 1 }; f()",
+		"let f = fn() { try { error(1)? } finally { 3 } }; f()",
+		"error(2)?; 3",
+	}
+
+	for _, input := range inputs {
+		env := object.NewEnvironment()
+		env.Set("twice", twice)
+		expected := eval.Eval(parse(t, input), env)
+
+		builtins := eval.Builtins()
+		builtins["twice"] = twice
+		got := testRun(t, input, builtins)
+
+		if expected.Type() != got.Type() || expected.Inspect() != got.Inspect() {
+			t.Errorf("%q: eval=%s, vm=%s", input, expected.Inspect(), got.Inspect())
+			continue
+		}
+		// エラーが起きた位置も同じはず
+		if err, ok := expected.(*object.Error); ok && err.Pos != got.(*object.Error).Pos {
+			t.Errorf("%q: wrong error position. eval=%s, vm=%s", input, err.Pos, got.(*object.Error).Pos)
+		}
+	}
+}
+
+func TestFunctionObject(t *testing.T) {
+	evaled := testRun(t, "fn(x) { x + 2; };", nil)
+
+	if evaled.Type() != object.FUNCTION {
+		t.Fatalf("object is not FUNCTION. got=%T (%+v)", evaled, evaled)
+	}
+}
+
+func TestFrameOverflow(t *testing.T) {
+	program := parse(t, "let loop = fn() { loop() }; loop()")
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode(), nil)
+	if err := vm.Run(); err == nil {
+		t.Errorf("expected overflow error")
+	}
+}
+
+func TestRunLoadedBytecode(t *testing.T) {
+	comp := compiler.New()
+	if err := comp.Compile(parse(t, "let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(10)")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := comp.Bytecode().WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo returned error: %s", err)
+	}
+
+	loaded, err := compiler.ReadBytecode(&buf)
+	if err != nil {
+		t.Fatalf("ReadBytecode returned error: %s", err)
+	}
+
+	vm := New(loaded, nil)
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if vm.Result().Inspect() != "55" {
+		t.Errorf("wrong result. want=55, got=%s", vm.Result().Inspect())
+	}
+}
+
+func TestErrorTrace(t *testing.T) {
+	input := "let f = fn() { throw 1 };\ntry { f() } catch (e) { trace(e) }"
+
+	got := testRun(t, input, eval.Builtins())
+
+	// let で束縛した関数の名前と位置は eval と同じ
+	expected := "at f (1:16)\nat main (2:8)"
+	if got.Inspect() != expected {
+		t.Errorf("wrong trace. expected=%q, got=%q", expected, got.Inspect())
+	}
+}
+
+func TestImportModule(t *testing.T) {
+	comp := compiler.New()
+	if err := comp.Compile(parse(t, `import "util"; util.double(21)`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	// モジュールは評価器で評価し、その関数も評価器で呼ぶ
+	loader := &eval.Loader{FS: fstest.MapFS{
+		"util.sl": {Data: []byte("export let double = fn(x) { x * 2 };")},
+	}}
+	vm := New(comp.Bytecode(), nil)
+	vm.Evaluator = eval.New(eval.Config{Loader: loader})
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if vm.Result().Inspect() != "42" {
+		t.Errorf("wrong result. want=42, got=%s", vm.Result().Inspect())
+	}
+}